@@ -0,0 +1,99 @@
+// Package apierrors defines the error codes and JSON error body shared by
+// the balancer, the servers and the db service.
+package apierrors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type Code string
+
+const (
+	BadRequest Code = "bad_request"
+	NotFound   Code = "not_found"
+	Conflict   Code = "conflict"
+	Overloaded Code = "overloaded"
+	Timeout    Code = "timeout"
+	Internal   Code = "internal"
+)
+
+func (c Code) Status() int {
+	switch c {
+	case BadRequest:
+		return http.StatusBadRequest
+	case NotFound:
+		return http.StatusNotFound
+	case Conflict:
+		return http.StatusConflict
+	case Overloaded:
+		return http.StatusServiceUnavailable
+	case Timeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type Error struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	status  int
+}
+
+func New(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// WithStatus overrides the HTTP status derived from the code, for responses
+// with a more specific status such as 405 or 431.
+func (e *Error) WithStatus(status int) *Error {
+	e.status = status
+	return e
+}
+
+func (e *Error) Status() int {
+	if e.status != 0 {
+		return e.status
+	}
+	return e.Code.Status()
+}
+
+func Write(w http.ResponseWriter, e *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status())
+	_ = json.NewEncoder(w).Encode(e)
+}
+
+// Decode reads the error body of a response. Responses without a JSON
+// error body get a code derived from their status.
+func Decode(resp *http.Response) *Error {
+	var e Error
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Code == "" {
+		e = Error{Code: codeFor(resp.StatusCode), Message: http.StatusText(resp.StatusCode)}
+	}
+	e.status = resp.StatusCode
+	return &e
+}
+
+func codeFor(status int) Code {
+	switch {
+	case status == http.StatusNotFound:
+		return NotFound
+	case status == http.StatusConflict:
+		return Conflict
+	case status == http.StatusServiceUnavailable || status == http.StatusTooManyRequests:
+		return Overloaded
+	case status == http.StatusGatewayTimeout:
+		return Timeout
+	case status >= 400 && status < 500:
+		return BadRequest
+	default:
+		return Internal
+	}
+}
@@ -0,0 +1,53 @@
+package apierrors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteAndDecode(t *testing.T) {
+	rw := httptest.NewRecorder()
+	Write(rw, New(NotFound, "key %s not found", "a"))
+
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rw.Code)
+	}
+	if rw.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected content type %q", rw.Header().Get("Content-Type"))
+	}
+	if body := strings.TrimSpace(rw.Body.String()); body != `{"code":"not_found","message":"key a not found"}` {
+		t.Errorf("Unexpected body %s", body)
+	}
+
+	decoded := Decode(rw.Result())
+	if decoded.Code != NotFound || decoded.Message != "key a not found" || decoded.Status() != http.StatusNotFound {
+		t.Errorf("Unexpected decoded error %+v", decoded)
+	}
+}
+
+func TestWithStatus(t *testing.T) {
+	rw := httptest.NewRecorder()
+	Write(rw, New(BadRequest, "too many headers").WithStatus(http.StatusRequestHeaderFieldsTooLarge))
+	if rw.Code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("Expected 431, got %d", rw.Code)
+	}
+}
+
+func TestDecodeWithoutBody(t *testing.T) {
+	cases := map[int]Code{
+		http.StatusNotFound:            NotFound,
+		http.StatusServiceUnavailable:  Overloaded,
+		http.StatusGatewayTimeout:      Timeout,
+		http.StatusBadRequest:          BadRequest,
+		http.StatusInternalServerError: Internal,
+	}
+	for status, code := range cases {
+		rw := httptest.NewRecorder()
+		rw.WriteHeader(status)
+		if decoded := Decode(rw.Result()); decoded.Code != code {
+			t.Errorf("Status %d decoded as %s, expected %s", status, decoded.Code, code)
+		}
+	}
+}
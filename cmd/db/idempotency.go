@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/apierrors"
+	"github.com/LeVasTiaN/KPI_Lab5/datastore"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	idempotencyPrefix = "__idempotency:"
+)
+
+var idempotencyTTL = flag.Duration("idempotency-ttl", 24*time.Hour, "how long results of writes with an Idempotency-Key are remembered")
+
+type recordedResponse struct {
+	Request string `json:"request"`
+	// BodyHash is the hex SHA-256 of the request body. Records stored
+	// before it was kept have none and match any body.
+	BodyHash    string `json:"bodyHash,omitempty"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// idempotentWrites replays the stored result of a write whose
+// Idempotency-Key was already seen instead of applying it again. Results
+// live in the datastore itself with a TTL, so they survive restarts.
+type idempotentWrites struct {
+	db   *datastore.Db
+	next http.Handler
+
+	mu       sync.Mutex
+	inFlight map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	waiters int
+}
+
+func newIdempotentWrites(db *datastore.Db, next http.Handler) *idempotentWrites {
+	return &idempotentWrites{db: db, next: next, inFlight: make(map[string]*keyLock)}
+}
+
+func (h *idempotentWrites) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get(idempotencyHeader)
+	if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	storedKey := scopedKey(r.Context(), key)
+
+	unlock := h.lock(storedKey)
+	defer unlock()
+
+	request := r.Method + " " + r.URL.Path
+	if recorded, ok := h.lookup(storedKey); ok {
+		if recorded.Request != request {
+			apierrors.Write(w, apierrors.New(apierrors.Conflict, "idempotency key %s was used for %s", key, recorded.Request).WithStatus(http.StatusUnprocessableEntity))
+			return
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, r.Body); err != nil {
+			apierrors.Write(w, apierrors.New(apierrors.BadRequest, "failed to read body: %s", err))
+			return
+		}
+		if recorded.BodyHash != "" && recorded.BodyHash != hex.EncodeToString(hash.Sum(nil)) {
+			apierrors.Write(w, apierrors.New(apierrors.Conflict, "idempotency key %s was used with another body", key).WithStatus(http.StatusUnprocessableEntity))
+			return
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		if recorded.ContentType != "" {
+			w.Header().Set("Content-Type", recorded.ContentType)
+		}
+		w.WriteHeader(recorded.Status)
+		w.Write(recorded.Body)
+		return
+	}
+
+	// The body is hashed as the handler reads it, and what it leaves
+	// unread afterwards.
+	hash := sha256.New()
+	body := r.Body
+	r.Body = io.NopCloser(io.TeeReader(body, hash))
+	recorder := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+	h.next.ServeHTTP(recorder, r)
+
+	// Server errors are not remembered so that the client can retry them.
+	if recorder.status >= http.StatusInternalServerError {
+		return
+	}
+	if _, err := io.Copy(hash, body); err != nil {
+		return
+	}
+	h.store(storedKey, recordedResponse{
+		Request:     request,
+		BodyHash:    hex.EncodeToString(hash.Sum(nil)),
+		Status:      recorder.status,
+		ContentType: recorder.Header().Get("Content-Type"),
+		Body:        recorder.body.Bytes(),
+	})
+}
+
+func (h *idempotentWrites) lock(key string) func() {
+	h.mu.Lock()
+	l, ok := h.inFlight[key]
+	if !ok {
+		l = &keyLock{}
+		h.inFlight[key] = l
+	}
+	l.waiters++
+	h.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		h.mu.Lock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(h.inFlight, key)
+		}
+		h.mu.Unlock()
+	}
+}
+
+func (h *idempotentWrites) lookup(key string) (recordedResponse, bool) {
+	var recorded recordedResponse
+	stored, err := h.db.Get(idempotencyPrefix + key)
+	if err != nil {
+		return recorded, false
+	}
+	if err := json.Unmarshal([]byte(stored), &recorded); err != nil {
+		log.Printf("Ignoring corrupted idempotency record %s: %s", key, err)
+		return recorded, false
+	}
+	return recorded, true
+}
+
+func (h *idempotentWrites) store(key string, recorded recordedResponse) {
+	encoded, err := json.Marshal(recorded)
+	if err != nil {
+		log.Printf("Failed to encode idempotency record %s: %s", key, err)
+		return
+	}
+	if err := h.db.PutWithTTL(idempotencyPrefix+key, string(encoded), *idempotencyTTL); err != nil {
+		log.Printf("Failed to store idempotency record %s: %s", key, err)
+	}
+}
+
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
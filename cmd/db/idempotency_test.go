@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore"
+)
+
+func TestIdempotentWrites(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var mu sync.Mutex
+	applied := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		applied++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	})
+	handler := newIdempotentWrites(db, next)
+
+	send := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"value":1}`))
+		if key != "" {
+			req.Header.Set(idempotencyHeader, key)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	first := send(http.MethodPost, "/db/counter", "k1")
+	retry := send(http.MethodPost, "/db/counter", "k1")
+	if applied != 1 {
+		t.Errorf("Expected write to be applied once, got %d", applied)
+	}
+	if retry.Code != first.Code || retry.Body.String() != "done" || retry.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Replayed response differs: %d %q", retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Expected replayed response to be marked")
+	}
+
+	if rw := send(http.MethodPost, "/db/other", "k1"); rw.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected key reuse for another request to be rejected, got %d", rw.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/db/counter", strings.NewReader(`{"value":2}`))
+	req.Header.Set(idempotencyHeader, "k1")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	if rw.Code != http.StatusUnprocessableEntity || applied != 1 {
+		t.Errorf("Expected key reuse with another body to be rejected, got %d after %d writes", rw.Code, applied)
+	}
+
+	send(http.MethodPost, "/db/counter", "")
+	send(http.MethodPost, "/db/counter", "")
+	if applied != 3 {
+		t.Errorf("Writes without a key should always apply, got %d", applied)
+	}
+
+	t.Run("concurrent retries", func(t *testing.T) {
+		applied = 0
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				send(http.MethodPost, "/db/counter", "k2")
+			}()
+		}
+		wg.Wait()
+		if applied != 1 {
+			t.Errorf("Expected concurrent retries to apply once, got %d", applied)
+		}
+	})
+}
+
+func TestIdempotentWrites_ReservedKeys(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	handler := newIdempotentWrites(db, &dbHandler{db: db})
+
+	req := httptest.NewRequest(http.MethodPost, "/db/key", strings.NewReader(`{"value":"v"}`))
+	req.Header.Set(idempotencyHeader, "retry-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		req := httptest.NewRequest(method, "/db/"+idempotencyPrefix+"retry-1", strings.NewReader(`{"value":"forged"}`))
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Code != http.StatusBadRequest {
+			t.Errorf("Expected a %s of an idempotency record to be rejected, got %d: %s", method, rw.Code, rw.Body)
+		}
+	}
+	if stored, err := db.Get(idempotencyPrefix + "retry-1"); err != nil || strings.Contains(stored, "forged") {
+		t.Errorf("Expected the idempotency record to be left alone, got %q (%v)", stored, err)
+	}
+}
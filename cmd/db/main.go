@@ -1,13 +1,51 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/LeVasTiaN/KPI_Lab5/apierrors"
 	"github.com/LeVasTiaN/KPI_Lab5/datastore"
+	"github.com/LeVasTiaN/KPI_Lab5/signal"
+)
+
+const binaryContentType = "application/octet-stream"
+
+var (
+	fsyncPolicy   = flag.String("fsync", "interval", "when writes are flushed to disk: always, interval or never")
+	fsyncInterval = flag.Duration("fsync-interval", time.Second, "how often writes are flushed to disk with the interval policy")
+
+	lowPriorityCompaction = flag.Bool("low-priority-compaction", true, "whether compaction runs at reduced CPU and I/O priority")
+	compactionRate        = flag.Int64("compact-rate", 0, "bytes per second compaction may write (unlimited when 0)")
+	maxOpenSegments       = flag.Int("max-open-segments", 128, "how many segment files may be held open for reading at once")
+	checkpointInterval    = flag.Duration("checkpoint-interval", time.Minute, "how often the index is checkpointed to speed up restarts (disabled when 0)")
+	verifyCompaction      = flag.Bool("verify-compaction", false, "whether compacted segments are re-read and checked before they replace the originals")
+	dictionaryCompression = flag.Bool("dictionary-compression", false, "whether values are compressed with a dictionary trained during compaction")
+	mmapReads             = flag.Bool("mmap-reads", false, "whether sealed segments are memory-mapped for reads")
+	valueCacheSize        = flag.Int64("value-cache-size", 16*1024*1024, "how many bytes of recently read keys and values are kept in memory (disabled when 0)")
+	slowOpThreshold       = flag.Duration("slow-op-threshold", 100*time.Millisecond, "gets, puts and compactions taking at least this long are logged with a timing breakdown (disabled when 0)")
+
+	writeQueueDepth = flag.Int("write-queue-depth", 100, "how many writes may wait for the writer")
+	shedWrites      = flag.Bool("shed-writes", false, "whether writes are rejected with 503 instead of waiting while the write queue is full")
+
+	garbageRatio       = flag.Float64("compact-garbage-ratio", 0, "share of replaced record bytes, below 1, that triggers a compaction (disabled when 0)")
+	compactionSegments = flag.Int("compact-segments", 3, "number of segments, at least 2, that triggers a compaction (disabled when 0)")
+	compactionInterval = flag.Duration("compact-interval", 0, "how often sealed segments are compacted regardless of their number (disabled when 0)")
+
+	maxKeySize   = flag.Int("max-key-size", 4*1024, "max key length in bytes")
+	maxValueSize = flag.Int("max-value-size", 1024*1024, "max value length in bytes")
 )
 
 type dbHandler struct {
@@ -16,44 +54,327 @@ type dbHandler struct {
 
 func (h *dbHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Path[len("/db/"):]
+	// Idempotency records would otherwise be readable and forgeable as
+	// ordinary keys.
+	if strings.HasPrefix(key, idempotencyPrefix) {
+		apierrors.Write(w, apierrors.New(apierrors.BadRequest, "keys starting with %s are reserved", idempotencyPrefix))
+		return
+	}
+	storedKey := scopedKey(r.Context(), key)
 
 	switch r.Method {
-	case http.MethodGet:
-		value, err := h.db.Get(key)
+	case http.MethodGet, http.MethodHead:
+		// A range only makes sense of the raw bytes, so it is served from
+		// them whatever the client accepts.
+		if r.Header.Get("Range") != "" || acceptsBinary(r) {
+			value, err := h.db.OpenValue(r.Context(), storedKey)
+			if err != nil {
+				apierrors.Write(w, apiError(key, err))
+				return
+			}
+			defer value.Close()
+			serveBinary(w, r, value)
+			return
+		}
+
+		value, err := h.db.GetContext(r.Context(), storedKey)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
+			apierrors.Write(w, apiError(key, err))
 			return
 		}
 
 		response := map[string]interface{}{
 			"key":   key,
-			"value": value,
+			"value": string(value),
 		}
 		json.NewEncoder(w).Encode(response)
 
 	case http.MethodPost:
+		var ttl time.Duration
+		if value := r.URL.Query().Get("ttl"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				apierrors.Write(w, apierrors.New(apierrors.BadRequest, "ttl must be a positive duration, got %q", value))
+				return
+			}
+			ttl = parsed
+		}
+
+		if r.Header.Get("Content-Type") == binaryContentType {
+			value, err := io.ReadAll(r.Body)
+			if err != nil {
+				apierrors.Write(w, apierrors.New(apierrors.BadRequest, "failed to read body: %s", err))
+				return
+			}
+			if err := h.put(r.Context(), storedKey, value, ttl); err != nil {
+				apierrors.Write(w, apiError(key, err))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
 		var request struct {
 			Value interface{} `json:"value"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			apierrors.Write(w, apierrors.New(apierrors.BadRequest, "invalid JSON body: %s", err))
 			return
 		}
 
 		stringValue := fmt.Sprintf("%v", request.Value)
-		if err := h.db.Put(key, stringValue); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		if err := h.put(r.Context(), storedKey, []byte(stringValue), ttl); err != nil {
+			apierrors.Write(w, apiError(key, err))
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
 	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		apierrors.Write(w, apierrors.New(apierrors.BadRequest, "method %s not allowed", r.Method).WithStatus(http.StatusMethodNotAllowed))
+	}
+}
+
+// put stores value for good, or until ttl has passed if it is not 0.
+func (h *dbHandler) put(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl > 0 {
+		return h.db.PutWithTTLContext(ctx, key, value, ttl)
+	}
+	return h.db.PutContext(ctx, key, value)
+}
+
+// acceptsBinary reports whether the Accept header of r lists the binary
+// content type, with or without parameters.
+func acceptsBinary(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(accepted, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), binaryContentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveBinary writes value as raw bytes, or only the ranges a Range header
+// asks for. The ETag follows the value, so that a client resuming a download
+// with If-Range gets the whole new value instead of parts of two. The value
+// is streamed twice, once for the ETag, rather than held in memory.
+func serveBinary(w http.ResponseWriter, r *http.Request, value io.ReadSeeker) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, value); err != nil {
+		apierrors.Write(w, apierrors.New(apierrors.Internal, "reading value: %s", err))
+		return
+	}
+	if _, err := value.Seek(0, io.SeekStart); err != nil {
+		apierrors.Write(w, apierrors.New(apierrors.Internal, "reading value: %s", err))
+		return
+	}
+	w.Header().Set("Content-Type", binaryContentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, hash.Sum(nil)[:16]))
+	http.ServeContent(w, r, "", time.Time{}, value)
+}
+
+func apiError(key string, err error) *apierrors.Error {
+	switch {
+	case errors.Is(err, datastore.ErrKeyNotFound):
+		return apierrors.New(apierrors.NotFound, "key %s not found", key)
+	case errors.Is(err, datastore.ErrValueTooLarge):
+		return apierrors.New(apierrors.BadRequest, "%s", err).WithStatus(http.StatusRequestEntityTooLarge)
+	case errors.Is(err, datastore.ErrKeyTooLarge):
+		return apierrors.New(apierrors.BadRequest, "%s", err).WithStatus(http.StatusRequestURITooLong)
+	case errors.Is(err, datastore.ErrTypeMismatch):
+		return apierrors.New(apierrors.Conflict, "%s", err)
+	case errors.Is(err, datastore.ErrDBClosed):
+		return apierrors.New(apierrors.Overloaded, "database is shutting down")
+	case errors.Is(err, datastore.ErrWriteQueueFull):
+		return apierrors.New(apierrors.Overloaded, "too many pending writes")
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return apierrors.New(apierrors.Timeout, "request for key %s timed out", key)
+	default:
+		return apierrors.New(apierrors.Internal, "%s", err)
+	}
+}
+
+// compactionHandler reports the compaction status. POST requests first
+// force a compaction, or pause or resume compaction with action=pause or
+// action=resume.
+func compactionHandler(db *datastore.Db) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			switch action := r.URL.Query().Get("action"); action {
+			case "", "compact":
+				if err := db.Compact(); err != nil {
+					apierrors.Write(w, apiError("", err))
+					return
+				}
+			case "pause":
+				db.PauseCompaction()
+			case "resume":
+				db.ResumeCompaction()
+			default:
+				apierrors.Write(w, apierrors.New(apierrors.BadRequest, "unknown compaction action %q", action))
+				return
+			}
+		default:
+			apierrors.Write(w, apierrors.New(apierrors.BadRequest, "method %s not allowed", r.Method).WithStatus(http.StatusMethodNotAllowed))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(db.CompactionStatus())
+	}
+}
+
+// rewriteHandler reports the progress of the latest rewrite of outdated
+// records, after starting one for POST requests.
+func rewriteHandler(db *datastore.Db) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := db.RewriteAll(); err != nil {
+				apierrors.Write(w, apiError("", err))
+				return
+			}
+		default:
+			apierrors.Write(w, apierrors.New(apierrors.BadRequest, "method %s not allowed", r.Method).WithStatus(http.StatusMethodNotAllowed))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(db.RewriteStatus())
+	}
+}
+
+// tenantStats is what statsHandler reports to a tenant.
+type tenantStats struct {
+	Tenant string
+	Keys   int
+}
+
+// statsHandler reports the key count, segments, disk usage and compaction
+// counters of the database. Behind a tenant scope it reports only how many
+// keys the tenant of the request has.
+func statsHandler(db *datastore.Db) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierrors.Write(w, apierrors.New(apierrors.BadRequest, "method %s not allowed", r.Method).WithStatus(http.StatusMethodNotAllowed))
+			return
+		}
+		if tenant, ok := tenantOf(r.Context()); ok {
+			stats := tenantStats{Tenant: tenant}
+			it := db.Scan(scopedKey(r.Context(), ""))
+			for it.Next() {
+				stats.Keys++
+			}
+			if err := it.Err(); err != nil {
+				apierrors.Write(w, apiError("", err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(stats)
+			return
+		}
+		stats, err := db.Stats()
+		if err != nil {
+			apierrors.Write(w, apiError("", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// segmentsHandler lists the segment files of the database, oldest first.
+func segmentsHandler(db *datastore.Db) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierrors.Write(w, apierrors.New(apierrors.BadRequest, "method %s not allowed", r.Method).WithStatus(http.StatusMethodNotAllowed))
+			return
+		}
+		segments := db.Segments()
+		if segments == nil {
+			apierrors.Write(w, apiError("", datastore.ErrDBClosed))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(segments)
+	}
+}
+
+// maxScanKeys bounds the keys scanHandler returns in one response.
+const maxScanKeys = 1000
+
+type scannedKey struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// scanHandler lists the keys with the prefix given with prefix= and their
+// values in key order, up to limit= of them. Behind a tenant scope it lists
+// only the keys of the tenant, under the names the tenant gave them.
+func scanHandler(db *datastore.Db) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierrors.Write(w, apierrors.New(apierrors.BadRequest, "method %s not allowed", r.Method).WithStatus(http.StatusMethodNotAllowed))
+			return
+		}
+		limit := maxScanKeys
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				apierrors.Write(w, apierrors.New(apierrors.BadRequest, "limit must be a positive number, got %q", value))
+				return
+			}
+			limit = min(parsed, maxScanKeys)
+		}
+
+		keys := []scannedKey{}
+		scope := scopedKey(r.Context(), "")
+		it := db.Scan(scopedKey(r.Context(), r.URL.Query().Get("prefix")), datastore.WithOrder(datastore.KeyOrder))
+		for len(keys) < limit && it.Next() {
+			key := strings.TrimPrefix(it.Key(), scope)
+			value, err := it.Value()
+			if err != nil {
+				apierrors.Write(w, apiError(key, err))
+				return
+			}
+			keys = append(keys, scannedKey{Key: key, Value: value})
+		}
+		if err := it.Err(); err != nil {
+			apierrors.Write(w, apiError("", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+// vacuumHandler removes the files of the database directory that belong
+// to no segment and reports how many it removed.
+func vacuumHandler(db *datastore.Db) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			apierrors.Write(w, apierrors.New(apierrors.BadRequest, "method %s not allowed", r.Method).WithStatus(http.StatusMethodNotAllowed))
+			return
+		}
+		removed, err := db.Vacuum()
+		if err != nil {
+			apierrors.Write(w, apiError("", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"removed": removed})
 	}
 }
 
 func main() {
+	flag.Parse()
+
+	var replica *standby
 	http.HandleFunc("/db/health", func(w http.ResponseWriter, r *http.Request) {
+		if replica != nil {
+			replica.announce(w.Header())
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
@@ -62,16 +383,130 @@ func main() {
 		log.Fatalf("Failed to create data directory: %v", err)
 	}
 
-	db, err := datastore.CreateDb("/opt/practice-4/out", 250)
+	var syncOption datastore.Option
+	switch *fsyncPolicy {
+	case "always":
+		syncOption = datastore.WithSyncPolicy(datastore.SyncAlways)
+	case "interval":
+		syncOption = datastore.WithSyncInterval(*fsyncInterval)
+	case "never":
+		syncOption = datastore.WithSyncPolicy(datastore.SyncNever)
+	default:
+		log.Fatalf("Unknown fsync policy: %s", *fsyncPolicy)
+	}
+
+	options := []datastore.Option{
+		datastore.WithSegmentSize(250),
+		syncOption,
+		datastore.WithMaxOpenSegments(*maxOpenSegments),
+		datastore.WithMaxKeySize(*maxKeySize),
+		datastore.WithMaxValueSize(*maxValueSize),
+		datastore.WithCheckpointInterval(*checkpointInterval),
+		datastore.WithSlowOpThreshold(*slowOpThreshold),
+		datastore.WithValueCache(*valueCacheSize),
+		datastore.WithWriteQueueDepth(*writeQueueDepth),
+		datastore.WithCompactionSegments(*compactionSegments),
+		datastore.WithCompactionRateLimit(*compactionRate),
+		datastore.WithCompactionInterval(*compactionInterval),
+	}
+	if *lowPriorityCompaction {
+		options = append(options, datastore.WithLowPriorityCompaction())
+	}
+	if *verifyCompaction {
+		options = append(options, datastore.WithCompactionVerification())
+	}
+	if *dictionaryCompression {
+		options = append(options, datastore.WithDictionaryCompression())
+	}
+	if *mmapReads {
+		options = append(options, datastore.WithMmapReads())
+	}
+	if *shedWrites {
+		options = append(options, datastore.WithWriteQueueShedding())
+	}
+	if *garbageRatio > 0 {
+		options = append(options, datastore.WithGarbageCompaction(*garbageRatio))
+	}
+
+	db, err := datastore.Open("/opt/practice-4/out", options...)
 	if err != nil {
 		log.Fatalf("DB initialization failed: %v", err)
 	}
+	expvar.Publish("db_slow_ops", expvar.Func(func() any { return db.SlowOps() }))
+	expvar.Publish("db_write_queue_depth", expvar.Func(func() any { return db.WriteQueueDepth() }))
+	expvar.Publish("db_garbage", expvar.Func(func() any { return db.Garbage() }))
 
-	handler := &dbHandler{db: db}
+	// The admin endpoints and the change feed reach every key, so once
+	// tenants are enabled they are only served to the operator.
+	tenants := *tenantHeader != "" || *tenantTokens != ""
+	if tenants && *operatorToken == "" {
+		log.Fatalf("Tenants require -operator-token to guard the admin and replication endpoints")
+	}
+	operator := func(handler http.Handler) http.Handler { return operatorOnly(*operatorToken, handler) }
+	http.Handle("/admin/compaction", operator(compactionHandler(db)))
+	http.Handle("/admin/rewrite", operator(rewriteHandler(db)))
+	http.Handle("/admin/vacuum", operator(vacuumHandler(db)))
+	http.Handle("/admin/segments", operator(segmentsHandler(db)))
+
+	feed := newChangeFeed(db, *changeLogSize)
+	http.Handle("/replication/changes", operator(feed))
+
+	// Handlers that read or write keys only see those of the tenant of the
+	// request once tenants are enabled. Operator scoped ones also serve
+	// the operator, with the operator token, as if tenants were disabled.
+	scoped := func(handler http.Handler) http.Handler { return handler }
+	operatorScoped := scoped
+	if tenants {
+		var tokens map[string]string
+		if *tenantTokens != "" {
+			if tokens, err = loadTenantTokens(*tenantTokens); err != nil {
+				log.Fatalf("Failed to load tenant tokens: %v", err)
+			}
+		}
+		scoped = func(handler http.Handler) http.Handler {
+			return newTenantScope(*tenantHeader, tokens, handler)
+		}
+		operatorScoped = func(handler http.Handler) http.Handler {
+			scope := newTenantScope(*tenantHeader, tokens, handler)
+			scope.operator = *operatorToken
+			return scope
+		}
+		log.Printf("Scoping keys to tenants")
+	}
+	http.Handle("/admin/scan", scoped(scanHandler(db)))
+	http.Handle("/admin/stats", operatorScoped(statsHandler(db)))
+
+	handler := scoped(newIdempotentWrites(db, &dbHandler{db: db}))
+	if *standbyOf != "" {
+		replica = newStandby(strings.TrimSuffix(*standbyOf, "/"), *operatorToken, db)
+		handler = replica.guard(handler)
+		http.Handle("/replication/promote", operator(http.HandlerFunc(replica.servePromote)))
+		go replica.run(*standbyPoll, *promoteAfter, nil)
+		log.Printf("Following %s as a standby", *standbyOf)
+	}
 	http.Handle("/db/", handler)
 
-	log.Println("Starting DB server on :8083")
-	if err := http.ListenAndServe(":8083", nil); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	// Watch streams do not end on their own, so they are ended once the
+	// server shuts down rather than holding up Shutdown.
+	server := &http.Server{Addr: ":8083"}
+	watchesDone := make(chan struct{})
+	server.RegisterOnShutdown(func() { close(watchesDone) })
+	http.Handle("/watch", scoped(watchHandler(db, watchesDone)))
+	go func() {
+		log.Println("Starting DB server on :8083")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	signal.WaitForTerminationSignal()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to stop HTTP server: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
 	}
 }
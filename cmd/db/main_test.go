@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/apierrors"
+	"github.com/LeVasTiaN/KPI_Lab5/datastore"
+)
+
+func TestDbHandlerErrors(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory(), datastore.WithMaxKeySize(16), datastore.WithMaxValueSize(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	handler := &dbHandler{db: db}
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		code   apierrors.Code
+		status int
+	}{
+		{http.MethodGet, "/db/missing", "", apierrors.NotFound, http.StatusNotFound},
+		{http.MethodPost, "/db/missing", "", apierrors.BadRequest, http.StatusBadRequest},
+		{http.MethodDelete, "/db/missing", "", apierrors.BadRequest, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/db/key", `{"value":"a value over the limit"}`, apierrors.BadRequest, http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "/db/a-key-over-the-limit", `{"value":"v"}`, apierrors.BadRequest, http.StatusRequestURITooLong},
+		{http.MethodPost, "/db/key?ttl=soon", `{"value":"v"}`, apierrors.BadRequest, http.StatusBadRequest},
+		{http.MethodPost, "/db/key?ttl=-1s", `{"value":"v"}`, apierrors.BadRequest, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if rw.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.method, tc.status, rw.Code)
+		}
+		if e := apierrors.Decode(rw.Result()); e.Code != tc.code {
+			t.Errorf("%s: expected code %s, got %s", tc.method, tc.code, e.Code)
+		}
+	}
+}
+
+func TestDbHandlerTTL(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	handler := &dbHandler{db: db}
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/db/json?ttl=50ms", strings.NewReader(`{"value":"v"}`)))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rw.Code)
+	}
+	rw = httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/db/binary?ttl=50ms", strings.NewReader("v"))
+	request.Header.Set("Content-Type", binaryContentType)
+	handler.ServeHTTP(rw, request)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rw.Code)
+	}
+	if value, err := db.Get("json"); err != nil || value != "v" {
+		t.Fatalf("Expected 'v' before the ttl passes, got %q (%v)", value, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	for _, key := range []string{"json", "binary"} {
+		if _, err := db.Get(key); !errors.Is(err, datastore.ErrKeyNotFound) {
+			t.Errorf("Expected %s to expire, got %v", key, err)
+		}
+	}
+}
+
+func TestCompactionHandler(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Put("a", "1")
+	db.Put("a", "2")
+
+	rw := httptest.NewRecorder()
+	compactionHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/admin/compaction", nil))
+	var status datastore.CompactionStatus
+	if err := json.NewDecoder(rw.Body).Decode(&status); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d, %v", rw.Code, err)
+	}
+	if status.Compactions != 1 || status.ReclaimedBytes <= 0 {
+		t.Errorf("Expected a forced compaction, got %+v", status)
+	}
+
+	for _, action := range []string{"pause", "resume"} {
+		rw = httptest.NewRecorder()
+		compactionHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/admin/compaction?action="+action, nil))
+		status = datastore.CompactionStatus{}
+		if err := json.NewDecoder(rw.Body).Decode(&status); err != nil || rw.Code != http.StatusOK {
+			t.Fatalf("Unexpected response to %s: %d, %v", action, rw.Code, err)
+		}
+		if status.Paused != (action == "pause") {
+			t.Errorf("Expected paused %v after %s, got %+v", action == "pause", action, status)
+		}
+	}
+
+	rw = httptest.NewRecorder()
+	compactionHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/admin/compaction?action=stop", nil))
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown action, got %d", rw.Code)
+	}
+
+	rw = httptest.NewRecorder()
+	compactionHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodDelete, "/admin/compaction", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rw.Code)
+	}
+}
+
+func TestRewriteHandler(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rw := httptest.NewRecorder()
+	rewriteHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/admin/rewrite", nil))
+	var status datastore.RewriteStatus
+	if err := json.NewDecoder(rw.Body).Decode(&status); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d, %v", rw.Code, err)
+	}
+
+	rw = httptest.NewRecorder()
+	rewriteHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodDelete, "/admin/rewrite", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rw.Code)
+	}
+
+	db.Close()
+	rw = httptest.NewRecorder()
+	rewriteHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/admin/rewrite", nil))
+	if rw.Code == http.StatusOK {
+		t.Error("Expected a rewrite of a closed database to fail")
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	statsHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	var stats datastore.Stats
+	if err := json.NewDecoder(rw.Body).Decode(&stats); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d, %v", rw.Code, err)
+	}
+	if stats.Keys != 1 || stats.Segments != 1 || stats.DiskBytes == 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	rw = httptest.NewRecorder()
+	statsHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/admin/stats", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rw.Code)
+	}
+}
+
+func TestStatsHandler_Tenants(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, key := range []string{tenantKeyPrefix + "team-a/1", tenantKeyPrefix + "team-a/2", tenantKeyPrefix + "team-b/1"} {
+		if err := db.Put(key, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scope := newTenantScope("X-Tenant", nil, statsHandler(db))
+	scope.operator = "s3cret"
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+	req.Header.Set("X-Tenant", "team-a")
+	rw := httptest.NewRecorder()
+	scope.ServeHTTP(rw, req)
+	var stats map[string]any
+	if err := json.NewDecoder(rw.Body).Decode(&stats); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d, %v", rw.Code, err)
+	}
+	if stats["Tenant"] != "team-a" || stats["Keys"] != 2.0 || stats["DiskBytes"] != nil {
+		t.Errorf("Expected only the key count of team-a, got %v", stats)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+	req.Header.Set("X-Tenant", "team/a")
+	rw = httptest.NewRecorder()
+	scope.ServeHTTP(rw, req)
+	if rw.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an invalid tenant to be refused, got %d", rw.Code)
+	}
+
+	for _, authorization := range []string{"", "Bearer wrong"} {
+		req = httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rw = httptest.NewRecorder()
+		scope.ServeHTTP(rw, req)
+		if rw.Code != http.StatusUnauthorized {
+			t.Errorf("Expected the stats of the whole database to be refused for %q, got %d", authorization, rw.Code)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rw = httptest.NewRecorder()
+	scope.ServeHTTP(rw, req)
+	var global datastore.Stats
+	if err := json.NewDecoder(rw.Body).Decode(&global); err != nil || global.Keys != 3 {
+		t.Errorf("Expected the stats of the whole database for the operator, got %+v (%v)", global, err)
+	}
+}
+
+func TestSegmentsHandler(t *testing.T) {
+	db, err := datastore.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	rw := httptest.NewRecorder()
+	segmentsHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/admin/segments", nil))
+	var segments []datastore.SegmentInfo
+	if err := json.NewDecoder(rw.Body).Decode(&segments); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d, %v", rw.Code, err)
+	}
+	if len(segments) != 1 || !segments[0].Active || segments[0].Keys != 1 {
+		t.Errorf("Unexpected segments %+v", segments)
+	}
+
+	db.Close()
+	rw = httptest.NewRecorder()
+	segmentsHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/admin/segments", nil))
+	if rw.Code == http.StatusOK {
+		t.Error("Expected listing the segments of a closed database to fail")
+	}
+}
+
+func TestScanHandler(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, key := range []string{"user:2", "user:1", "user:3", "session:1"} {
+		if err := db.Put(key, "v-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	scanHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/admin/scan?prefix=user:&limit=2", nil))
+	var keys []scannedKey
+	if err := json.NewDecoder(rw.Body).Decode(&keys); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected response %d, %v", rw.Code, err)
+	}
+	if len(keys) != 2 || keys[0] != (scannedKey{"user:1", "v-user:1"}) || keys[1].Key != "user:2" {
+		t.Errorf("Expected the first two user keys in order, got %+v", keys)
+	}
+
+	rw = httptest.NewRecorder()
+	scanHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/admin/scan?limit=none", nil))
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid limit, got %d", rw.Code)
+	}
+}
+
+func TestScanHandler_Tenants(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, key := range []string{tenantKeyPrefix + "team-a/user:1", tenantKeyPrefix + "team-b/user:1", "user:1"} {
+		if err := db.Put(key, "v-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := newTenantScope("X-Tenant", nil, scanHandler(db))
+
+	for _, prefix := range []string{"", "user:", "../team-b/"} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/scan?prefix="+prefix, nil)
+		req.Header.Set("X-Tenant", "team-a")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		var keys []scannedKey
+		if err := json.NewDecoder(rw.Body).Decode(&keys); err != nil || rw.Code != http.StatusOK {
+			t.Fatalf("Unexpected response %d, %v", rw.Code, err)
+		}
+		if prefix == "../team-b/" {
+			if len(keys) != 0 {
+				t.Errorf("Expected team-a to see nothing of team-b, got %+v", keys)
+			}
+			continue
+		}
+		expected := scannedKey{"user:1", "v-" + tenantKeyPrefix + "team-a/user:1"}
+		if len(keys) != 1 || keys[0] != expected {
+			t.Errorf("Expected only the key of team-a for prefix %q, got %+v", prefix, keys)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/admin/scan", nil))
+	if rw.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a scan without a tenant to be refused, got %d", rw.Code)
+	}
+}
+
+func TestVacuumHandler(t *testing.T) {
+	db, err := datastore.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rw := httptest.NewRecorder()
+	vacuumHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/admin/vacuum", nil))
+	var result map[string]int
+	if err := json.NewDecoder(rw.Body).Decode(&result); err != nil || rw.Code != http.StatusOK || result["removed"] != 0 {
+		t.Fatalf("Unexpected response %d, %v (%v)", rw.Code, result, err)
+	}
+
+	rw = httptest.NewRecorder()
+	vacuumHandler(db).ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/admin/vacuum", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rw.Code)
+	}
+}
+
+func TestDbHandlerRange(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	handler := &dbHandler{db: db}
+	if err := db.PutBytes("blob", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(header http.Header) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/db/blob", nil)
+		r.Header = header
+		if r.Header.Get("Accept") == "" {
+			r.Header.Set("Accept", binaryContentType)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+		return rw.Result()
+	}
+	body := func(resp *http.Response) string {
+		data, _ := io.ReadAll(resp.Body)
+		return string(data)
+	}
+
+	full := get(http.Header{})
+	etag := full.Header.Get("ETag")
+	if full.StatusCode != http.StatusOK || body(full) != "0123456789" || etag == "" || full.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("Expected the whole value with an ETag, got %d %v", full.StatusCode, full.Header)
+	}
+
+	partial := get(http.Header{"Range": {"bytes=2-5"}})
+	if partial.StatusCode != http.StatusPartialContent || body(partial) != "2345" || partial.Header.Get("Content-Range") != "bytes 2-5/10" {
+		t.Errorf("Expected bytes 2-5, got %d %v", partial.StatusCode, partial.Header)
+	}
+	if partial.Header.Get("Content-Type") != binaryContentType {
+		t.Errorf("Expected the binary content type, got %q", partial.Header.Get("Content-Type"))
+	}
+
+	resumed := get(http.Header{"Range": {"bytes=7-"}, "If-Range": {etag}})
+	if resumed.StatusCode != http.StatusPartialContent || body(resumed) != "789" {
+		t.Errorf("Expected the rest of the value, got %d", resumed.StatusCode)
+	}
+
+	if err := db.PutBytes("blob", []byte("abcdefghij")); err != nil {
+		t.Fatal(err)
+	}
+	changed := get(http.Header{"Range": {"bytes=7-"}, "If-Range": {etag}})
+	if changed.StatusCode != http.StatusOK || body(changed) != "abcdefghij" {
+		t.Errorf("Expected the whole changed value, got %d", changed.StatusCode)
+	}
+
+	if unsatisfiable := get(http.Header{"Range": {"bytes=20-"}}); unsatisfiable.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("Expected 416, got %d", unsatisfiable.StatusCode)
+	}
+
+	// A Range header gets raw bytes whatever the Accept header says, and
+	// the binary type anywhere in an Accept list gets them without one.
+	for _, accept := range []string{"", "*/*", "application/json", "application/json, application/octet-stream;q=0.9"} {
+		r := httptest.NewRequest(http.MethodGet, "/db/blob", nil)
+		r.Header.Set("Range", "bytes=0-2")
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+		if resp := rw.Result(); resp.StatusCode != http.StatusPartialContent || body(resp) != "abc" {
+			t.Errorf("Expected bytes 0-2 with Accept %q, got %d", accept, resp.StatusCode)
+		}
+	}
+	for accept, expected := range map[string]string{
+		"application/json, application/octet-stream;q=0.9": "abcdefghij",
+		"*/*": `{"key":"blob","value":"abcdefghij"}` + "\n",
+		"":    `{"key":"blob","value":"abcdefghij"}` + "\n",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/db/blob", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+		if got := body(rw.Result()); got != expected {
+			t.Errorf("Expected %s with Accept %q, got %s", expected, accept, got)
+		}
+	}
+}
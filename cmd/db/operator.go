@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"net/http"
+	"strings"
+
+	"github.com/LeVasTiaN/KPI_Lab5/apierrors"
+)
+
+var operatorToken = flag.String("operator-token", "", "bearer token the admin and replication endpoints require and standbys send to their leader (required once tenants are enabled)")
+
+// operatorOnly lets through only requests that carry token as their bearer
+// token, or every request if token is empty. It guards the endpoints that
+// reach past tenants: the admin ones and the change feed.
+func operatorOnly(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isOperator(r, token) {
+			apierrors.Write(w, apierrors.New(apierrors.BadRequest, "operator token required").WithStatus(http.StatusUnauthorized))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isOperator reports whether r carries token as its bearer token.
+func isOperator(r *http.Request, token string) bool {
+	presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(presented)), []byte(token)) == 1
+}
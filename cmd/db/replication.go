@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/apierrors"
+	"github.com/LeVasTiaN/KPI_Lab5/datastore"
+)
+
+var (
+	standbyOf     = flag.String("standby-of", "", "base URL of a leader, e.g. http://db:8083, to follow as a read-only standby")
+	standbyPoll   = flag.Duration("standby-poll", 500*time.Millisecond, "how often a standby fetches new writes from its leader")
+	promoteAfter  = flag.Duration("promote-after", 0, "how long the leader may stay unreachable before a standby promotes itself (only manual promotion when 0)")
+	changeLogSize = flag.Int("change-log-size", 10000, "how many recent writes are kept for standbys to catch up from")
+)
+
+// maxChangesPerFetch bounds the writes sent to a standby in one response.
+const maxChangesPerFetch = 1000
+
+// change is a write as the datastore reported it. ExpiresAt is in Unix
+// nanoseconds, 0 for keys that do not expire.
+type change struct {
+	Seq       uint64 `json:"seq"`
+	Key       string `json:"key"`
+	Value     []byte `json:"value"`
+	Int64     bool   `json:"int64,omitempty"`
+	ExpiresAt int64  `json:"expiresAt,omitempty"`
+}
+
+func changeOf(event datastore.Event) change {
+	c := change{Key: event.Key, Value: event.Value, Int64: event.Int64}
+	if !event.ExpiresAt.IsZero() {
+		c.ExpiresAt = event.ExpiresAt.UnixNano()
+	}
+	return c
+}
+
+func (c change) event() datastore.Event {
+	event := datastore.Event{Type: datastore.EventPut, Key: c.Key, Value: c.Value, Int64: c.Int64}
+	if c.ExpiresAt != 0 {
+		event.ExpiresAt = time.Unix(0, c.ExpiresAt)
+	}
+	return event
+}
+
+// changeBatch answers a standby that has applied every change up to some
+// sequence number. Seq is the last one the batch brings it to. A reset
+// batch holds every key instead, for standbys that fell too far behind or
+// follow an earlier run of the leader, which had another epoch.
+type changeBatch struct {
+	Epoch   string   `json:"epoch"`
+	Seq     uint64   `json:"seq"`
+	Reset   bool     `json:"reset"`
+	Changes []change `json:"changes"`
+}
+
+// changeFeed numbers the writes the datastore applies, in the order its
+// writer applies them, and keeps the most recent ones for standbys to
+// fetch. It follows every write, including those of idempotency records
+// and those a standby applies itself.
+type changeFeed struct {
+	db    *datastore.Db
+	epoch string
+	size  int
+
+	mu      sync.Mutex
+	seq     uint64
+	changes []change
+}
+
+func newChangeFeed(db *datastore.Db, size int) *changeFeed {
+	f := &changeFeed{db: db, epoch: newEpoch(), size: max(size, 1)}
+	events, stop := db.Watch("")
+	go f.follow(events, stop)
+	return f
+}
+
+func newEpoch() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// follow records the writes the datastore reports. A watch that falls too
+// far behind is dropped with the writes it missed, so the feed watches
+// again and starts a new epoch, which makes standbys load a snapshot. The
+// watch also ends once the datastore is closed.
+func (f *changeFeed) follow(events <-chan datastore.Event, stop func()) {
+	for {
+		for event := range events {
+			f.record(event)
+		}
+		stop()
+		if f.db.Segments() == nil {
+			return
+		}
+
+		events, stop = f.db.Watch("")
+		f.mu.Lock()
+		f.epoch, f.changes = newEpoch(), nil
+		f.mu.Unlock()
+		log.Printf("Change feed fell behind the datastore, standbys will reload")
+	}
+}
+
+func (f *changeFeed) record(event datastore.Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	c := changeOf(event)
+	c.Seq = f.seq
+	f.changes = append(f.changes, c)
+	// Old changes are dropped in bulk, so that at least size are kept
+	// without moving them on every write.
+	if len(f.changes) >= 2*f.size {
+		f.changes = append([]change(nil), f.changes[len(f.changes)-f.size:]...)
+	}
+}
+
+// since returns the changes after seq after in epoch, or a snapshot when
+// they are no longer kept.
+func (f *changeFeed) since(epoch string, after uint64) (changeBatch, error) {
+	f.mu.Lock()
+	seq, current := f.seq, f.epoch
+	oldest := seq + 1
+	if len(f.changes) > 0 {
+		oldest = f.changes[0].Seq
+	}
+	if epoch == current && after <= seq && after+1 >= oldest {
+		batch := changeBatch{Epoch: current, Seq: after}
+		for _, c := range f.changes {
+			if c.Seq > after && len(batch.Changes) < maxChangesPerFetch {
+				batch.Changes = append(batch.Changes, c)
+				batch.Seq = c.Seq
+			}
+		}
+		f.mu.Unlock()
+		return batch, nil
+	}
+	f.mu.Unlock()
+
+	// Every write up to seq was applied before it was recorded, so the
+	// snapshot holds it or a newer value that a later change repeats.
+	batch := changeBatch{Epoch: current, Seq: seq, Reset: true}
+	it := f.db.Keys()
+	for it.Next() {
+		event, err := it.Event()
+		if err != nil {
+			return changeBatch{}, err
+		}
+		batch.Changes = append(batch.Changes, changeOf(event))
+	}
+	return batch, it.Err()
+}
+
+func (f *changeFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierrors.Write(w, apierrors.New(apierrors.BadRequest, "method %s not allowed", r.Method).WithStatus(http.StatusMethodNotAllowed))
+		return
+	}
+	after, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+	if err != nil && r.URL.Query().Get("after") != "" {
+		apierrors.Write(w, apierrors.New(apierrors.BadRequest, "invalid after parameter: %s", err))
+		return
+	}
+
+	batch, err := f.since(r.URL.Query().Get("epoch"), after)
+	if err != nil {
+		apierrors.Write(w, apiError("", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
+
+// standby follows a leader by applying its change feed, and serves reads
+// but no writes until it is promoted, by hand or once the leader has been
+// unreachable for the promotion delay.
+type standby struct {
+	leader string
+	// token is the operator token of the leader, if it has one.
+	token  string
+	client *http.Client
+	db     *datastore.Db
+
+	epoch    string
+	seq      uint64
+	promoted atomic.Bool
+}
+
+func newStandby(leader, token string, db *datastore.Db) *standby {
+	return &standby{leader: leader, token: token, client: &http.Client{Timeout: 3 * time.Second}, db: db}
+}
+
+// sync fetches and applies one batch of changes.
+func (s *standby) sync(ctx context.Context) error {
+	url := fmt.Sprintf("%s/replication/changes?epoch=%s&after=%d", s.leader, s.epoch, s.seq)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if s.token != "" {
+		request.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apierrors.Decode(resp)
+	}
+
+	var batch changeBatch
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return fmt.Errorf("invalid change batch: %w", err)
+	}
+	if batch.Reset {
+		log.Printf("Loading a snapshot of %d keys from %s", len(batch.Changes), s.leader)
+	}
+	for _, c := range batch.Changes {
+		if err := s.db.Apply(c.event()); err != nil {
+			return err
+		}
+	}
+	s.epoch, s.seq = batch.Epoch, batch.Seq
+	return nil
+}
+
+// run follows the leader until the standby is promoted or done is closed.
+func (s *standby) run(poll, promoteAfter time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	lastContact := time.Now()
+	failing := false
+	for !s.promoted.Load() {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		err := s.sync(context.Background())
+		if err == nil {
+			if failing {
+				log.Printf("Leader %s reachable again", s.leader)
+			}
+			lastContact, failing = time.Now(), false
+			continue
+		}
+		if !failing {
+			log.Printf("Failed to fetch changes from %s: %v", s.leader, err)
+			failing = true
+		}
+		if unreachable := time.Since(lastContact); promoteAfter > 0 && unreachable >= promoteAfter {
+			s.promote(fmt.Sprintf("leader unreachable for %s", unreachable.Round(time.Millisecond)))
+		}
+	}
+}
+
+func (s *standby) promote(reason string) {
+	if s.promoted.CompareAndSwap(false, true) {
+		log.Printf("Promoted to leader: %s", reason)
+	}
+}
+
+func (s *standby) announce(header http.Header) {
+	if !s.promoted.Load() {
+		header.Set("X-Role", "standby")
+	}
+}
+
+// guard rejects writes until the standby is promoted.
+func (s *standby) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !s.promoted.Load() {
+			apierrors.Write(w, apierrors.New(apierrors.Overloaded, "standby does not accept writes"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *standby) servePromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierrors.Write(w, apierrors.New(apierrors.BadRequest, "method %s not allowed", r.Method).WithStatus(http.StatusMethodNotAllowed))
+		return
+	}
+	s.promote("requested")
+	w.WriteHeader(http.StatusOK)
+}
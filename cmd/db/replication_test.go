@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore"
+)
+
+func openMemoryDB(t *testing.T) *datastore.Db {
+	t.Helper()
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// waitForChanges waits until feed has recorded n writes.
+func waitForChanges(t *testing.T, feed *changeFeed, n uint64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		feed.mu.Lock()
+		seq := feed.seq
+		feed.mu.Unlock()
+		if seq >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the feed to record %d writes, got %d", n, seq)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStandby(t *testing.T) {
+	leaderDB := openMemoryDB(t)
+	feed := newChangeFeed(leaderDB, 2)
+	mux := http.NewServeMux()
+	mux.Handle("/db/", &dbHandler{db: leaderDB})
+	mux.Handle("/replication/changes", feed)
+	leader := httptest.NewServer(mux)
+	defer leader.Close()
+
+	put := func(handler http.Handler, key, value string) int {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/db/"+key, strings.NewReader(`{"value":"`+value+`"}`)))
+		return rw.Code
+	}
+	put(mux, "a", "1")
+
+	standbyDB := openMemoryDB(t)
+	replica := newStandby(leader.URL, "", standbyDB)
+	written := uint64(1)
+	assertValue := func(key, expected string) {
+		t.Helper()
+		waitForChanges(t, feed, written)
+		if err := replica.sync(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := standbyDB.Get(key); err != nil || value != expected {
+			t.Errorf("Expected %s=%s on the standby, got %q, %v", key, expected, value, err)
+		}
+	}
+	assertValue("a", "1")
+
+	put(mux, "b", "2")
+	written++
+	assertValue("b", "2")
+
+	// More writes than the feed keeps make the standby load a snapshot.
+	for _, value := range []string{"3", "4", "5", "6"} {
+		put(mux, "a", value)
+		written++
+	}
+	assertValue("a", "6")
+	if replica.seq != 6 {
+		t.Errorf("Expected the standby to catch up to 6, got %d", replica.seq)
+	}
+
+	guarded := replica.guard(&dbHandler{db: standbyDB})
+	if code := put(guarded, "c", "1"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a standby to reject writes, got %d", code)
+	}
+	rw := httptest.NewRecorder()
+	replica.servePromote(rw, httptest.NewRequest(http.MethodPost, "/replication/promote", nil))
+	if code := put(guarded, "c", "1"); rw.Code != http.StatusOK || code != http.StatusOK {
+		t.Errorf("Expected writes after promotion, got %d and %d", rw.Code, code)
+	}
+}
+
+func TestChangeFeed_OperatorToken(t *testing.T) {
+	leaderDB := openMemoryDB(t)
+	if err := leaderDB.Put(tenantKeyPrefix+"team-a/secret", "v"); err != nil {
+		t.Fatal(err)
+	}
+	leader := httptest.NewServer(operatorOnly("s3cret", newChangeFeed(leaderDB, 8)))
+	defer leader.Close()
+
+	for _, authorization := range []string{"", "Bearer wrong"} {
+		req, _ := http.NewRequest(http.MethodGet, leader.URL+"/replication/changes", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := leader.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected the feed to refuse %q, got %d", authorization, resp.StatusCode)
+		}
+	}
+
+	if err := newStandby(leader.URL, "wrong", openMemoryDB(t)).sync(t.Context()); err == nil {
+		t.Error("Expected a standby with the wrong token to be refused")
+	}
+	standbyDB := openMemoryDB(t)
+	if err := newStandby(leader.URL, "s3cret", standbyDB).sync(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := standbyDB.Get(tenantKeyPrefix + "team-a/secret"); err != nil || value != "v" {
+		t.Errorf("Expected the standby with the operator token to follow, got %q, %v", value, err)
+	}
+}
+
+func TestStandby_PromotesWhenLeaderIsGone(t *testing.T) {
+	leader := httptest.NewServer(http.NotFoundHandler())
+	leader.Close()
+
+	db := openMemoryDB(t)
+	replica := newStandby(leader.URL, "", db)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		replica.run(time.Millisecond, 20*time.Millisecond, done)
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		close(done)
+		t.Fatal("Expected the standby to promote itself")
+	}
+	if !replica.promoted.Load() {
+		t.Error("Expected the standby to be promoted")
+	}
+}
+
+func TestStandby_ConcurrentWrites(t *testing.T) {
+	leaderDB := openMemoryDB(t)
+	feed := newChangeFeed(leaderDB, 64)
+	mux := http.NewServeMux()
+	mux.Handle("/db/", newIdempotentWrites(leaderDB, &dbHandler{db: leaderDB}))
+	mux.Handle("/replication/changes", feed)
+	leader := httptest.NewServer(mux)
+	defer leader.Close()
+
+	following := newStandby(leader.URL, "", openMemoryDB(t))
+	stopSync := make(chan struct{})
+	synced := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case <-stopSync:
+				synced <- nil
+				return
+			default:
+			}
+			if err := following.sync(t.Context()); err != nil {
+				synced <- err
+				return
+			}
+		}
+	}()
+
+	const writers, writes, increments = 8, 50, 20
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				rw := httptest.NewRecorder()
+				mux.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/db/k", strings.NewReader(fmt.Sprintf(`{"value":"%d-%d"}`, w, i))))
+				if rw.Code != http.StatusOK {
+					t.Errorf("Expected the write to succeed, got %d", rw.Code)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < increments; i++ {
+			if _, err := leaderDB.Increment("n", 1); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	request := httptest.NewRequest(http.MethodPost, "/db/once", strings.NewReader(`{"value":"1"}`))
+	request.Header.Set(idempotencyHeader, "retry-1")
+	mux.ServeHTTP(httptest.NewRecorder(), request)
+	// Every other write was applied before the last one, so a standby that
+	// has it has them all, whether from changes or from a snapshot.
+	if err := leaderDB.Put("last", "1"); err != nil {
+		t.Fatal(err)
+	}
+	close(stopSync)
+	if err := <-synced; err != nil {
+		t.Fatal(err)
+	}
+
+	fresh := newStandby(leader.URL, "", openMemoryDB(t))
+	for name, replica := range map[string]*standby{"following": following, "fresh": fresh} {
+		deadline := time.Now().Add(2 * time.Second)
+		for found := false; !found; found, _ = replica.db.Has("last") {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: expected the standby to catch up", name)
+			}
+			if err := replica.sync(t.Context()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, _ := leaderDB.Get("k")
+		if value, err := replica.db.Get("k"); err != nil || value != expected {
+			t.Errorf("%s: expected k=%s like the leader, got %q (%v)", name, expected, value, err)
+		}
+		if n, err := replica.db.GetInt64("n"); err != nil || n != increments {
+			t.Errorf("%s: expected the int64 n=%d, got %d (%v)", name, increments, n, err)
+		}
+		if found, _ := replica.db.Has(idempotencyPrefix + "retry-1"); !found {
+			t.Errorf("%s: expected the idempotency record to be replicated", name)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/LeVasTiaN/KPI_Lab5/apierrors"
+)
+
+// Keys of a tenant are stored under tenantKeyPrefix, the tenant ID and a
+// slash. Tenant IDs cannot contain a slash, so no tenant can reach the keys
+// of another one.
+const tenantKeyPrefix = "tenant:"
+
+var (
+	tenantHeader = flag.String("tenant-header", "", "request header naming the tenant whose keys a request works with (tenants are disabled unless this or -tenant-tokens is set)")
+	tenantTokens = flag.String("tenant-tokens", "", "file with one \"<bearer token> <tenant>\" pair per line; tokens take precedence over the tenant header")
+
+	validTenant = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+type tenantContextKey struct{}
+
+// tenantScope finds the tenant of every request and rejects requests
+// without one, so that handlers can scope keys with scopedKey.
+type tenantScope struct {
+	header string
+	tokens map[string]string
+	next   http.Handler
+	// operator is a bearer token that lets a request through unscoped,
+	// for endpoints that serve operators as well as tenants. Requests
+	// without it have to name a tenant like everywhere else.
+	operator string
+}
+
+func newTenantScope(header string, tokens map[string]string, next http.Handler) *tenantScope {
+	return &tenantScope{header: header, tokens: tokens, next: next}
+}
+
+func (s *tenantScope) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isOperator(r, s.operator) {
+		s.next.ServeHTTP(w, r)
+		return
+	}
+	tenant, err := s.tenant(r)
+	if err != nil {
+		apierrors.Write(w, apierrors.New(apierrors.BadRequest, "%s", err).WithStatus(http.StatusUnauthorized))
+		return
+	}
+	s.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, tenant)))
+}
+
+func (s *tenantScope) tenant(r *http.Request) (string, error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && len(s.tokens) > 0 {
+		tenant, known := s.tokens[strings.TrimSpace(token)]
+		if !known {
+			return "", fmt.Errorf("unknown token")
+		}
+		return tenant, nil
+	}
+	if s.header != "" {
+		tenant := r.Header.Get(s.header)
+		if !validTenant.MatchString(tenant) {
+			return "", fmt.Errorf("missing or invalid %s header", s.header)
+		}
+		return tenant, nil
+	}
+	return "", fmt.Errorf("missing bearer token")
+}
+
+// scopedKey returns the key the datastore holds key under for the tenant of
+// the request, or key itself when tenants are disabled.
+func scopedKey(ctx context.Context, key string) string {
+	tenant, ok := tenantOf(ctx)
+	if !ok {
+		return key
+	}
+	return tenantKeyPrefix + tenant + "/" + key
+}
+
+// tenantOf returns the tenant a tenant scope found for the request.
+func tenantOf(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantContextKey{}).(string)
+	return tenant, ok
+}
+
+// loadTenantTokens reads the token file given to -tenant-tokens.
+func loadTenantTokens(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tokens := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 || !validTenant.MatchString(fields[1]) {
+			return nil, fmt.Errorf("%s:%d: expected a token and a tenant", path, line)
+		}
+		tokens[fields[0]] = fields[1]
+	}
+	return tokens, scanner.Err()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore"
+)
+
+func TestTenantScope(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tokens := map[string]string{"secret-a": "team-a"}
+	handler := newTenantScope("X-Tenant", tokens, newIdempotentWrites(db, &dbHandler{db: db}))
+
+	send := func(method, tenant, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/db/shared", strings.NewReader(body))
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := send(http.MethodPost, "team-b", "", `{"value":"b"}`); rw.Code != http.StatusOK {
+		t.Fatalf("Expected the write of team-b to succeed, got %d", rw.Code)
+	}
+	if rw := send(http.MethodPost, "", "secret-a", `{"value":"a"}`); rw.Code != http.StatusOK {
+		t.Fatalf("Expected the write of team-a to succeed, got %d", rw.Code)
+	}
+
+	if rw := send(http.MethodGet, "team-b", "", ""); !strings.Contains(rw.Body.String(), `"value":"b"`) || !strings.Contains(rw.Body.String(), `"key":"shared"`) {
+		t.Errorf("Expected team-b to read its own value under its own key name, got %s", rw.Body)
+	}
+	if rw := send(http.MethodGet, "team-a", "", ""); !strings.Contains(rw.Body.String(), `"value":"a"`) {
+		t.Errorf("Expected team-a to read the value written with its token, got %s", rw.Body)
+	}
+	if rw := send(http.MethodGet, "team-c", "", ""); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected team-c not to see other tenants' keys, got %d", rw.Code)
+	}
+	if value, err := db.Get(tenantKeyPrefix + "team-b/shared"); err != nil || value != "b" {
+		t.Errorf("Expected the key to be stored under the tenant prefix, got %q (%v)", value, err)
+	}
+
+	for name, rw := range map[string]*httptest.ResponseRecorder{
+		"no tenant":      send(http.MethodGet, "", "", ""),
+		"unknown token":  send(http.MethodGet, "team-a", "guess", ""),
+		"invalid tenant": send(http.MethodGet, "team/a", "", ""),
+	} {
+		if rw.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", name, rw.Code)
+		}
+	}
+}
+
+func TestTenantScope_IdempotencyKeys(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	handler := newTenantScope("X-Tenant", nil, newIdempotentWrites(db, &dbHandler{db: db}))
+
+	for _, tenant := range []string{"team-a", "team-b"} {
+		req := httptest.NewRequest(http.MethodPost, "/db/key", strings.NewReader(`{"value":"`+tenant+`"}`))
+		req.Header.Set("X-Tenant", tenant)
+		req.Header.Set(idempotencyHeader, "same")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if rw.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("Expected the idempotency key of %s not to replay another tenant's write", tenant)
+		}
+	}
+	if value, err := db.Get(tenantKeyPrefix + "team-b/key"); err != nil || value != "team-b" {
+		t.Errorf("Expected the write of team-b to be applied, got %q (%v)", value, err)
+	}
+}
+
+func TestLoadTenantTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("# token tenant\nsecret-a team-a\n\nsecret-b team-b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := loadTenantTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens["secret-b"] != "team-b" {
+		t.Errorf("Unexpected tokens %v", tokens)
+	}
+
+	if err := os.WriteFile(path, []byte("secret-a team/a\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTenantTokens(path); err == nil {
+		t.Error("Expected an invalid tenant to be rejected")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/LeVasTiaN/KPI_Lab5/apierrors"
+	"github.com/LeVasTiaN/KPI_Lab5/datastore"
+)
+
+// watchHandler streams the keys written from now on, optionally only those
+// with the prefix parameter, as server-sent events: a put event per write,
+// with {"key": ...} as its data. The stream ends when the watch falls too
+// far behind, the datastore closes or done is closed, after which a client
+// has to reread the keys it cares about, since it may have missed writes.
+func watchHandler(db *datastore.Db, done <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierrors.Write(w, apierrors.New(apierrors.BadRequest, "method %s not allowed", r.Method).WithStatus(http.StatusMethodNotAllowed))
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			apierrors.Write(w, apierrors.New(apierrors.Internal, "streaming is not supported"))
+			return
+		}
+
+		scope := scopedKey(r.Context(), "")
+		events, stop := db.Watch(scopedKey(r.Context(), r.URL.Query().Get("prefix")))
+		defer stop()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-done:
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if strings.HasPrefix(event.Key, idempotencyPrefix) {
+					continue
+				}
+				data, err := json.Marshal(map[string]string{"key": strings.TrimPrefix(event.Key, scope)})
+				if err != nil {
+					return
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore"
+)
+
+// watchLines opens the watch stream of server and returns its data lines.
+func watchLines(t *testing.T, server *httptest.Server, tenant string) <-chan string {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/watch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant != "" {
+		req.Header.Set("X-Tenant", tenant)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected response %d, %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				lines <- data
+			}
+		}
+	}()
+	return lines
+}
+
+func expectLine(t *testing.T, lines <-chan string, expected string) {
+	t.Helper()
+	select {
+	case line := <-lines:
+		if line != expected {
+			t.Errorf("Expected %s, got %s", expected, line)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected %s, got nothing", expected)
+	}
+}
+
+func TestWatchHandler(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	done := make(chan struct{})
+	server := httptest.NewServer(watchHandler(db, done))
+	defer server.Close()
+
+	lines := watchLines(t, server, "")
+	if err := db.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL(idempotencyPrefix+"a", "{}", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	expectLine(t, lines, `{"key":"a"}`)
+	expectLine(t, lines, `{"key":"b"}`)
+
+	close(done)
+	select {
+	case line, ok := <-lines:
+		if ok {
+			t.Errorf("Expected the stream to end, got %s", line)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the stream to end once done is closed")
+	}
+}
+
+func TestWatchHandler_Tenants(t *testing.T) {
+	db, err := datastore.Open("", datastore.WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	done := make(chan struct{})
+	server := httptest.NewServer(newTenantScope("X-Tenant", nil, watchHandler(db, done)))
+	defer server.Close()
+	defer close(done)
+
+	lines := watchLines(t, server, "team-a")
+	for _, key := range []string{tenantKeyPrefix + "team-b/user:1", "user:1", tenantKeyPrefix + "team-a/user:1"} {
+		if err := db.Put(key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLine(t, lines, `{"key":"user:1"}`)
+	select {
+	case line := <-lines:
+		t.Errorf("Expected team-a to see only its own key, got %s", line)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	resp, err := server.Client().Get(server.URL + "/watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a watch without a tenant to be refused, got %d", resp.StatusCode)
+	}
+}
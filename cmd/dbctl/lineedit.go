@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Control characters the line editor handles.
+const (
+	keyInterrupt = 3
+	keyEOF       = 4
+	keyBackspace = 8
+	keyTab       = 9
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// lineReader reads command lines. On a terminal it edits them itself in raw
+// mode to complete words with Tab; otherwise it reads plain lines without
+// a prompt, so that commands can be piped in.
+type lineReader struct {
+	in       *bufio.Reader
+	out      io.Writer
+	complete func(line string) []string
+	// restore puts the terminal back in its original mode; it is nil if
+	// input is not a terminal.
+	restore func()
+}
+
+func newLineReader(stdin io.Reader, stdout io.Writer, complete func(string) []string) *lineReader {
+	r := &lineReader{in: bufio.NewReader(stdin), out: stdout, complete: complete}
+	if file, ok := stdin.(*os.File); ok {
+		if restore, err := makeRaw(int(file.Fd())); err == nil {
+			r.restore = restore
+		}
+	}
+	return r
+}
+
+func (r *lineReader) Close() {
+	if r.restore != nil {
+		r.restore()
+	}
+}
+
+// ReadLine returns the next line, or io.EOF once input ends.
+func (r *lineReader) ReadLine(prompt string) (string, error) {
+	if r.restore != nil {
+		return r.edit(prompt)
+	}
+	line, err := r.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// edit reads a line key by key. Ctrl-C drops the line, Ctrl-D on an empty
+// line ends input, and escape sequences such as the arrow keys are ignored.
+func (r *lineReader) edit(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	var line []byte
+	for {
+		b, err := r.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case '\r', '\n':
+			fmt.Fprint(r.out, "\r\n")
+			return string(line), nil
+		case keyEOF:
+			if len(line) == 0 {
+				fmt.Fprint(r.out, "\r\n")
+				return "", io.EOF
+			}
+		case keyInterrupt:
+			fmt.Fprint(r.out, "^C\r\n"+prompt)
+			line = line[:0]
+		case keyBackspace, keyDelete:
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRune(line)
+				line = line[:len(line)-size]
+				fmt.Fprint(r.out, "\b \b")
+			}
+		case keyTab:
+			line = r.completeLine(prompt, line)
+		case keyEscape:
+			r.skipEscape()
+		default:
+			if b >= ' ' {
+				line = append(line, b)
+				r.out.Write([]byte{b})
+			}
+		}
+	}
+}
+
+// completeLine completes the last word of line as far as the candidates
+// agree, and lists them when they do not.
+func (r *lineReader) completeLine(prompt string, line []byte) []byte {
+	candidates := r.complete(string(line))
+	if len(candidates) == 0 {
+		return line
+	}
+	word := string(line[strings.LastIndexByte(string(line), ' ')+1:])
+	completion := commonPrefix(candidates)
+	if len(candidates) == 1 {
+		completion += " "
+	}
+	if len(completion) > len(word) {
+		added := completion[len(word):]
+		fmt.Fprint(r.out, added)
+		return append(line, added...)
+	}
+	fmt.Fprintf(r.out, "\r\n%s\r\n%s%s", strings.Join(candidates, "  "), prompt, line)
+	return line
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// skipEscape consumes the rest of a CSI or SS3 escape sequence.
+func (r *lineReader) skipEscape() {
+	b, err := r.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return
+	}
+	for {
+		b, err := r.in.ReadByte()
+		if err != nil || (b >= 0x40 && b <= 0x7e) {
+			return
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: dbctl shell (-dir DIR | -url URL) [-format raw|json|table]
+
+Opens an interactive prompt with get, put, del, scan and stats commands on
+a data directory, which no server may have open at the same time, or on the
+HTTP service of a db server.`
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "shell" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	os.Exit(shell(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+}
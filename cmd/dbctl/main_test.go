@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const script = `put user:2 bob
+put user:1 alice smith
+get user:1
+scan user:
+format raw
+get user:1
+scan user:
+format json
+get user:1
+stats
+del user:1
+frobnicate
+exit
+get user:2
+`
+
+func TestShell(t *testing.T) {
+	expected := `OK
+OK
+KEY     VALUE
+user:1  alice smith
+KEY     VALUE
+user:1  alice smith
+user:2  bob
+alice smith
+user:1	alice smith
+user:2	bob
+{
+  "key": "user:1",
+  "value": "alice smith"
+}
+`
+	run := func(t *testing.T, args ...string) {
+		var stdout, stderr bytes.Buffer
+		if code := shell(args, strings.NewReader(script), &stdout, &stderr); code != 0 {
+			t.Fatalf("Expected success, got %d: %s", code, stderr.String())
+		}
+		if !strings.HasPrefix(stdout.String(), expected) {
+			t.Errorf("Unexpected output:\n%s", stdout.String())
+		}
+		var stats map[string]any
+		if err := json.NewDecoder(strings.NewReader(strings.TrimPrefix(stdout.String(), expected))).Decode(&stats); err != nil || stats["Keys"] != 2.0 {
+			t.Errorf("Expected the stats of 2 keys after the get, got %v (%v)", stats, err)
+		}
+		errors := stderr.String()
+		if !strings.Contains(errors, "error: "+errNoDelete.Error()) || !strings.Contains(errors, `unknown command "frobnicate"`) {
+			t.Errorf("Expected errors for del and an unknown command, got:\n%s", errors)
+		}
+	}
+
+	t.Run("data directory", func(t *testing.T) {
+		run(t, "-dir", t.TempDir())
+	})
+
+	t.Run("db service", func(t *testing.T) {
+		server := httptest.NewServer(fakeService())
+		defer server.Close()
+		run(t, "-url", server.URL)
+	})
+}
+
+// fakeService serves the parts of the db service the shell uses.
+func fakeService() http.Handler {
+	values := map[string]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/db/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/db/")
+		if r.Method == http.MethodPost {
+			var body struct{ Value string }
+			json.NewDecoder(r.Body).Decode(&body)
+			values[key] = body.Value
+			return
+		}
+		value, ok := values[key]
+		if !ok {
+			http.Error(w, `{"code":"not_found","message":"key not found"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"key": key, "value": value})
+	})
+	mux.HandleFunc("/admin/scan", func(w http.ResponseWriter, r *http.Request) {
+		pairs := []pair{}
+		for _, key := range []string{"session:1", "user:1", "user:2"} {
+			if value, ok := values[key]; ok && strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+				pairs = append(pairs, pair{Key: key, Value: value})
+			}
+		}
+		json.NewEncoder(w).Encode(pairs)
+	})
+	mux.HandleFunc("/admin/stats", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"Keys":%d,"Compaction":{"Running":false}}`, len(values))
+	})
+	return mux
+}
+
+func TestShell_Usage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"-dir", "a", "-url", "http://b"},
+		{"-dir", "a", "-format", "xml"},
+	} {
+		if code := shell(args, strings.NewReader(""), io.Discard, io.Discard); code != 2 {
+			t.Errorf("Expected a usage error for %v, got %d", args, code)
+		}
+	}
+}
+
+func TestShell_StatsTable(t *testing.T) {
+	session := &session{format: "table", out: new(bytes.Buffer)}
+	session.printStats(map[string]any{"Keys": 3, "Compaction": map[string]any{"Running": true}})
+	expected := "FIELD               VALUE\nCompaction.Running  true\nKeys                3\n"
+	if output := session.out.(*bytes.Buffer).String(); output != expected {
+		t.Errorf("Unexpected table:\n%s", output)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected []string
+	}{
+		{"", commands},
+		{"s", []string{"scan", "stats"}},
+		{"ge", []string{"get"}},
+		{"get ", nil},
+		{"format ", formats},
+		{"format j", []string{"json"}},
+	}
+	for _, tc := range cases {
+		if matches := complete(tc.line); fmt.Sprint(matches) != fmt.Sprint(tc.expected) {
+			t.Errorf("complete(%q) = %v, expected %v", tc.line, matches, tc.expected)
+		}
+	}
+}
+
+func TestLineReader_Edit(t *testing.T) {
+	var out bytes.Buffer
+	keys := "ge\tuser:x\x7f1\x1b[A\r" + "s\t\x03st\t\r" + "\x04"
+	reader := &lineReader{in: bufio.NewReader(strings.NewReader(keys)), out: &out, complete: complete, restore: func() {}}
+
+	for _, expected := range []string{"get user:1", "stats "} {
+		line, err := reader.ReadLine("db> ")
+		if err != nil || line != expected {
+			t.Errorf("Expected %q, got %q (%v)", expected, line, err)
+		}
+	}
+	if _, err := reader.ReadLine("db> "); err != io.EOF {
+		t.Errorf("Expected Ctrl-D to end input, got %v", err)
+	}
+	if !strings.Contains(out.String(), "scan  stats") {
+		t.Errorf("Expected the candidates to be listed, got %q", out.String())
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	commands = []string{"get", "put", "del", "scan", "stats", "format", "help", "exit"}
+	formats  = []string{"raw", "json", "table"}
+)
+
+const help = `commands:
+  get KEY         print the value of KEY
+  put KEY VALUE   set KEY to VALUE, which is the rest of the line
+  del KEY         delete KEY
+  scan [PREFIX]   list the keys with PREFIX and their values in key order
+  stats           print the statistics of the datastore
+  format [NAME]   print or set the output format: raw, json or table
+  help            print this help
+  exit            leave the shell (or press Ctrl-D)`
+
+func shell(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("shell", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	dir := flags.String("dir", "", "data directory to open")
+	target := flags.String("url", "", "base URL of a db server, e.g. http://localhost:8083")
+	format := flags.String("format", "table", "output format: raw, json or table")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if (*dir == "") == (*target == "") {
+		fmt.Fprintln(stderr, "exactly one of -dir and -url is required")
+		return 2
+	}
+	if !slices.Contains(formats, *format) {
+		fmt.Fprintf(stderr, "unknown format %q\n", *format)
+		return 2
+	}
+
+	var s store
+	if *dir != "" {
+		local, err := openLocal(*dir)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		s = local
+	} else {
+		s = newRemote(*target)
+	}
+	defer s.Close()
+
+	session := &session{store: s, format: *format, out: stdout}
+	lines := newLineReader(stdin, stdout, complete)
+	defer lines.Close()
+	for {
+		line, err := lines.ReadLine("db> ")
+		if err == io.EOF {
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		exit, err := session.run(line)
+		if err != nil {
+			fmt.Fprintf(stderr, "error: %s\n", err)
+		}
+		if exit {
+			return 0
+		}
+	}
+}
+
+type session struct {
+	store  store
+	format string
+	out    io.Writer
+}
+
+// run executes one command line and reports whether the shell should end.
+func (s *session) run(line string) (bool, error) {
+	command, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	rest = strings.TrimSpace(rest)
+	switch command {
+	case "":
+	case "get":
+		if rest == "" || strings.Contains(rest, " ") {
+			return false, errors.New("usage: get KEY")
+		}
+		value, err := s.store.Get(rest)
+		if err != nil {
+			return false, err
+		}
+		s.printPairs([]pair{{Key: rest, Value: value}}, true)
+	case "put":
+		key, value, ok := strings.Cut(rest, " ")
+		if !ok || key == "" {
+			return false, errors.New("usage: put KEY VALUE")
+		}
+		if err := s.store.Put(key, strings.TrimSpace(value)); err != nil {
+			return false, err
+		}
+		s.printDone()
+	case "del":
+		if rest == "" || strings.Contains(rest, " ") {
+			return false, errors.New("usage: del KEY")
+		}
+		if err := s.store.Delete(rest); err != nil {
+			return false, err
+		}
+		s.printDone()
+	case "scan":
+		pairs, err := s.store.Scan(rest)
+		if err != nil {
+			return false, err
+		}
+		s.printPairs(pairs, false)
+	case "stats":
+		stats, err := s.store.Stats()
+		if err != nil {
+			return false, err
+		}
+		s.printStats(stats)
+	case "format":
+		if rest == "" {
+			fmt.Fprintln(s.out, s.format)
+			break
+		}
+		if !slices.Contains(formats, rest) {
+			return false, fmt.Errorf("unknown format %q, expected one of %s", rest, strings.Join(formats, ", "))
+		}
+		s.format = rest
+	case "help":
+		fmt.Fprintln(s.out, help)
+	case "exit", "quit":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown command %q, type help for the list", command)
+	}
+	return false, nil
+}
+
+// printPairs prints keys and their values. Raw output of a single get is
+// the bare value, so that it can be piped on.
+func (s *session) printPairs(pairs []pair, single bool) {
+	switch s.format {
+	case "json":
+		if single {
+			s.printJSON(pairs[0])
+			return
+		}
+		if pairs == nil {
+			pairs = []pair{}
+		}
+		s.printJSON(pairs)
+	case "table":
+		w := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE")
+		for _, p := range pairs {
+			fmt.Fprintf(w, "%s\t%s\n", p.Key, p.Value)
+		}
+		w.Flush()
+	default:
+		for _, p := range pairs {
+			if single {
+				fmt.Fprintln(s.out, p.Value)
+			} else {
+				fmt.Fprintf(s.out, "%s\t%s\n", p.Key, p.Value)
+			}
+		}
+	}
+}
+
+func (s *session) printDone() {
+	if s.format == "json" {
+		s.printJSON(map[string]bool{"ok": true})
+		return
+	}
+	fmt.Fprintln(s.out, "OK")
+}
+
+// printStats prints nested statistics as dotted names in raw and table
+// output.
+func (s *session) printStats(stats map[string]any) {
+	if s.format == "json" {
+		s.printJSON(stats)
+		return
+	}
+	fields := flatten("", stats, nil)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if s.format == "table" {
+		w := tabwriter.NewWriter(s.out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "FIELD\tVALUE")
+		for _, name := range names {
+			fmt.Fprintf(w, "%s\t%v\n", name, fields[name])
+		}
+		w.Flush()
+		return
+	}
+	for _, name := range names {
+		fmt.Fprintf(s.out, "%s=%v\n", name, fields[name])
+	}
+}
+
+func flatten(prefix string, object map[string]any, fields map[string]any) map[string]any {
+	if fields == nil {
+		fields = make(map[string]any)
+	}
+	for name, value := range object {
+		if nested, ok := value.(map[string]any); ok {
+			flatten(prefix+name+".", nested, fields)
+			continue
+		}
+		fields[prefix+name] = value
+	}
+	return fields
+}
+
+func (s *session) printJSON(v any) {
+	encoder := json.NewEncoder(s.out)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// complete returns the words that can complete the last word of line: the
+// commands for the first word, and the formats after format.
+func complete(line string) []string {
+	fields := strings.Fields(line)
+	if strings.HasSuffix(line, " ") || line == "" {
+		fields = append(fields, "")
+	}
+
+	var candidates []string
+	switch {
+	case len(fields) == 1:
+		candidates = commands
+	case len(fields) == 2 && fields[0] == "format":
+		candidates = formats
+	}
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, fields[len(fields)-1]) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/apierrors"
+	"github.com/LeVasTiaN/KPI_Lab5/datastore"
+)
+
+// maxScanKeys bounds the keys one scan lists, like the db service does.
+const maxScanKeys = 1000
+
+var errNoDelete = errors.New("the datastore does not support deleting keys")
+
+type pair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// store is what the shell reads and writes: a data directory opened in
+// process, or the HTTP service of a db server.
+type store interface {
+	Get(key string) (string, error)
+	Put(key, value string) error
+	Delete(key string) error
+	Scan(prefix string) ([]pair, error)
+	// Stats returns the statistics of the datastore as decoded from JSON.
+	Stats() (map[string]any, error)
+	Close() error
+}
+
+type localStore struct {
+	db *datastore.Db
+}
+
+func openLocal(dir string) (*localStore, error) {
+	db, err := datastore.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &localStore{db: db}, nil
+}
+
+func (s *localStore) Get(key string) (string, error) {
+	return s.db.Get(key)
+}
+
+func (s *localStore) Put(key, value string) error {
+	return s.db.Put(key, value)
+}
+
+func (s *localStore) Delete(string) error {
+	return errNoDelete
+}
+
+func (s *localStore) Scan(prefix string) ([]pair, error) {
+	var pairs []pair
+	it := s.db.Scan(prefix, datastore.WithOrder(datastore.KeyOrder))
+	for len(pairs) < maxScanKeys && it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", it.Key(), err)
+		}
+		pairs = append(pairs, pair{Key: it.Key(), Value: value})
+	}
+	return pairs, it.Err()
+}
+
+func (s *localStore) Stats() (map[string]any, error) {
+	stats, err := s.db.Stats()
+	if err != nil {
+		return nil, err
+	}
+	return asJSONObject(stats)
+}
+
+func (s *localStore) Close() error {
+	return s.db.Close()
+}
+
+// asJSONObject converts v to what decoding its JSON encoding into a map
+// gives, so that local and remote statistics print the same.
+func asJSONObject(v any) (map[string]any, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	err = decoder.Decode(&object)
+	return object, err
+}
+
+type remoteStore struct {
+	base   string
+	client *http.Client
+}
+
+func newRemote(base string) *remoteStore {
+	return &remoteStore{base: strings.TrimSuffix(base, "/"), client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *remoteStore) Get(key string) (string, error) {
+	var body struct {
+		Value string `json:"value"`
+	}
+	err := s.do(http.MethodGet, "/db/"+url.PathEscape(key), nil, &body)
+	return body.Value, err
+}
+
+func (s *remoteStore) Put(key, value string) error {
+	request, _ := json.Marshal(map[string]string{"value": value})
+	return s.do(http.MethodPost, "/db/"+url.PathEscape(key), request, nil)
+}
+
+func (s *remoteStore) Delete(string) error {
+	return errNoDelete
+}
+
+func (s *remoteStore) Scan(prefix string) ([]pair, error) {
+	var pairs []pair
+	err := s.do(http.MethodGet, "/admin/scan?prefix="+url.QueryEscape(prefix), nil, &pairs)
+	return pairs, err
+}
+
+func (s *remoteStore) Stats() (map[string]any, error) {
+	var stats map[string]any
+	err := s.do(http.MethodGet, "/admin/stats", nil, &stats)
+	return stats, err
+}
+
+func (s *remoteStore) Close() error {
+	return nil
+}
+
+// do sends a request with a JSON body and decodes the JSON response into
+// result, or the error body of a failed request.
+func (s *remoteStore) do(method, path string, body []byte, result any) error {
+	req, err := http.NewRequest(method, s.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return apierrors.Decode(resp)
+	}
+	if result == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	return decoder.Decode(result)
+}
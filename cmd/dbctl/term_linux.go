@@ -0,0 +1,33 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// makeRaw turns off echo and line buffering on the terminal fd, so that
+// the shell sees every key, and returns the function that undoes it. It
+// fails if fd is not a terminal. Output processing stays on.
+func makeRaw(fd int) (func(), error) {
+	var original syscall.Termios
+	if err := ioctlTermios(fd, syscall.TCGETS, &original); err != nil {
+		return nil, err
+	}
+	raw := original
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctlTermios(fd, syscall.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() { _ = ioctlTermios(fd, syscall.TCSETS, &original) }, nil
+}
+
+func ioctlTermios(fd int, request uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// makeRaw is only implemented on Linux. Elsewhere the shell reads whole
+// lines from the terminal, without completion.
+func makeRaw(int) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
@@ -2,24 +2,39 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
-	"hash/fnv"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
+	"github.com/LeVasTiaN/KPI_Lab5/apierrors"
 	"github.com/LeVasTiaN/KPI_Lab5/httptools"
 	"github.com/LeVasTiaN/KPI_Lab5/signal"
+	"github.com/LeVasTiaN/KPI_Lab5/strategy"
 )
 
 var (
 	port       = flag.Int("port", 8090, "load balancer port")
-	timeoutSec = flag.Int("timeout-sec", 3, "request timeout time in seconds")
+	timeoutSec = flag.Int("timeout-sec", 3, "health probe timeout in seconds")
 	https      = flag.Bool("https", false, "whether backends support HTTPs")
 
+	metricsPort = flag.Int("metrics-port", 0, "port to expose balancer metrics on (disabled when 0)")
+
+	strategyName = flag.String("strategy", "hash", "backend selection strategy: hash or random")
+	strategySeed = flag.Int64("strategy-seed", 0, "seed for randomized strategies (0 uses the current time)")
+
 	traceEnabled = flag.Bool("trace", false, "whether to include client info in responses")
+
+	tlsCertFile       = flag.String("tls-cert", "", "path to the TLS certificate for frontend termination")
+	tlsKeyFile        = flag.String("tls-key", "", "path to the TLS private key for frontend termination")
+	tlsReloadInterval = flag.Duration("tls-reload-interval", 30*time.Second, "how often to check certificate files for changes")
 )
 
 var (
@@ -32,28 +47,62 @@ var (
 )
 
 type ServerConnections struct {
-	address string
-	health  bool
+	address   string
+	health    bool
+	status    probeStatus
+	checkedAt time.Time
+
+	// weight is the share of traffic the backend announced, reached
+	// gradually from weightFrom starting at weightSince.
+	weight      int
+	weightFrom  int
+	weightSince time.Time
 }
 
 type LoadBalancer struct {
-	servers []ServerConnections
+	mu         sync.RWMutex
+	servers    []ServerConnections
+	strategy   strategy.Strategy
+	clientInfo strategy.ClientInfoFunc
+	cache      responseCache
+	peers      *sharedHealth
+	// fallbacks answers requests while no backend is healthy; nil leaves
+	// them with a bare 503.
+	fallbacks *fallbacks
+	// roll returns a random number in [0, n) for weighted admission.
+	roll func(n int) int
+	// decisions logs routing choices when set.
+	decisions *decisionLog
+	// admission queues requests by the class classifier assigns them over
+	// -max-concurrent; nil admits every request right away.
+	admission  *admission
+	classifier classifier
+	// clock and prober replace time.Now and probeWeight in simulations.
+	clock  func() time.Time
+	prober func(dst string) (probeStatus, int)
 }
 
 func NewLoadBalancer() *LoadBalancer {
 	servers := make([]ServerConnections, len(serversPool))
 	for i, server := range serversPool {
 		servers[i] = ServerConnections{
-			address: server,
-			health:  false,
+			address:    server,
+			health:     false,
+			weight:     fullWeight,
+			weightFrom: fullWeight,
 		}
 	}
 	return &LoadBalancer{
-		servers: servers,
+		servers:    servers,
+		strategy:   strategy.Hash{},
+		clientInfo: defaultClientInfo(),
 	}
 }
 
 func (lb *LoadBalancer) getHealthyServers() []ServerConnections {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
 	healthyServers := make([]ServerConnections, 0)
 	for _, server := range lb.servers {
 		if server.health {
@@ -64,20 +113,104 @@ func (lb *LoadBalancer) getHealthyServers() []ServerConnections {
 }
 
 func (lb *LoadBalancer) getServer(clientAddr string) (*ServerConnections, error) {
-	healthyServers := lb.getHealthyServers()
-	if len(healthyServers) == 0 {
-		return nil, fmt.Errorf("no healthy servers available")
+	return lb.getServerExcluding(strategy.ClientInfo{Key: clientAddr}, "")
+}
+
+func (lb *LoadBalancer) getServerExcluding(client strategy.ClientInfo, excludedAddress string) (*ServerConnections, error) {
+	healthyServers := make([]ServerConnections, 0, len(lb.servers))
+	for _, server := range lb.getHealthyServers() {
+		if server.address != excludedAddress {
+			healthyServers = append(healthyServers, server)
+		}
+	}
+	now := lb.now()
+	admitted := lb.admitByWeight(healthyServers, now)
+	addresses := make([]string, 0, len(admitted))
+	for _, server := range admitted {
+		addresses = append(addresses, server.address)
 	}
 
-	hash := fnv.New32a()
-	hash.Write([]byte(clientAddr))
-	serverIndex := int(hash.Sum32()) % len(healthyServers)
+	selected, err := lb.selectionStrategy().Select(client, addresses)
+	if err != nil {
+		return nil, err
+	}
+	// Choices made with a backend excluded depend on a failure and would
+	// not replay the same, so only first choices are logged.
+	if excludedAddress == "" {
+		lb.decisions.record(now, client, healthyServers, addresses, selected)
+	}
+	for i := range admitted {
+		if admitted[i].address == selected {
+			return &admitted[i], nil
+		}
+	}
+	return nil, fmt.Errorf("strategy selected unknown server %s", selected)
+}
 
-	return &healthyServers[serverIndex], nil
+func (lb *LoadBalancer) selectionStrategy() strategy.Strategy {
+	if lb.strategy == nil {
+		return strategy.Hash{}
+	}
+	return lb.strategy
+}
+
+func (lb *LoadBalancer) now() time.Time {
+	if lb.clock == nil {
+		return time.Now()
+	}
+	return lb.clock()
+}
+
+func (lb *LoadBalancer) probeBackend(dst string) (probeStatus, int) {
+	if lb.prober == nil {
+		return probeWeight(dst)
+	}
+	return lb.prober(dst)
+}
+
+func (lb *LoadBalancer) describeClient(r *http.Request) strategy.ClientInfo {
+	if lb.clientInfo == nil {
+		return strategy.ClientInfo{Key: r.RemoteAddr}
+	}
+	return lb.clientInfo(r)
 }
 
 func (lb *LoadBalancer) updateServerHealth(serverIndex int, isHealthy bool) {
-	lb.servers[serverIndex].health = isHealthy
+	status := probeHealthy
+	if !isHealthy {
+		status = probeUnhealthy
+	}
+	lb.updateServerStatus(serverIndex, status)
+}
+
+func (lb *LoadBalancer) updateServerStatus(serverIndex int, status probeStatus) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	server := &lb.servers[serverIndex]
+	before := server.state()
+	server.health = status.inRotation()
+	server.status = status
+	server.checkedAt = lb.now()
+	noteTransition(server.address, before, server.state())
+}
+
+func (lb *LoadBalancer) checkServer(serverIndex int) probeStatus {
+	server := lb.servers[serverIndex].address
+	start := lb.now()
+	status, weight := lb.probeBackend(server)
+	responses, failed := takeResponses(server)
+	status = degrade(status, lb.now().Sub(start), responses, failed)
+	lb.updateServerStatus(serverIndex, status)
+	if status.inRotation() {
+		lb.updateServerWeight(serverIndex, weight, lb.now())
+	}
+	log.Printf("Server %s health is %v (%s)", server, status.inRotation(), status)
+
+	if lb.peers != nil && status != probeUnresolved {
+		lb.peers.publish(server, status.inRotation())
+	}
+	return status
 }
 
 func scheme() string {
@@ -88,26 +221,157 @@ func scheme() string {
 }
 
 func health(dst string) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	return probe(dst) == probeHealthy
+}
+
+func (lb *LoadBalancer) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	r = startTrace(r)
+
+	if err := limitRequestHeaders(r.Header); err != nil {
+		log.Printf("Rejecting request from %s: %s", r.RemoteAddr, err)
+		apierrors.Write(rw, apierrors.New(apierrors.BadRequest, "%s", err).WithStatus(http.StatusRequestHeaderFieldsTooLarge))
+		return
+	}
+
+	if err := validateRequest(r); err != nil {
+		rejectedMalformedRequests.Add(1)
+		log.Printf("Rejecting malformed request from %s: %s", r.RemoteAddr, err)
+		apierrors.Write(rw, apierrors.New(apierrors.BadRequest, "%s", err))
+		return
+	}
+
+	if err := normalizeRequestPath(r.URL); err != nil {
+		log.Printf("Rejecting request from %s: %s", r.RemoteAddr, err)
+		apierrors.Write(rw, apierrors.New(apierrors.BadRequest, "%s", err))
+		return
+	}
+
+	var key string
+	if lb.cache != nil && isCacheableRequest(r) && !isLongPollRoute(r.URL.Path) {
+		key = cacheKey(r)
+		if cached, ok := lb.cache.Get(key); ok {
+			cacheHits.Add(1)
+			writeCachedResponse(rw, cached)
+			return
+		}
+		cacheMisses.Add(1)
+
+		capture := &capturingWriter{ResponseWriter: rw, limit: *cacheMaxBody}
+		rw = capture
+		defer func() {
+			if cached, ok := capture.cachedResponse(); ok {
+				go func() {
+					if err := lb.cache.Put(key, cached); err != nil {
+						log.Printf("Failed to store cached response: %s", err)
+						return
+					}
+					cacheStores.Add(1)
+				}()
+			}
+		}()
+	}
 
-	req, _ := http.NewRequestWithContext(ctx, "GET",
-		fmt.Sprintf("%s://%s/health", scheme(), dst), nil)
+	// Long polls would hold their slot for as long as they wait.
+	if !isLongPollRoute(r.URL.Path) {
+		class := lb.classifier.classify(r)
+		release, err := lb.admission.acquire(r.Context(), class)
+		if err != nil {
+			log.Printf("Shedding %s request %s %s: %s", class, r.Method, r.URL, err)
+			apierrors.Write(rw, apierrors.New(apierrors.Overloaded, "too many concurrent requests"))
+			return
+		}
+		defer release()
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	client := lb.describeClient(r)
+	server, err := lb.getServerExcluding(client, "")
 	if err != nil {
-		return false
+		log.Printf("Error getting server: %s", err)
+		if lb.fallbacks.serve(rw, r) {
+			return
+		}
+		apierrors.Write(rw, apierrors.New(apierrors.Overloaded, "no healthy backends"))
+		return
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return false
+	rw, recorded := lb.fallbacks.record(rw, r)
+	defer recorded()
+
+	replayable := false
+	if isReplayable(r) {
+		replayable, err = bufferBody(r, *replayBodyLimit)
+		if err != nil {
+			log.Printf("Failed to read request body: %s", err)
+			apierrors.Write(rw, apierrors.New(apierrors.BadRequest, "failed to read request body"))
+			return
+		}
+		if !replayable {
+			replaySkippedRequests.Add(1)
+		}
 	}
 
-	return true
+	// A soft first byte limit only makes sense when there is another backend
+	// to move the request to.
+	var firstByteLimit time.Duration
+	if replayable && *ttfbFailover > 0 {
+		if _, nextErr := lb.getServerExcluding(client, server.address); nextErr == nil {
+			firstByteLimit = *ttfbFailover
+		}
+	}
+
+	err = proxyWithin(server.address, rw, r, firstByteLimit)
+	if err != nil && replayable && (isConnectError(err) || errors.Is(err, errSlowFirstByte)) {
+		if next, nextErr := lb.getServerExcluding(client, server.address); nextErr == nil {
+			if replayErr := rewindBody(r); replayErr == nil {
+				if errors.Is(err, errSlowFirstByte) {
+					ttfbFailovers.Add(1)
+				}
+				replayedRequests.Add(1)
+				log.Printf("Replaying %s %s to %s", r.Method, r.URL, next.address)
+				err = proxy(next.address, rw, r)
+			}
+		}
+	}
+	if err != nil {
+		apierrors.Write(rw, backendError(err))
+	}
 }
 
 func forward(dst string, rw http.ResponseWriter, r *http.Request) error {
-	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	err := proxy(dst, rw, startTrace(r))
+	if err != nil {
+		apierrors.Write(rw, backendError(err))
+	}
+	return err
+}
+
+// backendError describes a failed proxy attempt. Timeouts keep the 503 status
+// the balancer has always used so that clients retry them the same way.
+func backendError(err error) *apierrors.Error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return apierrors.New(apierrors.Timeout, "backend timed out").WithStatus(http.StatusServiceUnavailable)
+	}
+	return apierrors.New(apierrors.Overloaded, "backend unavailable")
+}
+
+func proxy(dst string, rw http.ResponseWriter, r *http.Request) error {
+	return proxyWithin(dst, rw, r, 0)
+}
+
+// proxyWithin gives up with errSlowFirstByte before anything is written to
+// rw when the backend has not sent response headers within firstByteLimit.
+// A zero limit leaves only the regular timeouts.
+func proxyWithin(dst string, rw http.ResponseWriter, r *http.Request, firstByteLimit time.Duration) error {
+	longPoll := isLongPollRoute(r.URL.Path)
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if longPoll {
+		ctx, cancel = context.WithCancel(r.Context())
+		_ = http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+	} else {
+		ctx, cancel = context.WithTimeout(r.Context(), *requestTimeout)
+	}
 	defer cancel()
 
 	fwdRequest := r.Clone(ctx)
@@ -115,28 +379,58 @@ func forward(dst string, rw http.ResponseWriter, r *http.Request) error {
 	fwdRequest.URL.Host = dst
 	fwdRequest.URL.Scheme = scheme()
 	fwdRequest.Host = dst
+	removeHopByHopHeaders(fwdRequest.Header)
+
+	traceSpan := spanFrom(ctx)
+	if traceSpan != nil {
+		fwdRequest.Header.Set(traceIDHeader, traceSpan.id)
+	}
 
-	resp, err := http.DefaultClient.Do(fwdRequest)
+	var firstByteTimer *time.Timer
+	if firstByteLimit > 0 {
+		firstByteTimer = time.AfterFunc(firstByteLimit, cancel)
+	}
+
+	start := time.Now()
+	resp, err := backendClient.Do(fwdRequest)
+	if firstByteTimer != nil && !firstByteTimer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		log.Printf("No response headers from %s within %s", dst, firstByteLimit)
+		return errSlowFirstByte
+	}
 	if err != nil {
 		log.Printf("Failed to get response from %s: %s", dst, err)
-		rw.WriteHeader(http.StatusServiceUnavailable)
+		observeResponse(dst, http.StatusBadGateway)
 		return err
 	}
+	observeResponse(dst, resp.StatusCode)
+	observeLatency(backendTTFB, dst, time.Since(start))
+	defer func() {
+		observeLatency(backendLatency, dst, time.Since(start))
+	}()
 
 	for k, values := range resp.Header {
 		for _, value := range values {
 			rw.Header().Add(k, value)
 		}
 	}
-	if *traceEnabled {
+	if traceSpan != nil {
 		rw.Header().Set("lb-from", dst)
+		rw.Header().Set(traceIDHeader, traceSpan.id)
+		defer traceSpan.finish(r, dst, resp.StatusCode)
 	}
 
 	log.Printf("fwd %s %s -> %s", r.Method, r.URL, dst)
 
 	rw.WriteHeader(resp.StatusCode)
 	defer resp.Body.Close()
-	_, err = io.Copy(rw, resp.Body)
+	if longPoll || isEventStream(resp.Header) {
+		err = copyStreaming(rw, resp.Body, *flushInterval)
+	} else {
+		_, err = io.Copy(rw, resp.Body)
+	}
 	if err != nil {
 		log.Printf("Failed to write response: %s", err)
 	}
@@ -147,40 +441,132 @@ func forward(dst string, rw http.ResponseWriter, r *http.Request) error {
 func main() {
 	flag.Parse()
 
+	problems := validateConfig()
+	if *validateConfigOnly {
+		os.Exit(reportConfig(os.Stdout, problems))
+	}
+	if len(problems) > 0 {
+		log.Fatalf("Invalid configuration: %s", errors.Join(problems...))
+	}
+	timeout = time.Duration(*timeoutSec) * time.Second
+	backendClient = newBackendClient()
+
 	lb := NewLoadBalancer()
+	expvar.Publish("lb_backends_by_state", expvar.Func(func() any { return lb.stateCounts() }))
 
-	for i, server := range serversPool {
-		i := i
-		server := server
+	var source rand.Source
+	if *strategySeed != 0 {
+		source = rand.NewSource(*strategySeed)
+	}
+	// Simulations have to be reproducible, so they never seed from the
+	// current time.
+	simulationSeed := *strategySeed
+	if simulationSeed == 0 {
+		simulationSeed = 1
+	}
+	if *simulate && source == nil {
+		source = rand.NewSource(simulationSeed)
+	}
+	selectionStrategy, err := strategy.New(*strategyName, source)
+	if err != nil {
+		log.Fatalf("Invalid strategy: %s", err)
+	}
+	zones, err := strategy.ParseZones(*backendZones)
+	if err != nil {
+		log.Fatalf("Invalid backend zones: %s", err)
+	}
+	if len(zones) > 0 {
+		selectionStrategy = strategy.ZoneAffinity{Next: selectionStrategy, Zones: zones}
+	}
+	lb.strategy = selectionStrategy
+
+	if *simulate {
+		log.SetOutput(io.Discard)
+		runFailureSimulation(os.Stdout, serversPool, selectionStrategy, simulationSeed, *simulateClients)
+		return
+	}
+
+	if *decisionLogFile != "" {
+		name := *strategyName
+		if len(zones) > 0 {
+			name = "zone-affinity+" + name
+		}
+		lb.decisions, err = openDecisionLog(*decisionLogFile, name)
+		if err != nil {
+			log.Fatalf("Failed to open decision log: %s", err)
+		}
+		log.Printf("Logging routing decisions to %s", *decisionLogFile)
+	}
+
+	if *cacheEnabled {
+		lb.cache = newDatastoreCache(*dbURL)
+		log.Printf("Caching responses in %s", *dbURL)
+	}
 
+	routes, _ := parsePriorityRoutes(*priorityRoutes)
+	lb.classifier = classifier{routes: routes, header: *priorityHeader}
+	if *maxConcurrent > 0 {
+		weights, _ := parsePriorityWeights(*priorityWeights)
+		lb.admission = newAdmission(*maxConcurrent, weights, *queueTimeout)
+		log.Printf("Queueing requests over %d concurrent ones by priority", *maxConcurrent)
+	}
+
+	if *fallbackFile != "" {
+		lb.fallbacks, err = loadFallbacks(*fallbackFile)
+		if err != nil {
+			log.Fatalf("Invalid fallback routes: %s", err)
+		}
+		log.Printf("Serving fallback responses for %d routes", len(lb.fallbacks.routes))
+	}
+
+	go lb.monitorServers(*probeWorkers, nil)
+
+	if *sharedHealthEnabled {
+		lb.peers = newSharedHealth(newDbClient(*dbURL), *replicaID)
 		go func() {
-			for range time.Tick(10 * time.Second) {
-				isHealthy := health(server)
-				lb.updateServerHealth(i, isHealthy)
-				log.Printf("Server %s health is %v", server, isHealthy)
+			for range time.Tick(*sharedHealthInterval) {
+				lb.syncPeerHealth()
 			}
 		}()
+		log.Printf("Sharing health observations as replica %s", lb.peers.replica)
+	}
 
+	if *healthSummariesEnabled {
+		summaries := newHealthSummaries(newDbClient(*dbURL), *replicaID, time.Now())
 		go func() {
-			isHealthy := health(server)
-			lb.updateServerHealth(i, isHealthy)
-			log.Printf("Server %s health is %v", server, isHealthy)
+			for now := range time.Tick(*healthSummaryInterval) {
+				summaries.write(lb, now)
+			}
 		}()
+		log.Printf("Writing health summaries every %s", *healthSummaryInterval)
+	}
+
+	if *metricsPort > 0 {
+		httptools.CreateServer(*metricsPort, expvar.Handler()).Start()
+		log.Printf("Serving metrics on port %d", *metricsPort)
 	}
 
-	frontend := httptools.CreateServer(*port, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		server, err := lb.getServer(r.RemoteAddr)
+	var handler http.Handler = lb
+	var frontend httptools.Server
+	if *tlsCertFile != "" || *tlsKeyFile != "" {
+		certificates, err := httptools.NewCertificateReloader(*tlsCertFile, *tlsKeyFile)
 		if err != nil {
-			log.Printf("Error getting server: %s", err)
-			rw.WriteHeader(http.StatusServiceUnavailable)
-			return
+			log.Fatalf("Failed to load TLS certificate: %s", err)
 		}
-
-		forward(server.address, rw, r)
-	}))
+		certificates.Watch(*tlsReloadInterval)
+		signal.OnReloadSignal(func() {
+			if err := certificates.Reload(); err != nil {
+				log.Printf("Failed to reload certificate: %s", err)
+			}
+		})
+		frontend = httptools.CreateTLSServer(*port, handler, certificates)
+		log.Printf("TLS termination enabled with certificate %s", *tlsCertFile)
+	} else {
+		frontend = httptools.CreateServer(*port, handler)
+	}
 
 	log.Printf("Starting load balancer on port %d", *port)
-	log.Printf("Tracing support enabled: %t", *traceEnabled)
+	log.Printf("Tracing support enabled: %t (sampling: %s)", *traceEnabled, *traceSampling)
 	frontend.Start()
 	signal.WaitForTerminationSignal()
 }
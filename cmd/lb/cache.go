@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"expvar"
+	"flag"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cacheKeyPrefix = "lb-cache-"
+
+var (
+	cacheEnabled = flag.Bool("cache", false, "whether to cache GET responses in the datastore")
+	cacheTTL     = flag.Duration("cache-ttl", 30*time.Second, "default lifetime of cached responses")
+	cacheMaxBody = flag.Int("cache-max-body", 1<<20, "max response body size in bytes stored in the cache")
+
+	cacheHits   = expvar.NewInt("lb_cache_hits")
+	cacheMisses = expvar.NewInt("lb_cache_misses")
+	cacheStores = expvar.NewInt("lb_cache_stores")
+)
+
+type cachedResponse struct {
+	Status    int         `json:"status"`
+	Header    http.Header `json:"header"`
+	Body      []byte      `json:"body"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+type responseCache interface {
+	Get(key string) (*cachedResponse, bool)
+	Put(key string, response *cachedResponse) error
+}
+
+type datastoreCache struct {
+	db *dbClient
+}
+
+func newDatastoreCache(baseURL string) *datastoreCache {
+	return &datastoreCache{db: newDbClient(baseURL)}
+}
+
+func (c *datastoreCache) Get(key string) (*cachedResponse, bool) {
+	var cached cachedResponse
+	if err := c.db.getJSON(key, &cached); err != nil {
+		return nil, false
+	}
+	if time.Now().After(cached.ExpiresAt) {
+		return nil, false
+	}
+	return &cached, true
+}
+
+// Put stores the response with a datastore TTL, so that the datastore drops
+// it once it expires instead of keeping every response ever cached.
+func (c *datastoreCache) Put(key string, response *cachedResponse) error {
+	ttl := time.Until(response.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return c.db.putJSONWithTTL(key, response, ttl)
+}
+
+// cacheKey tells apart responses to requests that accept different
+// content codings, since a backend may compress some of them.
+func cacheKey(r *http.Request) string {
+	sum := sha1.Sum([]byte(r.Method + " " + r.Host + r.URL.RequestURI() + "\n" + acceptedEncodings(r)))
+	return cacheKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// isCacheableRequest reports whether r may be answered from the cache. Range
+// requests go to the backend, which serves the parts they ask for, while
+// the cache only holds whole responses.
+func isCacheableRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet || r.Header.Get("Authorization") != "" || r.Header.Get("Range") != "" {
+		return false
+	}
+	return !hasCacheDirective(r.Header, "no-store") && !hasCacheDirective(r.Header, "no-cache")
+}
+
+func responseTTL(status int, header http.Header) (time.Duration, bool) {
+	if status != http.StatusOK || header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	if hasCacheDirective(header, "no-store") || hasCacheDirective(header, "private") || hasCacheDirective(header, "no-cache") {
+		return 0, false
+	}
+	if !variesOnlyByEncoding(header) {
+		return 0, false
+	}
+	for _, directive := range cacheDirectives(header) {
+		if value, ok := strings.CutPrefix(directive, "max-age="); ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return *cacheTTL, true
+}
+
+func cacheDirectives(header http.Header) []string {
+	var directives []string
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directives = append(directives, strings.ToLower(strings.TrimSpace(directive)))
+		}
+	}
+	return directives
+}
+
+func hasCacheDirective(header http.Header, name string) bool {
+	for _, directive := range cacheDirectives(header) {
+		if directive == name {
+			return true
+		}
+	}
+	return false
+}
+
+func writeCachedResponse(rw http.ResponseWriter, cached *cachedResponse) {
+	for k, values := range cached.Header {
+		for _, value := range values {
+			rw.Header().Add(k, value)
+		}
+	}
+	rw.Header().Set("X-Cache", "HIT")
+	rw.WriteHeader(cached.Status)
+	rw.Write(cached.Body)
+}
+
+// capturingWriter passes the response through while keeping a copy of it
+// for the cache, giving up on the copy once the body grows past the limit.
+type capturingWriter struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (w *capturingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *capturingWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.overflow {
+		if w.body.Len()+len(data) > w.limit {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(data)
+		}
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *capturingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// cachedResponse returns the captured response for the cache, or false if it
+// may not be cached. Fallbacks stand in for backends only while they are
+// down, so they are never cached, whatever their headers say.
+func (w *capturingWriter) cachedResponse() (*cachedResponse, bool) {
+	if w.overflow || w.Header().Get(fallbackHeader) != "" {
+		return nil, false
+	}
+	ttl, ok := responseTTL(w.status, w.Header())
+	if !ok {
+		return nil, false
+	}
+
+	header := w.Header().Clone()
+	header.Del("lb-from")
+	header.Del(traceIDHeader)
+	return &cachedResponse{
+		Status:    w.status,
+		Header:    header,
+		Body:      bytes.Clone(w.body.Bytes()),
+		ExpiresAt: time.Now().Add(ttl),
+	}, true
+}
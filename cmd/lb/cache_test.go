@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newFakeDatastore(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var mu sync.Mutex
+	values := make(map[string]string)
+	expiries := make(map[string]time.Time)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/db/")
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodGet:
+			if expiresAt, ok := expiries[key]; ok && time.Now().After(expiresAt) {
+				delete(values, key)
+				delete(expiries, key)
+			}
+			value, ok := values[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"key": key, "value": value})
+		case http.MethodPost:
+			var request struct {
+				Value string `json:"value"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			values[key] = request.Value
+			delete(expiries, key)
+			if ttl, err := time.ParseDuration(r.URL.Query().Get("ttl")); err == nil {
+				expiries[key] = time.Now().Add(ttl)
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func waitForCacheStore(t *testing.T, cache responseCache, key string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := cache.Get(key); ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Response was not stored in cache")
+}
+
+func TestServeHTTP_CachesResponsesInDatastore(t *testing.T) {
+	var backendHits int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendHits++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("payload"))
+	}))
+	defer backend.Close()
+	*https = false
+
+	db := newFakeDatastore(t)
+	lb := &LoadBalancer{
+		servers: []ServerConnections{{address: backend.URL[7:], health: true}},
+		cache:   newDatastoreCache(db.URL + "/db/"),
+	}
+
+	first := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/data", nil)
+	lb.ServeHTTP(first, req)
+	if first.Body.String() != "payload" {
+		t.Fatalf("Unexpected body %q", first.Body.String())
+	}
+	waitForCacheStore(t, lb.cache, cacheKey(req))
+
+	second := httptest.NewRecorder()
+	lb.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "http://example.com/data", nil))
+	if second.Body.String() != "payload" {
+		t.Errorf("Unexpected cached body %q", second.Body.String())
+	}
+	if second.Header().Get("X-Cache") != "HIT" {
+		t.Error("Expected second response to be served from cache")
+	}
+	if backendHits != 1 {
+		t.Errorf("Expected backend to be hit once, got %d", backendHits)
+	}
+
+	restarted := &LoadBalancer{cache: newDatastoreCache(db.URL + "/db/")}
+	third := httptest.NewRecorder()
+	restarted.ServeHTTP(third, httptest.NewRequest(http.MethodGet, "http://example.com/data", nil))
+	if third.Header().Get("X-Cache") != "HIT" {
+		t.Error("Expected cache to be shared across balancer instances")
+	}
+}
+
+func TestResponseTTL(t *testing.T) {
+	testCases := []struct {
+		name      string
+		status    int
+		header    http.Header
+		cacheable bool
+		ttl       time.Duration
+	}{
+		{"default ttl", http.StatusOK, http.Header{}, true, *cacheTTL},
+		{"max-age", http.StatusOK, http.Header{"Cache-Control": {"public, max-age=5"}}, true, 5 * time.Second},
+		{"no-store", http.StatusOK, http.Header{"Cache-Control": {"no-store"}}, false, 0},
+		{"private", http.StatusOK, http.Header{"Cache-Control": {"private"}}, false, 0},
+		{"cookie", http.StatusOK, http.Header{"Set-Cookie": {"a=b"}}, false, 0},
+		{"error status", http.StatusInternalServerError, http.Header{}, false, 0},
+		{"vary by encoding", http.StatusOK, http.Header{"Vary": {"accept-encoding"}}, true, *cacheTTL},
+		{"vary by cookie", http.StatusOK, http.Header{"Vary": {"Accept-Encoding, Cookie"}}, false, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ttl, ok := responseTTL(tc.status, tc.header)
+			if ok != tc.cacheable || ttl != tc.ttl {
+				t.Errorf("Expected (%v, %v), got (%v, %v)", tc.ttl, tc.cacheable, ttl, ok)
+			}
+		})
+	}
+}
+
+func TestDatastoreCache_ExpiredEntry(t *testing.T) {
+	db := newFakeDatastore(t)
+	cache := newDatastoreCache(db.URL + "/db/")
+
+	if err := cache.Put("expired", &cachedResponse{Status: http.StatusOK, ExpiresAt: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("expired"); ok {
+		t.Error("Expired entry should not be returned")
+	}
+}
+
+func TestDatastoreCache_StoresWithTTL(t *testing.T) {
+	db := newFakeDatastore(t)
+	cache := newDatastoreCache(db.URL + "/db/")
+	client := newDbClient(db.URL + "/db/")
+
+	if err := cache.Put("fresh", &cachedResponse{Status: http.StatusOK, ExpiresAt: time.Now().Add(50 * time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.get("fresh"); err != nil {
+		t.Fatalf("Expected the entry in the datastore, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := client.get("fresh"); !errors.Is(err, errValueNotFound) {
+		t.Errorf("Expected the datastore to drop the entry once it expired, got %v", err)
+	}
+}
+
+func TestServeHTTP_RangeRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer backend.Close()
+	*https = false
+
+	db := newFakeDatastore(t)
+	lb := &LoadBalancer{
+		servers: []ServerConnections{{address: backend.URL[7:], health: true}},
+		cache:   newDatastoreCache(db.URL + "/db/"),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/blob", nil)
+	lb.ServeHTTP(httptest.NewRecorder(), req)
+	waitForCacheStore(t, lb.cache, cacheKey(req))
+
+	partial := httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/blob", nil)
+	req.Header.Set("Range", "bytes=7-")
+	req.Header.Set("If-Range", `"v1"`)
+	lb.ServeHTTP(partial, req)
+	if partial.Code != http.StatusPartialContent || partial.Body.String() != "789" {
+		t.Errorf("Expected the backend to serve the range, got %d %q", partial.Code, partial.Body.String())
+	}
+	if partial.Header().Get("Content-Range") != "bytes 7-9/10" || partial.Header().Get("X-Cache") == "HIT" {
+		t.Errorf("Expected Content-Range from the backend, got %v", partial.Header())
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/LeVasTiaN/KPI_Lab5/strategy"
+)
+
+var (
+	clientZoneHeader   = flag.String("client-zone-header", "X-Client-Zone", "request header carrying the client zone")
+	clientTenantHeader = flag.String("client-tenant-header", "X-Client-Tenant", "request header carrying the client tenant")
+	clientTierHeader   = flag.String("client-tier-header", "X-Client-Tier", "request header carrying the client tier")
+	backendZones       = flag.String("backend-zones", "", "comma separated backend=zone pairs enabling same-zone routing")
+)
+
+func defaultClientInfo() strategy.ClientInfoFunc {
+	return strategy.HeaderClientInfo(*clientZoneHeader, *clientTenantHeader, *clientTierHeader)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/LeVasTiaN/KPI_Lab5/strategy"
+)
+
+var validateConfigOnly = flag.Bool("validate-config", false, "check the configuration given by the other flags, print every problem found and exit (non-zero if there is any)")
+
+// validateConfig checks the whole configuration of the balancer and returns
+// every problem it finds rather than stopping at the first one.
+func validateConfig() []error {
+	var problems []error
+	check := func(setting string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", setting, err))
+		}
+	}
+
+	check("-trace-sampling", validateTraceSampling())
+	check("header limits", validateHeaderLimits())
+	check("timeouts", validateTimeouts())
+	check("-ttfb-failover", validateTTFBFailover())
+	check("prioritization", validatePriorities())
+	check("degraded backends", validateDegraded())
+	if _, err := strategy.New(*strategyName, nil); err != nil {
+		check("-strategy", err)
+	}
+	for _, err := range validateZones(*backendZones, serversPool) {
+		check("-backend-zones", err)
+	}
+	for _, err := range validateLongPollRoutes(*longPollRoutes) {
+		check("-long-poll-routes", err)
+	}
+	if *fallbackFile != "" {
+		_, err := loadFallbacks(*fallbackFile)
+		check("-fallback-file", err)
+	}
+	if (*tlsCertFile == "") != (*tlsKeyFile == "") {
+		check("TLS", fmt.Errorf("-tls-cert and -tls-key must be given together"))
+	}
+	if *healthInterval <= 0 {
+		check("-health-interval", fmt.Errorf("must be positive, got %s", *healthInterval))
+	}
+	if *probeWorkers <= 0 {
+		check("-probe-concurrency", fmt.Errorf("must be positive, got %d", *probeWorkers))
+	}
+	if *flushInterval <= 0 {
+		check("-flush-interval", fmt.Errorf("must be positive, got %s", *flushInterval))
+	}
+	return problems
+}
+
+// validateZones rejects zone mappings that name a backend outside the pool,
+// which would never receive same-zone traffic, and backends mapped twice.
+func validateZones(spec string, backends []string) []error {
+	if _, err := strategy.ParseZones(spec); err != nil {
+		return []error{err}
+	}
+
+	var problems []error
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(spec, ",") {
+		backend, _, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		if backend == "" {
+			continue
+		}
+		if seen[backend] {
+			problems = append(problems, fmt.Errorf("backend %s is mapped to more than one zone", backend))
+		}
+		seen[backend] = true
+		if !slices.Contains(backends, backend) {
+			problems = append(problems, fmt.Errorf("backend %s is not in the pool %v", backend, backends))
+		}
+	}
+	return problems
+}
+
+// validateLongPollRoutes rejects prefixes that are not paths and prefixes
+// another one already covers.
+func validateLongPollRoutes(spec string) []error {
+	var problems []error
+	var prefixes []string
+	for _, prefix := range strings.Split(spec, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix == "" {
+			continue
+		}
+		if !strings.HasPrefix(prefix, "/") {
+			problems = append(problems, fmt.Errorf("route %q does not start with /", prefix))
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	for i, prefix := range prefixes {
+		for j, other := range prefixes {
+			if i == j || !strings.HasPrefix(prefix, other) {
+				continue
+			}
+			if prefix != other {
+				problems = append(problems, fmt.Errorf("route %q is already covered by %q", prefix, other))
+			} else if j < i {
+				problems = append(problems, fmt.Errorf("route %q is listed more than once", prefix))
+			}
+			break
+		}
+	}
+	return problems
+}
+
+// reportConfig prints the result of validateConfig and returns the exit
+// code of -validate-config.
+func reportConfig(w io.Writer, problems []error) int {
+	if len(problems) == 0 {
+		fmt.Fprintln(w, "configuration is valid")
+		return 0
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(w, problem)
+	}
+	return 1
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	t.Run("defaults are valid", func(t *testing.T) {
+		if problems := validateConfig(); len(problems) != 0 {
+			t.Errorf("Expected no problems, got %v", problems)
+		}
+	})
+
+	t.Run("reports every problem", func(t *testing.T) {
+		prevStrategy, prevZones := *strategyName, *backendZones
+		*strategyName, *backendZones = "fastest", "server9:8080=eu"
+		t.Cleanup(func() { *strategyName, *backendZones = prevStrategy, prevZones })
+		withTraceFlags(t, true, "sometimes", 0)
+
+		problems := validateConfig()
+		if len(problems) != 3 {
+			t.Fatalf("Expected 3 problems, got %v", problems)
+		}
+		for i, setting := range []string{"-trace-sampling", "-strategy", "-backend-zones"} {
+			if !strings.HasPrefix(problems[i].Error(), setting+": ") {
+				t.Errorf("Expected problem %d to name %s, got %q", i, setting, problems[i])
+			}
+		}
+	})
+}
+
+func TestValidateZones(t *testing.T) {
+	pool := []string{"server1:8080", "server2:8080"}
+
+	if problems := validateZones("server1:8080=eu,server2:8080=us", pool); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+	if problems := validateZones("server1:8080", pool); len(problems) != 1 {
+		t.Errorf("Expected a malformed mapping to be rejected, got %v", problems)
+	}
+	problems := validateZones("server1:8080=eu,server1:8080=us,server3:8080=eu", pool)
+	if len(problems) != 2 {
+		t.Fatalf("Expected a duplicate and an unknown backend, got %v", problems)
+	}
+	if !strings.Contains(problems[0].Error(), "more than one zone") || !strings.Contains(problems[1].Error(), "server3:8080 is not in the pool") {
+		t.Errorf("Unexpected problems: %v", problems)
+	}
+}
+
+func TestValidateLongPollRoutes(t *testing.T) {
+	if problems := validateLongPollRoutes("/events, /api/watch"); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+
+	problems := validateLongPollRoutes("events,/api,/api/watch,/api")
+	want := []string{
+		`route "events" does not start with /`,
+		`route "/api/watch" is already covered by "/api"`,
+		`route "/api" is listed more than once`,
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("Expected %d problems, got %v", len(want), problems)
+	}
+	for i := range want {
+		if problems[i].Error() != want[i] {
+			t.Errorf("Expected %q, got %q", want[i], problems[i])
+		}
+	}
+}
+
+func TestReportConfig(t *testing.T) {
+	var out bytes.Buffer
+	if code := reportConfig(&out, nil); code != 0 || !strings.Contains(out.String(), "valid") {
+		t.Errorf("Expected exit code 0 and a confirmation, got %d and %q", code, out.String())
+	}
+
+	out.Reset()
+	problems := []error{errors.New("-strategy: unknown strategy: fastest"), errors.New("TLS: missing key")}
+	if code := reportConfig(&out, problems); code != 1 {
+		t.Errorf("Expected exit code 1, got %d", code)
+	}
+	if out.String() != "-strategy: unknown strategy: fastest\nTLS: missing key\n" {
+		t.Errorf("Unexpected report: %q", out.String())
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var dbURL = flag.String("db-url", "http://db:8083/db/", "datastore endpoint used for shared balancer state")
+
+var errValueNotFound = errors.New("value not found")
+
+type dbClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newDbClient(baseURL string) *dbClient {
+	return &dbClient{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: time.Second},
+	}
+}
+
+func (c *dbClient) get(key string) (string, error) {
+	resp, err := c.client.Get(c.baseURL + key)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errValueNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("datastore returned status %d", resp.StatusCode)
+	}
+
+	var record struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
+		return "", err
+	}
+	return record.Value, nil
+}
+
+func (c *dbClient) put(key, value string) error {
+	return c.putWithTTL(key, value, 0)
+}
+
+// putWithTTL has the datastore drop the value once ttl has passed, or keep
+// it for good if ttl is 0.
+func (c *dbClient) putWithTTL(key, value string, ttl time.Duration) error {
+	body, err := json.Marshal(map[string]string{"value": value})
+	if err != nil {
+		return err
+	}
+
+	url := c.baseURL + key
+	if ttl > 0 {
+		url += "?ttl=" + ttl.String()
+	}
+	resp, err := c.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("datastore returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *dbClient) getJSON(key string, target interface{}) error {
+	value, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(value), target)
+}
+
+func (c *dbClient) putJSON(key string, value interface{}) error {
+	return c.putJSONWithTTL(key, value, 0)
+}
+
+func (c *dbClient) putJSONWithTTL(key string, value interface{}, ttl time.Duration) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.putWithTTL(key, string(encoded), ttl)
+}
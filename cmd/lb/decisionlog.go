@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/strategy"
+)
+
+var decisionLogFile = flag.String("decision-log", "", "file to append routing decisions to, one JSON object per line, for replay with lbctl (disabled when empty)")
+
+// decisionLog appends the first routing choice made for every request, so
+// that lbctl replay can show how another strategy would have routed the
+// same traffic.
+type decisionLog struct {
+	strategy string
+
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func openDecisionLog(path, strategyName string) (*decisionLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &decisionLog{strategy: strategyName, file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (l *decisionLog) record(now time.Time, client strategy.ClientInfo, healthy []ServerConnections, backends []string, selected string) {
+	if l == nil {
+		return
+	}
+	decision := strategy.Decision{
+		Time:     now,
+		Client:   client,
+		Strategy: l.strategy,
+		Backends: backends,
+		Backend:  selected,
+		Health:   healthSnapshot(healthy, now),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.encoder.Encode(decision); err != nil {
+		log.Printf("Failed to log routing decision: %s", err)
+	}
+}
+
+// healthSnapshot hashes the healthy backends and their routing weights.
+func healthSnapshot(healthy []ServerConnections, now time.Time) string {
+	hash := fnv.New64a()
+	for _, server := range healthy {
+		fmt.Fprintf(hash, "%s=%d;", server.address, server.routingWeight(now))
+	}
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LeVasTiaN/KPI_Lab5/strategy"
+)
+
+func TestDecisionLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.log")
+	decisions, err := openDecisionLog(path, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decisions.file.Close()
+
+	lb := NewLoadBalancer()
+	lb.decisions = decisions
+	for i := range lb.servers {
+		lb.updateServerHealth(i, true)
+	}
+	first, err := lb.getServer("10.0.0.1:1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lb.getServerExcluding(strategy.ClientInfo{Key: "10.0.0.1:1234"}, first.address); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	result, err := strategy.Replay(file, strategy.Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 1 || result.Changed != 0 || result.Before[first.address] != 1 {
+		t.Errorf("Expected only the first choice to be logged and replay the same, got %+v", result)
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"strategy":"hash"`) || !strings.Contains(string(data), `"health":"`) {
+		t.Errorf("Expected the strategy and health snapshot in the log, got %s", data)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A backend that answers its probes but slowly, or fails many of the
+// requests proxied to it, is degraded rather than down: it stays in
+// rotation at half its weight. When every backend is somewhat unhealthy,
+// capacity then shrinks by half instead of falling to nothing.
+var (
+	degradedProbeLatency = flag.Duration("degraded-probe-latency", time.Second, "health probe duration over which a backend is degraded and gets half its weight (disabled when 0)")
+	degradedErrorRate    = flag.Float64("degraded-error-rate", 0.2, "share of 5xx responses since the previous probe over which a backend is degraded (disabled when 0)")
+	degradedMinResponses = flag.Int("degraded-min-responses", 20, "how many responses since the previous probe -degraded-error-rate needs to judge a backend")
+
+	backendStates     = expvar.NewMap("lb_backend_state")
+	healthTransitions = expvar.NewMap("lb_health_transitions")
+	backendResponses  = expvar.NewMap("lb_backend_responses")
+)
+
+// Health states a backend is routed in.
+const (
+	stateHealthy   = "healthy"
+	stateDegraded  = "degraded"
+	stateUnhealthy = "unhealthy"
+)
+
+// inRotation reports whether a backend with status gets traffic.
+func (s probeStatus) inRotation() bool {
+	return s == probeHealthy || s == probeDegraded
+}
+
+// state names the health state the backend is routed in. A backend another
+// replica reported down is unhealthy whatever its own last probe said.
+func (s ServerConnections) state() string {
+	switch {
+	case !s.health:
+		return stateUnhealthy
+	case s.status == probeDegraded:
+		return stateDegraded
+	}
+	return stateHealthy
+}
+
+// routingWeight is the effective weight, halved while the backend is
+// degraded.
+func (s ServerConnections) routingWeight(now time.Time) int {
+	weight := s.effectiveWeight(now)
+	if s.status == probeDegraded {
+		weight /= 2
+	}
+	return weight
+}
+
+// degrade turns a healthy probe into a degraded one when the probe took
+// longer than -degraded-probe-latency, or when more than
+// -degraded-error-rate of the responses since the previous probe were
+// server errors.
+func degrade(status probeStatus, latency time.Duration, responses, failed int64) probeStatus {
+	if status != probeHealthy {
+		return status
+	}
+	if *degradedProbeLatency > 0 && latency > *degradedProbeLatency {
+		return probeDegraded
+	}
+	if *degradedErrorRate > 0 && responses > 0 && responses >= int64(*degradedMinResponses) &&
+		float64(failed) > *degradedErrorRate*float64(responses) {
+		return probeDegraded
+	}
+	return status
+}
+
+// noteTransition publishes the state of a backend, and counts and logs
+// its change.
+func noteTransition(backend, from, to string) {
+	backendStates.Set(backend, stringVar(to))
+	if from == to {
+		return
+	}
+	healthTransitions.Add(from+"->"+to, 1)
+	log.Printf("Server %s went from %s to %s", backend, from, to)
+}
+
+func stringVar(value string) *expvar.String {
+	v := new(expvar.String)
+	v.Set(value)
+	return v
+}
+
+// stateCounts returns how many backends are in each health state.
+func (lb *LoadBalancer) stateCounts() map[string]int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	counts := map[string]int{stateHealthy: 0, stateDegraded: 0, stateUnhealthy: 0}
+	for _, server := range lb.servers {
+		counts[server.state()]++
+	}
+	return counts
+}
+
+// responseStats counts the responses of one backend, and the server errors
+// among them, since its last probe.
+type responseStats struct {
+	mu     sync.Mutex
+	total  int64
+	failed int64
+}
+
+func (s *responseStats) observe(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	if status >= http.StatusInternalServerError {
+		s.failed++
+	}
+}
+
+// take returns the counts and starts over.
+func (s *responseStats) take() (int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total, failed := s.total, s.failed
+	s.total, s.failed = 0, 0
+	return total, failed
+}
+
+func (s *responseStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	encoded, _ := json.Marshal(map[string]int64{"total": s.total, "failed": s.failed})
+	return string(encoded)
+}
+
+var responseStatsMu sync.Mutex
+
+// observeResponse counts a response of backend with status. Requests that
+// got no response count as a bad gateway.
+func observeResponse(backend string, status int) {
+	responseStatsMu.Lock()
+	stats, ok := backendResponses.Get(backend).(*responseStats)
+	if !ok {
+		stats = &responseStats{}
+		backendResponses.Set(backend, stats)
+	}
+	responseStatsMu.Unlock()
+	stats.observe(status)
+}
+
+// takeResponses returns the response counts of backend since the previous
+// call.
+func takeResponses(backend string) (int64, int64) {
+	if stats, ok := backendResponses.Get(backend).(*responseStats); ok {
+		return stats.take()
+	}
+	return 0, 0
+}
+
+// validateDegraded checks the flags that mark backends degraded.
+func validateDegraded() error {
+	if *degradedProbeLatency < 0 {
+		return fmt.Errorf("-degraded-probe-latency must not be negative, got %s", *degradedProbeLatency)
+	}
+	if *degradedErrorRate < 0 || *degradedErrorRate > 1 {
+		return fmt.Errorf("-degraded-error-rate must be between 0 and 1, got %g", *degradedErrorRate)
+	}
+	if *degradedMinResponses < 0 {
+		return fmt.Errorf("-degraded-min-responses must not be negative, got %d", *degradedMinResponses)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDegrade(t *testing.T) {
+	prevLatency, prevRate, prevMin := *degradedProbeLatency, *degradedErrorRate, *degradedMinResponses
+	*degradedProbeLatency, *degradedErrorRate, *degradedMinResponses = time.Second, 0.2, 10
+	defer func() {
+		*degradedProbeLatency, *degradedErrorRate, *degradedMinResponses = prevLatency, prevRate, prevMin
+	}()
+
+	cases := []struct {
+		status            probeStatus
+		latency           time.Duration
+		responses, failed int64
+		expected          probeStatus
+	}{
+		{probeHealthy, 10 * time.Millisecond, 0, 0, probeHealthy},
+		{probeHealthy, 2 * time.Second, 0, 0, probeDegraded},
+		{probeHealthy, 0, 100, 20, probeHealthy},
+		{probeHealthy, 0, 100, 21, probeDegraded},
+		{probeHealthy, 0, 5, 5, probeHealthy},
+		{probeUnhealthy, 2 * time.Second, 100, 100, probeUnhealthy},
+		{probeUnreachable, 0, 0, 0, probeUnreachable},
+	}
+	for _, tc := range cases {
+		if status := degrade(tc.status, tc.latency, tc.responses, tc.failed); status != tc.expected {
+			t.Errorf("degrade(%s, %s, %d, %d) = %s, expected %s",
+				tc.status, tc.latency, tc.responses, tc.failed, status, tc.expected)
+		}
+	}
+}
+
+func transitions(name string) int64 {
+	if count, ok := healthTransitions.Get(name).(*expvar.Int); ok {
+		return count.Value()
+	}
+	return 0
+}
+
+func TestCheckServer_HealthStates(t *testing.T) {
+	prevLatency := *degradedProbeLatency
+	*degradedProbeLatency = time.Second
+	defer func() { *degradedProbeLatency = prevLatency }()
+
+	now := time.Now()
+	probeTime, status := time.Duration(0), probeHealthy
+	lb := NewLoadBalancer()
+	lb.clock = func() time.Time { return now }
+	lb.prober = func(string) (probeStatus, int) {
+		now = now.Add(probeTime)
+		return status, fullWeight
+	}
+	before := map[string]int64{}
+	for _, name := range []string{"unhealthy->healthy", "healthy->degraded", "degraded->unhealthy"} {
+		before[name] = transitions(name)
+	}
+
+	steps := []struct {
+		probeTime time.Duration
+		status    probeStatus
+		expected  string
+	}{
+		{10 * time.Millisecond, probeHealthy, stateHealthy},
+		{2 * time.Second, probeHealthy, stateDegraded},
+		{2 * time.Second, probeUnhealthy, stateUnhealthy},
+		{10 * time.Millisecond, probeHealthy, stateHealthy},
+	}
+	for _, step := range steps {
+		probeTime, status = step.probeTime, step.status
+		lb.checkServer(0)
+		if state := lb.servers[0].state(); state != step.expected {
+			t.Fatalf("After a %s probe taking %s expected %s, got %s", step.status, step.probeTime, step.expected, state)
+		}
+		if published := backendStates.Get(lb.servers[0].address).(*expvar.String).Value(); published != step.expected {
+			t.Errorf("Expected %s to be published, got %s", step.expected, published)
+		}
+	}
+
+	for name, expected := range map[string]int64{"unhealthy->healthy": 2, "healthy->degraded": 1, "degraded->unhealthy": 1} {
+		if count := transitions(name) - before[name]; count != expected {
+			t.Errorf("Expected %d %s transitions, got %d", expected, name, count)
+		}
+	}
+
+	counts := lb.stateCounts()
+	if counts[stateHealthy] != 1 || counts[stateDegraded] != 0 || counts[stateUnhealthy] != 2 {
+		t.Errorf("Expected one healthy and two unhealthy backends, got %v", counts)
+	}
+}
+
+func TestCheckServer_DegradedByServerErrors(t *testing.T) {
+	prevRate, prevMin := *degradedErrorRate, *degradedMinResponses
+	*degradedErrorRate, *degradedMinResponses = 0.2, 10
+	defer func() { *degradedErrorRate, *degradedMinResponses = prevRate, prevMin }()
+
+	lb := NewLoadBalancer()
+	lb.servers[0].address = "errors.test:8080"
+	lb.prober = func(string) (probeStatus, int) { return probeHealthy, fullWeight }
+
+	for i := 0; i < 10; i++ {
+		status := http.StatusOK
+		if i%3 == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		observeResponse(lb.servers[0].address, status)
+	}
+	if status := lb.checkServer(0); status != probeDegraded {
+		t.Fatalf("Expected 4 server errors out of 10 responses to degrade the backend, got %s", status)
+	}
+	if !lb.servers[0].health {
+		t.Error("Expected a degraded backend to stay in rotation")
+	}
+
+	// Every probe judges the responses since the previous one.
+	if status := lb.checkServer(0); status != probeHealthy {
+		t.Errorf("Expected the backend to recover once the errors stop, got %s", status)
+	}
+}
+
+func TestAdmitByWeight_Degraded(t *testing.T) {
+	prevRamp := *drainRamp
+	*drainRamp = 0
+	defer func() { *drainRamp = prevRamp }()
+
+	lb := NewLoadBalancer()
+	lb.updateServerStatus(0, probeHealthy)
+	lb.updateServerStatus(1, probeDegraded)
+	lb.updateServerStatus(2, probeDegraded)
+	now := time.Now()
+
+	roll := 60
+	lb.roll = func(n int) int { return roll }
+	admitted := func() []string {
+		var result []string
+		for _, server := range lb.admitByWeight(lb.getHealthyServers(), now) {
+			result = append(result, server.address)
+		}
+		return result
+	}
+
+	if addresses := admitted(); len(addresses) != 1 || addresses[0] != serversPool[0] {
+		t.Errorf("Expected degraded backends at half weight to be dropped above the roll, got %v", addresses)
+	}
+	roll = 40
+	if addresses := admitted(); len(addresses) != 3 {
+		t.Errorf("Expected degraded backends at half weight to be kept below the roll, got %v", addresses)
+	}
+
+	lb.updateServerStatus(0, probeDegraded)
+	roll = 60
+	if addresses := admitted(); len(addresses) != 3 {
+		t.Errorf("Expected every degraded backend to stay in rotation when none is healthy, got %v", addresses)
+	}
+}
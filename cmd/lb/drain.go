@@ -0,0 +1,112 @@
+package main
+
+import (
+	"expvar"
+	"flag"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backends announce in their health responses how much traffic they want:
+// X-Drain: true while shutting down, or X-Weight with a share between 0 and
+// 100. The balancer moves to a new weight gradually over the drain ramp, so a
+// backend that starts draining loses its clients a few at a time and is idle
+// by the time it stops.
+const (
+	drainHeader  = "X-Drain"
+	weightHeader = "X-Weight"
+	fullWeight   = 100
+)
+
+var (
+	drainRamp = flag.Duration("drain-ramp", 5*time.Second, "how long traffic of a backend takes to follow a weight announced in its health response")
+
+	backendWeights = expvar.NewMap("lb_backend_weight")
+)
+
+// announcedWeight reads the weight a backend asks for from the headers of
+// its health response. Backends that announce nothing get the full weight.
+func announcedWeight(header http.Header) int {
+	if drain, err := strconv.ParseBool(strings.TrimSpace(header.Get(drainHeader))); err == nil && drain {
+		return 0
+	}
+	weight, err := strconv.Atoi(strings.TrimSpace(header.Get(weightHeader)))
+	if err != nil {
+		return fullWeight
+	}
+	return min(max(weight, 0), fullWeight)
+}
+
+// effectiveWeight interpolates between the weight the backend had when it
+// announced a new one and the announced weight.
+func (s ServerConnections) effectiveWeight(now time.Time) int {
+	elapsed := now.Sub(s.weightSince)
+	if *drainRamp <= 0 || elapsed >= *drainRamp || s.weightFrom == s.weight {
+		return s.weight
+	}
+	if elapsed < 0 {
+		return s.weightFrom
+	}
+	step := float64(s.weight-s.weightFrom) * float64(elapsed) / float64(*drainRamp)
+	return s.weightFrom + int(step)
+}
+
+func (lb *LoadBalancer) updateServerWeight(serverIndex int, weight int, now time.Time) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	server := &lb.servers[serverIndex]
+	if weight != server.weight {
+		server.weightFrom = server.effectiveWeight(now)
+		server.weight = weight
+		server.weightSince = now
+	}
+	backendWeights.Set(server.address, expvarInt(server.effectiveWeight(now)))
+}
+
+func expvarInt(value int) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(int64(value))
+	return v
+}
+
+// admitByWeight drops backends below the full weight from a selection with a
+// probability that grows as their weight falls. Every backend is compared to
+// the same roll, so backends at full weight are always kept and the strategy
+// keeps choosing among the same ones when nothing drains. If every backend
+// would be dropped, the ones with any weight left are kept, and if none has,
+// all of them: a draining backend still serves better than none. Degraded
+// backends count at half their weight, so they get less traffic next to
+// healthy ones but all stay in rotation when none is healthy.
+func (lb *LoadBalancer) admitByWeight(servers []ServerConnections, now time.Time) []ServerConnections {
+	roll := lb.rollWeight()
+
+	admitted := make([]ServerConnections, 0, len(servers))
+	remaining := make([]ServerConnections, 0, len(servers))
+	for _, server := range servers {
+		weight := server.routingWeight(now)
+		if weight > roll {
+			admitted = append(admitted, server)
+		}
+		if weight > 0 {
+			remaining = append(remaining, server)
+		}
+	}
+	if len(admitted) > 0 {
+		return admitted
+	}
+	if len(remaining) > 0 {
+		return remaining
+	}
+	return servers
+}
+
+func (lb *LoadBalancer) rollWeight() int {
+	if lb.roll != nil {
+		return lb.roll(fullWeight)
+	}
+	return rand.Intn(fullWeight)
+}
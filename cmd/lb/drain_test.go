@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAnnouncedWeight(t *testing.T) {
+	cases := []struct {
+		header   http.Header
+		expected int
+	}{
+		{http.Header{}, fullWeight},
+		{http.Header{drainHeader: {"true"}}, 0},
+		{http.Header{drainHeader: {"false"}, weightHeader: {"30"}}, 30},
+		{http.Header{drainHeader: {"true"}, weightHeader: {"30"}}, 0},
+		{http.Header{weightHeader: {"250"}}, fullWeight},
+		{http.Header{weightHeader: {"-5"}}, 0},
+		{http.Header{weightHeader: {"heavy"}}, fullWeight},
+	}
+	for _, tc := range cases {
+		if weight := announcedWeight(tc.header); weight != tc.expected {
+			t.Errorf("announcedWeight(%v) = %d, expected %d", tc.header, weight, tc.expected)
+		}
+	}
+}
+
+func TestDrainRamp(t *testing.T) {
+	prevRamp := *drainRamp
+	*drainRamp = 10 * time.Second
+	defer func() { *drainRamp = prevRamp }()
+
+	lb := NewLoadBalancer()
+	start := time.Now()
+	lb.updateServerWeight(0, 0, start)
+
+	server := lb.servers[0]
+	for _, step := range []struct {
+		elapsed  time.Duration
+		expected int
+	}{
+		{0, fullWeight},
+		{5 * time.Second, 50},
+		{10 * time.Second, 0},
+		{time.Minute, 0},
+	} {
+		if weight := server.effectiveWeight(start.Add(step.elapsed)); weight != step.expected {
+			t.Errorf("After %s expected weight %d, got %d", step.elapsed, step.expected, weight)
+		}
+	}
+
+	// A new announcement ramps from wherever the backend is now.
+	lb.updateServerWeight(0, fullWeight, start.Add(5*time.Second))
+	server = lb.servers[0]
+	if weight := server.effectiveWeight(start.Add(5 * time.Second)); weight != 50 {
+		t.Errorf("Expected the ramp to restart at 50, got %d", weight)
+	}
+	if weight := server.effectiveWeight(start.Add(10 * time.Second)); weight != 75 {
+		t.Errorf("Expected 75 halfway back, got %d", weight)
+	}
+}
+
+func TestAdmitByWeight(t *testing.T) {
+	prevRamp := *drainRamp
+	*drainRamp = 0
+	defer func() { *drainRamp = prevRamp }()
+
+	lb := NewLoadBalancer()
+	for i := range lb.servers {
+		lb.updateServerHealth(i, true)
+	}
+	now := time.Now()
+	lb.updateServerWeight(1, 40, now)
+	lb.updateServerWeight(2, 0, now)
+
+	roll := 50
+	lb.roll = func(n int) int { return roll }
+	addresses := func() []string {
+		var result []string
+		for _, server := range lb.admitByWeight(lb.getHealthyServers(), now) {
+			result = append(result, server.address)
+		}
+		return result
+	}
+
+	if admitted := addresses(); len(admitted) != 1 || admitted[0] != serversPool[0] {
+		t.Errorf("Expected only the full weight backend above the roll, got %v", admitted)
+	}
+	roll = 10
+	if admitted := addresses(); len(admitted) != 2 {
+		t.Errorf("Expected both weighted backends below the roll, got %v", admitted)
+	}
+
+	lb.updateServerHealth(0, false)
+	lb.updateServerHealth(1, false)
+	if admitted := addresses(); len(admitted) != 1 || admitted[0] != serversPool[2] {
+		t.Errorf("Expected a drained backend to serve when nothing else can, got %v", admitted)
+	}
+
+	if _, err := lb.getServerExcluding(lb.describeClient(httptest.NewRequest("GET", "/", nil)), serversPool[2]); err == nil {
+		t.Error("Expected no backend once the drained one is excluded")
+	}
+}
+
+func TestCheckServer_ReadsDrainHeader(t *testing.T) {
+	*https = false
+	prevRamp := *drainRamp
+	*drainRamp = 0
+	defer func() { *drainRamp = prevRamp }()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(drainHeader, "true")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	lb := NewLoadBalancer()
+	lb.servers[0].address = backend.URL[7:]
+	if status := lb.checkServer(0); status != probeHealthy {
+		t.Fatalf("Expected a draining backend to stay healthy, got %s", status)
+	}
+	if weight := lb.servers[0].effectiveWeight(time.Now()); weight != 0 {
+		t.Errorf("Expected the draining backend to lose its weight, got %d", weight)
+	}
+}
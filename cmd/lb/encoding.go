@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The balancer passes content codings through: it forwards Accept-Encoding
+// as the client sent it, never asks backends for a coding the client did
+// not, and never compresses or decompresses bodies itself. A backend that
+// compresses thereby answers with exactly what the client asked for, and
+// nothing is compressed twice or decompressed only to be compressed again.
+// Stored responses are told apart by the codings their request accepted.
+
+// acceptedEncodings returns the codings the Accept-Encoding header of r
+// accepts, lower case, sorted and comma separated, so that requests that
+// accept the same codings in another order or spelling get the same cached
+// response. Codings refused with q=0 are left out.
+func acceptedEncodings(r *http.Request) string {
+	var codings []string
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(item, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" || refused(params) {
+				continue
+			}
+			codings = append(codings, coding)
+		}
+	}
+	sort.Strings(codings)
+	return strings.Join(codings, ",")
+}
+
+// refused reports whether the parameters of an Accept-Encoding item give it
+// a quality of zero.
+func refused(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && strings.EqualFold(name, "q") {
+			value = strings.TrimRight(strings.TrimSpace(value), "0")
+			return value == "" || value == "0." || value == "0"
+		}
+	}
+	return false
+}
+
+// variesOnlyByEncoding reports whether a response that names the request
+// headers it depends on in Vary depends on no other than Accept-Encoding,
+// the one the cache tells requests apart by.
+func variesOnlyByEncoding(header http.Header) bool {
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" && !strings.EqualFold(name, "Accept-Encoding") {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// gzipBackend compresses its payload for clients that accept gzip, and
+// remembers the Accept-Encoding of the requests it gets.
+func gzipBackend(t *testing.T, payload string) (*httptest.Server, *[]string) {
+	t.Helper()
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Write([]byte(payload))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		writer := gzip.NewWriter(w)
+		writer.Write([]byte(payload))
+		writer.Close()
+	}))
+	t.Cleanup(server.Close)
+	return server, &seen
+}
+
+func assertGzipped(t *testing.T, response *httptest.ResponseRecorder, payload string) {
+	t.Helper()
+	if values := response.Header().Values("Content-Encoding"); len(values) != 1 || values[0] != "gzip" {
+		t.Fatalf("Expected Content-Encoding gzip once, got %v", values)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(response.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected a gzip body, got %q (%v)", response.Body.Bytes(), err)
+	}
+	if body, err := io.ReadAll(reader); err != nil || string(body) != payload {
+		t.Errorf("Expected %q, got %q (%v)", payload, body, err)
+	}
+}
+
+func TestServeHTTP_PassesContentEncodingThrough(t *testing.T) {
+	backend, seen := gzipBackend(t, "payload")
+	*https = false
+	lb := &LoadBalancer{servers: []ServerConnections{{address: backend.URL[7:], health: true}}}
+
+	compressed := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/data", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	lb.ServeHTTP(compressed, req)
+	assertGzipped(t, compressed, "payload")
+
+	plain := httptest.NewRecorder()
+	lb.ServeHTTP(plain, httptest.NewRequest(http.MethodGet, "http://example.com/data", nil))
+	if plain.Body.String() != "payload" || plain.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected an uncompressed body, got %q %v", plain.Body.String(), plain.Header())
+	}
+	if len(*seen) != 2 || (*seen)[0] != "gzip" || (*seen)[1] != "" {
+		t.Errorf("Expected Accept-Encoding to be forwarded as sent, got %q", *seen)
+	}
+}
+
+func TestServeHTTP_CachesResponsesPerEncoding(t *testing.T) {
+	backend, seen := gzipBackend(t, "payload")
+	*https = false
+	lb := &LoadBalancer{
+		servers: []ServerConnections{{address: backend.URL[7:], health: true}},
+		cache:   newDatastoreCache(newFakeDatastore(t).URL + "/db/"),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/data", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	lb.ServeHTTP(httptest.NewRecorder(), req)
+	waitForCacheStore(t, lb.cache, cacheKey(req))
+
+	plain := httptest.NewRecorder()
+	lb.ServeHTTP(plain, httptest.NewRequest(http.MethodGet, "http://example.com/data", nil))
+	if plain.Header().Get("X-Cache") == "HIT" || plain.Body.String() != "payload" {
+		t.Errorf("Expected the compressed response not to be served uncompressed, got %q %v", plain.Body.String(), plain.Header())
+	}
+
+	hit := httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "http://example.com/data", nil)
+	req.Header.Set("Accept-Encoding", "BR,gzip;q=0.8, zstd;q=0")
+	lb.ServeHTTP(hit, req)
+	if hit.Header().Get("X-Cache") != "HIT" {
+		t.Error("Expected a request accepting the same codings to be served from cache")
+	}
+	assertGzipped(t, hit, "payload")
+	if len(*seen) != 2 {
+		t.Errorf("Expected 2 backend requests, got %d", len(*seen))
+	}
+}
+
+func TestServeHTTP_ReplaysKeepContentEncoding(t *testing.T) {
+	backend, _ := gzipBackend(t, "payload")
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadAddr := dead.URL[7:]
+	dead.Close()
+	*https = false
+	lb := &LoadBalancer{servers: []ServerConnections{
+		{address: deadAddr, health: true},
+		{address: backend.URL[7:], health: true},
+	}}
+
+	response := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/data", nil)
+	req.RemoteAddr = clientRoutedTo(t, lb, deadAddr)
+	req.Header.Set("Accept-Encoding", "gzip")
+	lb.ServeHTTP(response, req)
+	assertGzipped(t, response, "payload")
+}
+
+func TestAcceptedEncodings(t *testing.T) {
+	testCases := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"br, GZIP", "br,gzip"},
+		{"gzip;q=1.0, deflate;q=0, br;q=0.000", "gzip"},
+		{"identity;q=0.5, *;q=0.1", "*,identity"},
+	}
+	for _, tc := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", tc.header)
+		if got := acceptedEncodings(r); got != tc.want {
+			t.Errorf("Expected %q for %q, got %q", tc.want, tc.header, got)
+		}
+	}
+}
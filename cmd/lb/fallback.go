@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	fallbackFile = flag.String("fallback-file", "", "JSON file with static responses per route served when no backend is healthy")
+
+	fallbackResponses = expvar.NewMap("lb_fallback_responses")
+)
+
+// fallbackRoute is the response served for paths under Prefix while no
+// backend is healthy. With LastKnownGood set, a URL under the route that was
+// successfully fetched before gets the last response proxied for it instead.
+type fallbackRoute struct {
+	Prefix        string      `json:"prefix"`
+	Status        int         `json:"status"`
+	Header        http.Header `json:"header"`
+	Body          string      `json:"body"`
+	LastKnownGood bool        `json:"last_known_good"`
+}
+
+// fallbacks holds the configured routes, longest prefix first, and the last
+// known good responses recorded for them by request URI and accepted
+// content codings.
+type fallbacks struct {
+	routes []fallbackRoute
+
+	mu       sync.RWMutex
+	lastGood map[string]*cachedResponse
+}
+
+func loadFallbacks(path string) (*fallbacks, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFallbacks(data)
+}
+
+func parseFallbacks(data []byte) (*fallbacks, error) {
+	var routes []fallbackRoute
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("invalid fallback routes: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range routes {
+		route := &routes[i]
+		if !strings.HasPrefix(route.Prefix, "/") {
+			return nil, fmt.Errorf("route %q does not start with /", route.Prefix)
+		}
+		if seen[route.Prefix] {
+			return nil, fmt.Errorf("route %q is listed more than once", route.Prefix)
+		}
+		seen[route.Prefix] = true
+		if route.Status == 0 {
+			route.Status = http.StatusServiceUnavailable
+		}
+		if route.Status < 100 || route.Status > 599 {
+			return nil, fmt.Errorf("route %q has invalid status %d", route.Prefix, route.Status)
+		}
+	}
+	// The longest matching prefix wins.
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].Prefix) > len(routes[j].Prefix)
+	})
+	return &fallbacks{routes: routes, lastGood: make(map[string]*cachedResponse)}, nil
+}
+
+func (f *fallbacks) match(path string) *fallbackRoute {
+	if f == nil {
+		return nil
+	}
+	for i := range f.routes {
+		if strings.HasPrefix(path, f.routes[i].Prefix) {
+			return &f.routes[i]
+		}
+	}
+	return nil
+}
+
+// record returns the writer to proxy r through so that a successful response
+// is kept as the last known good one of its route, and a function to call
+// once the response is complete.
+func (f *fallbacks) record(rw http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	route := f.match(r.URL.Path)
+	if route == nil || !route.LastKnownGood || r.Method != http.MethodGet {
+		return rw, func() {}
+	}
+
+	capture := &capturingWriter{ResponseWriter: rw, limit: *cacheMaxBody}
+	return capture, func() {
+		if capture.overflow || capture.status != http.StatusOK {
+			return
+		}
+		header := capture.Header().Clone()
+		header.Del("lb-from")
+		header.Del(traceIDHeader)
+		response := &cachedResponse{
+			Status: capture.status,
+			Header: header,
+			Body:   bytes.Clone(capture.body.Bytes()),
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.lastGood[lastGoodKey(r)] = response
+	}
+}
+
+// fallbackHeader marks responses served by a fallback rather than a backend,
+// which are never cached.
+const fallbackHeader = "X-Fallback"
+
+func lastGoodKey(r *http.Request) string {
+	return r.URL.RequestURI() + "\n" + acceptedEncodings(r)
+}
+
+// serve writes the fallback response for r and reports whether its path has
+// one.
+func (f *fallbacks) serve(rw http.ResponseWriter, r *http.Request) bool {
+	route := f.match(r.URL.Path)
+	if route == nil {
+		return false
+	}
+
+	f.mu.RLock()
+	lastGood := f.lastGood[lastGoodKey(r)]
+	f.mu.RUnlock()
+
+	if lastGood != nil {
+		fallbackResponses.Add("last-known-good", 1)
+		for k, values := range lastGood.Header {
+			for _, value := range values {
+				rw.Header().Add(k, value)
+			}
+		}
+		rw.Header().Set(fallbackHeader, "last-known-good")
+		rw.WriteHeader(lastGood.Status)
+		rw.Write(lastGood.Body)
+		return true
+	}
+
+	fallbackResponses.Add("static", 1)
+	for k, values := range route.Header {
+		for _, value := range values {
+			rw.Header().Add(k, value)
+		}
+	}
+	rw.Header().Set(fallbackHeader, "static")
+	rw.WriteHeader(route.Status)
+	rw.Write([]byte(route.Body))
+	return true
+}
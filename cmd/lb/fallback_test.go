@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseFallbacks(t *testing.T) {
+	f, err := parseFallbacks([]byte(`[
+		{"prefix": "/", "body": "down"},
+		{"prefix": "/api/v1/some-data", "status": 200, "body": "{}", "last_known_good": true}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route := f.match("/api/v1/some-data?key=a"); route == nil || route.Status != http.StatusOK {
+		t.Errorf("Expected the longer prefix to match, got %+v", route)
+	}
+	if route := f.match("/other"); route == nil || route.Status != http.StatusServiceUnavailable {
+		t.Errorf("Expected the catch-all route with the default status, got %+v", route)
+	}
+
+	for name, data := range map[string]string{
+		"not json":       `{`,
+		"relative route": `[{"prefix": "api"}]`,
+		"duplicate":      `[{"prefix": "/a"}, {"prefix": "/a"}]`,
+		"bad status":     `[{"prefix": "/a", "status": 1000}]`,
+	} {
+		if _, err := parseFallbacks([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestServeHTTP_Fallback(t *testing.T) {
+	*https = false
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"value":"` + r.URL.Query().Get("key") + `"}`))
+	}))
+	defer backend.Close()
+
+	f, err := parseFallbacks([]byte(`[
+		{"prefix": "/api/", "header": {"Content-Type": ["application/json"]}, "body": "{\"maintenance\":true}", "last_known_good": true}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := &LoadBalancer{
+		servers:   []ServerConnections{{address: backend.URL[7:], health: true, weight: fullWeight}},
+		fallbacks: f,
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		lb.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		return recorder
+	}
+
+	if recorder := get("http://example.com/api/data?key=a"); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the backend response, got %d", recorder.Code)
+	}
+	lb.updateServerHealth(0, false)
+
+	recorder := get("http://example.com/api/data?key=a")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != `{"value":"a"}` {
+		t.Errorf("Expected the last known good response, got %d %q", recorder.Code, recorder.Body)
+	}
+	if recorder.Header().Get("X-Fallback") != "last-known-good" {
+		t.Errorf("Expected X-Fallback: last-known-good, got %q", recorder.Header().Get("X-Fallback"))
+	}
+
+	recorder = get("http://example.com/api/data?key=b")
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Body.String() != `{"maintenance":true}` {
+		t.Errorf("Expected the static response, got %d %q", recorder.Code, recorder.Body)
+	}
+	if recorder.Header().Get("Content-Type") != "application/json" || recorder.Header().Get("X-Fallback") != "static" {
+		t.Errorf("Expected the configured headers, got %v", recorder.Header())
+	}
+
+	if recorder := get("http://example.com/other"); recorder.Header().Get("X-Fallback") != "" {
+		t.Error("Expected no fallback outside the configured routes")
+	}
+}
+
+func TestServeHTTP_FallbackIsNotCached(t *testing.T) {
+	*https = false
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("live"))
+	}))
+	defer backend.Close()
+
+	f, err := parseFallbacks([]byte(`[
+		{"prefix": "/api/", "status": 200, "header": {"Cache-Control": ["max-age=60"]}, "body": "fallback"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newFakeDatastore(t)
+	cache := newDatastoreCache(db.URL + "/db/")
+	lb := &LoadBalancer{
+		servers:   []ServerConnections{{address: backend.URL[7:], health: false, weight: fullWeight}},
+		fallbacks: f,
+		cache:     cache,
+	}
+	get := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		lb.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/api/data", nil))
+		return recorder
+	}
+
+	if recorder := get(); recorder.Body.String() != "fallback" {
+		t.Fatalf("Expected the static fallback, got %d %q", recorder.Code, recorder.Body)
+	}
+	// The cache is filled in the background.
+	time.Sleep(50 * time.Millisecond)
+
+	lb.updateServerHealth(0, true)
+	if recorder := get(); recorder.Body.String() != "live" || recorder.Header().Get(fallbackHeader) != "" {
+		t.Errorf("Expected the live response once the backend recovered, got %q (%s: %q)", recorder.Body, fallbackHeader, recorder.Header().Get(fallbackHeader))
+	}
+	waitForCacheStore(t, cache, cacheKey(httptest.NewRequest(http.MethodGet, "http://example.com/api/data", nil)))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	headerLimitDrop   = "drop"
+	headerLimitReject = "reject"
+)
+
+var (
+	maxForwardHeaders     = flag.Int("max-forward-headers", 64, "max number of request header fields forwarded to backends")
+	maxForwardHeaderBytes = flag.Int("max-forward-header-bytes", 32*1024, "max total size of request headers forwarded to backends")
+	maxHeaderValueBytes   = flag.Int("max-header-value-bytes", 8*1024, "max size of a single forwarded request header value")
+	headerLimitAction     = flag.String("header-limit-action", headerLimitReject, "what to do with requests over header limits: drop or reject")
+
+	droppedRequestHeaders  = expvar.NewInt("lb_dropped_request_headers")
+	rejectedHeaderRequests = expvar.NewInt("lb_rejected_header_requests")
+)
+
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func validateHeaderLimits() error {
+	if *headerLimitAction != headerLimitDrop && *headerLimitAction != headerLimitReject {
+		return fmt.Errorf("unknown header limit action: %s", *headerLimitAction)
+	}
+	if *maxForwardHeaders <= 0 || *maxForwardHeaderBytes <= 0 || *maxHeaderValueBytes <= 0 {
+		return fmt.Errorf("header limits must be positive")
+	}
+	return nil
+}
+
+// limitRequestHeaders enforces the forwarded header budget. In drop mode the
+// offending fields are removed from the header in place; in reject mode an
+// error is returned and the header is left untouched.
+func limitRequestHeaders(header http.Header) error {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields, totalBytes := 0, 0
+	for _, name := range names {
+		kept := header[name][:0]
+		for _, value := range header[name] {
+			size := len(name) + len(value)
+			var violation string
+			switch {
+			case len(value) > *maxHeaderValueBytes:
+				violation = fmt.Sprintf("header %s value exceeds %d bytes", name, *maxHeaderValueBytes)
+			case fields+1 > *maxForwardHeaders:
+				violation = fmt.Sprintf("more than %d header fields", *maxForwardHeaders)
+			case totalBytes+size > *maxForwardHeaderBytes:
+				violation = fmt.Sprintf("headers exceed %d bytes", *maxForwardHeaderBytes)
+			}
+
+			if violation != "" {
+				if *headerLimitAction == headerLimitReject {
+					rejectedHeaderRequests.Add(1)
+					return fmt.Errorf("request rejected: %s", violation)
+				}
+				droppedRequestHeaders.Add(1)
+				continue
+			}
+
+			fields++
+			totalBytes += size
+			kept = append(kept, value)
+		}
+
+		if len(kept) == 0 {
+			header.Del(name)
+		} else {
+			header[name] = kept
+		}
+	}
+	return nil
+}
+
+func removeHopByHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func withHeaderLimits(t *testing.T, fields, totalBytes, valueBytes int, action string) {
+	t.Helper()
+
+	prev := []int{*maxForwardHeaders, *maxForwardHeaderBytes, *maxHeaderValueBytes}
+	prevAction := *headerLimitAction
+	*maxForwardHeaders, *maxForwardHeaderBytes, *maxHeaderValueBytes = fields, totalBytes, valueBytes
+	*headerLimitAction = action
+	t.Cleanup(func() {
+		*maxForwardHeaders, *maxForwardHeaderBytes, *maxHeaderValueBytes = prev[0], prev[1], prev[2]
+		*headerLimitAction = prevAction
+	})
+}
+
+func TestLimitRequestHeaders(t *testing.T) {
+	t.Run("reject too many fields", func(t *testing.T) {
+		withHeaderLimits(t, 3, 1024, 128, headerLimitReject)
+
+		header := http.Header{}
+		for i := 0; i < 5; i++ {
+			header.Set(fmt.Sprintf("X-H%d", i), "v")
+		}
+		if err := limitRequestHeaders(header); err == nil {
+			t.Error("Expected too many headers to be rejected")
+		}
+		if len(header) != 5 {
+			t.Errorf("Rejected header should stay untouched, got %d fields", len(header))
+		}
+	})
+
+	t.Run("drop oversized value", func(t *testing.T) {
+		withHeaderLimits(t, 10, 1024, 8, headerLimitDrop)
+
+		header := http.Header{}
+		header.Set("X-Small", "ok")
+		header.Set("X-Large", strings.Repeat("x", 9))
+		if err := limitRequestHeaders(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Get("X-Large") != "" {
+			t.Error("Oversized header should be dropped")
+		}
+		if header.Get("X-Small") != "ok" {
+			t.Error("Small header should be kept")
+		}
+	})
+
+	t.Run("drop past total budget", func(t *testing.T) {
+		withHeaderLimits(t, 10, 20, 128, headerLimitDrop)
+
+		header := http.Header{}
+		header.Set("X-A", strings.Repeat("a", 10))
+		header.Set("X-B", strings.Repeat("b", 10))
+		if err := limitRequestHeaders(header); err != nil {
+			t.Fatal(err)
+		}
+		if len(header) != 1 || header.Get("X-A") == "" {
+			t.Errorf("Expected only X-A to be kept, got %v", header)
+		}
+	})
+}
+
+func TestServeHTTP_RejectsOversizedHeaders(t *testing.T) {
+	withHeaderLimits(t, 2, 1024, 128, headerLimitReject)
+
+	lb := &LoadBalancer{}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-A", "1")
+	req.Header.Set("X-B", "2")
+	req.Header.Set("X-C", "3")
+	recorder := httptest.NewRecorder()
+
+	lb.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestHeaderFieldsTooLarge, recorder.Code)
+	}
+}
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("Connection", "close, X-Internal")
+	header.Set("X-Internal", "secret")
+	header.Set("Keep-Alive", "timeout=5")
+	header.Set("X-Kept", "yes")
+
+	removeHopByHopHeaders(header)
+
+	for _, name := range []string{"Connection", "X-Internal", "Keep-Alive"} {
+		if header.Get(name) != "" {
+			t.Errorf("Header %s should be removed", name)
+		}
+	}
+	if header.Get("X-Kept") != "yes" {
+		t.Error("End-to-end header should be kept")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// healthSummaryKeyPrefix is reserved for balancer summaries. Keys continue
+// with the replica, the backend and the end of the summarized window, so a
+// prefix scan returns the history of one backend in order.
+const healthSummaryKeyPrefix = "lb-summary/"
+
+var (
+	healthSummariesEnabled = flag.Bool("health-summaries", false, "whether to periodically write backend health and latency summaries into the datastore")
+	healthSummaryInterval  = flag.Duration("health-summary-interval", time.Minute, "how often backend summaries are written")
+)
+
+type healthSummary struct {
+	Replica       string    `json:"replica"`
+	Backend       string    `json:"backend"`
+	Healthy       bool      `json:"healthy"`
+	Status        string    `json:"status"`
+	CheckedAt     time.Time `json:"checked_at"`
+	WindowStart   time.Time `json:"window_start"`
+	WindowEnd     time.Time `json:"window_end"`
+	Requests      int64     `json:"requests"`
+	TTFBMeanMs    float64   `json:"ttfb_mean_ms"`
+	LatencyMeanMs float64   `json:"latency_mean_ms"`
+}
+
+type latencyTotals struct {
+	ttfbCount, latencyCount int64
+	ttfb, latency           time.Duration
+}
+
+// healthSummaries writes what happened to every backend since the previous
+// summary, computed from the cumulative latency metrics.
+type healthSummaries struct {
+	db       *dbClient
+	replica  string
+	since    time.Time
+	previous map[string]latencyTotals
+}
+
+func newHealthSummaries(db *dbClient, replica string, now time.Time) *healthSummaries {
+	return &healthSummaries{
+		db:       db,
+		replica:  replica,
+		since:    now,
+		previous: make(map[string]latencyTotals),
+	}
+}
+
+func (s *healthSummaries) write(lb *LoadBalancer, now time.Time) {
+	lb.mu.RLock()
+	servers := append([]ServerConnections(nil), lb.servers...)
+	lb.mu.RUnlock()
+
+	for _, server := range servers {
+		current := currentLatencyTotals(server.address)
+		previous := s.previous[server.address]
+		s.previous[server.address] = current
+
+		summary := healthSummary{
+			Replica:       s.replica,
+			Backend:       server.address,
+			Healthy:       server.health,
+			Status:        server.status.String(),
+			CheckedAt:     server.checkedAt,
+			WindowStart:   s.since,
+			WindowEnd:     now,
+			Requests:      current.latencyCount - previous.latencyCount,
+			TTFBMeanMs:    meanMs(current.ttfb-previous.ttfb, current.ttfbCount-previous.ttfbCount),
+			LatencyMeanMs: meanMs(current.latency-previous.latency, current.latencyCount-previous.latencyCount),
+		}
+		if err := s.db.putJSON(healthSummaryKey(s.replica, server.address, now), summary); err != nil {
+			log.Printf("Failed to write health summary of %s: %s", server.address, err)
+		}
+	}
+	s.since = now
+}
+
+func healthSummaryKey(replica, backend string, at time.Time) string {
+	return fmt.Sprintf("%s%s/%s/%s", healthSummaryKeyPrefix, replica, backend, at.UTC().Format("20060102T150405Z"))
+}
+
+func currentLatencyTotals(backend string) latencyTotals {
+	var totals latencyTotals
+	if stats, ok := lookupLatencyStats(backendTTFB, backend); ok {
+		totals.ttfbCount, totals.ttfb = stats.totals()
+	}
+	if stats, ok := lookupLatencyStats(backendLatency, backend); ok {
+		totals.latencyCount, totals.latency = stats.totals()
+	}
+	return totals
+}
+
+func lookupLatencyStats(metrics *expvar.Map, backend string) (*latencyStats, bool) {
+	stats, ok := metrics.Get(backend).(*latencyStats)
+	return stats, ok
+}
+
+func meanMs(total time.Duration, count int64) float64 {
+	if count <= 0 {
+		return 0
+	}
+	return (total / time.Duration(count)).Seconds() * 1000
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthSummaries(t *testing.T) {
+	db := newFakeDatastore(t)
+	client := newDbClient(db.URL + "/db/")
+
+	lb := &LoadBalancer{
+		servers: []ServerConnections{
+			{address: "summary1:8080"},
+			{address: "summary2:8080"},
+		},
+	}
+	lb.updateServerHealth(0, true)
+	lb.updateServerHealth(1, false)
+
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	summaries := newHealthSummaries(client, "lb-1", start)
+
+	observeLatency(backendTTFB, "summary1:8080", 10*time.Millisecond)
+	observeLatency(backendLatency, "summary1:8080", 30*time.Millisecond)
+	observeLatency(backendTTFB, "summary1:8080", 20*time.Millisecond)
+	observeLatency(backendLatency, "summary1:8080", 50*time.Millisecond)
+
+	first := start.Add(time.Minute)
+	summaries.write(lb, first)
+
+	var summary healthSummary
+	if err := client.getJSON(healthSummaryKey("lb-1", "summary1:8080", first), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if !summary.Healthy || summary.Requests != 2 || summary.TTFBMeanMs != 15 || summary.LatencyMeanMs != 40 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	if !summary.WindowStart.Equal(start) || !summary.WindowEnd.Equal(first) {
+		t.Errorf("Unexpected window %s - %s", summary.WindowStart, summary.WindowEnd)
+	}
+
+	if err := client.getJSON(healthSummaryKey("lb-1", "summary2:8080", first), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Healthy || summary.Status != probeUnhealthy.String() || summary.Requests != 0 {
+		t.Errorf("Unexpected summary of an unhealthy backend %+v", summary)
+	}
+
+	observeLatency(backendTTFB, "summary1:8080", 40*time.Millisecond)
+	observeLatency(backendLatency, "summary1:8080", 100*time.Millisecond)
+	second := first.Add(time.Minute)
+	summaries.write(lb, second)
+
+	if err := client.getJSON(healthSummaryKey("lb-1", "summary1:8080", second), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Requests != 1 || summary.TTFBMeanMs != 40 || summary.LatencyMeanMs != 100 || !summary.WindowStart.Equal(first) {
+		t.Errorf("Expected only the second window to be summarized, got %+v", summary)
+	}
+}
+
+func TestHealthSummaryKeysSortByTime(t *testing.T) {
+	earlier := healthSummaryKey("lb-1", "server1:8080", time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC))
+	later := healthSummaryKey("lb-1", "server1:8080", time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC))
+	if earlier >= later {
+		t.Errorf("Expected %s to sort before %s", earlier, later)
+	}
+}
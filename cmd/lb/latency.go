@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ttfbFailover = flag.Duration("ttfb-failover", 0, "retry replayable requests on another backend when response headers take longer than this (disabled when 0)")
+
+	backendTTFB      = expvar.NewMap("lb_backend_ttfb")
+	backendLatency   = expvar.NewMap("lb_backend_latency")
+	ttfbFailovers    = expvar.NewInt("lb_ttfb_failovers")
+	errSlowFirstByte = fmt.Errorf("backend did not respond within the first byte limit: %w", context.DeadlineExceeded)
+)
+
+// latencyStats summarizes observed durations of one backend. Time to first
+// byte and total latency are tracked separately, so that large responses
+// from healthy backends do not look like slow backends.
+type latencyStats struct {
+	mu    sync.Mutex
+	count int64
+	total time.Duration
+	max   time.Duration
+	last  time.Duration
+}
+
+func (s *latencyStats) observe(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	s.total += d
+	s.last = d
+	if d > s.max {
+		s.max = d
+	}
+}
+
+// totals returns how many durations were observed and their sum.
+func (s *latencyStats) totals() (int64, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count, s.total
+}
+
+func (s *latencyStats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var mean time.Duration
+	if s.count > 0 {
+		mean = s.total / time.Duration(s.count)
+	}
+	encoded, _ := json.Marshal(map[string]interface{}{
+		"count":   s.count,
+		"mean_ms": mean.Seconds() * 1000,
+		"max_ms":  s.max.Seconds() * 1000,
+		"last_ms": s.last.Seconds() * 1000,
+	})
+	return string(encoded)
+}
+
+var latencyStatsMu sync.Mutex
+
+func observeLatency(metrics *expvar.Map, backend string, d time.Duration) {
+	latencyStatsMu.Lock()
+	stats, ok := metrics.Get(backend).(*latencyStats)
+	if !ok {
+		stats = &latencyStats{}
+		metrics.Set(backend, stats)
+	}
+	latencyStatsMu.Unlock()
+	stats.observe(d)
+}
+
+func validateTTFBFailover() error {
+	if *ttfbFailover < 0 {
+		return fmt.Errorf("ttfb failover must not be negative")
+	}
+	if *ttfbFailover > *responseHeaderTimeout {
+		return fmt.Errorf("ttfb failover %s exceeds response header timeout %s", *ttfbFailover, *responseHeaderTimeout)
+	}
+	return nil
+}
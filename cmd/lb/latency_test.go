@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func withTTFBFailover(t *testing.T, limit time.Duration) {
+	t.Helper()
+	prev := *ttfbFailover
+	*ttfbFailover = limit
+	t.Cleanup(func() { *ttfbFailover = prev })
+}
+
+func newLatencyTestBalancer(t *testing.T) (*LoadBalancer, string, string) {
+	t.Helper()
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(300 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte("slow"))
+	}))
+	t.Cleanup(slowServer.Close)
+
+	fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	t.Cleanup(fastServer.Close)
+
+	lb := &LoadBalancer{
+		servers: []ServerConnections{
+			{address: slowServer.URL[7:], health: true},
+			{address: fastServer.URL[7:], health: true},
+		},
+	}
+	return lb, slowServer.URL[7:], fastServer.URL[7:]
+}
+
+func TestServeHTTP_FailsOverOnSlowFirstByte(t *testing.T) {
+	lb, slowAddr, _ := newLatencyTestBalancer(t)
+	*https = false
+	withTTFBFailover(t, 50*time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/api", nil)
+	req.RemoteAddr = clientRoutedTo(t, lb, slowAddr)
+	recorder := httptest.NewRecorder()
+
+	before := ttfbFailovers.Value()
+	lb.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "fast" {
+		t.Errorf("Expected the fast backend to answer, got %d %q", recorder.Code, recorder.Body.String())
+	}
+	if ttfbFailovers.Value() != before+1 {
+		t.Error("Expected the failover to be counted")
+	}
+}
+
+func TestServeHTTP_WaitsForSlowFirstByteWithoutFailover(t *testing.T) {
+	lb, slowAddr, _ := newLatencyTestBalancer(t)
+	*https = false
+	withTTFBFailover(t, 0)
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/api", nil)
+	req.RemoteAddr = clientRoutedTo(t, lb, slowAddr)
+	recorder := httptest.NewRecorder()
+	lb.ServeHTTP(recorder, req)
+
+	if recorder.Body.String() != "slow" {
+		t.Errorf("Expected the slow backend to answer, got %q", recorder.Body.String())
+	}
+}
+
+func TestProxy_TracksFirstByteSeparately(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("body"))
+	}))
+	defer backend.Close()
+	*https = false
+
+	if err := forward(backend.URL[7:], httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	var ttfb, total map[string]float64
+	json.Unmarshal([]byte(backendTTFB.Get(backend.URL[7:]).String()), &ttfb)
+	json.Unmarshal([]byte(backendLatency.Get(backend.URL[7:]).String()), &total)
+
+	if ttfb["count"] != 1 || total["count"] != 1 {
+		t.Fatalf("Expected one observation each, got %v and %v", ttfb, total)
+	}
+	if ttfb["last_ms"] >= 100 || total["last_ms"] < 100 {
+		t.Errorf("Expected first byte before the body delay and total after it, got %vms and %vms", ttfb["last_ms"], total["last_ms"])
+	}
+}
+
+func TestValidateTTFBFailover(t *testing.T) {
+	withTimeouts(t, time.Second, time.Minute)
+
+	withTTFBFailover(t, 2*time.Second)
+	if err := validateTTFBFailover(); err == nil {
+		t.Error("Expected a limit above the response header timeout to be rejected")
+	}
+	withTTFBFailover(t, 100*time.Millisecond)
+	if err := validateTTFBFailover(); err != nil {
+		t.Error(err)
+	}
+}
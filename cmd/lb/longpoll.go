@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	longPollRoutes = flag.String("long-poll-routes", "", "comma separated path prefixes proxied without a response timeout")
+	flushInterval  = flag.Duration("flush-interval", 100*time.Millisecond, "how often streamed responses are flushed to the client")
+)
+
+func isLongPollRoute(path string) bool {
+	for _, prefix := range strings.Split(*longPollRoutes, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix != "" && strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// latencyWriter flushes buffered response data at most interval after it
+// was written, so slow streams reach the client without per-write flushes.
+type latencyWriter struct {
+	dst        io.Writer
+	controller *http.ResponseController
+	interval   time.Duration
+
+	mu           sync.Mutex
+	flushPending bool
+	timer        *time.Timer
+}
+
+func (w *latencyWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n, err := w.dst.Write(data)
+	if w.interval <= 0 {
+		w.controller.Flush()
+		return n, err
+	}
+	if !w.flushPending {
+		w.flushPending = true
+		if w.timer == nil {
+			w.timer = time.AfterFunc(w.interval, w.delayedFlush)
+		} else {
+			w.timer.Reset(w.interval)
+		}
+	}
+	return n, err
+}
+
+func (w *latencyWriter) delayedFlush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.flushPending {
+		w.controller.Flush()
+		w.flushPending = false
+	}
+}
+
+func (w *latencyWriter) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if w.flushPending {
+		w.controller.Flush()
+		w.flushPending = false
+	}
+}
+
+func copyStreaming(rw http.ResponseWriter, body io.Reader, interval time.Duration) error {
+	writer := &latencyWriter{
+		dst:        rw,
+		controller: http.NewResponseController(rw),
+		interval:   interval,
+	}
+	defer writer.stop()
+
+	_, err := io.Copy(writer, body)
+	return err
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxy_LongPollRouteOutlivesTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < 3; i++ {
+			w.Write([]byte("data: tick\n\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+	*https = false
+
+	prevTimeout, prevRoutes := *requestTimeout, *longPollRoutes
+	*requestTimeout, *longPollRoutes = 60*time.Millisecond, "/watch"
+	defer func() { *requestTimeout, *longPollRoutes = prevTimeout, prevRoutes }()
+
+	lb := &LoadBalancer{servers: []ServerConnections{{address: backend.URL[7:], health: true}}}
+	frontend := httptest.NewServer(lb)
+	defer frontend.Close()
+
+	resp, err := http.Get(frontend.URL + "/watch/keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	firstLine := make(chan string, 1)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		firstLine <- line
+	}()
+	select {
+	case line := <-firstLine:
+		if !strings.HasPrefix(line, "data: tick") {
+			t.Errorf("Unexpected first event %q", line)
+		}
+	case <-time.After(40 * time.Millisecond):
+		t.Fatal("First event was not flushed to the client")
+	}
+
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Stream was cut: %v", err)
+	}
+	if strings.Count(string(rest), "data: tick") != 2 {
+		t.Errorf("Expected remaining two events, got %q", rest)
+	}
+}
+
+func TestIsLongPollRoute(t *testing.T) {
+	prev := *longPollRoutes
+	*longPollRoutes = "/watch, /db/stream"
+	defer func() { *longPollRoutes = prev }()
+
+	if !isLongPollRoute("/db/stream/keys") || !isLongPollRoute("/watch") {
+		t.Error("Expected configured prefixes to be long-poll routes")
+	}
+	if isLongPollRoute("/api/v1/some-data") {
+		t.Error("Regular route should not be long-poll")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var (
+	rejectSuspiciousPaths = flag.Bool("reject-suspicious-paths", false, "whether to reject requests with traversal or encoded separators in the path instead of normalizing them")
+
+	normalizedPaths = expvar.NewInt("lb_normalized_paths")
+	rejectedPaths   = expvar.NewInt("lb_rejected_paths")
+)
+
+// normalizePath rewrites an escaped request path into its canonical form:
+// unreserved percent-escapes are decoded, duplicate slashes collapsed and
+// dot segments resolved. The returned flag reports constructs that are
+// commonly used to sneak past prefix-based routing rules.
+func normalizePath(escaped string) (string, bool) {
+	suspicious := !strings.HasPrefix(escaped, "/")
+
+	var decoded strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		c := escaped[i]
+		if c == '\\' {
+			suspicious = true
+		}
+		if c != '%' {
+			decoded.WriteByte(c)
+			continue
+		}
+		if i+2 >= len(escaped) || !isHex(escaped[i+1]) || !isHex(escaped[i+2]) {
+			// A stray percent sign must not combine with the characters
+			// after it into an escape when the path is decoded again.
+			suspicious = true
+			decoded.WriteString("%25")
+			continue
+		}
+
+		b := unhex(escaped[i+1])<<4 | unhex(escaped[i+2])
+		i += 2
+		switch {
+		case isUnreserved(b):
+			decoded.WriteByte(b)
+		default:
+			if b == '/' || b == '\\' || b == 0 {
+				suspicious = true
+			}
+			fmt.Fprintf(&decoded, "%%%02X", b)
+		}
+	}
+
+	segments := strings.Split(decoded.String(), "/")
+	cleaned := make([]string, 0, len(segments))
+	trailingSlash := false
+	for _, segment := range segments {
+		trailingSlash = false
+		switch segment {
+		case "":
+			trailingSlash = true
+		case ".":
+			trailingSlash = true
+		case "..":
+			suspicious = true
+			trailingSlash = true
+			if len(cleaned) > 0 {
+				cleaned = cleaned[:len(cleaned)-1]
+			}
+		default:
+			cleaned = append(cleaned, segment)
+		}
+	}
+
+	normalized := "/" + strings.Join(cleaned, "/")
+	if trailingSlash && len(cleaned) > 0 {
+		normalized += "/"
+	}
+	return normalized, suspicious
+}
+
+// normalizeRequestPath replaces the path of u with its normalized form. An
+// error is returned when the path is suspicious and rejection is enabled.
+func normalizeRequestPath(u *url.URL) error {
+	original := u.EscapedPath()
+	normalized, suspicious := normalizePath(original)
+	if suspicious && *rejectSuspiciousPaths {
+		rejectedPaths.Add(1)
+		return fmt.Errorf("suspicious request path %q", original)
+	}
+	if normalized == original {
+		return nil
+	}
+
+	unescaped, err := url.PathUnescape(normalized)
+	if err != nil {
+		return fmt.Errorf("invalid request path %q: %w", original, err)
+	}
+	u.Path = unescaped
+	u.RawPath = ""
+	if u.EscapedPath() != normalized {
+		u.RawPath = normalized
+	}
+	normalizedPaths.Add(1)
+	return nil
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
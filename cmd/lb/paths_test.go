@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNormalizePath(t *testing.T) {
+	cases := []struct {
+		in         string
+		want       string
+		suspicious bool
+	}{
+		{"/", "/", false},
+		{"/api/v1/items", "/api/v1/items", false},
+		{"/api//v1///items/", "/api/v1/items/", false},
+		{"/api/./v1/.", "/api/v1/", false},
+		{"/%61pi/%7Euser", "/api/~user", false},
+		{"/a%2fb%20c", "/a%2Fb%20c", true},
+		{"/public/../admin", "/admin", true},
+		{"/../../etc/passwd", "/etc/passwd", true},
+		{"/public/%2e%2e/admin", "/admin", true},
+		{"/public%2Fadmin", "/public%2Fadmin", true},
+		{"/a%00b", "/a%00b", true},
+		{"/a\\b", "/a\\b", true},
+		{"/%%370", "/%2570", true},
+	}
+
+	for _, tc := range cases {
+		got, suspicious := normalizePath(tc.in)
+		if got != tc.want || suspicious != tc.suspicious {
+			t.Errorf("normalizePath(%q) = %q, %v; want %q, %v", tc.in, got, suspicious, tc.want, tc.suspicious)
+		}
+	}
+}
+
+func TestNormalizeRequestPath(t *testing.T) {
+	t.Run("rewrites path", func(t *testing.T) {
+		u, _ := url.Parse("http://lb/public/../admin//panel?x=1")
+		if err := normalizeRequestPath(u); err != nil {
+			t.Fatal(err)
+		}
+		if u.Path != "/admin/panel" || u.RawQuery != "x=1" {
+			t.Errorf("Unexpected url %s", u)
+		}
+	})
+
+	t.Run("keeps escaped separators", func(t *testing.T) {
+		u, _ := url.Parse("http://lb/files/a%2Fb")
+		if err := normalizeRequestPath(u); err != nil {
+			t.Fatal(err)
+		}
+		if u.EscapedPath() != "/files/a%2Fb" {
+			t.Errorf("Expected escaped slash to survive, got %s", u.EscapedPath())
+		}
+	})
+
+	t.Run("rejects when enabled", func(t *testing.T) {
+		prev := *rejectSuspiciousPaths
+		*rejectSuspiciousPaths = true
+		defer func() { *rejectSuspiciousPaths = prev }()
+
+		u, _ := url.Parse("http://lb/public/../admin")
+		if err := normalizeRequestPath(u); err == nil {
+			t.Error("Expected suspicious path to be rejected")
+		}
+	})
+}
+
+func TestServeHTTPForwardsNormalizedPath(t *testing.T) {
+	var seen string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.URL.EscapedPath()
+	}))
+	defer backend.Close()
+
+	lb := NewLoadBalancer()
+	lb.servers = []ServerConnections{{address: backend.URL[7:], health: true}}
+
+	req := httptest.NewRequest(http.MethodGet, "/static/..//internal/%7Estats", nil)
+	rw := httptest.NewRecorder()
+	lb.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rw.Code)
+	}
+	if seen != "/internal/~stats" {
+		t.Errorf("Backend saw %q", seen)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	maxConcurrent   = flag.Int("max-concurrent", 0, "how many requests may be proxied at the same time before the rest queue by priority (unlimited when 0)")
+	queueTimeout    = flag.Duration("queue-timeout", time.Second, "how long a request may wait for a slot under -max-concurrent before it is rejected")
+	priorityRoutes  = flag.String("priority-routes", "/health=critical", "comma separated prefix=class pairs assigning request paths to the critical, interactive or batch class (interactive otherwise)")
+	priorityHeader  = flag.String("priority-header", "X-Priority", "request header a client can name a lower class than that of its path in (disabled when empty)")
+	priorityWeights = flag.String("priority-weights", "critical=8,interactive=4,batch=1", "comma separated class=weight pairs giving the share of freed slots each class gets while requests queue")
+
+	queuedRequests = expvar.NewMap("lb_queued_requests")
+	shedRequests   = expvar.NewMap("lb_shed_requests")
+)
+
+// priorityClass orders requests competing for a slot. Lower classes are
+// more important.
+type priorityClass int
+
+const (
+	classCritical priorityClass = iota
+	classInteractive
+	classBatch
+	priorityClasses
+)
+
+var priorityClassNames = [priorityClasses]string{"critical", "interactive", "batch"}
+
+func (c priorityClass) String() string {
+	return priorityClassNames[c]
+}
+
+func parsePriorityClass(name string) (priorityClass, error) {
+	for c, known := range priorityClassNames {
+		if name == known {
+			return priorityClass(c), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority class %q", name)
+}
+
+type priorityRoute struct {
+	prefix string
+	class  priorityClass
+}
+
+// parsePriorityRoutes reads -priority-routes. Longer prefixes are matched
+// first whatever their order.
+func parsePriorityRoutes(spec string) ([]priorityRoute, error) {
+	var routes []priorityRoute
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		prefix, name, ok := strings.Cut(pair, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("route %q is not a path prefix and a class", pair)
+		}
+		class, err := parsePriorityClass(name)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, priorityRoute{prefix: prefix, class: class})
+	}
+	return routes, nil
+}
+
+// parsePriorityWeights reads -priority-weights. Classes left out keep a
+// weight of 1.
+func parsePriorityWeights(spec string) ([priorityClasses]int, error) {
+	weights := [priorityClasses]int{1, 1, 1}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return weights, fmt.Errorf("weight %q is not a class and a number", pair)
+		}
+		class, err := parsePriorityClass(name)
+		if err != nil {
+			return weights, err
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight <= 0 {
+			return weights, fmt.Errorf("weight of %s must be a positive number, got %q", name, value)
+		}
+		weights[class] = weight
+	}
+	return weights, nil
+}
+
+// classifier assigns requests to priority classes by path, and lets
+// clients demote their requests with a header. They cannot promote them,
+// or every bulk client would claim the critical class.
+type classifier struct {
+	routes []priorityRoute
+	header string
+}
+
+func (c classifier) classify(r *http.Request) priorityClass {
+	class, matched := classInteractive, 0
+	for _, route := range c.routes {
+		if len(route.prefix) > matched && strings.HasPrefix(r.URL.Path, route.prefix) {
+			class, matched = route.class, len(route.prefix)
+		}
+	}
+	if c.header != "" {
+		if requested, err := parsePriorityClass(r.Header.Get(c.header)); err == nil && requested > class {
+			class = requested
+		}
+	}
+	return class
+}
+
+var errQueueTimeout = fmt.Errorf("no request slot freed within the queue timeout")
+
+// admission limits how many requests are proxied at once. Requests over the
+// limit queue per class, and every freed slot goes to the head of one
+// queue, picked by smooth weighted round robin over the classes that have
+// requests waiting. Each class thereby gets its weighted share of the slots
+// and batch traffic cannot starve interactive requests, nor they batch.
+type admission struct {
+	limit   int
+	weights [priorityClasses]int
+	timeout time.Duration
+
+	mu      sync.Mutex
+	active  int
+	queues  [priorityClasses][]*queuedRequest
+	current [priorityClasses]int
+}
+
+type queuedRequest struct {
+	ready    chan struct{}
+	admitted bool
+}
+
+func newAdmission(limit int, weights [priorityClasses]int, timeout time.Duration) *admission {
+	return &admission{limit: limit, weights: weights, timeout: timeout}
+}
+
+// acquire waits for a slot for a request of class and returns the function
+// that frees it. It fails with errQueueTimeout once the request has waited
+// for the queue timeout, or with the error of ctx. A nil admission admits
+// everything.
+func (a *admission) acquire(ctx context.Context, class priorityClass) (func(), error) {
+	if a == nil {
+		return func() {}, nil
+	}
+
+	a.mu.Lock()
+	if a.active < a.limit && a.waiting() == 0 {
+		a.active++
+		a.mu.Unlock()
+		return a.release, nil
+	}
+	request := &queuedRequest{ready: make(chan struct{})}
+	a.queues[class] = append(a.queues[class], request)
+	a.mu.Unlock()
+	queuedRequests.Add(class.String(), 1)
+
+	timer := time.NewTimer(a.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-request.ready:
+		return a.release, nil
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if request.admitted {
+		// The slot was handed over while giving up; pass it on.
+		a.handOver()
+		return nil, err
+	}
+	for i, queued := range a.queues[class] {
+		if queued == request {
+			a.queues[class] = append(a.queues[class][:i], a.queues[class][i+1:]...)
+			break
+		}
+	}
+	shedRequests.Add(class.String(), 1)
+	return nil, err
+}
+
+func (a *admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.handOver()
+}
+
+// handOver gives the slot of a finished request to the next queued one, or
+// frees it.
+func (a *admission) handOver() {
+	class, ok := a.next()
+	if !ok {
+		a.active--
+		return
+	}
+	request := a.queues[class][0]
+	a.queues[class] = a.queues[class][1:]
+	request.admitted = true
+	close(request.ready)
+}
+
+// next picks the class whose queue gets the next slot.
+func (a *admission) next() (priorityClass, bool) {
+	total, best := 0, priorityClass(-1)
+	for class := range priorityClasses {
+		if len(a.queues[class]) == 0 {
+			a.current[class] = 0
+			continue
+		}
+		a.current[class] += a.weights[class]
+		total += a.weights[class]
+		if best < 0 || a.current[class] > a.current[best] {
+			best = class
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	a.current[best] -= total
+	return best, true
+}
+
+func (a *admission) waiting() int {
+	count := 0
+	for _, queue := range a.queues {
+		count += len(queue)
+	}
+	return count
+}
+
+// validatePriorities checks the prioritization flags.
+func validatePriorities() error {
+	if *maxConcurrent < 0 {
+		return fmt.Errorf("-max-concurrent must not be negative, got %d", *maxConcurrent)
+	}
+	if *queueTimeout <= 0 {
+		return fmt.Errorf("-queue-timeout must be positive, got %s", *queueTimeout)
+	}
+	if _, err := parsePriorityRoutes(*priorityRoutes); err != nil {
+		return fmt.Errorf("-priority-routes: %w", err)
+	}
+	if _, err := parsePriorityWeights(*priorityWeights); err != nil {
+		return fmt.Errorf("-priority-weights: %w", err)
+	}
+	return nil
+}
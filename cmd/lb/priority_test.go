@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClassifier(t *testing.T) {
+	routes, err := parsePriorityRoutes("/health=critical, /api/v1/sync=batch,/api/v1/sync/urgent=interactive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := classifier{routes: routes, header: "X-Priority"}
+
+	cases := []struct {
+		path, header string
+		expected     priorityClass
+	}{
+		{"/health", "", classCritical},
+		{"/api/v1/some-data", "", classInteractive},
+		{"/api/v1/sync/all", "", classBatch},
+		{"/api/v1/sync/urgent/1", "", classInteractive},
+		{"/api/v1/some-data", "batch", classBatch},
+		{"/api/v1/sync/all", "critical", classBatch},
+		{"/api/v1/some-data", "unknown", classInteractive},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.header != "" {
+			r.Header.Set("X-Priority", tc.header)
+		}
+		if class := c.classify(r); class != tc.expected {
+			t.Errorf("%s with priority %q: expected %s, got %s", tc.path, tc.header, tc.expected, class)
+		}
+	}
+
+	for _, spec := range []string{"health=critical", "/health", "/health=urgent"} {
+		if _, err := parsePriorityRoutes(spec); err == nil {
+			t.Errorf("Expected routes %q to be rejected", spec)
+		}
+	}
+	for _, spec := range []string{"critical", "batch=0", "batch=x", "urgent=1"} {
+		if _, err := parsePriorityWeights(spec); err == nil {
+			t.Errorf("Expected weights %q to be rejected", spec)
+		}
+	}
+}
+
+// queueRequests queues count requests of class behind a full admission and
+// reports the class of each one admitted on admitted.
+func queueRequests(t *testing.T, a *admission, class priorityClass, count int, admitted chan<- priorityClass) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		go func() {
+			if _, err := a.acquire(context.Background(), class); err != nil {
+				t.Error(err)
+				return
+			}
+			admitted <- class
+		}()
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		a.mu.Lock()
+		queued := len(a.queues[class])
+		a.mu.Unlock()
+		if queued == count {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d queued %s requests, got %d", count, class, queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdmission_WeightedFairQueueing(t *testing.T) {
+	a := newAdmission(1, [priorityClasses]int{8, 4, 1}, time.Minute)
+	release, err := a.acquire(context.Background(), classInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admitted := make(chan priorityClass)
+	queueRequests(t, a, classBatch, 3, admitted)
+	queueRequests(t, a, classInteractive, 8, admitted)
+
+	// Each admitted request frees its slot for the next one.
+	counts := make(map[priorityClass]int)
+	release()
+	for i := 0; i < 5; i++ {
+		counts[<-admitted]++
+		a.release()
+	}
+	if counts[classInteractive] != 4 || counts[classBatch] != 1 {
+		t.Errorf("Expected 4 interactive and 1 batch request in the first 5, got %v", counts)
+	}
+	for i := 0; i < 6; i++ {
+		counts[<-admitted]++
+		a.release()
+	}
+	if counts[classInteractive] != 8 || counts[classBatch] != 3 {
+		t.Errorf("Expected every queued request to be admitted, got %v", counts)
+	}
+	if a.active != 0 {
+		t.Errorf("Expected every slot to be free, got %d active", a.active)
+	}
+}
+
+func TestAdmission_GivesUp(t *testing.T) {
+	a := newAdmission(1, [priorityClasses]int{1, 1, 1}, 20*time.Millisecond)
+	release, err := a.acquire(context.Background(), classCritical)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.acquire(context.Background(), classBatch); !errors.Is(err, errQueueTimeout) {
+		t.Errorf("Expected errQueueTimeout, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.acquire(ctx, classBatch); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if a.waiting() != 0 {
+		t.Errorf("Expected requests that gave up to leave the queue, got %d", a.waiting())
+	}
+
+	release()
+	if _, err := a.acquire(context.Background(), classBatch); err != nil {
+		t.Errorf("Expected the freed slot to be taken, got %v", err)
+	}
+}
+
+func TestServeHTTP_Prioritization(t *testing.T) {
+	*https = false
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-unblock
+		}
+	}))
+	defer backend.Close()
+
+	lb := &LoadBalancer{
+		servers:   []ServerConnections{{address: backend.URL[7:], health: true, weight: fullWeight}},
+		admission: newAdmission(1, [priorityClasses]int{1, 1, 1}, 20*time.Millisecond),
+	}
+	done := make(chan struct{})
+	go func() {
+		lb.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/slow", nil))
+		close(done)
+	}()
+	<-started
+
+	recorder := httptest.NewRecorder()
+	lb.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/fast", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a request over the limit to be shed, got %d", recorder.Code)
+	}
+
+	close(unblock)
+	<-done
+	recorder = httptest.NewRecorder()
+	lb.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/fast", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected the request to pass once the slot is free, got %d", recorder.Code)
+	}
+}
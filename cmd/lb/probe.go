@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	healthInterval = flag.Duration("health-interval", 10*time.Second, "interval between backend health probes")
+	dnsRetryMin    = flag.Duration("dns-retry-min", 500*time.Millisecond, "initial delay before re-probing a backend whose host does not resolve")
+	probeWorkers   = flag.Int("probe-concurrency", 8, "how many backend health probes may run at the same time")
+)
+
+type probeStatus int
+
+const (
+	probeHealthy probeStatus = iota
+	probeUnhealthy
+	probeUnreachable
+	probeUnresolved
+	probeDegraded
+)
+
+func (s probeStatus) String() string {
+	switch s {
+	case probeHealthy:
+		return "healthy"
+	case probeUnhealthy:
+		return "unhealthy"
+	case probeUnreachable:
+		return "unreachable"
+	case probeUnresolved:
+		return "host not found"
+	case probeDegraded:
+		return "degraded"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+func probe(dst string) probeStatus {
+	status, _ := probeWeight(dst)
+	return status
+}
+
+// probeWeight also returns the weight a healthy backend announced in its
+// health response.
+func probeWeight(dst string) (probeStatus, int) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET",
+		fmt.Sprintf("%s://%s/health", scheme(), dst), nil)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return classifyProbeError(err), 0
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return probeUnhealthy, 0
+	}
+	return probeHealthy, announcedWeight(resp.Header)
+}
+
+func classifyProbeError(err error) probeStatus {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && (dnsErr.IsNotFound || dnsErr.IsTemporary) {
+		return probeUnresolved
+	}
+	return probeUnreachable
+}
+
+// nextProbeDelay keeps the regular interval for resolvable hosts and retries
+// unresolved ones with exponential backoff, so a backend that comes up after
+// the balancer is picked up without waiting a full interval.
+func nextProbeDelay(status probeStatus, backoff time.Duration) (time.Duration, time.Duration) {
+	if status != probeUnresolved {
+		return *healthInterval, *dnsRetryMin
+	}
+	next := backoff * 2
+	if next > *healthInterval {
+		next = *healthInterval
+	}
+	return backoff, next
+}
+
+// monitorServers probes every backend with at most workers probes in flight
+// until done is closed. First probes are spread evenly over the health
+// interval, and each backend is probed again once the delay after its
+// previous probe has passed, so probes of a large pool do not all start at
+// the same time.
+func (lb *LoadBalancer) monitorServers(workers int, done <-chan struct{}) {
+	count := len(lb.servers)
+	if count == 0 {
+		return
+	}
+
+	// Each backend is queued at most once at a time, so sends never block.
+	queue := make(chan int, count)
+	backoffs := make([]time.Duration, count)
+	for i := range backoffs {
+		backoffs[i] = *dnsRetryMin
+		time.AfterFunc(*healthInterval*time.Duration(i)/time.Duration(count), func() { queue <- i })
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				case i := <-queue:
+					var delay time.Duration
+					delay, backoffs[i] = nextProbeDelay(lb.checkServer(i), backoffs[i])
+					time.AfterFunc(delay, func() { queue <- i })
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
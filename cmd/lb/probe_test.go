@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProbe_DistinguishesFailures(t *testing.T) {
+	*https = false
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closedAddr := closed.URL[7:]
+	closed.Close()
+
+	if status := probe(closedAddr); status != probeUnreachable {
+		t.Errorf("Expected refused connection to be unreachable, got %s", status)
+	}
+
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+	if status := probe(unhealthy.URL[7:]); status != probeUnhealthy {
+		t.Errorf("Expected 503 to be unhealthy, got %s", status)
+	}
+}
+
+func TestClassifyProbeError(t *testing.T) {
+	notFound := &net.DNSError{Err: "no such host", Name: "server1", IsNotFound: true}
+	if status := classifyProbeError(notFound); status != probeUnresolved {
+		t.Errorf("Expected DNS not found to be unresolved, got %s", status)
+	}
+
+	refused := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	if status := classifyProbeError(refused); status != probeUnreachable {
+		t.Errorf("Expected refused connection to be unreachable, got %s", status)
+	}
+}
+
+func TestNextProbeDelay(t *testing.T) {
+	prevInterval, prevMin := *healthInterval, *dnsRetryMin
+	*healthInterval, *dnsRetryMin = 4*time.Second, time.Second
+	defer func() { *healthInterval, *dnsRetryMin = prevInterval, prevMin }()
+
+	backoff := *dnsRetryMin
+	var delays []time.Duration
+	for i := 0; i < 4; i++ {
+		var delay time.Duration
+		delay, backoff = nextProbeDelay(probeUnresolved, backoff)
+		delays = append(delays, delay)
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	for i := range expected {
+		if delays[i] != expected[i] {
+			t.Fatalf("Expected delays %v, got %v", expected, delays)
+		}
+	}
+
+	delay, backoff := nextProbeDelay(probeHealthy, backoff)
+	if delay != *healthInterval || backoff != *dnsRetryMin {
+		t.Errorf("Expected resolved host to reset backoff, got %v, %v", delay, backoff)
+	}
+}
+
+func TestMonitorServers_BoundsConcurrency(t *testing.T) {
+	*https = false
+	prevInterval := *healthInterval
+	*healthInterval = 50 * time.Millisecond
+	defer func() { *healthInterval = prevInterval }()
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer backend.Close()
+
+	lb := &LoadBalancer{servers: make([]ServerConnections, 8)}
+	for i := range lb.servers {
+		lb.servers[i].address = backend.URL[7:]
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		lb.monitorServers(2, done)
+		close(stopped)
+	}()
+	time.Sleep(300 * time.Millisecond)
+	close(done)
+	<-stopped
+
+	if len(lb.getHealthyServers()) != len(lb.servers) {
+		t.Errorf("Expected every backend to be probed, %d of %d are healthy", len(lb.getHealthyServers()), len(lb.servers))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if maxInFlight > 2 {
+		t.Errorf("Expected at most 2 probes in flight, got %d", maxInFlight)
+	}
+}
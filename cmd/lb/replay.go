@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"expvar"
+	"flag"
+	"io"
+	"net"
+	"net/http"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+var (
+	replayBodyLimit = flag.Int64("replay-body-limit", 64*1024, "max request body size in bytes buffered for retries on another backend")
+
+	replayedRequests      = expvar.NewInt("lb_replayed_requests")
+	replaySkippedRequests = expvar.NewInt("lb_replay_skipped_requests")
+)
+
+func isReplayable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	case http.MethodPost, http.MethodPut:
+		return r.Header.Get(idempotencyKeyHeader) != ""
+	}
+	return false
+}
+
+// bufferBody keeps up to limit bytes of the request body in memory so the
+// request can be sent again. Larger bodies are left streaming and reported
+// as not replayable.
+func bufferBody(r *http.Request, limit int64) (bool, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return true, nil
+	}
+	if r.ContentLength > limit {
+		return false, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return false, err
+	}
+	if int64(len(data)) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+		return false, nil
+	}
+	r.Body.Close()
+
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	r.Body, _ = r.GetBody()
+	r.ContentLength = int64(len(data))
+	return true, nil
+}
+
+func rewindBody(r *http.Request) error {
+	if r.GetBody == nil {
+		return nil
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return err
+	}
+	r.Body = body
+	return nil
+}
+
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial"
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
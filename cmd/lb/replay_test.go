@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newReplayTestBalancer(t *testing.T) (*LoadBalancer, string, string) {
+	t.Helper()
+
+	liveServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}))
+	t.Cleanup(liveServer.Close)
+
+	deadServer := httptest.NewServer(http.NotFoundHandler())
+	deadAddr := deadServer.URL[7:]
+	deadServer.Close()
+
+	lb := &LoadBalancer{
+		servers: []ServerConnections{
+			{address: deadAddr, health: true},
+			{address: liveServer.URL[7:], health: true},
+		},
+	}
+	return lb, deadAddr, liveServer.URL[7:]
+}
+
+func clientRoutedTo(t *testing.T, lb *LoadBalancer, address string) string {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		clientAddr := fmt.Sprintf("10.0.0.%d:1234", i)
+		server, err := lb.getServer(clientAddr)
+		if err == nil && server.address == address {
+			return clientAddr
+		}
+	}
+	t.Fatalf("No client address routes to %s", address)
+	return ""
+}
+
+func TestServeHTTP_ReplaysIdempotentPost(t *testing.T) {
+	lb, deadAddr, _ := newReplayTestBalancer(t)
+	*https = false
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/api", strings.NewReader("payload"))
+	req.RemoteAddr = clientRoutedTo(t, lb, deadAddr)
+	req.Header.Set(idempotencyKeyHeader, "abc")
+	recorder := httptest.NewRecorder()
+
+	before := replayedRequests.Value()
+	lb.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if recorder.Body.String() != "payload" {
+		t.Errorf("Expected replayed body 'payload', got '%s'", recorder.Body.String())
+	}
+	if replayedRequests.Value() != before+1 {
+		t.Errorf("Expected replay counter to increase")
+	}
+}
+
+func TestServeHTTP_DoesNotReplayUnmarkedPost(t *testing.T) {
+	lb, deadAddr, _ := newReplayTestBalancer(t)
+	*https = false
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/api", strings.NewReader("payload"))
+	req.RemoteAddr = clientRoutedTo(t, lb, deadAddr)
+	recorder := httptest.NewRecorder()
+
+	lb.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, recorder.Code)
+	}
+}
+
+func TestBufferBody(t *testing.T) {
+	t.Run("small body is buffered", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader("small"))
+
+		replayable, err := bufferBody(req, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !replayable {
+			t.Fatal("Expected small body to be replayable")
+		}
+
+		first, _ := io.ReadAll(req.Body)
+		rewindBody(req)
+		second, _ := io.ReadAll(req.Body)
+		if string(first) != "small" || string(second) != "small" {
+			t.Errorf("Unexpected bodies after rewind: %q, %q", first, second)
+		}
+	})
+
+	t.Run("large body keeps streaming", func(t *testing.T) {
+		payload := bytes.Repeat([]byte("x"), 64)
+		req := httptest.NewRequest(http.MethodPut, "/", io.NopCloser(bytes.NewReader(payload)))
+		req.ContentLength = -1
+
+		replayable, err := bufferBody(req, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replayable {
+			t.Fatal("Expected large body to be rejected for replay")
+		}
+
+		body, _ := io.ReadAll(req.Body)
+		if !bytes.Equal(body, payload) {
+			t.Errorf("Expected body to be preserved, got %d bytes", len(body))
+		}
+	})
+}
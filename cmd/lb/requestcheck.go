@@ -0,0 +1,63 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var rejectedMalformedRequests = expvar.NewInt("lb_rejected_malformed_requests")
+
+// validateRequest rejects requests that backends could interpret differently
+// from the balancer: odd Host values, unusual request targets and ambiguous
+// body framing. Absolute-form targets are accepted since the proxy always
+// rewrites the target host to the chosen backend.
+func validateRequest(r *http.Request) error {
+	if err := validateHost(r.Host); err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(r.RequestURI, "/") && r.RequestURI != "" {
+		if r.Method == http.MethodConnect || r.RequestURI == "*" {
+			return fmt.Errorf("unsupported request target %q", r.RequestURI)
+		}
+		if r.URL.Scheme != "http" && r.URL.Scheme != "https" {
+			return fmt.Errorf("unsupported scheme in request target %q", r.RequestURI)
+		}
+	}
+
+	if len(r.Header.Values("Host")) > 0 {
+		return fmt.Errorf("duplicate Host header")
+	}
+
+	contentLengths := r.Header.Values("Content-Length")
+	for _, value := range contentLengths[min(len(contentLengths), 1):] {
+		if value != contentLengths[0] {
+			return fmt.Errorf("conflicting Content-Length headers")
+		}
+	}
+	if len(r.TransferEncoding) > 0 || len(r.Header.Values("Transfer-Encoding")) > 0 {
+		if len(contentLengths) > 0 {
+			return fmt.Errorf("both Content-Length and Transfer-Encoding are set")
+		}
+		if len(r.TransferEncoding) > 1 || (len(r.TransferEncoding) == 1 && r.TransferEncoding[0] != "chunked") {
+			return fmt.Errorf("unsupported transfer encoding %v", r.TransferEncoding)
+		}
+	}
+	return nil
+}
+
+func validateHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("missing Host")
+	}
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if isUnreserved(c) || c == ':' || c == '[' || c == ']' {
+			continue
+		}
+		return fmt.Errorf("invalid character %q in Host", c)
+	}
+	return nil
+}
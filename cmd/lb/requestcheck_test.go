@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LeVasTiaN/KPI_Lab5/apierrors"
+)
+
+func readRawRequest(t *testing.T, raw string) *http.Request {
+	t.Helper()
+	r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("Failed to parse request: %v", err)
+	}
+	return r
+}
+
+func TestValidateRequest(t *testing.T) {
+	valid := []string{
+		"GET /api HTTP/1.1\r\nHost: balancer:8090\r\n\r\n",
+		"GET http://balancer:8090/api HTTP/1.1\r\nHost: balancer:8090\r\n\r\n",
+		"POST /api HTTP/1.1\r\nHost: [::1]:8090\r\nContent-Length: 2\r\n\r\nok",
+		"POST /api HTTP/1.1\r\nHost: balancer\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n",
+	}
+	for _, raw := range valid {
+		if err := validateRequest(readRawRequest(t, raw)); err != nil {
+			t.Errorf("Expected %q to be accepted, got %v", raw, err)
+		}
+	}
+
+	invalid := []string{
+		"GET /api HTTP/1.0\r\n\r\n",
+		"GET /api HTTP/1.1\r\nHost: bal ancer\r\n\r\n",
+		"GET /api HTTP/1.1\r\nHost: balancer@evil\r\n\r\n",
+		"GET ftp://balancer/api HTTP/1.1\r\nHost: balancer\r\n\r\n",
+		"OPTIONS * HTTP/1.1\r\nHost: balancer\r\n\r\n",
+	}
+	for _, raw := range invalid {
+		if err := validateRequest(readRawRequest(t, raw)); err == nil {
+			t.Errorf("Expected %q to be rejected", raw)
+		}
+	}
+
+	t.Run("conflicting framing headers", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader("ok"))
+		r.Header.Add("Content-Length", "2")
+		r.Header.Add("Content-Length", "5")
+		if err := validateRequest(r); err == nil {
+			t.Error("Expected conflicting Content-Length to be rejected")
+		}
+
+		r = httptest.NewRequest(http.MethodPost, "/api", strings.NewReader("ok"))
+		r.Header.Set("Content-Length", "2")
+		r.TransferEncoding = []string{"chunked"}
+		if err := validateRequest(r); err == nil {
+			t.Error("Expected Content-Length with Transfer-Encoding to be rejected")
+		}
+
+		r = httptest.NewRequest(http.MethodPost, "/api", strings.NewReader("ok"))
+		r.TransferEncoding = []string{"gzip", "chunked"}
+		if err := validateRequest(r); err == nil {
+			t.Error("Expected layered transfer encoding to be rejected")
+		}
+	})
+}
+
+func TestServeHTTPRejectsMalformedRequest(t *testing.T) {
+	lb := NewLoadBalancer()
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.Host = "bad host"
+	rw := httptest.NewRecorder()
+	lb.ServeHTTP(rw, req)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rw.Code)
+	}
+	if e := apierrors.Decode(rw.Result()); e.Code != apierrors.BadRequest || e.Message == "" {
+		t.Errorf("Expected a bad_request error body, got %+v", e)
+	}
+}
+
+func FuzzNormalizePath(f *testing.F) {
+	for _, seed := range []string{"/", "/a//b/../c", "/%2e%2e/%2F", "/a%00", "a/b", "/%zz/%4", "/.././/."} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, escaped string) {
+		normalized, _ := normalizePath(escaped)
+		if !strings.HasPrefix(normalized, "/") {
+			t.Fatalf("normalizePath(%q) = %q does not start with /", escaped, normalized)
+		}
+		if strings.Contains(normalized, "//") {
+			t.Fatalf("normalizePath(%q) = %q contains duplicate slashes", escaped, normalized)
+		}
+		for _, segment := range strings.Split(normalized, "/") {
+			if segment == "." || segment == ".." {
+				t.Fatalf("normalizePath(%q) = %q contains dot segments", escaped, normalized)
+			}
+		}
+		if again, _ := normalizePath(normalized); again != normalized {
+			t.Fatalf("normalizePath is not idempotent: %q -> %q -> %q", escaped, normalized, again)
+		}
+	})
+}
+
+func FuzzValidateRequest(f *testing.F) {
+	f.Add("GET /api HTTP/1.1\r\nHost: balancer\r\n\r\n")
+	f.Add("POST http://balancer/x HTTP/1.1\r\nHost: balancer\r\nContent-Length: 1\r\nTransfer-Encoding: chunked\r\n\r\n")
+	f.Fuzz(func(t *testing.T, raw string) {
+		r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			return
+		}
+		if validateRequest(r) != nil {
+			return
+		}
+		_ = normalizeRequestPath(r.URL)
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+)
+
+const sharedHealthKeyPrefix = "lb-health-"
+
+var (
+	sharedHealthEnabled   = flag.Bool("shared-health", false, "whether to share backend health observations with other balancer replicas via the datastore")
+	sharedHealthInterval  = flag.Duration("shared-health-interval", 2*time.Second, "how often to pull health observations of other replicas")
+	sharedHealthFreshness = flag.Duration("shared-health-freshness", 15*time.Second, "how long an observation of another replica is trusted")
+	replicaID             = flag.String("replica-id", defaultReplicaID(), "identifier of this balancer replica")
+)
+
+type healthObservation struct {
+	Replica    string    `json:"replica"`
+	Healthy    bool      `json:"healthy"`
+	ObservedAt time.Time `json:"observed_at"`
+}
+
+type sharedHealth struct {
+	db      *dbClient
+	replica string
+}
+
+func defaultReplicaID() string {
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return "lb"
+}
+
+func newSharedHealth(db *dbClient, replica string) *sharedHealth {
+	return &sharedHealth{db: db, replica: replica}
+}
+
+func (s *sharedHealth) publish(server string, healthy bool) {
+	observation := healthObservation{
+		Replica:    s.replica,
+		Healthy:    healthy,
+		ObservedAt: time.Now(),
+	}
+	if err := s.db.putJSON(sharedHealthKeyPrefix+server, observation); err != nil {
+		log.Printf("Failed to publish health of %s: %s", server, err)
+	}
+}
+
+func (s *sharedHealth) fetch(server string) (healthObservation, bool) {
+	var observation healthObservation
+	if err := s.db.getJSON(sharedHealthKeyPrefix+server, &observation); err != nil {
+		return observation, false
+	}
+	return observation, true
+}
+
+// syncPeerHealth takes servers out of rotation as soon as another replica
+// reports them down. Recovery is left to this replica's own probes so a
+// single optimistic peer cannot bring a dead backend back.
+func (lb *LoadBalancer) syncPeerHealth() {
+	if lb.peers == nil {
+		return
+	}
+
+	for i := range lb.servers {
+		lb.mu.RLock()
+		server := lb.servers[i]
+		lb.mu.RUnlock()
+
+		if !server.health {
+			continue
+		}
+
+		observation, ok := lb.peers.fetch(server.address)
+		if !ok || observation.Replica == lb.peers.replica || observation.Healthy {
+			continue
+		}
+		if time.Since(observation.ObservedAt) > *sharedHealthFreshness || !observation.ObservedAt.After(server.checkedAt) {
+			continue
+		}
+
+		lb.mu.Lock()
+		lb.servers[i].health = false
+		noteTransition(server.address, server.state(), stateUnhealthy)
+		lb.mu.Unlock()
+		log.Printf("Server %s marked unhealthy by replica %s", server.address, observation.Replica)
+	}
+}
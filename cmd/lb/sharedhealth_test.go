@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSyncPeerHealth(t *testing.T) {
+	db := newFakeDatastore(t)
+
+	first := &LoadBalancer{
+		servers: []ServerConnections{{address: "server1:8080"}},
+		peers:   newSharedHealth(newDbClient(db.URL+"/db/"), "lb-1"),
+	}
+	second := &LoadBalancer{
+		servers: []ServerConnections{{address: "server1:8080"}},
+		peers:   newSharedHealth(newDbClient(db.URL+"/db/"), "lb-2"),
+	}
+
+	first.updateServerHealth(0, true)
+	second.updateServerHealth(0, true)
+
+	time.Sleep(time.Millisecond)
+	first.peers.publish("server1:8080", false)
+	second.syncPeerHealth()
+
+	if len(second.getHealthyServers()) != 0 {
+		t.Error("Server reported down by a peer should leave rotation")
+	}
+
+	second.updateServerHealth(0, true)
+	second.syncPeerHealth()
+	if len(second.getHealthyServers()) != 1 {
+		t.Error("Observation older than the local probe should be ignored")
+	}
+
+	time.Sleep(time.Millisecond)
+	second.peers.publish("server1:8080", false)
+	second.syncPeerHealth()
+	if len(second.getHealthyServers()) != 1 {
+		t.Error("Replica should ignore its own observations")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/strategy"
+)
+
+var (
+	simulate        = flag.Bool("simulate", false, "run the backend failure simulation with the configured strategy, print how routing converges and exit")
+	simulateClients = flag.Int("simulate-clients", 1000, "number of distinct clients routed at each step of the simulation")
+)
+
+// simBackend is a backend of a simulation. Probes of a backend that is down
+// fail as if it did not accept connections.
+type simBackend struct {
+	up     bool
+	weight int
+	probes int
+}
+
+// simulation runs a LoadBalancer against simulated backends and a simulated
+// clock. Health probes happen on the same schedule monitorServers uses, but
+// only as the clock is advanced, and every random choice is drawn from a
+// seeded source, so a simulation with the same seed and steps always routes
+// the same way.
+type simulation struct {
+	lb       *LoadBalancer
+	now      time.Time
+	backends map[string]*simBackend
+	due      []time.Time
+	backoffs []time.Duration
+}
+
+func newSimulation(addresses []string, selection strategy.Strategy, seed int64) *simulation {
+	rng := rand.New(rand.NewSource(seed))
+	s := &simulation{
+		now:      time.Unix(0, 0),
+		backends: make(map[string]*simBackend),
+		due:      make([]time.Time, len(addresses)),
+		backoffs: make([]time.Duration, len(addresses)),
+	}
+
+	servers := make([]ServerConnections, len(addresses))
+	for i, address := range addresses {
+		servers[i] = ServerConnections{address: address, weight: fullWeight, weightFrom: fullWeight}
+		s.backends[address] = &simBackend{up: true, weight: fullWeight}
+		s.due[i] = s.now.Add(*healthInterval * time.Duration(i) / time.Duration(len(addresses)))
+		s.backoffs[i] = *dnsRetryMin
+	}
+	s.lb = &LoadBalancer{
+		servers:  servers,
+		strategy: selection,
+		roll:     rng.Intn,
+		clock:    func() time.Time { return s.now },
+		prober:   s.probe,
+	}
+	return s
+}
+
+func (s *simulation) probe(dst string) (probeStatus, int) {
+	backend := s.backends[dst]
+	backend.probes++
+	if !backend.up {
+		return probeUnreachable, 0
+	}
+	return probeHealthy, backend.weight
+}
+
+func (s *simulation) setUp(address string, up bool) {
+	s.backends[address].up = up
+}
+
+// setWeight makes the backend announce weight in its health responses, as a
+// draining backend does.
+func (s *simulation) setWeight(address string, weight int) {
+	s.backends[address].weight = weight
+}
+
+// advance moves the clock forward by d, running every probe that falls due
+// on the way in time order.
+func (s *simulation) advance(d time.Duration) {
+	end := s.now.Add(d)
+	for {
+		next := -1
+		for i, due := range s.due {
+			if !due.After(end) && (next < 0 || due.Before(s.due[next])) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+
+		s.now = s.due[next]
+		var delay time.Duration
+		delay, s.backoffs[next] = nextProbeDelay(s.lb.checkServer(next), s.backoffs[next])
+		s.due[next] = s.now.Add(delay)
+	}
+	s.now = end
+}
+
+// route sends one request from each of clients distinct clients and counts
+// where they were routed. Requests that could not be routed are counted
+// under "".
+func (s *simulation) route(clients int) map[string]int {
+	counts := make(map[string]int)
+	for c := 0; c < clients; c++ {
+		client := strategy.ClientInfo{Key: fmt.Sprintf("10.%d.%d.%d:1234", c>>16&0xff, c>>8&0xff, c&0xff)}
+		server, err := s.lb.getServerExcluding(client, "")
+		if err != nil {
+			counts[""]++
+			continue
+		}
+		counts[server.address]++
+	}
+	return counts
+}
+
+// converge advances the clock by step until converged accepts the routing
+// of clients, and returns how long that took. It gives up after limit.
+func (s *simulation) converge(step, limit time.Duration, clients int, converged func(counts map[string]int) bool) (time.Duration, bool) {
+	for elapsed := time.Duration(0); elapsed <= limit; elapsed += step {
+		if converged(s.route(clients)) {
+			return elapsed, true
+		}
+		s.advance(step)
+	}
+	return limit, false
+}
+
+// runFailureSimulation kills the first backend of a settled pool and reports
+// how long it takes until no client is routed to it anymore.
+func runFailureSimulation(w io.Writer, addresses []string, selection strategy.Strategy, seed int64, clients int) {
+	s := newSimulation(addresses, selection, seed)
+	s.advance(*healthInterval)
+	writeRouting(w, "before failure", s.route(clients))
+
+	dead := addresses[0]
+	s.setUp(dead, false)
+	took, ok := s.converge(100*time.Millisecond, 10**healthInterval, clients, func(counts map[string]int) bool {
+		return counts[dead] == 0
+	})
+	if ok {
+		fmt.Fprintf(w, "traffic left %s after %s\n", dead, took)
+	} else {
+		fmt.Fprintf(w, "traffic still reaches %s after %s\n", dead, took)
+	}
+	writeRouting(w, "after failure", s.route(clients))
+}
+
+func writeRouting(w io.Writer, title string, counts map[string]int) {
+	addresses := make([]string, 0, len(counts))
+	for address := range counts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	fmt.Fprintf(w, "%s:\n", title)
+	for _, address := range addresses {
+		name := address
+		if name == "" {
+			name = "(no backend)"
+		}
+		fmt.Fprintf(w, "  %s: %d\n", name, counts[address])
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/strategy"
+)
+
+var simulatedPool = []string{"server1:8080", "server2:8080", "server3:8080"}
+
+func TestSimulation_FailureConvergence(t *testing.T) {
+	s := newSimulation(simulatedPool, strategy.Hash{}, 1)
+	s.advance(*healthInterval)
+	if counts := s.route(300); counts[""] != 0 || counts["server1:8080"] == 0 {
+		t.Fatalf("Expected every backend to serve a settled pool, got %v", counts)
+	}
+
+	s.setUp("server1:8080", false)
+	took, ok := s.converge(100*time.Millisecond, 2**healthInterval, 300, func(counts map[string]int) bool {
+		return counts["server1:8080"] == 0
+	})
+	if !ok || took > *healthInterval {
+		t.Errorf("Expected traffic to leave the dead backend within one health interval, took %s", took)
+	}
+	if counts := s.route(300); counts[""] != 0 {
+		t.Errorf("Expected the remaining backends to take every client, got %v", counts)
+	}
+
+	s.setUp("server1:8080", true)
+	s.advance(*healthInterval)
+	if counts := s.route(300); counts["server1:8080"] == 0 {
+		t.Errorf("Expected the backend to get traffic back after recovering, got %v", counts)
+	}
+}
+
+func TestSimulation_DrainRamp(t *testing.T) {
+	s := newSimulation(simulatedPool, strategy.Hash{}, 1)
+	s.advance(*healthInterval)
+	before := s.route(300)["server2:8080"]
+
+	// Halfway through the ramp after the next probe of server2.
+	s.setWeight("server2:8080", 0)
+	s.advance(s.due[1].Sub(s.now) + *drainRamp/2)
+	ramping := s.route(300)["server2:8080"]
+	s.advance(*drainRamp)
+	drained := s.route(300)["server2:8080"]
+
+	if !(before > ramping && ramping > 0 && drained == 0) {
+		t.Errorf("Expected a draining backend to lose clients gradually, got %d, %d, %d", before, ramping, drained)
+	}
+}
+
+func TestSimulation_Reproducible(t *testing.T) {
+	run := func() string {
+		var out bytes.Buffer
+		selection, err := strategy.New("random", rand.NewSource(7))
+		if err != nil {
+			t.Fatal(err)
+		}
+		runFailureSimulation(&out, simulatedPool, selection, 7, 500)
+		return out.String()
+	}
+	if first, second := run(), run(); first != second {
+		t.Errorf("Expected identical runs, got\n%s\nand\n%s", first, second)
+	}
+}
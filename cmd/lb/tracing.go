@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	samplingAlways = "always"
+	samplingRatio  = "ratio"
+	samplingHeader = "header"
+
+	traceIDHeader = "lb-trace-id"
+)
+
+var (
+	traceSampling = flag.String("trace-sampling", samplingAlways, "which requests are traced when tracing is enabled: always, ratio or header")
+	traceRatio    = flag.Float64("trace-ratio", 0.01, "fraction of requests traced in ratio sampling mode")
+	traceHeader   = flag.String("trace-header", "X-Trace", "request header that forces tracing in header sampling mode")
+)
+
+var (
+	samplerMu  sync.Mutex
+	samplerRnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+type span struct {
+	id    string
+	start time.Time
+}
+
+type spanKey struct{}
+
+func validateTraceSampling() error {
+	switch *traceSampling {
+	case samplingAlways, samplingHeader:
+		return nil
+	case samplingRatio:
+		if *traceRatio < 0 || *traceRatio > 1 {
+			return fmt.Errorf("trace ratio must be within [0, 1], got %v", *traceRatio)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown trace sampling mode: %s", *traceSampling)
+}
+
+func shouldTrace(r *http.Request) bool {
+	if !*traceEnabled {
+		return false
+	}
+
+	switch *traceSampling {
+	case samplingRatio:
+		samplerMu.Lock()
+		defer samplerMu.Unlock()
+		return samplerRnd.Float64() < *traceRatio
+	case samplingHeader:
+		return r.Header.Get(*traceHeader) != "" || r.Header.Get(traceIDHeader) != ""
+	}
+	return true
+}
+
+func startTrace(r *http.Request) *http.Request {
+	if spanFrom(r.Context()) != nil || !shouldTrace(r) {
+		return r
+	}
+
+	id := r.Header.Get(traceIDHeader)
+	if id == "" {
+		samplerMu.Lock()
+		id = fmt.Sprintf("%016x", samplerRnd.Uint64())
+		samplerMu.Unlock()
+	}
+	return r.WithContext(context.WithValue(r.Context(), spanKey{}, &span{id: id, start: time.Now()}))
+}
+
+func spanFrom(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+func (s *span) finish(r *http.Request, dst string, status int) {
+	log.Printf("trace %s %s %s -> %s status %d in %s", s.id, r.Method, r.URL, dst, status, time.Since(s.start))
+}
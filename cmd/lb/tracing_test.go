@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func withTraceFlags(t *testing.T, enabled bool, sampling string, ratio float64) {
+	t.Helper()
+
+	prevEnabled, prevSampling, prevRatio := *traceEnabled, *traceSampling, *traceRatio
+	*traceEnabled, *traceSampling, *traceRatio = enabled, sampling, ratio
+	t.Cleanup(func() {
+		*traceEnabled, *traceSampling, *traceRatio = prevEnabled, prevSampling, prevRatio
+	})
+}
+
+func TestShouldTrace(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	withTraceFlags(t, false, samplingAlways, 1)
+	if shouldTrace(req) {
+		t.Error("Tracing disabled but request was sampled")
+	}
+
+	withTraceFlags(t, true, samplingAlways, 0)
+	if !shouldTrace(req) {
+		t.Error("Always sampling skipped a request")
+	}
+
+	withTraceFlags(t, true, samplingRatio, 0)
+	for i := 0; i < 100; i++ {
+		if shouldTrace(req) {
+			t.Fatal("Zero ratio sampled a request")
+		}
+	}
+
+	withTraceFlags(t, true, samplingHeader, 0)
+	if shouldTrace(req) {
+		t.Error("Header sampling traced a request without the header")
+	}
+	req.Header.Set(*traceHeader, "1")
+	if !shouldTrace(req) {
+		t.Error("Header sampling skipped a request with the header")
+	}
+}
+
+func TestForward_UntracedRequestHasNoTraceHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(traceIDHeader) != "" {
+			t.Errorf("Untraced request carried trace id %s", r.Header.Get(traceIDHeader))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	withTraceFlags(t, true, samplingHeader, 0)
+	*https = false
+
+	recorder := httptest.NewRecorder()
+	if err := forward(backend.URL[7:], recorder, httptest.NewRequest(http.MethodGet, "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Header().Get("lb-from") != "" {
+		t.Error("Untraced response carried lb-from header")
+	}
+
+	traced := httptest.NewRequest(http.MethodGet, "/", nil)
+	traced.Header.Set(traceIDHeader, "abc")
+	recorder = httptest.NewRecorder()
+	backend.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(traceIDHeader) != "abc" {
+			t.Errorf("Expected trace id to propagate, got %q", r.Header.Get(traceIDHeader))
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	if err := forward(backend.URL[7:], recorder, traced); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Header().Get("lb-from") == "" {
+		t.Error("Traced response is missing lb-from header")
+	}
+}
+
+func TestValidateTraceSampling(t *testing.T) {
+	withTraceFlags(t, true, "sometimes", 0)
+	if err := validateTraceSampling(); err == nil {
+		t.Error("Expected unknown mode to be rejected")
+	}
+
+	withTraceFlags(t, true, samplingRatio, 2)
+	if err := validateTraceSampling(); err == nil {
+		t.Error("Expected out of range ratio to be rejected")
+	}
+}
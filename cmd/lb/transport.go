@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	dialTimeout           = flag.Duration("dial-timeout", time.Second, "max time to establish a connection to a backend")
+	responseHeaderTimeout = flag.Duration("response-header-timeout", 3*time.Second, "max time to wait for backend response headers")
+	requestTimeout        = flag.Duration("request-timeout", 60*time.Second, "max total time of a proxied request including the response body")
+)
+
+var backendClient = newBackendClient()
+
+func newBackendClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   *dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.ResponseHeaderTimeout = *responseHeaderTimeout
+	// Accept-Encoding goes to backends as the client sent it; see
+	// encoding.go.
+	transport.DisableCompression = true
+	return &http.Client{Transport: transport}
+}
+
+func validateTimeouts() error {
+	if *timeoutSec <= 0 {
+		return fmt.Errorf("timeout-sec must be positive")
+	}
+	if *dialTimeout <= 0 || *responseHeaderTimeout <= 0 || *requestTimeout <= 0 {
+		return fmt.Errorf("timeouts must be positive")
+	}
+	if *responseHeaderTimeout > *requestTimeout {
+		return fmt.Errorf("response header timeout %s exceeds request timeout %s", *responseHeaderTimeout, *requestTimeout)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func withTimeouts(t *testing.T, headers, total time.Duration) {
+	t.Helper()
+
+	prevHeaders, prevTotal, prevClient := *responseHeaderTimeout, *requestTimeout, backendClient
+	*responseHeaderTimeout, *requestTimeout = headers, total
+	backendClient = newBackendClient()
+	t.Cleanup(func() {
+		*responseHeaderTimeout, *requestTimeout, backendClient = prevHeaders, prevTotal, prevClient
+	})
+}
+
+func TestForward_SlowBodyWithinRequestTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for i := 0; i < 3; i++ {
+			time.Sleep(30 * time.Millisecond)
+			w.Write([]byte("chunk"))
+		}
+	}))
+	defer backend.Close()
+	*https = false
+	withTimeouts(t, 50*time.Millisecond, time.Second)
+
+	recorder := httptest.NewRecorder()
+	if err := forward(backend.URL[7:], recorder, httptest.NewRequest(http.MethodGet, "/", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Body.String() != "chunkchunkchunk" {
+		t.Errorf("Download outliving the header timeout was cut: %q", recorder.Body.String())
+	}
+}
+
+func TestForward_SlowHeadersTimeOut(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	*https = false
+	withTimeouts(t, 50*time.Millisecond, time.Second)
+
+	recorder := httptest.NewRecorder()
+	if err := forward(backend.URL[7:], recorder, httptest.NewRequest(http.MethodGet, "/", nil)); err == nil {
+		t.Fatal("Expected response header timeout")
+	}
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, recorder.Code)
+	}
+}
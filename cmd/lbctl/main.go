@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+
+	"github.com/LeVasTiaN/KPI_Lab5/strategy"
+)
+
+const usage = `usage: lbctl replay -log FILE [-strategy NAME] [-seed N] [-backend-zones SPEC]
+
+Replays routing decisions logged by the balancer with -decision-log against
+a strategy and reports how many clients it would route elsewhere.`
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "replay" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	os.Exit(replay(os.Args[2:], os.Stdout, os.Stderr))
+}
+
+func replay(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	logFile := flags.String("log", "", "decision log written by the balancer")
+	strategyName := flags.String("strategy", "hash", "strategy to replay the decisions with: hash or random")
+	seed := flags.Int64("seed", 1, "seed for randomized strategies")
+	zones := flags.String("backend-zones", "", "comma separated backend=zone pairs enabling same-zone routing")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *logFile == "" {
+		fmt.Fprintln(stderr, "-log is required")
+		return 2
+	}
+
+	selection, err := strategy.New(*strategyName, rand.NewSource(*seed))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	zoneMap, err := strategy.ParseZones(*zones)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if len(zoneMap) > 0 {
+		selection = strategy.ZoneAffinity{Next: selection, Zones: zoneMap}
+	}
+
+	file, err := os.Open(*logFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer file.Close()
+
+	result, err := strategy.Replay(file, selection)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", *logFile, err)
+		return 1
+	}
+	writeResult(stdout, result)
+	return 0
+}
+
+func writeResult(w io.Writer, result strategy.ReplayResult) {
+	changed := 0.0
+	if result.Total > 0 {
+		changed = 100 * float64(result.Changed) / float64(result.Total)
+	}
+	fmt.Fprintf(w, "decisions: %d\n", result.Total)
+	fmt.Fprintf(w, "changed:   %d (%.1f%%)\n", result.Changed, changed)
+
+	backends := make([]string, 0, len(result.Before))
+	for backend := range result.Before {
+		backends = append(backends, backend)
+	}
+	for backend := range result.After {
+		if _, ok := result.Before[backend]; !ok {
+			backends = append(backends, backend)
+		}
+	}
+	sort.Strings(backends)
+	for _, backend := range backends {
+		fmt.Fprintf(w, "  %s: %d -> %d\n", backend, result.Before[backend], result.After[backend])
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LeVasTiaN/KPI_Lab5/strategy"
+)
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.log")
+	var log bytes.Buffer
+	backends := []string{"server1:8080", "server2:8080"}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		client := strategy.ClientInfo{Key: key}
+		selected, _ := strategy.Hash{}.Select(client, backends)
+		json.NewEncoder(&log).Encode(strategy.Decision{Client: client, Strategy: "hash", Backends: backends, Backend: selected})
+	}
+	if err := os.WriteFile(path, log.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := replay([]string{"-log", path, "-strategy", "hash"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected success, got %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "decisions: 4\nchanged:   0 (0.0%)\n") {
+		t.Errorf("Unexpected report:\n%s", stdout.String())
+	}
+
+	if code := replay([]string{"-log", path, "-strategy", "fastest"}, &stdout, &stderr); code != 2 {
+		t.Errorf("Expected an unknown strategy to be a usage error, got %d", code)
+	}
+	if code := replay([]string{"-log", filepath.Join(t.TempDir(), "missing")}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected a missing log to fail, got %d", code)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/apierrors"
+)
+
+var (
+	dbCacheTTL   = flag.Duration("db-cache-ttl", time.Second, "how long datastore values are cached in memory (0 disables the cache)")
+	dbCacheStale = flag.Duration("db-cache-stale", 5*time.Minute, "how long past their TTL cached values are kept to serve reads while the datastore is unreachable")
+	dbWatchRetry = flag.Duration("db-watch-retry", time.Second, "how often the datastore watch that evicts cached values is retried while it is lost")
+	dbStandbyURL = flag.String("db-standby-url", "", "datastore URL, e.g. http://db-standby:8083/db/, used while the primary one cannot be reached")
+)
+
+var errDBNotFound = errors.New("key not found in db")
+
+type dbStore interface {
+	Get(key string) (interface{}, error)
+	Put(key string, value interface{}) error
+}
+
+type dbHTTPClient struct {
+	baseURL string
+	// standbyURL is tried when baseURL cannot be reached. Requests stay
+	// with whichever of the two answered last.
+	standbyURL string
+	client     *http.Client
+	onStandby  atomic.Bool
+}
+
+func (c *dbHTTPClient) currentURL(standby bool) string {
+	if standby {
+		return c.standbyURL
+	}
+	return c.baseURL
+}
+
+// do sends the request that build makes for the datastore URL in use, and
+// to the other one if that cannot be reached.
+func (c *dbHTTPClient) do(build func(baseURL string) (*http.Request, error)) (*http.Response, error) {
+	return c.doWith(c.client, build)
+}
+
+func (c *dbHTTPClient) doWith(client *http.Client, build func(baseURL string) (*http.Request, error)) (*http.Response, error) {
+	send := func(standby bool) (*http.Response, error) {
+		request, err := build(c.currentURL(standby))
+		if err != nil {
+			return nil, err
+		}
+		return client.Do(request)
+	}
+
+	standby := c.onStandby.Load()
+	resp, err := send(standby)
+	if err == nil || c.standbyURL == "" {
+		return resp, err
+	}
+	resp, retryErr := send(!standby)
+	if retryErr != nil {
+		return nil, err
+	}
+	if c.onStandby.CompareAndSwap(standby, !standby) {
+		log.Printf("Datastore at %s unreachable, switched to %s", c.currentURL(standby), c.currentURL(!standby))
+	}
+	return resp, nil
+}
+
+// health probes the datastore in use.
+func (c *dbHTTPClient) health() error {
+	resp, err := c.do(func(baseURL string) (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, baseURL+"health", nil)
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health probe returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *dbHTTPClient) Get(key string) (interface{}, error) {
+	resp, err := c.do(func(baseURL string) (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, baseURL+key, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errDBNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apierrors.Decode(resp)
+	}
+
+	var dbResponse struct {
+		Key   string      `json:"key"`
+		Value interface{} `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&dbResponse); err != nil {
+		return nil, fmt.Errorf("invalid db response: %w", err)
+	}
+	return dbResponse.Value, nil
+}
+
+// Watch subscribes to the writes of the datastore in use and sends the key
+// of each on the returned channel, which is closed once the stream ends. The
+// stream outlives the timeout of the client, so ctx is what stops it.
+func (c *dbHTTPClient) Watch(ctx context.Context) (<-chan string, error) {
+	stream := &http.Client{Transport: c.client.Transport}
+	resp, err := c.doWith(stream, func(baseURL string) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, watchURL(baseURL), nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, apierrors.Decode(resp)
+	}
+
+	keys := make(chan string)
+	go func() {
+		defer close(keys)
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var event struct {
+				Key string `json:"key"`
+			}
+			if json.Unmarshal([]byte(data), &event) != nil {
+				continue
+			}
+			select {
+			case keys <- event.Key:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return keys, nil
+}
+
+// watchURL turns a datastore URL such as http://db:8083/db/ into that of
+// its watch stream, http://db:8083/watch.
+func watchURL(baseURL string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/db") + "/watch"
+}
+
+func (c *dbHTTPClient) Put(key string, value interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"value": value})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(func(baseURL string) (*http.Request, error) {
+		request, err := http.NewRequest(http.MethodPost, baseURL+key, bytes.NewReader(body))
+		if err == nil {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		return request, err
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return apierrors.Decode(resp)
+	}
+	return nil
+}
+
+type cachedValue struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// keyWatcher reports the keys written to a store, on a channel that is
+// closed once it stops doing so.
+type keyWatcher interface {
+	Watch(ctx context.Context) (<-chan string, error)
+}
+
+// dbCache keeps recently read values in memory. Reads go through to the
+// store on a miss and writes update both the store and the cache. While the
+// cache follows the writes of the store, values written by other servers
+// are evicted as soon as they are reported, and otherwise become visible
+// within the TTL. Expired values are kept for another stale period to serve
+// reads while the store is unreachable.
+type dbCache struct {
+	store dbStore
+	ttl   time.Duration
+	stale time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedValue
+	// fills tracks the keys being read or written through to the store, so
+	// that a fill that raced with an eviction of its key does not cache the
+	// value it got. A key is dropped once none of its fills is in flight.
+	fills map[string]*keyFills
+	// generation grows whenever every value is expired at once, which
+	// overtakes the fills of all keys.
+	generation uint64
+	// sweepAt is when entries past their stale period are next dropped.
+	sweepAt time.Time
+}
+
+func newDBCache(store dbStore, ttl, stale time.Duration) *dbCache {
+	return &dbCache{store: store, ttl: ttl, stale: stale, now: time.Now, entries: make(map[string]cachedValue), fills: make(map[string]*keyFills)}
+}
+
+// keyFills counts the fills of a key in flight and the evictions of the key
+// since the first of them started.
+type keyFills struct {
+	pending int
+	version uint64
+}
+
+// fill is a read or write of a key whose value is to be cached.
+type fill struct {
+	version    uint64
+	generation uint64
+}
+
+// startFill registers a fill of key, which set or endFill has to end.
+func (c *dbCache) startFill(key string) fill {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fills, ok := c.fills[key]
+	if !ok {
+		fills = &keyFills{}
+		c.fills[key] = fills
+	}
+	fills.pending++
+	return fill{version: fills.version, generation: c.generation}
+}
+
+// endFill ends a fill of key and reports whether it is still current. The
+// caller holds mu.
+func (c *dbCache) endFill(key string, f fill) bool {
+	fills := c.fills[key]
+	current := fills.version == f.version && c.generation == f.generation
+	if fills.pending--; fills.pending == 0 {
+		delete(c.fills, key)
+	}
+	return current
+}
+
+func (c *dbCache) Get(key string) (interface{}, error) {
+	if c.ttl <= 0 {
+		return c.store.Get(key)
+	}
+
+	c.mu.Lock()
+	cached, ok := c.entries[key]
+	if ok && c.now().Before(cached.expiresAt) {
+		c.mu.Unlock()
+		return cached.value, nil
+	}
+	c.mu.Unlock()
+
+	f := c.startFill(key)
+	value, err := c.store.Get(key)
+	if errors.Is(err, errDBNotFound) {
+		c.Invalidate(key)
+	}
+	if err != nil {
+		c.abandon(key, f)
+		return nil, err
+	}
+	c.set(key, value, f)
+	return value, nil
+}
+
+// GetCached returns the value of key from the cache alone, including an
+// expired value still within its stale period.
+func (c *dbCache) GetCached(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.entries[key]
+	if !ok || !c.now().Before(cached.expiresAt.Add(c.stale)) {
+		return nil, false
+	}
+	return cached.value, true
+}
+
+func (c *dbCache) Put(key string, value interface{}) error {
+	c.Invalidate(key)
+	if c.ttl <= 0 {
+		return c.store.Put(key, value)
+	}
+
+	f := c.startFill(key)
+	if err := c.store.Put(key, value); err != nil {
+		c.abandon(key, f)
+		return err
+	}
+	c.set(key, value, f)
+	return nil
+}
+
+func (c *dbCache) Invalidate(key string) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	if fills, ok := c.fills[key]; ok {
+		fills.version++
+	}
+	c.mu.Unlock()
+}
+
+// expireAll marks every value as expired, leaving it to serve reads only
+// while the store is unreachable.
+func (c *dbCache) expireAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for key, cached := range c.entries {
+		if now.Before(cached.expiresAt) {
+			cached.expiresAt = now
+			c.entries[key] = cached
+		}
+	}
+	c.generation++
+}
+
+// abandon ends a fill of key that got no value to cache.
+func (c *dbCache) abandon(key string, f fill) {
+	c.mu.Lock()
+	c.endFill(key, f)
+	c.mu.Unlock()
+}
+
+// set ends fill f of key and caches value unless key was evicted since f
+// started, as the value may then be older than the eviction. Entries past
+// their stale period are dropped at most once per TTL and stale period, so
+// that a miss does not cost a pass over the whole cache.
+func (c *dbCache) set(key string, value interface{}, f fill) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if !now.Before(c.sweepAt) {
+		for k, cached := range c.entries {
+			if !now.Before(cached.expiresAt.Add(c.stale)) {
+				delete(c.entries, k)
+			}
+		}
+		c.sweepAt = now.Add(c.ttl + c.stale)
+	}
+	if !c.endFill(key, f) {
+		return
+	}
+	c.entries[key] = cachedValue{value: value, expiresAt: now.Add(c.ttl)}
+}
+
+// follow evicts the keys watcher reports written until ctx is done. Writes
+// made while it is not watching are missed, so every value cached by then
+// expires whenever it starts watching again, which it tries every retry.
+func (c *dbCache) follow(ctx context.Context, watcher keyWatcher, retry time.Duration) {
+	for {
+		keys, err := watcher.Watch(ctx)
+		if err == nil {
+			c.expireAll()
+			for key := range keys {
+				c.Invalidate(key)
+			}
+			if ctx.Err() == nil {
+				log.Printf("Lost the datastore watch, caching values for up to %s until it is back", c.ttl)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+	}
+}
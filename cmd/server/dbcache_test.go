@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	values map[string]interface{}
+	gets   int
+}
+
+func (s *fakeStore) Get(key string) (interface{}, error) {
+	s.gets++
+	value, ok := s.values[key]
+	if !ok {
+		return nil, errDBNotFound
+	}
+	return value, nil
+}
+
+func (s *fakeStore) Put(key string, value interface{}) error {
+	s.values[key] = value
+	return nil
+}
+
+func TestDBCache(t *testing.T) {
+	store := &fakeStore{values: map[string]interface{}{"hot": "v1"}}
+	cache := newDBCache(store, time.Second, 0)
+	now := time.Unix(0, 0)
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if value, err := cache.Get("hot"); err != nil || value != "v1" {
+			t.Fatalf("Unexpected result %v, %v", value, err)
+		}
+	}
+	if store.gets != 1 {
+		t.Errorf("Expected a single read-through, got %d", store.gets)
+	}
+
+	store.values["hot"] = "v2"
+	now = now.Add(2 * time.Second)
+	if value, _ := cache.Get("hot"); value != "v2" {
+		t.Errorf("Expected expired entry to be refreshed, got %v", value)
+	}
+
+	if err := cache.Put("hot", "v3"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := cache.Get("hot"); value != "v3" || store.values["hot"] != "v3" {
+		t.Errorf("Expected write-through to update store and cache, got %v", value)
+	}
+
+	if _, err := cache.Get("missing"); !errors.Is(err, errDBNotFound) {
+		t.Errorf("Expected errDBNotFound, got %v", err)
+	}
+	gets := store.gets
+	cache.Get("missing")
+	if store.gets != gets+1 {
+		t.Error("Missing keys should not be cached")
+	}
+
+	t.Run("stale", func(t *testing.T) {
+		store := &fakeStore{values: map[string]interface{}{"k": "v"}}
+		cache := newDBCache(store, time.Second, time.Minute)
+		cache.now = func() time.Time { return now }
+
+		cache.Get("k")
+		now = now.Add(30 * time.Second)
+		if value, ok := cache.GetCached("k"); !ok || value != "v" {
+			t.Errorf("Expected the stale value, got %v, %v", value, ok)
+		}
+		now = now.Add(time.Minute)
+		if _, ok := cache.GetCached("k"); ok {
+			t.Error("Expected the value to be gone after the stale period")
+		}
+
+		cache.Get("k")
+		delete(store.values, "k")
+		now = now.Add(2 * time.Second)
+		cache.Get("k")
+		if _, ok := cache.GetCached("k"); ok {
+			t.Error("Expected a key missing from the store to be dropped")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		store := &fakeStore{values: map[string]interface{}{"k": "v"}}
+		cache := newDBCache(store, 0, 0)
+		cache.Get("k")
+		cache.Get("k")
+		if store.gets != 2 {
+			t.Errorf("Expected every read to reach the store, got %d", store.gets)
+		}
+	})
+}
+
+// fakeWatcher hands out the channels of its streams one by one and fails
+// once it has none left.
+type fakeWatcher struct {
+	streams chan chan string
+}
+
+func (w *fakeWatcher) Watch(ctx context.Context) (<-chan string, error) {
+	select {
+	case stream := <-w.streams:
+		return stream, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestDBCache_Follow(t *testing.T) {
+	store := &fakeStore{values: map[string]interface{}{"k": "v1", "other": "o1"}}
+	cache := newDBCache(store, time.Minute, time.Minute)
+	watcher := &fakeWatcher{streams: make(chan chan string)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cache.follow(ctx, watcher, time.Millisecond)
+
+	stream := make(chan string)
+	watcher.streams <- stream
+	cache.Get("k")
+	cache.Get("other")
+
+	store.values["k"] = "v2"
+	stream <- "k"
+	// The stream is unbuffered, so the eviction of "k" is done once the
+	// next key is taken.
+	stream <- "unrelated"
+	if value, _ := cache.Get("k"); value != "v2" {
+		t.Errorf("Expected the reported write to evict 'k', got %v", value)
+	}
+	gets := store.gets
+	if value, _ := cache.Get("other"); value != "o1" || store.gets != gets {
+		t.Errorf("Expected 'other' to stay cached, got %v after %d reads", value, store.gets-gets)
+	}
+
+	// Writes may be missed until the watch is back, so what was cached
+	// then is reread, but still serves reads while the store is down.
+	close(stream)
+	store.values["other"] = "o2"
+	stream = make(chan string)
+	watcher.streams <- stream
+	stream <- "unrelated"
+	if value, ok := cache.GetCached("other"); !ok || value != "o1" {
+		t.Errorf("Expected the stale value to be kept, got %v, %v", value, ok)
+	}
+	if value, _ := cache.Get("other"); value != "o2" {
+		t.Errorf("Expected values cached before the watch came back to expire, got %v", value)
+	}
+}
+
+func TestDBCache_EvictionDuringRead(t *testing.T) {
+	store := &fakeStore{values: map[string]interface{}{"k": "v1"}}
+	cache := newDBCache(store, time.Minute, 0)
+
+	// A write reported while the value is being read may be newer than the
+	// value read.
+	f := cache.startFill("k")
+	cache.Invalidate("k")
+	cache.set("k", "v1", f)
+	if _, ok := cache.GetCached("k"); ok {
+		t.Error("Expected a value read before an eviction not to be cached")
+	}
+
+	// Evictions of other keys do not hold back the fills of this one.
+	f = cache.startFill("k")
+	cache.Invalidate("other")
+	cache.set("k", "v1", f)
+	if value, ok := cache.GetCached("k"); !ok || value != "v1" {
+		t.Errorf("Expected a write to another key not to keep 'k' from being cached, got %v, %v", value, ok)
+	}
+
+	// Expiring every value at once overtakes the fills of all keys.
+	cache.Invalidate("k")
+	f = cache.startFill("k")
+	cache.expireAll()
+	cache.set("k", "v1", f)
+	if _, ok := cache.GetCached("k"); ok {
+		t.Error("Expected a value read before every value expired not to be cached")
+	}
+
+	if len(cache.fills) != 0 {
+		t.Errorf("Expected no fills to be tracked once all ended, got %v", cache.fills)
+	}
+}
+
+func TestDBCache_Sweep(t *testing.T) {
+	store := &fakeStore{values: map[string]interface{}{"a": "a", "b": "b", "c": "c"}}
+	cache := newDBCache(store, time.Second, time.Second)
+	now := time.Unix(0, 0)
+	cache.now = func() time.Time { return now }
+
+	cache.Get("a")
+	cache.Get("b")
+	now = now.Add(time.Second)
+	cache.Get("c")
+	if len(cache.entries) != 3 {
+		t.Errorf("Expected no sweep before the TTL and stale period pass, got %d entries", len(cache.entries))
+	}
+
+	now = now.Add(1500 * time.Millisecond)
+	delete(store.values, "c")
+	store.values["d"] = "d"
+	cache.Get("d")
+	if _, ok := cache.entries["a"]; ok || len(cache.entries) != 2 {
+		t.Errorf("Expected the entries past their stale period to be swept, got %v", cache.entries)
+	}
+}
+
+func TestDBHTTPClient_Watch(t *testing.T) {
+	db := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/watch" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: put\ndata: {\"key\":\"a\"}\n\n: comment\n\nevent: put\ndata: {\"key\":\"b\"}\n\n")
+	}))
+	defer db.Close()
+
+	client := &dbHTTPClient{baseURL: db.URL + "/db/", client: db.Client()}
+	keys, err := client.Watch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []string
+	for key := range keys {
+		received = append(received, key)
+	}
+	if len(received) != 2 || received[0] != "a" || received[1] != "b" {
+		t.Errorf("Expected keys a and b, got %v", received)
+	}
+}
+
+func TestDBHTTPClient(t *testing.T) {
+	values := map[string]interface{}{}
+	db := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path[len("/db/"):]
+		if r.Method == http.MethodPost {
+			var request struct {
+				Value interface{} `json:"value"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			values[key] = request.Value
+			return
+		}
+		value, ok := values[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"key": key, "value": value})
+	}))
+	defer db.Close()
+
+	client := &dbHTTPClient{baseURL: db.URL + "/db/", client: db.Client()}
+	if _, err := client.Get("team"); !errors.Is(err, errDBNotFound) {
+		t.Errorf("Expected errDBNotFound, got %v", err)
+	}
+	if err := client.Put("team", "2026-10-16"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := client.Get("team"); err != nil || value != "2026-10-16" {
+		t.Errorf("Unexpected value %v, %v", value, err)
+	}
+}
+
+func TestDBHTTPClient_Standby(t *testing.T) {
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"key": "team", "value": "standby"})
+	}))
+	defer standby.Close()
+	primary := httptest.NewServer(http.NotFoundHandler())
+	primary.Close()
+
+	client := &dbHTTPClient{baseURL: primary.URL + "/db/", standbyURL: standby.URL + "/db/", client: standby.Client()}
+	if value, err := client.Get("team"); err != nil || value != "standby" {
+		t.Errorf("Expected the standby to answer, got %v, %v", value, err)
+	}
+	if !client.onStandby.Load() {
+		t.Error("Expected later requests to go to the standby")
+	}
+	if err := client.health(); err != nil {
+		t.Errorf("Expected the standby to be healthy, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	dbProbeInterval = flag.Duration("db-probe-interval", 5*time.Second, "how often the datastore health is probed (0 disables probing)")
+	degradedWeight  = flag.Int("degraded-weight", 25, "share of traffic, from 0 to 100, asked of the balancer while the datastore is unreachable")
+)
+
+// dbHealth switches the server into a degraded mode while the datastore
+// does not answer its health probes: reads are served from the cache,
+// writes are rejected and health responses ask the balancer for less
+// traffic instead of failing, so the server is deprioritized rather than
+// dropped.
+type dbHealth struct {
+	probe    func() error
+	degraded atomic.Bool
+}
+
+func newDBHealth(store *dbHTTPClient) *dbHealth {
+	return &dbHealth{probe: store.health}
+}
+
+// check probes the datastore once and updates the mode.
+func (h *dbHealth) check() {
+	err := h.probe()
+	wasDegraded := h.degraded.Swap(err != nil)
+	switch {
+	case err != nil && !wasDegraded:
+		log.Printf("Datastore unreachable, serving in degraded mode: %s", err)
+	case err == nil && wasDegraded:
+		log.Printf("Datastore reachable again, leaving degraded mode")
+	}
+}
+
+func (h *dbHealth) monitor(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		h.check()
+	}
+}
+
+// announce lowers the weight asked of the balancer while degraded. A server
+// that is draining keeps asking for no traffic at all.
+func (h *dbHealth) announce(header http.Header) {
+	if !h.degraded.Load() || header.Get("X-Drain") != "" {
+		return
+	}
+	weight := min(max(*degradedWeight, 0), 100)
+	if announced, err := strconv.Atoi(header.Get("X-Weight")); err == nil && announced < weight {
+		weight = announced
+	}
+	header.Set("X-Weight", strconv.Itoa(weight))
+}
+
+// retryAfter is the Retry-After value of writes rejected while degraded: the
+// earliest the next probe can bring the server back.
+func (h *dbHealth) retryAfter() string {
+	seconds := int((*dbProbeInterval + time.Second - 1) / time.Second)
+	return strconv.Itoa(max(seconds, 1))
+}
+
+type healthPayload struct {
+	Status string `json:"status"`
+	DB     string `json:"db"`
+}
+
+// writeHealth answers a health probe. Clients that accept JSON get the
+// extended payload, the others the plain text status.
+func (h *dbHealth) writeHealth(w http.ResponseWriter, r *http.Request) {
+	payload := healthPayload{Status: "ok", DB: "reachable"}
+	text := "OK"
+	if h.degraded.Load() {
+		payload = healthPayload{Status: "degraded", DB: "unreachable"}
+		text = "Degraded"
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Accept")); mediaType == "application/json" {
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(payload)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(text))
+}
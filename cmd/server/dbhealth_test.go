@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDBHealth(t *testing.T) {
+	var probeErr error
+	state := &dbHealth{probe: func() error { return probeErr }}
+
+	health := func(accept string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/health", nil)
+		request.Header.Set("Accept", accept)
+		state.announce(recorder.Header())
+		state.writeHealth(recorder, request)
+		return recorder
+	}
+
+	state.check()
+	if recorder := health(""); recorder.Body.String() != "OK" || recorder.Header().Get("X-Weight") != "" {
+		t.Errorf("Expected a plain OK without weight, got %q %v", recorder.Body, recorder.Header())
+	}
+
+	probeErr = errors.New("connection refused")
+	state.check()
+	recorder := health("application/json")
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected a degraded server to stay healthy, got %d", recorder.Code)
+	}
+	var payload healthPayload
+	if err := json.NewDecoder(recorder.Body).Decode(&payload); err != nil || payload.Status != "degraded" || payload.DB != "unreachable" {
+		t.Errorf("Expected the degraded payload, got %+v, %v", payload, err)
+	}
+	if recorder.Header().Get("X-Weight") != "25" {
+		t.Errorf("Expected the degraded weight, got %q", recorder.Header().Get("X-Weight"))
+	}
+
+	header := http.Header{"X-Weight": {"10"}}
+	state.announce(header)
+	if header.Get("X-Weight") != "10" {
+		t.Errorf("Expected a lower configured weight to be kept, got %q", header.Get("X-Weight"))
+	}
+	header = http.Header{"X-Drain": {"true"}}
+	state.announce(header)
+	if header.Get("X-Weight") != "" {
+		t.Errorf("Expected a draining server to announce no weight, got %v", header)
+	}
+
+	probeErr = nil
+	state.check()
+	if state.degraded.Load() {
+		t.Error("Expected a successful probe to leave degraded mode")
+	}
+}
+
+func TestReadValue_Degraded(t *testing.T) {
+	store := &fakeStore{values: map[string]interface{}{"k": "v"}}
+	cache := newDBCache(store, time.Nanosecond, time.Hour)
+	state := &dbHealth{}
+
+	if value, err := readValue(cache, state, "k"); err != nil || value != "v" {
+		t.Fatalf("Unexpected result %v, %v", value, err)
+	}
+
+	state.degraded.Store(true)
+	gets := store.gets
+	if value, err := readValue(cache, state, "k"); err != nil || value != "v" {
+		t.Errorf("Expected the cached value, got %v, %v", value, err)
+	}
+	if store.gets != gets {
+		t.Error("Expected degraded reads not to reach the store")
+	}
+	if _, err := readValue(cache, state, "other"); err == nil {
+		t.Error("Expected an error for a key that is not cached")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// confHealthWeight sets the share of traffic, from 0 to 100, the server asks
+// the balancer for in its health responses.
+const confHealthWeight = "CONF_HEALTH_WEIGHT"
+
+const shutdownTimeout = 5 * time.Second
+
+var drainDelay = flag.Duration("drain-delay", 0, "how long to keep serving after a termination signal while health responses ask the balancer to drain traffic")
+
+// drainState tells the balancer through health response headers to move
+// traffic away before the server stops, so that deploys drop no requests.
+type drainState struct {
+	draining atomic.Bool
+}
+
+func (d *drainState) announce(header http.Header) {
+	if d.draining.Load() {
+		header.Set("X-Drain", "true")
+		return
+	}
+	if weight := os.Getenv(confHealthWeight); weight != "" {
+		header.Set("X-Weight", weight)
+	}
+}
+
+// wait starts draining and keeps the server up for delay, which should cover
+// the balancer health interval and drain ramp.
+func (d *drainState) wait(delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	d.draining.Store(true)
+	log.Printf("Draining for %s before shutdown", delay)
+	time.Sleep(delay)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDrainState_Announce(t *testing.T) {
+	t.Setenv(confHealthWeight, "")
+	drain := &drainState{}
+
+	header := http.Header{}
+	drain.announce(header)
+	if len(header) != 0 {
+		t.Errorf("Expected no announcement from a serving server, got %v", header)
+	}
+
+	t.Setenv(confHealthWeight, "25")
+	header = http.Header{}
+	drain.announce(header)
+	if header.Get("X-Weight") != "25" {
+		t.Errorf("Expected the configured weight, got %v", header)
+	}
+
+	drain.draining.Store(true)
+	header = http.Header{}
+	drain.announce(header)
+	if header.Get("X-Drain") != "true" || header.Get("X-Weight") != "" {
+		t.Errorf("Expected only the drain announcement, got %v", header)
+	}
+}
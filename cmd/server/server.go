@@ -1,8 +1,9 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/LeVasTiaN/KPI_Lab5/apierrors"
 	"github.com/LeVasTiaN/KPI_Lab5/httptools"
 	"github.com/LeVasTiaN/KPI_Lab5/signal"
 )
@@ -33,19 +35,32 @@ const (
 )
 
 func main() {
+	flag.Parse()
+
 	h := http.NewServeMux()
 
+	dbClient := &http.Client{Timeout: 3 * time.Second}
+	store := &dbHTTPClient{baseURL: dbServiceURL, standbyURL: *dbStandbyURL, client: dbClient}
+	dbState := newDBHealth(store)
+
+	drain := &drainState{}
 	h.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		drain.announce(w.Header())
+		dbState.announce(w.Header())
 		if os.Getenv(confHealthFailure) != "" {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			w.Write([]byte("Unhealthy"))
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+		dbState.writeHealth(w, r)
 	})
 
-	initializeDB()
+	db := newDBCache(store, *dbCacheTTL, *dbCacheStale)
+	if *dbCacheTTL > 0 {
+		go db.follow(context.Background(), store, *dbWatchRetry)
+	}
+	initializeDB(db)
+	go dbState.monitor(*dbProbeInterval)
 
 	report := make(Report)
 
@@ -65,30 +80,33 @@ func main() {
 
 		key := r.URL.Query().Get("key")
 		if key == "" {
-			rw.WriteHeader(http.StatusBadRequest)
+			apierrors.Write(rw, apierrors.New(apierrors.BadRequest, "missing key parameter"))
 			return
 		}
 
-		resp, err := http.Get(dbServiceURL + key)
-		if err != nil || resp.StatusCode == http.StatusNotFound {
-			rw.WriteHeader(http.StatusNotFound)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && dbState.degraded.Load() {
+			rw.Header().Set("Retry-After", dbState.retryAfter())
+			apierrors.Write(rw, apierrors.New(apierrors.Overloaded, "db service unavailable, writes are disabled"))
 			return
 		}
-		defer resp.Body.Close()
 
-		var dbResponse struct {
-			Key   string      `json:"key"`
-			Value interface{} `json:"value"`
+		value, err := readValue(db, dbState, key)
+		if errors.Is(err, errDBNotFound) {
+			apierrors.Write(rw, apierrors.New(apierrors.NotFound, "key %s not found", key))
+			return
 		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&dbResponse); err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
+		if err != nil {
+			var apiErr *apierrors.Error
+			if !errors.As(err, &apiErr) {
+				apiErr = apierrors.New(apierrors.Overloaded, "db service unavailable: %s", err)
+			}
+			apierrors.Write(rw, apiErr)
 			return
 		}
 
 		rw.Header().Set("content-type", "application/json")
 		rw.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(rw).Encode(dbResponse.Value)
+		_ = json.NewEncoder(rw).Encode(value)
 	})
 
 	h.Handle("/report", report)
@@ -97,32 +115,41 @@ func main() {
 	server.Start()
 
 	signal.WaitForTerminationSignal()
+	drain.wait(*drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Printf("Shutdown did not finish cleanly: %v\n", err)
+	}
 }
 
-func initializeDB() {
+// readValue reads key through the cache, or from the cache alone while the
+// datastore is unreachable.
+func readValue(db *dbCache, dbState *dbHealth, key string) (interface{}, error) {
+	if !dbState.degraded.Load() {
+		return db.Get(key)
+	}
+	if value, ok := db.GetCached(key); ok {
+		return value, nil
+	}
+	return nil, apierrors.New(apierrors.Overloaded, "db service unavailable and key %s is not cached", key)
+}
+
+func initializeDB(db dbStore) {
 	currentDate := time.Now().Format("2006-01-02")
-	requestBody, _ := json.Marshal(map[string]string{"value": currentDate})
 
 	maxRetries := 10
 	retryInterval := 5 * time.Second
 
 	for i := 0; i < maxRetries; i++ {
-		resp, err := http.Post(
-			dbServiceURL+teamName,
-			"application/json",
-			bytes.NewBuffer(requestBody),
-		)
-
-		if err == nil && resp.StatusCode == http.StatusOK {
+		err := db.Put(teamName, currentDate)
+		if err == nil {
 			fmt.Println("Successfully initialized DB")
 			return
 		}
 
-		if err != nil {
-			fmt.Printf("Attempt %d: DB connection failed: %v\n", i+1, err)
-		} else {
-			fmt.Printf("Attempt %d: DB returned status %d\n", i+1, resp.StatusCode)
-		}
+		fmt.Printf("Attempt %d: DB initialization failed: %v\n", i+1, err)
 
 		if i < maxRetries-1 {
 			time.Sleep(retryInterval)
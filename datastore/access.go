@@ -0,0 +1,76 @@
+package datastore
+
+import (
+	"context"
+	"fmt"
+)
+
+// AccessOp is the kind of key access an Authorizer decides on.
+type AccessOp int
+
+const (
+	// AccessRead covers Get and its variants, Has, GetMany and the keys
+	// listed by iterators.
+	AccessRead AccessOp = iota
+	// AccessWrite covers puts, batches and read-modify-write operations
+	// such as Append and Increment.
+	AccessWrite
+)
+
+func (op AccessOp) String() string {
+	switch op {
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	default:
+		return fmt.Sprintf("AccessOp(%d)", int(op))
+	}
+}
+
+// Access is one key access submitted to an Authorizer. Principal is the
+// one attached to the context of the call with ContextWithPrincipal, and
+// empty for calls without a context.
+type Access struct {
+	Principal string
+	Key       string
+	Op        AccessOp
+}
+
+// Authorizer reports whether an access is allowed. It is called on the
+// goroutine of the caller and must be safe for concurrent use.
+type Authorizer func(Access) bool
+
+type principalKey struct{}
+
+// ContextWithPrincipal attaches the principal the Authorizer sees for calls
+// made with the returned context.
+func ContextWithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal attached to ctx, or "".
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// authorize fails with ErrAccessDenied unless the authorizer set with
+// WithAuthorizer allows op on every key.
+func (db *Db) authorize(ctx context.Context, op AccessOp, keys ...string) error {
+	if db.authorizer == nil {
+		return nil
+	}
+	principal := PrincipalFromContext(ctx)
+	for _, key := range keys {
+		if !db.authorizer(Access{Principal: principal, Key: key, Op: op}) {
+			return fmt.Errorf("%w: %s of key '%s' by %q", ErrAccessDenied, op, key, principal)
+		}
+	}
+	return nil
+}
+
+// readable reports whether key may be listed without a principal.
+func (db *Db) readable(key string) bool {
+	return db.authorizer == nil || db.authorizer(Access{Key: key, Op: AccessRead})
+}
@@ -0,0 +1,72 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDb_Authorizer(t *testing.T) {
+	// Everyone reads public/, only admin writes anywhere and reads the rest.
+	var seen []Access
+	authorize := func(access Access) bool {
+		seen = append(seen, access)
+		if access.Principal == "admin" {
+			return true
+		}
+		return access.Op == AccessRead && strings.HasPrefix(access.Key, "public/")
+	}
+	database, err := Open(t.TempDir(), WithAuthorizer(authorize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	admin := ContextWithPrincipal(context.Background(), "admin")
+	guest := ContextWithPrincipal(context.Background(), "guest")
+	for _, key := range []string{"public/a", "secret/b"} {
+		if err := database.PutContext(admin, key, []byte("v")); err != nil {
+			t.Fatalf("Expected admin to write %s, got %v", key, err)
+		}
+	}
+
+	if _, err := database.GetContext(guest, "public/a"); err != nil {
+		t.Errorf("Expected guest to read public/a, got %v", err)
+	}
+	if len(seen) == 0 || seen[len(seen)-1] != (Access{Principal: "guest", Key: "public/a", Op: AccessRead}) {
+		t.Errorf("Expected the authorizer to see the guest read, got %+v", seen)
+	}
+	if _, err := database.GetContext(guest, "secret/b"); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Expected ErrAccessDenied for secret/b, got %v", err)
+	}
+	if err := database.PutContext(guest, "public/a", []byte("x")); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Expected ErrAccessDenied for a guest write, got %v", err)
+	}
+
+	// Calls without a context have no principal.
+	if err := database.Put("public/a", "x"); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Expected Put to be denied, got %v", err)
+	}
+	if _, err := database.Increment("public/n", 1); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Expected Increment to be denied, got %v", err)
+	}
+	if err := <-database.PutAsync("public/a", "x"); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Expected PutAsync to be denied, got %v", err)
+	}
+	if _, err := database.Has("secret/b"); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Expected Has to be denied, got %v", err)
+	}
+	if _, err := database.GetMany([]string{"public/a", "secret/b"}); !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Expected GetMany to be denied, got %v", err)
+	}
+	assertValue(t, database, "public/a", "v")
+
+	var keys []string
+	for it := database.Keys(); it.Next(); {
+		keys = append(keys, it.Key())
+	}
+	if len(keys) != 1 || keys[0] != "public/a" {
+		t.Errorf("Expected iterators to skip unreadable keys, got %v", keys)
+	}
+}
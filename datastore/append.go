@@ -0,0 +1,22 @@
+package datastore
+
+import "fmt"
+
+// Append adds suffix to the end of the value stored under key, creating the
+// key when it does not exist. The existing expiration is kept.
+func (db *Db) Append(key, suffix string) error {
+	return db.readModifyWrite(key, func() ([]entry, error) {
+		value, valueType, expiresAt, _, err := db.readCurrent(key)
+		if err != nil {
+			return nil, err
+		}
+		if valueType != valueTypeBytes {
+			return nil, fmt.Errorf("%w: cannot append to %s value of key '%s'", ErrTypeMismatch, valueType, key)
+		}
+
+		combined := make([]byte, 0, len(value)+len(suffix))
+		combined = append(combined, value...)
+		combined = append(combined, suffix...)
+		return []entry{{key: key, value: combined, expiresAt: expiresAt}}, nil
+	})
+}
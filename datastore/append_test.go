@@ -0,0 +1,57 @@
+package datastore
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDb_Append(t *testing.T) {
+	database, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	if err := database.Append("log", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Append("log", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := database.Get("log"); err != nil || value != "ab" {
+		t.Errorf("Expected 'ab', got %q (%v)", value, err)
+	}
+
+	t.Run("concurrent appends are not lost", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := database.Append("concurrent", "x"); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		value, err := database.Get("concurrent")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != strings.Repeat("x", 20) {
+			t.Errorf("Expected 20 appends, got %q", value)
+		}
+	})
+
+	t.Run("int64 values are rejected", func(t *testing.T) {
+		if err := database.PutInt64("number", 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Append("number", "x"); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("Expected ErrTypeMismatch, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package datastore
+
+import "bytes"
+
+type WriteBatch struct {
+	entries []entry
+}
+
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+func (b *WriteBatch) Put(key, value string) {
+	b.entries = append(b.entries, entry{key: key, value: []byte(value)})
+}
+
+func (b *WriteBatch) PutBytes(key string, value []byte) {
+	b.entries = append(b.entries, entry{key: key, value: bytes.Clone(value)})
+}
+
+func (b *WriteBatch) Len() int {
+	return len(b.entries)
+}
+
+func (b *WriteBatch) Reset() {
+	b.entries = b.entries[:0]
+}
+
+// Write appends all batched records with a single file write, so either the
+// whole batch becomes visible or none of it does. Every record but the last
+// is marked as part of the batch, and recovery drops a batch whose last
+// record did not make it to disk, so that this holds across a crash too.
+func (db *Db) Write(batch *WriteBatch) error {
+	if batch == nil || batch.Len() == 0 {
+		return nil
+	}
+
+	entries := make([]entry, len(batch.entries))
+	copy(entries, batch.entries)
+	for i := range entries[:len(entries)-1] {
+		entries[i].batched = true
+	}
+	return db.write(entries)
+}
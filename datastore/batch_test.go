@@ -0,0 +1,207 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestDb_WriteBatch(t *testing.T) {
+	tempDir := t.TempDir()
+
+	database, err := Open(tempDir, WithSegmentSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	batch := NewWriteBatch()
+	for i := 0; i < 20; i++ {
+		batch.Put(fmt.Sprintf("key_%d", i), fmt.Sprintf("value_%d", i))
+	}
+	batch.Put("key_0", "overwritten")
+
+	if err := database.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < 20; i++ {
+		value, err := database.Get(fmt.Sprintf("key_%d", i))
+		if err != nil {
+			t.Fatalf("Failed to get key_%d: %v", i, err)
+		}
+		if value != fmt.Sprintf("value_%d", i) {
+			t.Errorf("Unexpected value for key_%d: %s", i, value)
+		}
+	}
+
+	value, err := database.Get("key_0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "overwritten" {
+		t.Errorf("Expected last write in batch to win, got %s", value)
+	}
+
+	t.Run("batch lands in a single segment", func(t *testing.T) {
+		big := NewWriteBatch()
+		for i := 0; i < 40; i++ {
+			big.Put(fmt.Sprintf("big_%d", i), "value")
+		}
+		if err := database.Write(big); err != nil {
+			t.Fatal(err)
+		}
+
+		location, err := database.getKeyPosition("big_0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < 40; i++ {
+			other, err := database.getKeyPosition(fmt.Sprintf("big_%d", i))
+			if err != nil || other.segment != location.segment {
+				t.Fatalf("Batch entries were split across segments")
+			}
+		}
+	})
+
+	t.Run("empty batch is a no-op", func(t *testing.T) {
+		if err := database.Write(NewWriteBatch()); err != nil {
+			t.Errorf("Unexpected error for empty batch: %v", err)
+		}
+	})
+}
+
+func TestDb_WriteBatchCutShort(t *testing.T) {
+	cuts := map[string]func(first, last int64) int64{
+		"between records":         func(first, last int64) int64 { return last },
+		"inside the last record":  func(first, last int64) int64 { return last + 3 },
+		"inside the first record": func(first, last int64) int64 { return first + 3 },
+	}
+	for name, cut := range cuts {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			database, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := database.Put("a", "1"); err != nil {
+				t.Fatal(err)
+			}
+			batch := NewWriteBatch()
+			batch.Put("a", "2")
+			batch.Put("b", "2")
+			batch.Put("c", "2")
+			if err := database.Write(batch); err != nil {
+				t.Fatal(err)
+			}
+			first, err := database.getKeyPosition("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			last, err := database.getKeyPosition("c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := database.getCurrentSegment().path
+			if err := database.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(path, cut(first.position, last.position)); err != nil {
+				t.Fatal(err)
+			}
+
+			database, err = Open(dir)
+			if err != nil {
+				t.Fatalf("Expected a batch cut short not to prevent opening, got %v", err)
+			}
+			assertValue(t, database, "a", "1")
+			if _, err := database.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected the whole batch to be dropped, got %v", err)
+			}
+			// A write after recovery must not complete what is left of
+			// the batch.
+			if err := database.Put("d", "4"); err != nil {
+				t.Fatal(err)
+			}
+			if err := database.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			database, err = Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer database.Close()
+			assertValue(t, database, "a", "1")
+			assertValue(t, database, "d", "4")
+			if _, err := database.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected the batch to stay dropped, got %v", err)
+			}
+		})
+	}
+}
+
+// countingAppendFile counts the writes and syncs reaching the active file.
+type countingAppendFile struct {
+	appendFile
+	writes, syncs int
+}
+
+func (f *countingAppendFile) Write(data []byte) (int, error) {
+	f.writes++
+	return f.appendFile.Write(data)
+}
+
+func (f *countingAppendFile) Sync() error {
+	f.syncs++
+	return f.appendFile.Sync()
+}
+
+func TestDb_GroupCommit(t *testing.T) {
+	database, err := Open(t.TempDir(), WithSyncPolicy(SyncAlways))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	operation := func(entries ...entry) WriteOperation {
+		return WriteOperation{entries: entries, response: make(chan error, 1)}
+	}
+	batch := []WriteOperation{
+		operation(entry{key: "a", value: []byte("1")}),
+		operation(entry{key: string(make([]byte, database.maxKeySize+1)), value: []byte("x")}),
+		operation(entry{key: "b", value: []byte("2")}, entry{key: "a", value: []byte("3")}),
+		{prepare: func() ([]entry, error) {
+			value, _, _, _, err := database.readCurrent("a")
+			return []entry{{key: "c", value: value}}, err
+		}, response: make(chan error, 1)},
+		operation(entry{key: "d", value: []byte("4")}),
+	}
+
+	database.fileLock.Lock()
+	file := &countingAppendFile{appendFile: database.activeFile}
+	database.activeFile = file
+	database.applyWrites(batch)
+	database.fileLock.Unlock()
+
+	for i, operation := range batch {
+		err := <-operation.response
+		if i == 1 {
+			if !errors.Is(err, ErrKeyTooLarge) {
+				t.Errorf("Expected ErrKeyTooLarge for the oversized key, got %v", err)
+			}
+		} else if err != nil {
+			t.Errorf("Operation %d failed: %v", i, err)
+		}
+	}
+	if file.writes != 3 || file.syncs != 3 {
+		t.Errorf("Expected 3 writes and syncs around the prepare step, got %d and %d", file.writes, file.syncs)
+	}
+
+	for key, want := range map[string]string{"a": "3", "b": "2", "c": "3", "d": "4"} {
+		if value, err := database.Get(key); err != nil || value != want {
+			t.Errorf("Expected %s for %s, got %q, %v", want, key, value, err)
+		}
+	}
+}
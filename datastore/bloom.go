@@ -0,0 +1,85 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// Bloom filters of sealed segments use about ten bits and seven probes per
+// key, which keeps false positives around one percent.
+const (
+	bloomBitsPerKey = 10
+	bloomProbes     = 7
+)
+
+// bloomFilter answers whether a segment may hold a key. A negative answer is
+// always right, so lookups of missing keys skip most sealed segments without
+// touching their index.
+type bloomFilter struct {
+	bits   []uint64
+	probes int
+}
+
+func newBloomFilter(keys int) *bloomFilter {
+	words := max((keys*bloomBitsPerKey+63)/64, 1)
+	return &bloomFilter{bits: make([]uint64, words), probes: bloomProbes}
+}
+
+// locations derives the probed bits from two halves of one hash.
+func (f *bloomFilter) locations(key string, visit func(bit uint64)) {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	sum := hash.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+
+	size := uint64(len(f.bits)) * 64
+	for i := 0; i < f.probes; i++ {
+		visit((h1 + uint64(i)*h2) % size)
+	}
+}
+
+func (f *bloomFilter) add(key string) {
+	f.locations(key, func(bit uint64) {
+		f.bits[bit/64] |= 1 << (bit % 64)
+	})
+}
+
+func (f *bloomFilter) mayContain(key string) bool {
+	present := true
+	f.locations(key, func(bit uint64) {
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			present = false
+		}
+	})
+	return present
+}
+
+func (f *bloomFilter) appendTo(buffer []byte) []byte {
+	buffer = binary.AppendUvarint(buffer, uint64(f.probes))
+	buffer = binary.AppendUvarint(buffer, uint64(len(f.bits)))
+	for _, word := range f.bits {
+		buffer = binary.LittleEndian.AppendUint64(buffer, word)
+	}
+	return buffer
+}
+
+// decodeBloomFilter reads a filter written by appendTo and returns the data
+// that follows it.
+func decodeBloomFilter(data []byte) (*bloomFilter, []byte, bool) {
+	probes, n := binary.Uvarint(data)
+	if n <= 0 || probes == 0 || probes > 64 {
+		return nil, nil, false
+	}
+	data = data[n:]
+	words, n := binary.Uvarint(data)
+	if n <= 0 || words == 0 || words > uint64(len(data)-n)/8 {
+		return nil, nil, false
+	}
+	data = data[n:]
+
+	f := &bloomFilter{bits: make([]uint64, words), probes: int(probes)}
+	for i := range f.bits {
+		f.bits[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return f, data[words*8:], true
+}
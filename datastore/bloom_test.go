@@ -0,0 +1,86 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	filter := newBloomFilter(1000)
+	for i := 0; i < 1000; i++ {
+		filter.add(fmt.Sprintf("key%d", i))
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !filter.mayContain(fmt.Sprintf("key%d", i)) {
+			t.Fatalf("Expected key%d to be reported as present", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.mayContain(fmt.Sprintf("missing%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("Expected about 1%% false positives, got %d of 10000", falsePositives)
+	}
+
+	decoded, rest, ok := decodeBloomFilter(append(filter.appendTo(nil), 0xAA))
+	if !ok || len(rest) != 1 || rest[0] != 0xAA {
+		t.Fatalf("Expected the filter to decode and leave the trailing byte, got ok=%v rest=%v", ok, rest)
+	}
+	for i := 0; i < 1000; i++ {
+		if !decoded.mayContain(fmt.Sprintf("key%d", i)) {
+			t.Fatalf("Expected decoded filter to contain key%d", i)
+		}
+	}
+
+	if _, _, ok := decodeBloomFilter(filter.appendTo(nil)[:10]); ok {
+		t.Error("Expected a truncated filter to be rejected")
+	}
+}
+
+func TestDb_SealedSegmentFilters(t *testing.T) {
+	dir := t.TempDir()
+	database, err := Open(dir, WithSegmentSize(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := database.Put(key, key+"-value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filters := func(database *Db) (sealed, active *bloomFilter) {
+		database.segmentLock.RLock()
+		defer database.segmentLock.RUnlock()
+		return database.segments[0].filter.Load(), database.segments[len(database.segments)-1].filter.Load()
+	}
+	sealed, active := filters(database)
+	if sealed == nil || active != nil {
+		t.Fatalf("Expected a filter for the sealed segment only, got %v and %v", sealed, active)
+	}
+	if !sealed.mayContain("a") || !sealed.mayContain("b") {
+		t.Error("Expected the filter to hold the keys of the sealed segment")
+	}
+	if _, err := database.Get("missing"); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	database.Close()
+
+	reopened, err := Open(dir, WithSegmentSize(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	loaded, _ := filters(reopened)
+	if loaded == nil || fmt.Sprint(loaded.bits) != fmt.Sprint(sealed.bits) {
+		t.Error("Expected the filter to be loaded from the hint")
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		assertValue(t, reopened, key, key+"-value")
+	}
+}
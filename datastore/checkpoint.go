@@ -0,0 +1,229 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"path/filepath"
+)
+
+// An index checkpoint holds the index of every segment at one point of the
+// log: the segment that was active and the offset its records had reached,
+// the log sequence number. Recovery restores the indexes from the newest
+// checkpoint and only replays records written after it. A checkpoint is used
+// only while the segments it lists are still the oldest ones on disk, so any
+// compaction since it was taken makes recovery fall back to hints and
+// replay.
+const (
+	checkpointFileName = "index.checkpoint"
+	checkpointMagic    = "\x89KVI"
+	checkpointVersion  = 1
+)
+
+type checkpoint struct {
+	segments []checkpointSegment
+	// lsn is the offset in the last listed segment up to which the indexes
+	// are complete.
+	lsn int64
+}
+
+type checkpointSegment struct {
+	id       segmentID
+	index    keyIndex
+	expiries map[string]int64
+}
+
+func (db *Db) checkpointPath() string {
+	return filepath.Join(db.directory, checkpointFileName)
+}
+
+// Checkpoint writes the current index to the checkpoint file, so that the
+// next Open only replays records written after this call.
+func (db *Db) Checkpoint() error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.closeMutex.RUnlock()
+	return db.writeCheckpoint()
+}
+
+func (db *Db) writeCheckpoint() error {
+	data := db.encodeCheckpoint()
+
+	path := db.checkpointPath()
+	_ = db.fs.Remove(path)
+	file, err := db.fs.OpenAppend(path, db.fileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		_ = db.fs.Remove(path)
+		return err
+	}
+	if db.syncPolicy != SyncNever {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			_ = db.fs.Remove(path)
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// encodeCheckpoint blocks writes and compaction swaps while it copies the
+// indexes, so that they all describe the same point of the log.
+func (db *Db) encodeCheckpoint() []byte {
+	db.fileLock.Lock()
+	defer db.fileLock.Unlock()
+	db.segmentLock.RLock()
+	defer db.segmentLock.RUnlock()
+
+	buffer := make([]byte, segmentHeaderSize)
+	copy(buffer, checkpointMagic)
+	binary.LittleEndian.PutUint16(buffer[len(checkpointMagic):], checkpointVersion)
+
+	buffer = binary.AppendUvarint(buffer, uint64(len(db.segments)))
+	for _, segment := range db.segments {
+		buffer = binary.AppendUvarint(buffer, uint64(segment.id.number))
+		buffer = binary.AppendUvarint(buffer, uint64(segment.id.generation))
+		buffer = appendIndex(buffer, segment)
+	}
+	buffer = binary.AppendUvarint(buffer, uint64(db.currentOffset))
+
+	return binary.LittleEndian.AppendUint32(buffer, crc32.Checksum(buffer, crcTable))
+}
+
+// readCheckpoint returns nil if there is no checkpoint or it is damaged.
+func (db *Db) readCheckpoint() *checkpoint {
+	file, err := db.fs.Open(db.checkpointPath())
+	if err != nil {
+		return nil
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil || len(data) < segmentHeaderSize+crc32.Size {
+		return nil
+	}
+
+	body, checksum := data[:len(data)-crc32.Size], data[len(data)-crc32.Size:]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(checksum) ||
+		!bytes.HasPrefix(body, []byte(checkpointMagic)) ||
+		binary.LittleEndian.Uint16(body[len(checkpointMagic):]) != checkpointVersion {
+		return nil
+	}
+	body = body[segmentHeaderSize:]
+
+	count, n := binary.Uvarint(body)
+	if n <= 0 || count == 0 || count > uint64(len(body)) {
+		return nil
+	}
+	body = body[n:]
+
+	c := &checkpoint{segments: make([]checkpointSegment, 0, count)}
+	for ; count > 0; count-- {
+		var saved checkpointSegment
+		number, n := binary.Uvarint(body)
+		if n <= 0 || number > math.MaxInt32 {
+			return nil
+		}
+		body = body[n:]
+		generation, n := binary.Uvarint(body)
+		if n <= 0 || generation > math.MaxInt32 {
+			return nil
+		}
+		body = body[n:]
+		saved.id = segmentID{number: int(number), generation: int(generation)}
+
+		var ok bool
+		saved.index, saved.expiries, body, ok = decodeIndex(body, math.MaxInt64)
+		if !ok {
+			return nil
+		}
+		c.segments = append(c.segments, saved)
+	}
+
+	lsn, n := binary.Uvarint(body)
+	if n <= 0 || n != len(body) || lsn > math.MaxInt64 {
+		return nil
+	}
+	c.lsn = int64(lsn)
+	for _, position := range c.segments[len(c.segments)-1].index {
+		if position >= c.lsn {
+			return nil
+		}
+	}
+	return c
+}
+
+// usable reports whether the checkpoint still describes the oldest segments
+// on disk and the last of them holds at least the checkpointed records.
+func (c *checkpoint) usable(segments []*Segment) bool {
+	if len(c.segments) > len(segments) {
+		return false
+	}
+	for i, saved := range c.segments {
+		if segments[i].id != saved.id {
+			return false
+		}
+	}
+
+	last := segments[len(c.segments)-1]
+	file, err := last.fs.Open(last.path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	_, err = file.ReadAt(make([]byte, 1), c.lsn-1)
+	return err == nil
+}
+
+// recoverFromCheckpoint restores the index of the i-th segment. Only the last
+// checkpointed segment can have records after the checkpoint; they are
+// replayed from the log sequence number.
+func (db *Db) recoverFromCheckpoint(c *checkpoint, i int, segment *Segment) error {
+	file, err := db.fs.Open(segment.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	version, _, err := readSegmentHeader(file)
+	if err != nil {
+		return fmt.Errorf("segment %s: %w", segment.path, err)
+	}
+	segment.version = version
+	segment.restoreIndex(c.segments[i].index, c.segments[i].expiries)
+
+	if i < len(c.segments)-1 {
+		return nil
+	}
+	return db.processRecovery(io.NewSectionReader(file, c.lsn, 1<<62), segment, c.lsn)
+}
+
+func (db *Db) startCheckpointer() {
+	if db.checkpointInterval <= 0 {
+		return
+	}
+
+	db.checkpointWG.Add(1)
+	go func() {
+		defer db.checkpointWG.Done()
+		ticks, stop := db.clock.NewTicker(db.checkpointInterval)
+		defer stop()
+
+		for {
+			select {
+			case <-db.done:
+				return
+			case <-ticks:
+				if err := db.writeCheckpoint(); err != nil {
+					fmt.Printf("Warning: index checkpoint failed: %v\n", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,167 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDb_Checkpoint(t *testing.T) {
+	put := func(t *testing.T, database *Db, keys ...string) {
+		t.Helper()
+		for _, key := range keys {
+			if err := database.Put(key, key+"-value"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	flipValue := func(t *testing.T, path, value string) {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset := -1
+		for i := 0; i+len(value) <= len(data); i++ {
+			if string(data[i:i+len(value)]) == value {
+				offset = i
+			}
+		}
+		if offset < 0 {
+			t.Fatalf("%q not found in %s", value, path)
+		}
+		data[offset] ^= 0xff
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("recovery replays only records after the checkpoint", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		put(t, database, "a", "b")
+		if err := database.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+		put(t, database, "c")
+		database.Close()
+
+		// Replay would drop the damaged record; only the checkpoint still
+		// points at it. c is written after the checkpoint and replayed.
+		segment := filepath.Join(dir, dataFileName+"0")
+		flipValue(t, segment, "a-value")
+
+		reopened, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		if _, err := reopened.Get("a"); !errors.Is(err, ErrCorruptedData) {
+			t.Errorf("Expected the checkpointed record to be read, got %v", err)
+		}
+		assertValue(t, reopened, "b", "b-value")
+		assertValue(t, reopened, "c", "c-value")
+	})
+
+	t.Run("segments started after the checkpoint are replayed", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir, WithSegmentSize(60))
+		if err != nil {
+			t.Fatal(err)
+		}
+		put(t, database, "a", "b")
+		if err := database.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+		put(t, database, "c", "d")
+		if err := database.Put("a", "updated"); err != nil {
+			t.Fatal(err)
+		}
+		database.Close()
+
+		reopened, err := Open(dir, WithSegmentSize(60))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		assertValue(t, reopened, "a", "updated")
+		for _, key := range []string{"b", "c", "d"} {
+			assertValue(t, reopened, key, key+"-value")
+		}
+	})
+
+	t.Run("checkpoint taken before compaction is ignored", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir, WithSegmentSize(40))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			put(t, database, fmt.Sprintf("k%d", i))
+		}
+		if err := database.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+		database.compactOldSegments()
+		if err := database.Put("k0", "updated"); err != nil {
+			t.Fatal(err)
+		}
+		database.Close()
+
+		reopened, err := Open(dir, WithSegmentSize(40))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		assertValue(t, reopened, "k0", "updated")
+		for i := 1; i < 5; i++ {
+			assertValue(t, reopened, fmt.Sprintf("k%d", i), fmt.Sprintf("k%d-value", i))
+		}
+	})
+
+	t.Run("damaged checkpoint is ignored", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		put(t, database, "a", "b")
+		if err := database.Checkpoint(); err != nil {
+			t.Fatal(err)
+		}
+		database.Close()
+		flipValue(t, filepath.Join(dir, checkpointFileName), "a")
+
+		reopened, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		assertValue(t, reopened, "a", "a-value")
+		assertValue(t, reopened, "b", "b-value")
+	})
+
+	t.Run("close writes a checkpoint when enabled", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir, WithCheckpointInterval(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		put(t, database, "a")
+		database.Close()
+
+		if _, err := os.Stat(filepath.Join(dir, checkpointFileName)); err != nil {
+			t.Errorf("Expected a checkpoint after Close: %v", err)
+		}
+		if err := database.Checkpoint(); !errors.Is(err, ErrDBClosed) {
+			t.Errorf("Expected ErrDBClosed, got %v", err)
+		}
+	})
+}
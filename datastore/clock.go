@@ -0,0 +1,26 @@
+package datastore
+
+import "time"
+
+// Clock is the time source of a database. Expirations, the timestamps of
+// CompactionStatus and RewriteStatus and the periodic background work all
+// follow it, so that a test can move time forward with WithClock instead
+// of sleeping. Compaction throttling and slow operation tracking measure
+// real time spent on I/O and keep using the system clock.
+type Clock interface {
+	Now() time.Time
+	// NewTicker delivers the time every d on the returned channel, dropping
+	// ticks a slow receiver misses, until the returned function is called.
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
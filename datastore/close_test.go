@@ -0,0 +1,225 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDb_Close(t *testing.T) {
+	t.Run("operations after close", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Close(); err != nil {
+			t.Errorf("Second Close returned %v", err)
+		}
+
+		checks := map[string]error{}
+		_, checks["Get"] = database.Get("key")
+		_, checks["GetInt64"] = database.GetInt64("key")
+		_, checks["Has"] = database.Has("key")
+		_, checks["GetMany"] = database.GetMany([]string{"key"})
+		_, checks["Increment"] = database.Increment("n", 1)
+		checks["Put"] = database.Put("key", "value")
+		checks["Append"] = database.Append("key", "value")
+		for name, err := range checks {
+			if !errors.Is(err, ErrDBClosed) {
+				t.Errorf("%s after Close returned %v, expected ErrDBClosed", name, err)
+			}
+		}
+	})
+
+	t.Run("drains in-flight writes and compaction", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir, WithSegmentSize(120))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		acknowledged := map[string]bool{}
+		started := make(chan struct{})
+		var startOnce sync.Once
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 25; i++ {
+					key := fmt.Sprintf("w%d-%d", w, i)
+					err := database.Put(key, "value")
+					if errors.Is(err, ErrDBClosed) {
+						return
+					}
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					mu.Lock()
+					acknowledged[key] = true
+					if len(acknowledged) == 10 {
+						startOnce.Do(func() { close(started) })
+					}
+					mu.Unlock()
+				}
+			}(w)
+		}
+
+		<-started
+		if err := database.Close(); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+
+		reopened, err := Open(dir, WithSegmentSize(120))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		for key := range acknowledged {
+			if _, err := reopened.Get(key); err != nil {
+				t.Errorf("Acknowledged write %s lost: %v", key, err)
+			}
+		}
+	})
+
+	t.Run("operations racing with close", func(t *testing.T) {
+		baseline := runtime.NumGoroutine()
+		database, err := Open(t.TempDir(), WithSegmentSize(200), WithCompactionInterval(time.Millisecond), WithExpirySweepInterval(time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		failures := make(chan error, 64)
+		check := func(name string, err error) {
+			if err != nil && !errors.Is(err, ErrDBClosed) && !errors.Is(err, ErrKeyNotFound) {
+				select {
+				case failures <- fmt.Errorf("%s: %w", name, err):
+				default:
+				}
+			}
+		}
+		operations := []func(i int){
+			func(i int) { check("Put", database.Put(fmt.Sprintf("key%d", i%50), fmt.Sprint(i))) },
+			func(i int) { check("PutAsync", <-database.PutAsync(fmt.Sprintf("key%d", i%50), fmt.Sprint(i))) },
+			func(i int) { _, err := database.Get(fmt.Sprintf("key%d", i%50)); check("Get", err) },
+			func(i int) { _, err := database.GetMany([]string{"key1", "key2"}); check("GetMany", err) },
+			func(i int) { _, err := database.Has("key3"); check("Has", err) },
+			func(i int) { _, err := database.Increment("counter", 1); check("Increment", err) },
+			func(i int) { check("Compact", database.Compact()) },
+			func(i int) { check("RewriteAll", database.RewriteAll()) },
+			func(i int) { _, err := database.Vacuum(); check("Vacuum", err) },
+			func(i int) { _, err := database.Stats(); check("Stats", err) },
+		}
+		for w, operation := range operations {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := w; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					operation(i)
+				}
+			}()
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		closed := make(chan error, 1)
+		go func() { closed <- database.Close() }()
+		select {
+		case err := <-closed:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("Close did not return while operations raced with it")
+		}
+		if err := database.Put("key", "value"); !errors.Is(err, ErrDBClosed) {
+			t.Errorf("Expected ErrDBClosed after Close, got %v", err)
+		}
+		close(stop)
+		wg.Wait()
+		close(failures)
+		for err := range failures {
+			t.Error(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > baseline {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				t.Fatalf("Expected no goroutines left, got %d over %d:\n%s", runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("new operations fail while close waits", func(t *testing.T) {
+		entered := make(chan struct{})
+		release := make(chan struct{})
+		database, err := Open(t.TempDir(), WithAuthorizer(func(access Access) bool {
+			if access.Key == "slow" {
+				close(entered)
+				<-release
+			}
+			return true
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Put("other", "value"); err != nil {
+			t.Fatal(err)
+		}
+
+		read := make(chan error, 1)
+		go func() {
+			_, err := database.Get("slow")
+			read <- err
+		}()
+		<-entered
+		closed := make(chan error, 1)
+		go func() { closed <- database.Close() }()
+
+		// Once Close waits for the read, new calls give up without waiting.
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, err := database.Get("other")
+			if errors.Is(err, ErrDBClosed) {
+				break
+			}
+			if err != nil || time.Now().After(deadline) {
+				t.Fatalf("Expected ErrDBClosed while Close waits, got %v", err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		select {
+		case <-closed:
+			t.Fatal("Expected Close to wait for the read in progress")
+		default:
+		}
+
+		close(release)
+		if err := <-read; !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected the read in progress to complete, got %v", err)
+		}
+		if err := <-closed; err != nil {
+			t.Fatal(err)
+		}
+	})
+}
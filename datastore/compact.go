@@ -0,0 +1,183 @@
+package datastore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CompactionStatus reports the compactions since the database was opened.
+// ReclaimedBytes adds up how much smaller the compacted segments are than
+// the segments they replaced.
+type CompactionStatus struct {
+	Running        bool
+	Paused         bool
+	Compactions    int64
+	ReclaimedBytes int64
+	LastCompleted  time.Time
+}
+
+type compactionStats struct {
+	mu     sync.Mutex
+	status CompactionStatus
+}
+
+func (s *compactionStats) begin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Running = true
+}
+
+func (s *compactionStats) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Running = false
+}
+
+func (s *compactionStats) completed(reclaimed int64, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Compactions++
+	s.status.ReclaimedBytes += reclaimed
+	s.status.LastCompleted = now
+}
+
+// CompactionStatus reports whether a compaction is running and what the
+// finished ones achieved.
+func (db *Db) CompactionStatus() CompactionStatus {
+	db.compactionStats.mu.Lock()
+	status := db.compactionStats.status
+	db.compactionStats.mu.Unlock()
+	status.Paused = db.compactionGate.paused()
+	return status
+}
+
+// compactionGate holds compactions back while it is paused.
+type compactionGate struct {
+	mu sync.Mutex
+	// resumed is closed on resume, and nil while not paused.
+	resumed chan struct{}
+}
+
+func (g *compactionGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+func (g *compactionGate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+func (g *compactionGate) paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed != nil
+}
+
+// wait blocks while the gate is paused, until it is resumed or done is
+// closed, and reports whether it blocked.
+func (g *compactionGate) wait(done <-chan struct{}) bool {
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+	if resumed == nil {
+		return false
+	}
+	select {
+	case <-resumed:
+	case <-done:
+	}
+	return true
+}
+
+// PauseCompaction stops compaction between two records until
+// ResumeCompaction, for instance to keep the disk to foreground reads and
+// writes during a load peak. Compactions triggered meanwhile wait before
+// they start. Segments keep piling up while compaction is paused.
+func (db *Db) PauseCompaction() {
+	db.compactionGate.pause()
+}
+
+// ResumeCompaction lets a paused compaction continue.
+func (db *Db) ResumeCompaction() {
+	db.compactionGate.resume()
+}
+
+// Compact seals the active segment and merges it with all other segments
+// into one, without waiting for enough segments to pile up. It returns once
+// the merged segment has replaced them, after any compaction already
+// running, and waits while compaction is paused.
+func (db *Db) Compact() error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+
+	db.fileLock.Lock()
+	var err error
+	if db.getCurrentSegment().dataBytes.Load() > 0 {
+		err = db.initializeNewSegment()
+	}
+	db.fileLock.Unlock()
+	if err != nil {
+		db.closeMutex.RUnlock()
+		return err
+	}
+	// Close waits for the compaction like for background ones rather than
+	// for the read lock, so that it gets to end a pause the compaction is
+	// waiting in.
+	db.compactionWG.Add(1)
+	db.closeMutex.RUnlock()
+	defer db.compactionWG.Done()
+	return db.compactSegments(1)
+}
+
+// startCompactionScheduler compacts the sealed segments with the period set
+// with WithCompactionInterval.
+func (db *Db) startCompactionScheduler() {
+	if db.compactionInterval <= 0 {
+		return
+	}
+
+	db.scheduleWG.Add(1)
+	go func() {
+		defer db.scheduleWG.Done()
+		ticks, stop := db.clock.NewTicker(db.compactionInterval)
+		defer stop()
+
+		for {
+			select {
+			case <-db.done:
+				return
+			case <-ticks:
+				if !db.worthScheduledCompaction() {
+					continue
+				}
+				db.pendingCompactions.Add(1)
+				err := db.compactSegments(1)
+				db.pendingCompactions.Add(-1)
+				if err != nil {
+					fmt.Printf("Warning: scheduled compaction failed: %v\n", err)
+				}
+			}
+		}
+	}()
+}
+
+// worthScheduledCompaction reports whether compacting the sealed segments
+// could reclaim anything: a lone compacted segment holds no replaced
+// records, while a lone written one may.
+func (db *Db) worthScheduledCompaction() bool {
+	db.segmentLock.RLock()
+	defer db.segmentLock.RUnlock()
+
+	sealed := db.segments[:max(len(db.segments)-1, 0)]
+	return len(sealed) > 1 || len(sealed) == 1 && sealed[0].id.generation == 0
+}
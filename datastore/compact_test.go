@@ -0,0 +1,206 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+func TestDb_Compact(t *testing.T) {
+	dir := t.TempDir()
+	database, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := database.Put("key", fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	database.Close()
+
+	// The active segment continued after reopening is compacted as well.
+	database, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	if status := database.CompactionStatus(); status.Compactions != 0 || status.Running {
+		t.Errorf("Expected no compactions yet, got %+v", status)
+	}
+
+	before := time.Now()
+	if err := database.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	status := database.CompactionStatus()
+	if status.Running || status.Compactions != 1 || status.LastCompleted.Before(before) {
+		t.Errorf("Expected one finished compaction, got %+v", status)
+	}
+	record := entry{key: "key", value: []byte("value0")}
+	if status.ReclaimedBytes != 9*record.GetLength() {
+		t.Errorf("Expected the 9 replaced records to be reclaimed, got %d bytes", status.ReclaimedBytes)
+	}
+	if files := segmentFiles(t, dir); len(files) != 2 {
+		t.Errorf("Expected the compacted and a new active segment, got %v", files)
+	}
+	assertValue(t, database, "key", "value9")
+
+	if err := database.Compact(); err != nil {
+		t.Errorf("Expected compacting a compacted database to succeed, got %v", err)
+	}
+}
+
+func TestDb_CompactionTriggers(t *testing.T) {
+	segmentCount := func(database *Db) int {
+		database.segmentLock.RLock()
+		defer database.segmentLock.RUnlock()
+		return len(database.segments)
+	}
+
+	t.Run("segment count threshold", func(t *testing.T) {
+		database, err := Open(t.TempDir(), WithSegmentSize(40), WithCompactionSegments(6))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		for i := 0; segmentCount(database) < 5; i++ {
+			if err := database.Put("key", fmt.Sprint(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		database.compactionWG.Wait()
+		if compactions := database.CompactionStatus().Compactions; compactions != 0 {
+			t.Errorf("Expected no compaction below 6 segments, got %d", compactions)
+		}
+		// The compaction may shrink the segment list before it is counted.
+		for i := 0; segmentCount(database) < 6 && database.CompactionStatus().Compactions == 0; i++ {
+			if err := database.Put("key", fmt.Sprint(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		database.compactionWG.Wait()
+		if compactions := database.CompactionStatus().Compactions; compactions != 1 {
+			t.Errorf("Expected a compaction at 6 segments, got %d", compactions)
+		}
+	})
+
+	t.Run("segment count disabled", func(t *testing.T) {
+		database, err := Open(t.TempDir(), WithSegmentSize(40), WithCompactionSegments(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		for i := 0; segmentCount(database) < 2*defaultCompactionSegments; i++ {
+			if err := database.Put("key", fmt.Sprint(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		database.compactionWG.Wait()
+		if compactions := database.CompactionStatus().Compactions; compactions != 0 {
+			t.Errorf("Expected no compaction, got %d", compactions)
+		}
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		clock := testutil.NewClock(time.Now())
+		database, err := Open(t.TempDir(), WithSegmentSize(40), WithCompactionSegments(0), WithCompactionInterval(time.Minute), WithClock(clock))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		last := ""
+		for i := 0; segmentCount(database) < 4; i++ {
+			last = fmt.Sprint(i)
+			if err := database.Put("key", last); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if compactions := database.CompactionStatus().Compactions; compactions != 0 {
+			t.Fatalf("Expected no compaction before the interval, got %d", compactions)
+		}
+		clock.Advance(time.Minute)
+		deadline := time.Now().Add(5 * time.Second)
+		for segmentCount(database) > 2 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected the scheduler to compact, got %d segments", segmentCount(database))
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		compactions := database.CompactionStatus().Compactions
+
+		// The lone compacted segment has nothing left to reclaim.
+		clock.Advance(time.Minute)
+		time.Sleep(50 * time.Millisecond)
+		if again := database.CompactionStatus().Compactions; again != compactions {
+			t.Errorf("Expected no compaction of a compacted segment, got %d after %d", again, compactions)
+		}
+		assertValue(t, database, "key", last)
+	})
+
+	t.Run("invalid segment count", func(t *testing.T) {
+		if _, err := Open(t.TempDir(), WithCompactionSegments(1)); err == nil {
+			t.Error("Expected a compaction segment count of 1 to be rejected")
+		}
+	})
+}
+
+func TestDb_PauseCompaction(t *testing.T) {
+	database, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := database.Put("key", fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	database.PauseCompaction()
+	if !database.CompactionStatus().Paused {
+		t.Error("Expected the status to report the pause")
+	}
+	compacted := make(chan error, 1)
+	go func() { compacted <- database.Compact() }()
+	select {
+	case err := <-compacted:
+		t.Fatalf("Expected Compact to wait while paused, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	database.ResumeCompaction()
+	if err := <-compacted; err != nil {
+		t.Fatal(err)
+	}
+	if status := database.CompactionStatus(); status.Paused || status.Compactions != 1 {
+		t.Errorf("Expected one compaction after resuming, got %+v", status)
+	}
+	assertValue(t, database, "key", "value9")
+
+	// Close ends a pause rather than waiting for it.
+	database.PauseCompaction()
+	if err := database.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	go func() { compacted <- database.Compact() }()
+	time.Sleep(10 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() { closed <- database.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Close to end the pause")
+	}
+	if err := <-compacted; !errors.Is(err, ErrDBClosed) {
+		t.Errorf("Expected the paused compaction to fail with ErrDBClosed, got %v", err)
+	}
+}
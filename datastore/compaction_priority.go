@@ -0,0 +1,92 @@
+package datastore
+
+import (
+	"runtime"
+	"time"
+)
+
+const (
+	compactionPaceRecords = 64
+	compactionPause       = time.Millisecond
+	// minThrottleSleep keeps the rate limit from sleeping after every record
+	// when records are small compared to the rate.
+	minThrottleSleep = 10 * time.Millisecond
+)
+
+// compactionPacer yields to foreground work every few records. With only
+// one or two Ps a Gosched rarely lets a blocked reader in, so the pacer
+// sleeps briefly instead.
+//
+// It also holds the copy back to rate bytes per second, if set, and stops
+// while the gate is paused.
+type compactionPacer struct {
+	enabled bool
+	records int
+	pause   time.Duration
+
+	rate    int64
+	gate    *compactionGate
+	done    <-chan struct{}
+	started time.Time
+	written int64
+}
+
+func newCompactionPacer(enabled bool) *compactionPacer {
+	pacer := &compactionPacer{enabled: enabled}
+	if runtime.GOMAXPROCS(0) <= 2 {
+		pacer.pause = compactionPause
+	}
+	return pacer
+}
+
+// newCompactionPacer returns the pacer for a compaction with the priority,
+// rate limit and pause gate of the database.
+func (db *Db) newCompactionPacer() *compactionPacer {
+	pacer := newCompactionPacer(db.lowPriorityCompaction)
+	pacer.rate = db.compactionRate
+	pacer.gate = &db.compactionGate
+	pacer.done = db.done
+	return pacer
+}
+
+func (p *compactionPacer) step() {
+	if !p.enabled {
+		return
+	}
+	p.records++
+	if p.records%compactionPaceRecords != 0 {
+		return
+	}
+	if p.pause > 0 {
+		time.Sleep(p.pause)
+	} else {
+		runtime.Gosched()
+	}
+}
+
+// copied accounts for n bytes written by the compaction. It waits while
+// compaction is paused, and sleeps for as long as the copy is ahead of the
+// rate limit, unless the database is closed. Time spent paused does not
+// count towards the rate.
+func (p *compactionPacer) copied(n int) {
+	if p.gate != nil && p.gate.wait(p.done) {
+		p.started, p.written = time.Time{}, 0
+	}
+	if p.rate <= 0 {
+		return
+	}
+	if p.started.IsZero() {
+		p.started = time.Now()
+	}
+	p.written += int64(n)
+
+	due := time.Duration(float64(p.written) / float64(p.rate) * float64(time.Second))
+	if ahead := due - time.Since(p.started); ahead >= minThrottleSleep {
+		timer := time.NewTimer(ahead)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-p.done:
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package datastore
+
+import (
+	"runtime"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassIdle  = 3
+	compactionNice   = 10
+)
+
+// lowerCompactionPriority moves the calling goroutine's thread to the idle
+// I/O class and a higher nice value. On Linux both apply per thread, so the
+// goroutine is locked to its thread until the returned restore is called.
+// Unprivileged processes may not be allowed to lower the nice value again;
+// the thread then stays locked so that it exits with the goroutine instead
+// of running other goroutines at the reduced priority.
+func lowerCompactionPriority() (restore func()) {
+	runtime.LockOSThread()
+	tid := syscall.Gettid()
+
+	previousIO, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0)
+	ioLowered := errno == 0 &&
+		setIOPriority(tid, ioprioClassIdle<<ioprioClassShift) == nil
+
+	previousNice, err := syscall.Getpriority(syscall.PRIO_PROCESS, tid)
+	niceLowered := err == nil &&
+		syscall.Setpriority(syscall.PRIO_PROCESS, tid, compactionNice) == nil
+
+	return func() {
+		restored := true
+		if ioLowered && setIOPriority(tid, previousIO) != nil {
+			restored = false
+		}
+		// The raw getpriority syscall returns 20 - nice.
+		if niceLowered && syscall.Setpriority(syscall.PRIO_PROCESS, tid, 20-previousNice) != nil {
+			restored = false
+		}
+		if restored {
+			runtime.UnlockOSThread()
+		}
+	}
+}
+
+func setIOPriority(tid int, priority uintptr) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), priority)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package datastore
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestLowerCompactionPriority(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		restore := lowerCompactionPriority()
+		tid := syscall.Gettid()
+		priority, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0)
+		if errno != 0 {
+			t.Logf("ioprio_get unsupported: %v", errno)
+		} else if class := priority >> ioprioClassShift; class != ioprioClassIdle {
+			t.Errorf("Expected idle I/O class, got %d", class)
+		}
+		restore()
+	}()
+	<-done
+}
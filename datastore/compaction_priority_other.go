@@ -0,0 +1,7 @@
+//go:build !linux
+
+package datastore
+
+func lowerCompactionPriority() (restore func()) {
+	return func() {}
+}
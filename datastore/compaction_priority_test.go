@@ -0,0 +1,81 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDb_LowPriorityCompaction(t *testing.T) {
+	database, err := Open(t.TempDir(), WithSegmentSize(150), WithLowPriorityCompaction())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	for i := 0; i < 20; i++ {
+		if err := database.Put(fmt.Sprintf("key%d", i%5), fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	database.compactOldSegments()
+
+	for i := 15; i < 20; i++ {
+		assertValue(t, database, fmt.Sprintf("key%d", i%5), fmt.Sprintf("value%d", i))
+	}
+}
+
+func TestCompactionPacer(t *testing.T) {
+	pacer := newCompactionPacer(false)
+	pacer.step()
+	if pacer.records != 0 {
+		t.Error("Disabled pacer should not count records")
+	}
+
+	pacer = newCompactionPacer(true)
+	for i := 0; i < compactionPaceRecords*2; i++ {
+		pacer.step()
+	}
+	if pacer.records != compactionPaceRecords*2 {
+		t.Errorf("Expected %d records, got %d", compactionPaceRecords*2, pacer.records)
+	}
+}
+
+func TestCompactionPacer_RateLimit(t *testing.T) {
+	pacer := newCompactionPacer(false)
+	pacer.rate = 100000
+
+	started := time.Now()
+	for i := 0; i < 6; i++ {
+		pacer.copied(1000)
+	}
+	// 6000 bytes at 100000 bytes per second take 60ms, less the last
+	// sleep skipped for being shorter than minThrottleSleep.
+	if elapsed := time.Since(started); elapsed < 60*time.Millisecond-minThrottleSleep {
+		t.Errorf("Expected the rate limit to slow the copy down, took %s", elapsed)
+	}
+}
+
+func TestCompactionPacer_Pause(t *testing.T) {
+	pacer := newCompactionPacer(false)
+	pacer.gate = &compactionGate{}
+	pacer.gate.pause()
+
+	copied := make(chan struct{})
+	go func() {
+		pacer.copied(1)
+		close(copied)
+	}()
+	select {
+	case <-copied:
+		t.Fatal("Expected the pacer to wait while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	pacer.gate.resume()
+	select {
+	case <-copied:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the pacer to continue once resumed")
+	}
+}
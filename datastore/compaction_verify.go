@@ -0,0 +1,82 @@
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// verifyCompaction reads the compacted segment back from storage and checks
+// it against the sealed segments it is about to replace. Every record has to
+// pass its checksum and sit where the compacted index says, and every key
+// has to hold the value and expiry of its newest version in sealed, unless
+// that version expired before now, in which case the key has to be gone.
+func (db *Db) verifyCompaction(sealed []*Segment, compacted *Segment, now int64) error {
+	written := newSegment(db.fs, compacted.id, compacted.path)
+	if err := db.recoverSegmentData(written); err != nil && err != io.EOF {
+		return err
+	}
+	if written.index.len() != compacted.index.len() {
+		return fmt.Errorf("%w: compacted segment holds %d readable records, expected %d",
+			ErrCorruptedData, written.index.len(), compacted.index.len())
+	}
+
+	seen := make(map[string]bool)
+	live := 0
+	var err error
+	for i := len(sealed) - 1; i >= 0 && err == nil; i-- {
+		segment := sealed[i]
+		segment.index.forEach(func(key string, position, expiresAt int64) bool {
+			if seen[key] {
+				return true
+			}
+			seen[key] = true
+
+			if expiresAt != 0 && expiresAt <= now {
+				if _, _, kept := written.index.get(key); kept {
+					err = fmt.Errorf("%w: compacted segment kept expired key %q", ErrCorruptedData, key)
+				}
+				return err == nil
+			}
+			live++
+
+			err = db.verifyCompactedKey(segment, position, expiresAt, written, compacted, key)
+			return err == nil
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if live != written.index.len() {
+		return fmt.Errorf("%w: compacted segment holds %d keys, expected %d",
+			ErrCorruptedData, written.index.len(), live)
+	}
+	return nil
+}
+
+func (db *Db) verifyCompactedKey(source *Segment, sourcePosition, expiresAt int64, written, compacted *Segment, key string) error {
+	position, writtenExpiry, found := written.index.get(key)
+	if !found {
+		return fmt.Errorf("%w: compacted segment lost key %q", ErrCorruptedData, key)
+	}
+	if indexed, _, _ := compacted.index.get(key); indexed != position {
+		return fmt.Errorf("%w: key %q indexed at %d but written at %d", ErrCorruptedData, key, indexed, position)
+	}
+	if writtenExpiry != expiresAt {
+		return fmt.Errorf("%w: key %q expires at %d, expected %d", ErrCorruptedData, key, writtenExpiry, expiresAt)
+	}
+
+	want, wantType, err := db.readRecord(source, key, sourcePosition, nil)
+	if err != nil {
+		return fmt.Errorf("key %q in %s: %w", key, source.path, err)
+	}
+	got, gotType, err := written.readTypedFromSegment(key, position)
+	if err != nil {
+		return fmt.Errorf("key %q in compacted segment: %w", key, err)
+	}
+	if gotType != wantType || !bytes.Equal(got, want) {
+		return fmt.Errorf("%w: key %q does not hold its newest value", ErrCorruptedData, key)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package datastore
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+func TestDb_CompactionVerification(t *testing.T) {
+	open := func(t *testing.T) *Db {
+		t.Helper()
+		dir := testutil.NewDir(t)
+		dir.Segment(testutil.Put("a", "1"), testutil.Put("b", "1"))
+		dir.Segment(testutil.Put("a", "2"), testutil.PutWithExpiry("c", "1", time.Unix(1, 0)))
+		dir.Segment(testutil.Put("d", "1"))
+
+		database, err := Open(dir.Path, WithCompactionVerification())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { database.Close() })
+		return database
+	}
+
+	compacted := func(t *testing.T, database *Db, records ...testutil.Record) *Segment {
+		t.Helper()
+		data := testutil.SegmentHeader(segmentFormatVersion)
+		for _, r := range records {
+			data = append(data, r.Encode()...)
+		}
+		path := filepath.Join(t.TempDir(), "compacted")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		segment := newSegment(database.fs, segmentID{number: 1, generation: 1}, path)
+		if err := database.recoverSegmentData(segment); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		return segment
+	}
+
+	verify := func(database *Db, segment *Segment) error {
+		database.segmentLock.RLock()
+		sealed := database.segments[:len(database.segments)-1]
+		database.segmentLock.RUnlock()
+		return database.verifyCompaction(sealed, segment, time.Now().UnixNano())
+	}
+
+	t.Run("correct compaction is swapped in", func(t *testing.T) {
+		database := open(t)
+		database.compactOldSegments()
+
+		database.segmentLock.RLock()
+		segments := len(database.segments)
+		generation := database.segments[0].id.generation
+		database.segmentLock.RUnlock()
+		if segments != 2 || generation != 1 {
+			t.Fatalf("Expected the compacted segment to replace the sealed ones, got %d segments", segments)
+		}
+		assertValue(t, database, "a", "2")
+		assertValue(t, database, "b", "1")
+		assertValue(t, database, "d", "1")
+	})
+
+	t.Run("matching segment passes", func(t *testing.T) {
+		database := open(t)
+		segment := compacted(t, database, testutil.Put("a", "2"), testutil.Put("b", "1"))
+		if err := verify(database, segment); err != nil {
+			t.Errorf("Expected verification to pass, got %v", err)
+		}
+	})
+
+	cases := map[string][]testutil.Record{
+		"stale value":  {testutil.Put("a", "1"), testutil.Put("b", "1")},
+		"missing key":  {testutil.Put("a", "2")},
+		"expired key":  {testutil.Put("a", "2"), testutil.Put("b", "1"), testutil.PutWithExpiry("c", "1", time.Unix(1, 0))},
+		"wrong expiry": {testutil.Put("a", "2"), testutil.PutWithExpiry("b", "1", time.Now().Add(time.Hour))},
+	}
+	for name, records := range cases {
+		t.Run(name, func(t *testing.T) {
+			database := open(t)
+			if err := verify(database, compacted(t, database, records...)); !errors.Is(err, ErrCorruptedData) {
+				t.Errorf("Expected ErrCorruptedData, got %v", err)
+			}
+		})
+	}
+
+	t.Run("damaged record", func(t *testing.T) {
+		database := open(t)
+		segment := compacted(t, database, testutil.Put("a", "2"), testutil.Put("b", "1"))
+		data, err := os.ReadFile(segment.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[len(data)-1] ^= 0xff
+		if err := os.WriteFile(segment.path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := verify(database, segment); !errors.Is(err, ErrCorruptedData) {
+			t.Errorf("Expected ErrCorruptedData, got %v", err)
+		}
+	})
+
+	t.Run("index pointing elsewhere", func(t *testing.T) {
+		database := open(t)
+		segment := compacted(t, database, testutil.Put("a", "2"), testutil.Put("b", "1"))
+		a, _, _ := segment.index.get("a")
+		b, _, _ := segment.index.get("b")
+		segment.setKey("a", b, 0)
+		segment.setKey("b", a, 0)
+		if err := verify(database, segment); !errors.Is(err, ErrCorruptedData) {
+			t.Errorf("Expected ErrCorruptedData, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// GetContext is like GetBytes but fails with ctx.Err() once ctx is done.
+func (db *Db) GetContext(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	op := db.startOp(opGet, key)
+	defer op.finish()
+
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.closeMutex.RUnlock()
+
+	location, err := db.getKeyPositionContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	op.phase("index")
+
+	return db.readLocation(key, location, op)
+}
+
+// PutContext is like PutBytes but stops waiting once ctx is done. A write
+// that has already been handed to the writer may still be applied after
+// PutContext returns ctx.Err().
+func (db *Db) PutContext(ctx context.Context, key string, value []byte) error {
+	return db.writeContext(ctx, []entry{{key: key, value: bytes.Clone(value)}})
+}
+
+// PutWithTTLContext is PutWithTTL stopping to wait once ctx is done, like
+// PutContext.
+func (db *Db) PutWithTTLContext(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid ttl: %s", ttl)
+	}
+	return db.writeContext(ctx, []entry{{
+		key:       key,
+		value:     bytes.Clone(value),
+		expiresAt: db.clock.Now().Add(ttl).UnixNano(),
+	}})
+}
+
+func (db *Db) writeContext(ctx context.Context, entries []entry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for i := range entries {
+		if err := db.authorize(ctx, AccessWrite, entries[i].key); err != nil {
+			return err
+		}
+	}
+
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.closeMutex.RUnlock()
+
+	return db.submitOperationContext(ctx, WriteOperation{entries: entries})
+}
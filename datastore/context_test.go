@@ -0,0 +1,85 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDb_GetPutContext(t *testing.T) {
+	database, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	ctx := context.Background()
+	if err := database.PutContext(ctx, "key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	value, err := database.GetContext(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "value" {
+		t.Errorf("Expected 'value', got %q", value)
+	}
+
+	if _, err := database.GetContext(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Error("Expected error for missing key")
+	}
+
+	t.Run("cancelled context", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := database.PutContext(cancelled, "key", []byte("other")); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		if _, err := database.GetContext(cancelled, "key"); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("put stuck behind writer", func(t *testing.T) {
+		database.fileLock.Lock()
+		defer database.fileLock.Unlock()
+
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		if err := database.PutContext(timeout, "blocked", []byte("v")); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+}
+
+func TestDb_WriteQueueShedding(t *testing.T) {
+	database, err := Open(t.TempDir(), WithWriteQueueDepth(1), WithWriteQueueShedding())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	database.fileLock.Lock()
+	first := database.PutAsync("first", "v")
+	for database.WriteQueueDepth() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	queued := database.PutAsync("queued", "v")
+	if depth := database.WriteQueueDepth(); depth != 1 {
+		t.Errorf("Expected a queue depth of 1, got %d", depth)
+	}
+	if err := database.Put("shed", "v"); !errors.Is(err, ErrWriteQueueFull) {
+		t.Errorf("Expected ErrWriteQueueFull, got %v", err)
+	}
+	database.fileLock.Unlock()
+
+	for _, result := range []<-chan error{first, queued} {
+		if err := <-result; err != nil {
+			t.Errorf("Expected queued writes to succeed, got %v", err)
+		}
+	}
+	if _, err := database.Get("shed"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the shed write to be dropped, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package datastore
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Increment adds delta to the numeric value of key and returns the result.
+// Missing keys start from zero and keep no expiration; existing keys keep
+// theirs. String values holding a decimal integer are accepted and
+// rewritten as int64 records.
+func (db *Db) Increment(key string, delta int64) (int64, error) {
+	var result int64
+	err := db.readModifyWrite(key, func() ([]entry, error) {
+		value, valueType, expiresAt, found, err := db.readCurrent(key)
+		if err != nil {
+			return nil, err
+		}
+
+		var current int64
+		if found {
+			current, err = numericValue(key, value, valueType)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+			return nil, fmt.Errorf("counter overflow for key '%s'", key)
+		}
+		result = current + delta
+
+		record := newInt64Entry(key, result)
+		record.expiresAt = expiresAt
+		return []entry{record}, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+func (db *Db) Decrement(key string, delta int64) (int64, error) {
+	if delta == math.MinInt64 {
+		return 0, fmt.Errorf("counter overflow for key '%s'", key)
+	}
+	return db.Increment(key, -delta)
+}
+
+func numericValue(key string, value []byte, valueType valueType) (int64, error) {
+	if valueType == valueTypeInt64 {
+		return decodeInt64(key, value, valueType)
+	}
+	number, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: key '%s' holds a non-numeric %s value", ErrTypeMismatch, key, valueType)
+	}
+	return number, nil
+}
@@ -0,0 +1,94 @@
+package datastore
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestDb_Increment(t *testing.T) {
+	database, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	value, err := database.Increment("hits", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 5 {
+		t.Errorf("Expected 5, got %d", value)
+	}
+
+	value, err = database.Decrement("hits", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 3 {
+		t.Errorf("Expected 3, got %d", value)
+	}
+
+	t.Run("concurrent increments are not lost", func(t *testing.T) {
+		const workers = 8
+		const perWorker = 50
+
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < perWorker; j++ {
+					if _, err := database.Increment("concurrent", 1); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		total, err := database.GetInt64("concurrent")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != workers*perWorker {
+			t.Errorf("Expected %d, got %d", workers*perWorker, total)
+		}
+	})
+
+	t.Run("numeric strings are upgraded", func(t *testing.T) {
+		if err := database.Put("legacy", "41"); err != nil {
+			t.Fatal(err)
+		}
+		value, err := database.Increment("legacy", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != 42 {
+			t.Errorf("Expected 42, got %d", value)
+		}
+		if _, err := database.GetInt64("legacy"); err != nil {
+			t.Errorf("Expected value to be stored as int64, got %v", err)
+		}
+	})
+
+	t.Run("non-numeric values are rejected", func(t *testing.T) {
+		if err := database.Put("name", "osb"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.Increment("name", 1); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("Expected ErrTypeMismatch, got %v", err)
+		}
+	})
+
+	t.Run("overflow is rejected", func(t *testing.T) {
+		if err := database.PutInt64("max", math.MaxInt64); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.Increment("max", 1); err == nil {
+			t.Error("Expected overflow error")
+		}
+	})
+}
@@ -2,33 +2,42 @@ package datastore
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
-	dataFileName    = "current-data"
-	bufferSize      = 8192
-	defaultFileMode = 0644
-	minSegments     = 3
+	dataFileName = "current-data"
+	// maxWriteBatch is the most queued write operations the writer appends
+	// with a single write.
+	maxWriteBatch = 100
 )
 
-type keyIndex map[string]int64
-
-type IndexOperation struct {
-	isWrite  bool
-	key      string
-	position int64
-	response chan *KeyLocation
-}
-
 type WriteOperation struct {
-	data     entry
+	entries  []entry
+	prepare  func() ([]entry, error)
 	response chan error
+	op       *opTimer
+	// async operations have no caller waiting to finish op.
+	async bool
+	// rewrites store values again unchanged, so watchers are not told.
+	rewrite bool
+}
+
+func (operation WriteOperation) reply(err error) {
+	operation.op.phase("write")
+	if operation.async {
+		operation.op.finish()
+	}
+	operation.response <- err
 }
 
 type KeyLocation struct {
@@ -37,73 +46,304 @@ type KeyLocation struct {
 }
 
 type Db struct {
-	activeFile      *os.File
-	activeFilePath  string
-	currentOffset   int64
-	directory       string
-	maxSegmentSize  int64
-	segmentCounter  int
-	indexOperations chan IndexOperation
-	writeOperations chan WriteOperation
-	segments        []*Segment
-	fileLock        sync.Mutex
-	segmentLock     sync.RWMutex
-	closed          bool
-	closeMutex      sync.Mutex
-	indexWG         sync.WaitGroup
-	writeWG         sync.WaitGroup
+	activeFile            appendFile
+	fs                    storage
+	handles               *fileHandles
+	dirLock               io.Closer
+	activeFilePath        string
+	currentOffset         int64
+	directory             string
+	maxSegmentSize        int64
+	maxKeySize            int
+	maxValueSize          int
+	bufferSize            int
+	fileMode              os.FileMode
+	syncPolicy            SyncPolicy
+	syncInterval          time.Duration
+	unsynced              bool
+	sweepInterval         time.Duration
+	checkpointInterval    time.Duration
+	lowPriorityCompaction bool
+	compactionRate        int64
+	compactionGate        compactionGate
+	verifyCompactions     bool
+	slowOpThreshold       time.Duration
+	slowOps               slowOpCounters
+	cache                 *valueCache
+	compressValues        bool
+	dictionary            *dictionary
+	mmapSegments          bool
+	segmentCounter        int
+	writeOperations       chan WriteOperation
+	shedWrites            bool
+	garbageRatio          float64
+	compactionSegments    int
+	compactionInterval    time.Duration
+	authorizer            Authorizer
+	clock                 Clock
+	garbageCompactions    atomic.Int64
+	pendingCompactions    atomic.Int32
+	segments              []*Segment
+	fileLock              sync.Mutex
+	segmentLock           sync.RWMutex
+	closed                bool
+	closeMutex            sync.RWMutex
+	writeWG               sync.WaitGroup
+	sweepWG               sync.WaitGroup
+	compactionWG          sync.WaitGroup
+	scheduleWG            sync.WaitGroup
+	compactionMu          sync.Mutex
+	compactionStats       compactionStats
+	rewrite               rewriteState
+	watchers              watchers
+	snapshots             snapshotSet
+	syncWG                sync.WaitGroup
+	checkpointWG          sync.WaitGroup
+	done                  chan struct{}
 }
 
 type Segment struct {
 	startOffset int64
-	keyIndex    keyIndex
-	path        string
-	mu          sync.RWMutex
+	index       *shardedIndex
+	id          segmentID
+	// version is the record format of the segment, read from its header.
+	version int
+	// filter is set once the segment is sealed and takes no more keys.
+	filter atomic.Pointer[bloomFilter]
+	// dict decompresses the values of the segment; it is nil if they are
+	// stored as they are.
+	dict *dictionary
+	// mapping is set once a sealed segment is mapped into memory.
+	mapping atomic.Pointer[segmentMapping]
+	// dataBytes counts the bytes of all records in the segment and
+	// deadBytes those of records replaced since, as far as tracked.
+	dataBytes atomic.Int64
+	deadBytes atomic.Int64
+	// retired is set once compaction has swapped the segment out; its file
+	// is removed right after.
+	retired atomic.Bool
+	// created is when the database started the segment, or for segments
+	// found on Open, when their file was last written.
+	created time.Time
+	// reindexing is set while the index is rebuilt from the file after a
+	// read found it pointing at the record of another key.
+	reindexing atomic.Bool
+	// end bounds the records of a frozen copy of the active segment; it is
+	// 0 for segments read up to the end of their file.
+	end int64
+	// pins counts the snapshots that read the segment. The files of a
+	// retired segment are only removed by cleanup once none does.
+	pinMu   sync.Mutex
+	pins    int
+	cleanup func()
+	path    string
+	fs      storage
 }
 
-func CreateDb(directory string, maxSegmentSize int64) (*Db, error) {
-	if err := os.MkdirAll(directory, defaultFileMode); err != nil {
-		return nil, err
+func newSegment(fs storage, id segmentID, path string) *Segment {
+	return &Segment{
+		id:      id,
+		version: segmentFormatVersion,
+		path:    path,
+		fs:      fs,
+		index:   newShardedIndex(),
 	}
+}
 
-	database := &Db{
-		segments:        make([]*Segment, 0),
-		directory:       directory,
-		maxSegmentSize:  maxSegmentSize,
-		indexOperations: make(chan IndexOperation, 100),
-		writeOperations: make(chan WriteOperation, 100),
+// setKey records the newest position of key.
+func (segment *Segment) setKey(key string, position, expiresAt int64) {
+	segment.index.set(key, position, expiresAt)
+}
+
+// seal builds the bloom filter of a segment that takes no more writes,
+// unless one was loaded with its hint.
+func (segment *Segment) seal() {
+	if segment.filter.Load() != nil {
+		return
 	}
+	filter := newBloomFilter(segment.index.len())
+	segment.index.forEach(func(key string, _, _ int64) bool {
+		filter.add(key)
+		return true
+	})
+	segment.filter.CompareAndSwap(nil, filter)
+}
 
-	files, err := os.ReadDir(directory)
-	if err != nil {
+// lookup reports where key is stored in this segment and whether that
+// version has already expired.
+func (segment *Segment) lookup(key string, now int64) (position int64, found, expired bool) {
+	if filter := segment.filter.Load(); filter != nil && !filter.mayContain(key) {
+		return 0, false, false
+	}
+	position, expiresAt, found := segment.index.get(key)
+	if !found {
+		return 0, false, false
+	}
+	return position, true, expiresAt != 0 && expiresAt <= now
+}
+
+func CreateDb(directory string, maxSegmentSize int64) (*Db, error) {
+	return Open(directory, WithSegmentSize(maxSegmentSize))
+}
+
+func Open(directory string, opts ...Option) (*Db, error) {
+	config := defaultOptions()
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if err := config.validate(); err != nil {
 		return nil, err
 	}
-	for _, file := range files {
-		if file.IsDir() || !file.Type().IsRegular() || !filepath.HasPrefix(file.Name(), dataFileName) {
-			continue
+
+	var fs storage
+	var handles *fileHandles
+	if config.inMemory {
+		fs = newMemStorage()
+	} else {
+		if err := checkFileLimit(config.maxOpenSegments); err != nil {
+			return nil, err
 		}
-		path := filepath.Join(directory, file.Name())
-		segment := &Segment{
-			path:     path,
-			keyIndex: make(keyIndex),
+		handles = newFileHandles(diskStorage{}, config.maxOpenSegments)
+		fs = handles
+	}
+	if err := fs.MkdirAll(directory, config.dirMode); err != nil {
+		return nil, err
+	}
+
+	var lock io.Closer
+	if !config.inMemory {
+		var err error
+		if lock, err = lockDirectory(directory, config.fileMode); err != nil {
+			return nil, err
 		}
-		database.segments = append(database.segments, segment)
 	}
 
-	if err := database.recoverAllSegments(); err != nil && err != io.EOF {
-		return nil, err
+	database := &Db{
+		fs:                    fs,
+		handles:               handles,
+		dirLock:               lock,
+		segments:              make([]*Segment, 0),
+		directory:             directory,
+		maxSegmentSize:        config.maxSegmentSize,
+		maxKeySize:            config.maxKeySize,
+		maxValueSize:          config.maxValueSize,
+		bufferSize:            config.bufferSize,
+		fileMode:              config.fileMode,
+		syncPolicy:            config.syncPolicy,
+		syncInterval:          config.syncInterval,
+		sweepInterval:         config.sweepInterval,
+		checkpointInterval:    config.checkpointInterval,
+		lowPriorityCompaction: config.lowPriorityCompaction,
+		compactionRate:        config.compactionRate,
+		verifyCompactions:     config.verifyCompaction,
+		slowOpThreshold:       config.slowOpThreshold,
+		cache:                 newValueCache(config.valueCacheSize),
+		compressValues:        config.dictionaryCompression,
+		mmapSegments:          config.mmapReads && !config.inMemory,
+		done:                  make(chan struct{}),
+		writeOperations:       make(chan WriteOperation, config.writeQueueDepth),
+		shedWrites:            config.shedWrites,
+		garbageRatio:          config.garbageRatio,
+		compactionSegments:    config.compactionSegments,
+		compactionInterval:    config.compactionInterval,
+		authorizer:            config.authorizer,
+		clock:                 config.clock,
 	}
 
-	if err := database.initializeNewSegment(); err != nil {
+	if err := database.loadSegments(); err != nil {
+		database.releaseFiles()
 		return nil, err
 	}
+	if database.garbageRatio > 0 {
+		if err := database.measureGarbage(); err != nil {
+			database.releaseFiles()
+			return nil, err
+		}
+	}
 
-	database.startIndexHandler()
 	database.startWriteHandler()
+	database.startExpirySweeper()
+	database.startSyncer()
+	database.startCheckpointer()
+	database.startCompactionScheduler()
 
 	return database, nil
 }
 
+func (db *Db) loadSegments() error {
+	ids, err := listSegments(db.fs, db.directory)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		segment := newSegment(db.fs, id, db.segmentPath(id))
+		if _, modTime, err := db.fs.Stat(segment.path); err == nil {
+			segment.created = modTime
+		}
+		if err := db.loadDictionary(segment); err != nil {
+			return err
+		}
+		if segment.dict != nil {
+			db.dictionary = segment.dict
+		}
+		db.segments = append(db.segments, segment)
+		db.segmentCounter = id.number + 1
+	}
+
+	if err := db.recoverAllSegments(); err != nil && err != io.EOF {
+		return err
+	}
+	for _, segment := range db.segments[:max(len(db.segments)-1, 0)] {
+		segment.seal()
+		db.mapSegment(segment)
+		db.measureData(segment)
+	}
+	return db.openActiveSegment()
+}
+
+// measureData sets the record bytes of a sealed segment from the size of
+// its file.
+func (db *Db) measureData(segment *Segment) {
+	size, _, err := db.fs.Stat(segment.path)
+	if err != nil {
+		return
+	}
+	segment.dataBytes.Store(size - segment.dataStart())
+}
+
+// dataStart returns the offset of the first record of segment.
+func (segment *Segment) dataStart() int64 {
+	if segment.version == formatFixed {
+		return 0
+	}
+	return segmentHeaderSize
+}
+
+// releaseFiles closes every file the database holds and gives up the
+// directory lock.
+func (db *Db) releaseFiles() error {
+	for _, segment := range db.segments {
+		segment.unmap()
+	}
+	var err error
+	if db.handles != nil {
+		err = db.handles.closeAll()
+	}
+	if db.activeFile != nil {
+		err = errors.Join(err, db.activeFile.Close())
+	}
+	if db.dirLock != nil {
+		err = errors.Join(err, db.dirLock.Close())
+	}
+	return err
+}
+
+// Close waits for the reads and writes in progress, and for every write
+// already queued, to complete, and makes operations started from then on
+// fail with ErrDBClosed right away. A running compaction is abandoned at
+// the next record and its partial output removed, so that the segments it
+// was merging stay as they were; Close waits for it, and for RewriteAll and
+// Vacuum, to stop before it releases the files.
 func (db *Db) Close() error {
 	db.closeMutex.Lock()
 	defer db.closeMutex.Unlock()
@@ -113,174 +353,577 @@ func (db *Db) Close() error {
 	}
 
 	db.closed = true
-	close(db.indexOperations)
+	close(db.done)
 	close(db.writeOperations)
 
-	db.indexWG.Wait()
+	// The writer may start a compaction while draining the queue, so it has
+	// to finish before waiting for compactions.
 	db.writeWG.Wait()
-
+	db.sweepWG.Wait()
+	db.syncWG.Wait()
+	db.checkpointWG.Wait()
+	db.scheduleWG.Wait()
+	db.compactionWG.Wait()
+	db.watchers.closeAll()
+	db.snapshots.releaseAll()
+
+	var syncErr error
 	if db.activeFile != nil {
-		return db.activeFile.Close()
+		syncErr = db.syncActiveFile()
 	}
-	return nil
+	if syncErr == nil && db.checkpointInterval > 0 {
+		if err := db.writeCheckpoint(); err != nil {
+			fmt.Printf("Warning: index checkpoint failed: %v\n", err)
+		}
+	}
+	if err := db.releaseFiles(); syncErr == nil {
+		return err
+	}
+	return syncErr
 }
 
-func (db *Db) startIndexHandler() {
-	db.indexWG.Add(1)
-	go func() {
-		defer db.indexWG.Done()
-		for operation := range db.indexOperations {
-			if operation.isWrite {
-				db.updateIndex(operation.key, operation.position)
-			} else {
-				segment, pos, err := db.findKeyLocation(operation.key)
-				if err != nil {
-					operation.response <- nil
-				} else {
-					operation.response <- &KeyLocation{segment, pos}
-				}
-			}
-		}
-	}()
+// enter holds Close off while an operation runs. Once Close has started
+// it fails with ErrDBClosed rather than waiting for Close to finish. Callers
+// release it with db.closeMutex.RUnlock.
+func (db *Db) enter() error {
+	// Close is the only writer of closeMutex, so TryRLock only fails while
+	// Close waits for the lock or holds it.
+	if !db.closeMutex.TryRLock() {
+		return ErrDBClosed
+	}
+	if db.closed {
+		db.closeMutex.RUnlock()
+		return ErrDBClosed
+	}
+	return nil
 }
 
 func (db *Db) startWriteHandler() {
 	db.writeWG.Add(1)
 	go func() {
 		defer db.writeWG.Done()
+		batch := make([]WriteOperation, 0, maxWriteBatch)
 		for operation := range db.writeOperations {
+			batch = append(batch[:0], operation)
+			batch = db.collectQueuedWrites(batch)
+
 			db.fileLock.Lock()
+			db.applyWrites(batch)
+			db.compactGarbage()
+			db.fileLock.Unlock()
+		}
+	}()
+}
 
-			entrySize := operation.data.GetLength()
-			fileInfo, err := db.activeFile.Stat()
-			if err != nil {
-				operation.response <- err
-				db.fileLock.Unlock()
-				continue
+// collectQueuedWrites adds the operations already waiting in the queue to
+// batch without blocking.
+func (db *Db) collectQueuedWrites(batch []WriteOperation) []WriteOperation {
+	for len(batch) < maxWriteBatch {
+		select {
+		case operation, ok := <-db.writeOperations:
+			if !ok {
+				return batch
 			}
+			batch = append(batch, operation)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
 
-			if fileInfo.Size()+entrySize > db.maxSegmentSize {
-				if err := db.initializeNewSegment(); err != nil {
-					operation.response <- err
-					db.fileLock.Unlock()
-					continue
-				}
+// applyWrites appends the records of consecutive operations with a single
+// write, and a single sync under SyncAlways, before replying to all of them.
+// Operations with a prepare step see the state left by the ones before them,
+// so the group is written out before they run.
+func (db *Db) applyWrites(batch []WriteOperation) {
+	var group []WriteOperation
+	var entries []entry
+	var size int64
+	flush := func() {
+		if len(group) == 0 {
+			return
+		}
+		err := db.appendEntries(entries)
+		if err == nil {
+			// The records of the group may be compressed by now, but
+			// those of the operations are not.
+			var events []Event
+			for _, operation := range group {
+				events = append(events, db.watchers.match(operation.entries)...)
 			}
+			db.watchers.publish(events)
+		}
+		for _, operation := range group {
+			operation.reply(err)
+		}
+		group, entries, size = group[:0], nil, 0
+	}
 
-			currentPos := db.currentOffset
-			bytesWritten, err := db.activeFile.Write(operation.data.Encode())
-			if err == nil {
-				db.currentOffset += int64(bytesWritten)
-				db.updateIndex(operation.data.key, currentPos)
-			}
+	for _, operation := range batch {
+		operation.op.phase("queue")
+		if operation.prepare != nil {
+			flush()
+			operation.reply(db.applyWrite(operation))
+			continue
+		}
+		if err := db.checkEntries(operation.entries); err != nil {
+			operation.reply(err)
+			continue
+		}
 
-			operation.response <- err
-			db.fileLock.Unlock()
+		// A group never outgrows a segment, so that it can always be
+		// appended as a whole.
+		operationSize := entriesLength(operation.entries)
+		if size+operationSize > db.maxSegmentSize-segmentHeaderSize {
+			flush()
 		}
-	}()
+		group = append(group, operation)
+		entries = append(entries, operation.entries...)
+		size += operationSize
+	}
+	flush()
+}
+
+// applyWrite runs on the writer goroutine. Operations with a prepare step
+// build their records from the current state, which no other write can
+// change until the records are appended.
+func (db *Db) applyWrite(operation WriteOperation) error {
+	entries := operation.entries
+	if operation.prepare != nil {
+		prepared, err := operation.prepare()
+		if err != nil {
+			return err
+		}
+		entries = prepared
+	}
+	if err := db.checkEntries(entries); err != nil {
+		return err
+	}
+	var events []Event
+	if !operation.rewrite {
+		events = db.watchers.match(entries)
+	}
+	if err := db.appendEntries(entries); err != nil {
+		return err
+	}
+	db.watchers.publish(events)
+	return nil
+}
+
+func (db *Db) checkEntries(entries []entry) error {
+	for _, e := range entries {
+		if err := db.checkEntrySize(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func entriesLength(entries []entry) int64 {
+	var length int64
+	for i := range entries {
+		length += entries[i].GetLength()
+	}
+	return length
+}
+
+// checkEntrySize rejects records that exceed the configured limits or would
+// not fit into a segment on their own.
+func (db *Db) checkEntrySize(e entry) error {
+	if len(e.key) > db.maxKeySize {
+		return fmt.Errorf("%w: key of %d bytes exceeds the limit of %d", ErrKeyTooLarge, len(e.key), db.maxKeySize)
+	}
+	if len(e.value) > db.maxValueSize {
+		return fmt.Errorf("%w: value for key '%s' is %d bytes, the limit is %d", ErrValueTooLarge, e.key, len(e.value), db.maxValueSize)
+	}
+	if e.GetLength() > db.maxSegmentSize-segmentHeaderSize {
+		return fmt.Errorf("%w: record for key '%s' is %d bytes and does not fit into a segment of %d", ErrValueTooLarge, e.key, e.GetLength(), db.maxSegmentSize)
+	}
+	return nil
+}
+
+// maxRecordLength is the largest record recovery accepts. It covers
+// everything the size limits allow, and never less than recovery used to
+// accept, so that segments written with other limits stay readable.
+func (db *Db) maxRecordLength() int64 {
+	overhead := int64(3*binary.MaxVarintLen32 + typeTagSize + expirationSize + checksumSize)
+	return max(int64(db.maxKeySize+db.maxValueSize)+overhead, int64(db.bufferSize*10))
+}
+
+func (db *Db) appendEntries(entries []entry) error {
+	totalSize := entriesLength(entries)
+
+	size, err := db.activeFile.Size()
+	if err != nil {
+		return err
+	}
+
+	if size > 0 && size+totalSize > db.maxSegmentSize {
+		if err := db.initializeNewSegment(); err != nil {
+			return err
+		}
+	}
+
+	db.compressEntries(entries)
+	buffer := make([]byte, 0, totalSize)
+	positions := make([]int64, len(entries))
+	for i := range entries {
+		positions[i] = db.currentOffset + int64(len(buffer))
+		buffer = append(buffer, entries[i].Encode()...)
+	}
+
+	bytesWritten, err := db.activeFile.Write(buffer)
+	if bytesWritten > 0 {
+		db.unsynced = true
+	}
+	if err == nil && db.syncPolicy == SyncAlways {
+		err = db.syncActiveFile()
+	}
+	if err != nil {
+		if bytesWritten > 0 {
+			_ = db.activeFile.Truncate(db.currentOffset)
+		}
+		return err
+	}
+
+	db.currentOffset += int64(bytesWritten)
+	db.getCurrentSegment().dataBytes.Add(int64(bytesWritten))
+	for i := range entries {
+		if db.garbageRatio > 0 {
+			db.markReplaced(entries[i].key)
+		}
+		db.updateIndex(entries[i].key, positions[i], entries[i].expiresAt)
+		db.cache.remove(entries[i].key)
+	}
+	return nil
+}
+
+// openActiveSegment continues appending to the newest segment found on
+// disk while it has room, and starts a new one otherwise.
+func (db *Db) openActiveSegment() error {
+	if len(db.segments) == 0 {
+		return db.initializeNewSegment()
+	}
+
+	last := db.segments[len(db.segments)-1]
+	file, err := db.fs.OpenAppend(last.path, db.fileMode)
+	if err != nil {
+		return err
+	}
+	size, err := file.Size()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	// Segments in an older format are left as they are; new records always
+	// go to a segment in the current one.
+	if size >= db.maxSegmentSize || (size > 0 && last.version != segmentFormatVersion) {
+		file.Close()
+		last.seal()
+		_ = db.writeHint(last, size)
+		db.mapSegment(last)
+		return db.initializeNewSegment()
+	}
+	if size == 0 {
+		if size, err = writeSegmentHeader(file); err != nil {
+			file.Close()
+			return err
+		}
+		last.version = segmentFormatVersion
+	}
+
+	db.activeFile = file
+	db.activeFilePath = last.path
+	db.currentOffset = size
+	last.dataBytes.Store(size - segmentHeaderSize)
+	return nil
 }
 
 func (db *Db) initializeNewSegment() error {
-	newFilePath := db.generateFileName()
-	file, err := os.OpenFile(newFilePath, os.O_APPEND|os.O_RDWR|os.O_CREATE, defaultFileMode)
+	id := segmentID{number: db.segmentCounter}
+	db.segmentCounter++
+	newFilePath := db.segmentPath(id)
+	_ = db.fs.Remove(hintPath(newFilePath))
+	_ = db.fs.Remove(dictionaryPath(newFilePath))
+	file, err := db.fs.OpenAppend(newFilePath, db.fileMode)
 	if err != nil {
 		return err
 	}
 
-	segment := &Segment{
-		path:     newFilePath,
-		keyIndex: make(keyIndex),
+	dataStart, err := writeSegmentHeader(file)
+	if err != nil {
+		file.Close()
+		_ = db.fs.Remove(newFilePath)
+		return err
+	}
+
+	segment := newSegment(db.fs, id, newFilePath)
+	segment.created = db.clock.Now()
+	if db.compressValues && db.dictionary != nil {
+		if err := db.writeDictionary(newFilePath, db.dictionary); err != nil {
+			file.Close()
+			_ = db.fs.Remove(newFilePath)
+			return err
+		}
+		segment.dict = db.dictionary
 	}
 
 	if db.activeFile != nil {
+		if db.syncPolicy != SyncNever {
+			if err := db.syncActiveFile(); err != nil {
+				file.Close()
+				return err
+			}
+		}
 		db.activeFile.Close()
+		sealed := db.getCurrentSegment()
+		sealed.seal()
+		_ = db.writeHint(sealed, db.currentOffset)
+		db.mapSegment(sealed)
 	}
 
 	db.activeFile = file
-	db.currentOffset = 0
+	db.currentOffset = dataStart
 	db.activeFilePath = newFilePath
 
 	db.segmentLock.Lock()
 	db.segments = append(db.segments, segment)
+	count := len(db.segments)
 	db.segmentLock.Unlock()
 
-	if len(db.segments) >= minSegments {
-		go db.compactOldSegments()
+	if db.compactionSegments > 0 && count >= db.compactionSegments {
+		db.startCompaction(db.compactionSegments - 1)
 	}
 
 	return nil
 }
 
-func (db *Db) generateFileName() string {
-	fileName := filepath.Join(db.directory, fmt.Sprintf("%s%d", dataFileName, db.segmentCounter))
-	db.segmentCounter++
-	return fileName
+// startCompaction compacts the sealed segments in the background if there
+// are at least minSealed of them by then.
+func (db *Db) startCompaction(minSealed int) {
+	db.pendingCompactions.Add(1)
+	db.compactionWG.Add(1)
+	go func() {
+		defer db.compactionWG.Done()
+		defer db.pendingCompactions.Add(-1)
+		_ = db.compactSegments(minSealed)
+	}()
 }
 
+// compactOldSegments compacts once enough segments have piled up, or as
+// soon as there are two when the segment count does not trigger compaction.
 func (db *Db) compactOldSegments() {
-	db.segmentLock.Lock()
-	defer db.segmentLock.Unlock()
+	_ = db.compactSegments(max(db.compactionSegments-1, 1))
+}
 
-	if len(db.segments) < minSegments {
-		return
+// compactSegments merges all sealed segments into one. The segment list is
+// only locked to take a snapshot and to swap in the result, so reads and
+// writes keep going while records are copied. Reads that looked a key up
+// in a segment before the swap retry once its file is gone.
+func (db *Db) compactSegments(minSealed int) error {
+	db.compactionMu.Lock()
+	defer db.compactionMu.Unlock()
+
+	db.segmentLock.RLock()
+	sealed := append([]*Segment(nil), db.segments[:max(len(db.segments)-1, 0)]...)
+	db.segmentLock.RUnlock()
+
+	if len(sealed) < max(minSealed, 1) || db.isShuttingDown() {
+		return nil
+	}
+	if db.compactionGate.wait(db.done) && db.isShuttingDown() {
+		return ErrDBClosed
+	}
+	db.compactionStats.begin()
+	defer db.compactionStats.end()
+
+	if db.lowPriorityCompaction {
+		defer lowerCompactionPriority()()
 	}
+	pacer := db.newCompactionPacer()
+	op := db.startOp(opCompact, "")
 
-	compactedFilePath := db.generateFileName()
-	compactedFile, err := os.OpenFile(compactedFilePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, defaultFileMode)
+	newest := sealed[len(sealed)-1].id
+	compactedID := segmentID{number: newest.number, generation: newest.generation + 1}
+	compactedFilePath := db.segmentPath(compactedID)
+	compactedFile, err := db.fs.OpenAppend(compactedFilePath, db.fileMode)
 	if err != nil {
-		return
+		return err
 	}
 	defer compactedFile.Close()
 
-	compactedSegment := &Segment{
-		path:     compactedFilePath,
-		keyIndex: make(keyIndex),
+	compactedSegment := newSegment(db.fs, compactedID, compactedFilePath)
+	compactedSegment.created = db.clock.Now()
+	_ = db.fs.Remove(dictionaryPath(compactedFilePath))
+	if db.compressValues {
+		if data := trainDictionary(sampleValues(sealed), maxDictionarySize); data != nil {
+			dict := newDictionary(data)
+			if err := db.writeDictionary(compactedFilePath, dict); err != nil {
+				compactedFile.Close()
+				_ = db.fs.Remove(compactedFilePath)
+				return err
+			}
+			compactedSegment.dict = dict
+		}
 	}
+	op.phase("train")
 
-	var writeOffset int64
+	writeOffset, err := writeSegmentHeader(compactedFile)
+	if err != nil {
+		compactedFile.Close()
+		_ = db.fs.Remove(dictionaryPath(compactedFilePath))
+		_ = db.fs.Remove(compactedFilePath)
+		return err
+	}
+	dataStart := writeOffset
 	keysWritten := make(map[string]bool)
+	now := db.clock.Now().UnixNano()
+
+	for i := len(sealed) - 1; i >= 0; i-- {
+		if db.isShuttingDown() {
+			compactedFile.Close()
+			_ = db.fs.Remove(dictionaryPath(compactedFilePath))
+			_ = db.fs.Remove(compactedFilePath)
+			return ErrDBClosed
+		}
 
-	for i := len(db.segments) - 2; i >= 0; i-- {
-		segment := db.segments[i]
-		segment.mu.RLock()
+		segment := sealed[i]
+		segment.index.forEach(func(key string, position, expiresAt int64) bool {
+			if db.isShuttingDown() {
+				return false
+			}
+			if keysWritten[key] {
+				return true
+			}
 
-		for key, position := range segment.keyIndex {
-			if !keysWritten[key] {
-				value, err := segment.readFromSegmentWithChecksum(position)
-				if err != nil {
-					continue
-				}
+			if expiresAt != 0 && expiresAt <= now {
+				keysWritten[key] = true
+				return true
+			}
 
-				record := entry{
-					key:   key,
-					value: value,
-				}
+			value, valueType, err := db.readRecord(segment, key, position, nil)
+			if err != nil {
+				return true
+			}
 
-				bytesWritten, err := compactedFile.Write(record.Encode())
-				if err == nil {
-					compactedSegment.keyIndex[key] = writeOffset
-					writeOffset += int64(bytesWritten)
-					keysWritten[key] = true
-				}
+			record := entry{
+				key:       key,
+				value:     value,
+				valueType: valueType,
+				expiresAt: expiresAt,
+			}
+			if compactedSegment.dict != nil {
+				record.compress(compactedSegment.dict)
+			}
+
+			bytesWritten, err := compactedFile.Write(record.Encode())
+			if err == nil {
+				compactedSegment.setKey(key, writeOffset, expiresAt)
+				writeOffset += int64(bytesWritten)
+				keysWritten[key] = true
+				pacer.copied(bytesWritten)
 			}
+			pacer.step()
+			return true
+		})
+	}
+	if db.isShuttingDown() {
+		// The copy may have stopped short of the last records.
+		compactedFile.Close()
+		_ = db.fs.Remove(dictionaryPath(compactedFilePath))
+		_ = db.fs.Remove(compactedFilePath)
+		return ErrDBClosed
+	}
+	op.phase("copy")
+
+	if db.syncPolicy != SyncNever {
+		if err := compactedFile.Sync(); err != nil {
+			compactedFile.Close()
+			_ = db.fs.Remove(dictionaryPath(compactedFilePath))
+			_ = db.fs.Remove(compactedFilePath)
+			return err
 		}
-		segment.mu.RUnlock()
+	}
+	op.phase("sync")
+
+	if db.verifyCompactions {
+		if err := db.verifyCompaction(sealed, compactedSegment, now); err != nil {
+			fmt.Printf("Warning: compaction into %s abandoned: %v\n", compactedFilePath, err)
+			compactedFile.Close()
+			_ = db.fs.Remove(dictionaryPath(compactedFilePath))
+			_ = db.fs.Remove(compactedFilePath)
+			return err
+		}
+		op.phase("verify")
 	}
 
-	newSegments := []*Segment{compactedSegment, db.segments[len(db.segments)-1]}
-	for i := 0; i < len(db.segments)-1; i++ {
-		_ = os.Remove(db.segments[i].path)
+	compactedSegment.seal()
+	_ = db.writeHint(compactedSegment, writeOffset)
+	db.mapSegment(compactedSegment)
+	op.phase("seal")
+
+	compactedSegment.dataBytes.Store(writeOffset - dataStart)
+	db.segmentLock.Lock()
+	newer := db.segments[len(sealed):]
+	if db.garbageRatio > 0 {
+		// Keys written since the snapshot replaced their copies already.
+		if live, err := db.liveBytes(compactedSegment, newer); err == nil {
+			compactedSegment.deadBytes.Store(writeOffset - dataStart - live)
+		}
 	}
+	db.segments = append([]*Segment{compactedSegment}, newer...)
+	db.segmentLock.Unlock()
+	if compactedSegment.dict != nil {
+		db.fileLock.Lock()
+		db.dictionary = compactedSegment.dict
+		db.fileLock.Unlock()
+	}
+
+	op.phase("swap")
 
-	db.segments = newSegments
+	reclaimed := -writeOffset
+	for _, segment := range sealed {
+		if _, end, err := db.segmentSpan(segment); err == nil {
+			reclaimed += end
+		}
+		segment.retire(func() {
+			segment.unmap()
+			db.removeSegmentFiles(segment.path)
+		})
+	}
+	op.phase("cleanup")
+	op.finish()
+	db.compactionStats.completed(reclaimed, db.clock.Now())
+	return nil
+}
+
+func (db *Db) isShuttingDown() bool {
+	select {
+	case <-db.done:
+		return true
+	default:
+		return false
+	}
 }
 
 func (db *Db) recoverAllSegments() error {
 	db.segmentLock.RLock()
 	defer db.segmentLock.RUnlock()
 
-	for _, segment := range db.segments {
-		if err := db.recoverSegmentData(segment); err != nil && err != io.EOF {
+	var saved *checkpoint
+	if c := db.readCheckpoint(); c != nil && c.usable(db.segments) {
+		saved = c
+	}
+
+	for i, segment := range db.segments {
+		var err error
+		if saved != nil && i < len(saved.segments) {
+			err = db.recoverFromCheckpoint(saved, i, segment)
+		} else {
+			err = db.recoverSegmentData(segment)
+		}
+		if err != nil && err != io.EOF {
 			return err
 		}
 	}
@@ -288,21 +931,45 @@ func (db *Db) recoverAllSegments() error {
 }
 
 func (db *Db) recoverSegmentData(segment *Segment) error {
-	file, err := os.Open(segment.path)
+	file, err := db.fs.Open(segment.path)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	return db.processRecovery(file, segment)
+	version, dataStart, err := readSegmentHeader(file)
+	if err != nil {
+		return fmt.Errorf("segment %s: %w", segment.path, err)
+	}
+	segment.version = version
+	if db.loadHint(segment, file) {
+		return nil
+	}
+	return db.processRecovery(io.NewSectionReader(file, dataStart, 1<<62), segment, dataStart)
 }
 
-func (db *Db) processRecovery(file *os.File, segment *Segment) error {
+// processRecovery replays the records read from file into the index of
+// segment. currentOffset is where the first of them starts in the segment
+// file, and every record is indexed at its own offset there, header
+// included, so positions match those the writer assigned.
+func (db *Db) processRecovery(file io.Reader, segment *Segment, currentOffset int64) error {
 	var err error
-	var buffer [bufferSize]byte
-	var currentOffset int64
+	var torn int64
+	maxLength := db.maxRecordLength()
 
+	bufferSize := db.bufferSize
+	buffer := make([]byte, bufferSize)
 	tempIndex := make(map[string]int64)
+	tempExpiries := make(map[string]int64)
+	// The records of a batch are held back until its last one is read, so
+	// that a batch a crash cut short, or one with a damaged record, is
+	// dropped as a whole.
+	type heldRecord struct {
+		key                 string
+		position, expiresAt int64
+	}
+	var batch []heldRecord
+	var damaged bool
 
 	reader := bufio.NewReaderSize(file, bufferSize)
 	for err == nil {
@@ -318,125 +985,336 @@ func (db *Db) processRecovery(file *os.File, segment *Segment) error {
 			return err
 		}
 
-		if len(header) < 4 {
+		recordSize, ok := peekRecordSize(header, segment.version)
+		if !ok {
+			// Only the start of a record header made it to disk.
+			torn = int64(len(header))
 			break
 		}
-
-		recordSize := binary.LittleEndian.Uint32(header)
-		if recordSize == 0 || recordSize > uint32(bufferSize*10) {
-			return fmt.Errorf("invalid record size: %d", recordSize)
+		if recordSize <= 0 || recordSize > maxLength {
+			return fmt.Errorf("%w: invalid record size %d", ErrCorruptedData, recordSize)
 		}
 
-		if recordSize < bufferSize {
+		if int(recordSize) < bufferSize {
 			data = buffer[:recordSize]
 		} else {
 			data = make([]byte, recordSize)
 		}
 
-		bytesRead, err = reader.Read(data)
+		bytesRead, err = io.ReadFull(reader, data)
+		if err == io.ErrUnexpectedEOF {
+			// The segment ends inside the record: an append was cut short.
+			torn = int64(bytesRead)
+			break
+		}
 		if err == nil {
-			if bytesRead != int(recordSize) {
-				return fmt.Errorf("data corruption detected: expected %d bytes, got %d", recordSize, bytesRead)
-			}
-
 			var record entry
-			record.Decode(data)
-
-			if checksumErr := record.verifyChecksum(); checksumErr != nil {
+			decodeErr := record.Decode(data, segment.version)
+			checksumErr := decodeErr
+			if checksumErr == nil {
+				checksumErr = record.verifyChecksum()
+			}
+			if checksumErr != nil {
 				fmt.Printf("Warning: corrupted entry found during recovery for key '%s': %v\n", record.key, checksumErr)
-				currentOffset += int64(bytesRead)
-				continue
+				// A damaged record takes the batch it belongs to down
+				// with it. One whose tag cannot be read is taken to
+				// end its batch.
+				damaged = true
+				if decodeErr != nil {
+					record.batched = false
+				}
 			}
 
-			tempIndex[record.key] = currentOffset
+			batch = append(batch, heldRecord{record.key, currentOffset, record.expiresAt})
 			currentOffset += int64(bytesRead)
+			if record.batched {
+				continue
+			}
+			if damaged {
+				if len(batch) > 1 {
+					fmt.Printf("Warning: dropping the %d records of the damaged batch at offset %d of %s\n", len(batch), batch[0].position, segment.path)
+				}
+			} else {
+				for _, held := range batch {
+					tempIndex[held.key] = held.position
+					tempExpiries[held.key] = held.expiresAt
+				}
+			}
+			batch, damaged = batch[:0], false
 		}
 	}
 
-	segment.mu.Lock()
-	for key, position := range tempIndex {
-		segment.keyIndex[key] = position
+	if err != nil && err != io.EOF && torn == 0 {
+		return err
+	}
+	if len(batch) > 0 {
+		// The truncation below makes sure the next record written is
+		// not taken for the end of the batch.
+		fmt.Printf("Warning: batch cut short at offset %d of %s, dropping its %d records\n", batch[0].position, segment.path, len(batch))
+		torn += currentOffset - batch[0].position
+		currentOffset = batch[0].position
+	}
+	db.finishRecovery(segment, tempIndex, tempExpiries, currentOffset)
+	if torn > 0 {
+		return db.truncateTornRecord(segment, currentOffset, torn)
 	}
-	segment.mu.Unlock()
+	return err
+}
 
+func (db *Db) finishRecovery(segment *Segment, index keyIndex, expiries map[string]int64, end int64) {
+	for key, position := range index {
+		segment.setKey(key, position, expiries[key])
+	}
 	if segment == db.getCurrentSegment() {
-		db.currentOffset = currentOffset
+		db.currentOffset = end
 	}
+}
 
-	return err
+// truncateTornRecord cuts off the size bytes of an incomplete record at
+// offset, which a crash in the middle of an append leaves at the end of a
+// segment, so that recovery can go on and new records follow the last
+// complete one.
+func (db *Db) truncateTornRecord(segment *Segment, offset, size int64) error {
+	fmt.Printf("Warning: torn record at offset %d of %s, truncating %d bytes\n", offset, segment.path, size)
+
+	file, err := db.fs.OpenAppend(segment.path, db.fileMode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+	return file.Sync()
 }
 
-func (db *Db) updateIndex(key string, position int64) {
-	currentSegment := db.getCurrentSegment()
-	currentSegment.mu.Lock()
-	currentSegment.keyIndex[key] = position
-	currentSegment.mu.Unlock()
+func (db *Db) updateIndex(key string, position, expiresAt int64) {
+	db.getCurrentSegment().setKey(key, position, expiresAt)
 }
 
+// findKeyLocation looks key up in the segments from newest to oldest on the
+// calling goroutine; only appends go through the write handler.
 func (db *Db) findKeyLocation(key string) (*Segment, int64, error) {
 	db.segmentLock.RLock()
 	defer db.segmentLock.RUnlock()
+	return findIn(db.segments, key, db.clock.Now().UnixNano())
+}
 
-	for i := len(db.segments) - 1; i >= 0; i-- {
-		segment := db.segments[i]
-		segment.mu.RLock()
-		position, found := segment.keyIndex[key]
-		segment.mu.RUnlock()
-
+// findIn looks key up in segments from newest to oldest, as of now.
+func findIn(segments []*Segment, key string, now int64) (*Segment, int64, error) {
+	for i := len(segments) - 1; i >= 0; i-- {
+		segment := segments[i]
+		position, found, expired := segment.lookup(key, now)
+		if expired {
+			break
+		}
 		if found {
 			return segment, position, nil
 		}
 	}
-	return nil, 0, fmt.Errorf("key not found in datastore")
+	return nil, 0, ErrKeyNotFound
 }
 
-func (db *Db) getKeyPosition(key string) *KeyLocation {
-	db.closeMutex.Lock()
-	defer db.closeMutex.Unlock()
+func (db *Db) getKeyPosition(key string) (*KeyLocation, error) {
+	return db.getKeyPositionContext(context.Background(), key)
+}
 
-	if db.closed {
-		return nil
+// getKeyPositionContext checks key against the authorizer with the principal
+// of ctx before looking it up. Callers hold off Close with enter.
+func (db *Db) getKeyPositionContext(ctx context.Context, key string) (*KeyLocation, error) {
+	if err := db.authorize(ctx, AccessRead, key); err != nil {
+		return nil, err
 	}
 
 	segment, pos, err := db.findKeyLocation(key)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &KeyLocation{segment, pos}
+	return &KeyLocation{segment, pos}, nil
 }
 
 func (db *Db) Get(key string) (string, error) {
-	location := db.getKeyPosition(key)
-	if location == nil {
-		return "", fmt.Errorf("key not found in datastore")
+	value, err := db.GetBytes(key)
+	if err != nil {
+		return "", err
 	}
+	return string(value), nil
+}
+
+func (db *Db) GetBytes(key string) ([]byte, error) {
+	op := db.startOp(opGet, key)
+	defer op.finish()
+
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.closeMutex.RUnlock()
 
-	value, err := location.segment.readFromSegmentWithChecksum(location.position)
+	location, err := db.getKeyPosition(key)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	op.phase("index")
+
+	return db.readLocation(key, location, op)
+}
+
+// readLocation serves the value of key at location from the value cache if
+// it holds it, and caches it otherwise.
+func (db *Db) readLocation(key string, location *KeyLocation, op *opTimer) ([]byte, error) {
+	if value, ok := db.cache.get(key, location); ok {
+		op.phase("cache")
+		return value, nil
 	}
+	value, err := db.readBytes(location.segment, key, location.position, op)
+	if err != nil && location.segment.retired.Load() {
+		// Compaction swapped the segment out after the lookup, so the key
+		// is looked up again in the segment that replaced it.
+		if location, err = db.getKeyPosition(key); err != nil {
+			return nil, err
+		}
+		value, err = db.readBytes(location.segment, key, location.position, op)
+	}
+	if err != nil {
+		return nil, err
+	}
+	db.cache.add(key, location, value)
 	return value, nil
 }
 
+func (db *Db) Has(key string) (bool, error) {
+	if err := db.authorize(context.Background(), AccessRead, key); err != nil {
+		return false, err
+	}
+
+	if err := db.enter(); err != nil {
+		return false, err
+	}
+	defer db.closeMutex.RUnlock()
+
+	_, _, err := db.findKeyLocation(key)
+	return err == nil, nil
+}
+
 func (db *Db) Put(key, value string) error {
-	db.closeMutex.Lock()
-	defer db.closeMutex.Unlock()
+	return db.PutBytes(key, []byte(value))
+}
 
-	if db.closed {
-		return fmt.Errorf("database is closed")
+func (db *Db) PutBytes(key string, value []byte) error {
+	return db.write([]entry{{key: key, value: bytes.Clone(value)}})
+}
+
+// PutAsync queues a put and returns a channel that receives its result once
+// it is written. Puts are written in the order they were queued, so a
+// producer can issue many without waiting for each in turn. PutAsync itself
+// only blocks while the write queue is full.
+func (db *Db) PutAsync(key, value string) <-chan error {
+	response := make(chan error, 1)
+
+	if err := db.enter(); err != nil {
+		response <- err
+		return response
+	}
+	defer db.closeMutex.RUnlock()
+	if err := db.authorize(context.Background(), AccessWrite, key); err != nil {
+		response <- err
+		return response
 	}
+	operation := WriteOperation{
+		entries:  []entry{{key: key, value: []byte(value)}},
+		response: response,
+		op:       db.startOp(opPut, key),
+		async:    true,
+	}
+	if err := db.enqueueWrite(context.Background(), operation); err != nil {
+		response <- err
+	}
+	return response
+}
 
+// enqueueWrite hands operation to the writer. It waits for room in the queue
+// until ctx is done, or fails right away when writes are shed.
+func (db *Db) enqueueWrite(ctx context.Context, operation WriteOperation) error {
+	if db.shedWrites {
+		select {
+		case db.writeOperations <- operation:
+			return nil
+		default:
+			return ErrWriteQueueFull
+		}
+	}
+
+	select {
+	case db.writeOperations <- operation:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WriteQueueDepth returns how many writes are waiting for the writer.
+func (db *Db) WriteQueueDepth() int {
+	return len(db.writeOperations)
+}
+
+func (db *Db) write(entries []entry) error {
+	return db.writeContext(context.Background(), entries)
+}
+
+func (db *Db) submitOperation(operation WriteOperation) error {
+	return db.submitOperationContext(context.Background(), operation)
+}
+
+func (db *Db) submitOperationContext(ctx context.Context, operation WriteOperation) error {
 	responseChannel := make(chan error, 1)
-	operation := WriteOperation{
-		data: entry{
-			key:   key,
-			value: value,
-		},
-		response: responseChannel,
+	operation.response = responseChannel
+	if len(operation.entries) > 0 {
+		operation.op = db.startOp(opPut, operation.entries[0].key)
+	} else {
+		operation.op = db.startOp(opPut, "")
+	}
+
+	if err := db.enqueueWrite(ctx, operation); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-responseChannel:
+		operation.op.finish()
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (db *Db) readModifyWrite(key string, prepare func() ([]entry, error)) error {
+	if err := db.authorize(context.Background(), AccessWrite, key); err != nil {
+		return err
 	}
 
-	db.writeOperations <- operation
-	return <-responseChannel
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.closeMutex.RUnlock()
+
+	return db.submitOperation(WriteOperation{prepare: prepare})
+}
+
+// readCurrent loads the live version of key. It is meant for prepare steps
+// running on the writer goroutine.
+func (db *Db) readCurrent(key string) (value []byte, valueType valueType, expiresAt int64, found bool, err error) {
+	segment, position, lookupErr := db.findKeyLocation(key)
+	if lookupErr != nil {
+		return nil, 0, 0, false, nil
+	}
+
+	value, valueType, err = db.readRecord(segment, key, position, nil)
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
+
+	_, expiresAt, _ = segment.index.get(key)
+	return value, valueType, expiresAt, true, nil
 }
 
 func (db *Db) getCurrentSegment() *Segment {
@@ -450,43 +1328,41 @@ func (db *Db) getCurrentSegment() *Segment {
 }
 
 func (segment *Segment) readFromSegment(position int64) (string, error) {
-	file, err := os.Open(segment.path)
+	reader, release, err := segment.recordReader(position)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
+	defer release()
 
-	_, err = file.Seek(position, 0)
+	value, _, err := readTimedValue(reader, segment.version, segment.dict, "", nil)
 	if err != nil {
 		return "", err
 	}
+	return string(value), nil
+}
 
-	reader := bufio.NewReader(file)
-	value, err := readValue(reader)
-	if err != nil {
-		return "", err
-	}
-	return value, nil
+func (segment *Segment) readTypedFromSegment(key string, position int64) ([]byte, valueType, error) {
+	return segment.readTyped(key, position, nil)
 }
 
-func (segment *Segment) readFromSegmentWithChecksum(position int64) (string, error) {
-	file, err := os.Open(segment.path)
+// readTyped reads the value of the record of key at position. It fails with
+// an *indexDivergenceError if the record there belongs to another key.
+func (segment *Segment) readTyped(key string, position int64, op *opTimer) ([]byte, valueType, error) {
+	reader, release, err := segment.recordReader(position)
 	if err != nil {
-		return "", err
+		return nil, 0, err
 	}
-	defer file.Close()
+	defer release()
 
-	_, err = file.Seek(position, 0)
-	if err != nil {
-		return "", err
+	value, valueType, err := readTimedValue(reader, segment.version, segment.dict, key, op)
+	var divergence *indexDivergenceError
+	if errors.As(err, &divergence) {
+		divergence.segment, divergence.position = segment, position
+		return nil, 0, divergence
 	}
-
-	reader := bufio.NewReader(file)
-
-	value, err := readValue(reader)
 	if err != nil {
-		return "", fmt.Errorf("checksum verification failed: %w", err)
+		return nil, 0, fmt.Errorf("checksum verification failed: %w", err)
 	}
 
-	return value, nil
+	return value, valueType, nil
 }
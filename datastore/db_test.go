@@ -1,6 +1,8 @@
 package datastore
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -201,6 +203,44 @@ func TestDb_Segmentation(t *testing.T) {
 	})
 }
 
+func TestDb_CompactionSwap(t *testing.T) {
+	database, err := Open(t.TempDir(), WithSegmentSize(60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	if err := database.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := database.getKeyPosition("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	segmentCount := func() int {
+		database.segmentLock.RLock()
+		defer database.segmentLock.RUnlock()
+		return len(database.segments)
+	}
+	for i := 0; segmentCount() < defaultCompactionSegments; i++ {
+		if err := database.Put("b", fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	database.compactionWG.Wait()
+	database.compactOldSegments()
+
+	if !stale.segment.retired.Load() {
+		t.Fatal("Expected compaction to retire the segment")
+	}
+	if _, err := os.Stat(stale.segment.path); !os.IsNotExist(err) {
+		t.Errorf("Expected the retired segment file to be removed, got %v", err)
+	}
+	if value, err := database.readLocation("a", stale, nil); err != nil || string(value) != "1" {
+		t.Errorf("Expected a read through a retired segment to find the key again, got %q, %v", value, err)
+	}
+}
+
 func TestDb_ParallelOperations(t *testing.T) {
 	tempDir, err := ioutil.TempDir("", "parallel_test")
 	if err != nil {
@@ -260,6 +300,47 @@ func TestDb_ParallelOperations(t *testing.T) {
 		}
 	})
 
+	t.Run("parallel gets during writes", func(t *testing.T) {
+		const numReaders = 8
+
+		done := make(chan struct{})
+		var writer sync.WaitGroup
+		writer.Add(1)
+		go func() {
+			defer writer.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if err := database.Put(fmt.Sprintf("churn_%d", i%20), "churn"); err != nil {
+					t.Errorf("Failed to put churn key: %v", err)
+					return
+				}
+			}
+		}()
+
+		var wg sync.WaitGroup
+		for reader := 0; reader < numReaders; reader++ {
+			wg.Add(1)
+			go func(reader int) {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					key := fmt.Sprintf("key_%d", (reader+i)%50)
+					expectedValue := fmt.Sprintf("value_%d", (reader+i)%50)
+					if value, err := database.Get(key); err != nil || value != expectedValue {
+						t.Errorf("Expected %s for %s, got %q, %v", expectedValue, key, value, err)
+						return
+					}
+				}
+			}(reader)
+		}
+		wg.Wait()
+		close(done)
+		writer.Wait()
+	})
+
 	t.Run("parallel writes to different keys", func(t *testing.T) {
 		const numWorkers = 5
 		const keysPerWorker = 10
@@ -355,6 +436,174 @@ func TestDb_ParallelOperations(t *testing.T) {
 	})
 }
 
+func TestDb_OpenOptions(t *testing.T) {
+	t.Run("invalid segment size", func(t *testing.T) {
+		if _, err := Open(t.TempDir(), WithSegmentSize(0)); err == nil {
+			t.Error("Expected error for zero segment size")
+		}
+	})
+
+	t.Run("invalid buffer size", func(t *testing.T) {
+		if _, err := Open(t.TempDir(), WithBufferSize(1)); err == nil {
+			t.Error("Expected error for buffer smaller than record header")
+		}
+	})
+
+	t.Run("custom file mode and sync policy", func(t *testing.T) {
+		tempDir := t.TempDir()
+		database, err := Open(tempDir, WithFileMode(0600), WithSyncPolicy(SyncAlways), WithBufferSize(512))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if err := database.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+		value, err := database.Get("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != "value" {
+			t.Errorf("Expected value, got %s", value)
+		}
+
+		fileInfo, err := os.Stat(filepath.Join(tempDir, dataFileName+"0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fileInfo.Mode().Perm() != 0600 {
+			t.Errorf("Expected file mode 0600, got %v", fileInfo.Mode().Perm())
+		}
+	})
+	t.Run("missing directory is created", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "nested", "db")
+		database, err := Open(dir, WithDirMode(0700))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.IsDir() || info.Mode().Perm() != 0700 {
+			t.Errorf("Expected a directory with mode 0700, got %v", info.Mode())
+		}
+		if err := database.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("path is a file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "db")
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(path); !errors.Is(err, ErrNotDirectory) {
+			t.Errorf("Expected ErrNotDirectory, got %v", err)
+		}
+	})
+}
+
+func TestDb_Has(t *testing.T) {
+	tempDir := t.TempDir()
+
+	database, err := Open(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.Put("present", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := database.Has("present")
+	if err != nil || !found {
+		t.Errorf("Expected present key to be found, got %v, %v", found, err)
+	}
+
+	found, err = database.Has("absent")
+	if err != nil || found {
+		t.Errorf("Expected absent key to be missing, got %v, %v", found, err)
+	}
+
+	if err := os.Remove(filepath.Join(tempDir, dataFileName+"0")); err != nil {
+		t.Fatal(err)
+	}
+	found, err = database.Has("present")
+	if err != nil || !found {
+		t.Errorf("Has should not touch segment files, got %v, %v", found, err)
+	}
+
+	database.Close()
+	if _, err := database.Has("present"); !errors.Is(err, ErrDBClosed) {
+		t.Error("Expected error after close")
+	}
+}
+
+func TestDb_PutBytes(t *testing.T) {
+	tempDir := t.TempDir()
+
+	database, err := Open(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binary := []byte{0x00, 0xff, 0x10, 0x00, 0x80, '\n'}
+	large := make([]byte, 3*defaultBufferSize)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+
+	if err := database.PutBytes("binary", binary); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.PutBytes("large", large); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Put("text", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(db *Db) {
+		value, err := db.GetBytes("binary")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, binary) {
+			t.Errorf("Binary value mismatch: %v", value)
+		}
+
+		value, err = db.GetBytes("large")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(value, large) {
+			t.Errorf("Large value mismatch: got %d bytes", len(value))
+		}
+
+		text, err := db.GetBytes("text")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(text) != "hello" {
+			t.Errorf("Expected string value to be readable as bytes, got %q", text)
+		}
+	}
+
+	check(database)
+	database.Close()
+
+	reopened, err := Open(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	check(reopened)
+}
+
 func createTestDatabase(directory string, segmentSize int64) (*Db, error) {
 	return CreateDb(directory, segmentSize)
 }
@@ -362,3 +611,43 @@ func createTestDatabase(directory string, segmentSize int64) (*Db, error) {
 func newDb(directory string, segmentSize int64) (*Db, error) {
 	return createTestDatabase(directory, segmentSize)
 }
+
+func TestDb_PutAsync(t *testing.T) {
+	tempDir := t.TempDir()
+
+	database, err := Open(tempDir, WithSegmentSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const numPuts = 200
+	results := make([]<-chan error, numPuts)
+	for i := range results {
+		results[i] = database.PutAsync("counter", fmt.Sprintf("value_%d", i))
+	}
+	last := database.PutAsync("last", "queued before close")
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, result := range results {
+		if err := <-result; err != nil {
+			t.Errorf("Put %d failed: %v", i, err)
+		}
+	}
+	if err := <-last; err != nil {
+		t.Errorf("Expected puts queued before Close to be written, got %v", err)
+	}
+	if err := <-database.PutAsync("late", "value"); !errors.Is(err, ErrDBClosed) {
+		t.Errorf("Expected ErrDBClosed, got %v", err)
+	}
+
+	database, err = Open(tempDir, WithSegmentSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	if value, err := database.Get("counter"); err != nil || value != fmt.Sprintf("value_%d", numPuts-1) {
+		t.Errorf("Expected the last queued value to win, got %q, %v", value, err)
+	}
+}
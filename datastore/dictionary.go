@@ -0,0 +1,255 @@
+package datastore
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"sync"
+)
+
+// With dictionary compression, compaction trains a dictionary on a sample of
+// the values it copies. The compacted segment and every segment started
+// after it compress values with that dictionary, which lets small values
+// that look alike compress well even though each is compressed on its own.
+// A segment keeps the dictionary its records were written with in a file
+// next to it, with a header like a segment and a CRC32-C of the dictionary.
+const (
+	dictionarySuffix  = ".dict"
+	dictionaryMagic   = "\x89KVD"
+	dictionaryVersion = 1
+
+	// maxDictionarySize is the window of deflate; older dictionary bytes
+	// could never be referenced.
+	maxDictionarySize = 32 * 1024
+	// Compaction samples at most dictionarySampleBytes of values, skipping
+	// values longer than maxSampleSize, which compress well on their own.
+	dictionarySampleBytes = 1024 * 1024
+	maxSampleSize         = 4 * 1024
+	// dictionaryShingle is the length of the substrings samples are compared
+	// by.
+	dictionaryShingle = 8
+)
+
+func dictionaryPath(segmentPath string) string {
+	return segmentPath + dictionarySuffix
+}
+
+// dictionary compresses and decompresses values with one trained
+// dictionary. Deflate writers are expensive to create, so they are pooled.
+type dictionary struct {
+	data    []byte
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func newDictionary(data []byte) *dictionary {
+	return &dictionary{data: data}
+}
+
+// compress returns value compressed with the dictionary, or false if that
+// would not make it smaller.
+func (d *dictionary) compress(value []byte) ([]byte, bool) {
+	var buffer bytes.Buffer
+	writer, _ := d.writers.Get().(*flate.Writer)
+	if writer == nil {
+		writer, _ = flate.NewWriterDict(&buffer, flate.BestCompression, d.data)
+	} else {
+		writer.Reset(&buffer)
+	}
+	defer d.writers.Put(writer)
+
+	if _, err := writer.Write(value); err != nil {
+		return nil, false
+	}
+	if err := writer.Close(); err != nil || buffer.Len() >= len(value) {
+		return nil, false
+	}
+	return buffer.Bytes(), true
+}
+
+func (d *dictionary) decompress(compressed []byte) ([]byte, error) {
+	reader, _ := d.readers.Get().(io.ReadCloser)
+	if reader == nil {
+		reader = flate.NewReaderDict(bytes.NewReader(compressed), d.data)
+	} else {
+		reader.(flate.Resetter).Reset(bytes.NewReader(compressed), d.data)
+	}
+	defer d.readers.Put(reader)
+
+	value, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: compressed value: %v", ErrCorruptedData, err)
+	}
+	return value, nil
+}
+
+// trainDictionary builds a dictionary of at most size bytes from the samples
+// that share the most substrings with the other samples. The best ones go to
+// the end, where deflate reaches them with the shortest distances. It
+// returns nil if the samples have nothing in common.
+func trainDictionary(samples [][]byte, size int) []byte {
+	type candidate struct {
+		sample []byte
+		score  float64
+	}
+
+	seen := make(map[string]bool)
+	shingles := make(map[string]int)
+	var unique [][]byte
+	for _, sample := range samples {
+		if len(sample) < dictionaryShingle || seen[string(sample)] {
+			continue
+		}
+		seen[string(sample)] = true
+		unique = append(unique, sample)
+		for shingle := range sampleShingles(sample) {
+			shingles[shingle]++
+		}
+	}
+
+	var candidates []candidate
+	for _, sample := range unique {
+		shared := 0
+		for shingle := range sampleShingles(sample) {
+			shared += shingles[shingle] - 1
+		}
+		if shared > 0 {
+			candidates = append(candidates, candidate{sample, float64(shared) / float64(len(sample))})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		switch {
+		case a.score > b.score:
+			return -1
+		case a.score < b.score:
+			return 1
+		}
+		return 0
+	})
+
+	var chosen [][]byte
+	total := 0
+	for _, c := range candidates {
+		if total+len(c.sample) > size {
+			continue
+		}
+		chosen = append(chosen, c.sample)
+		total += len(c.sample)
+	}
+
+	data := make([]byte, 0, total)
+	for i := len(chosen) - 1; i >= 0; i-- {
+		data = append(data, chosen[i]...)
+	}
+	return data
+}
+
+func sampleShingles(sample []byte) map[string]struct{} {
+	shingles := make(map[string]struct{})
+	for i := 0; i+dictionaryShingle <= len(sample); i++ {
+		shingles[string(sample[i:i+dictionaryShingle])] = struct{}{}
+	}
+	return shingles
+}
+
+// sampleValues collects values of sealed segments, newest first, for
+// training.
+func sampleValues(sealed []*Segment) [][]byte {
+	var samples [][]byte
+	total := 0
+	for i := len(sealed) - 1; i >= 0 && total < dictionarySampleBytes; i-- {
+		segment := sealed[i]
+		segment.index.forEach(func(key string, position, _ int64) bool {
+			value, valueType, err := segment.readTypedFromSegment(key, position)
+			if err == nil && valueType == valueTypeBytes && len(value) <= maxSampleSize {
+				samples = append(samples, value)
+				total += len(value)
+			}
+			return total < dictionarySampleBytes
+		})
+	}
+	return samples
+}
+
+// writeDictionary stores the dictionary of the segment at segmentPath. The
+// segment cannot be read without it, so it is always synced.
+func (db *Db) writeDictionary(segmentPath string, dict *dictionary) error {
+	path := dictionaryPath(segmentPath)
+	_ = db.fs.Remove(path)
+
+	buffer := make([]byte, segmentHeaderSize, segmentHeaderSize+len(dict.data)+crc32.Size)
+	copy(buffer, dictionaryMagic)
+	binary.LittleEndian.PutUint16(buffer[len(dictionaryMagic):], dictionaryVersion)
+	buffer = append(buffer, dict.data...)
+	buffer = binary.LittleEndian.AppendUint32(buffer, crc32.Checksum(buffer, crcTable))
+
+	file, err := db.fs.OpenAppend(path, db.fileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buffer); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		_ = db.fs.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+// loadDictionary reads the dictionary of segment, if it has one.
+func (db *Db) loadDictionary(segment *Segment) error {
+	file, err := db.fs.Open(dictionaryPath(segment.path))
+	if err != nil {
+		return nil
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	if len(data) < segmentHeaderSize+crc32.Size {
+		return fmt.Errorf("%w: dictionary of %s is truncated", ErrCorruptedData, segment.path)
+	}
+	body, checksum := data[:len(data)-crc32.Size], data[len(data)-crc32.Size:]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(checksum) ||
+		!bytes.HasPrefix(body, []byte(dictionaryMagic)) ||
+		binary.LittleEndian.Uint16(body[len(dictionaryMagic):]) != dictionaryVersion {
+		return fmt.Errorf("%w: dictionary of %s is damaged", ErrCorruptedData, segment.path)
+	}
+	segment.dict = newDictionary(body[segmentHeaderSize:])
+	return nil
+}
+
+// compressEntries compresses the values of entries with the dictionary of
+// the active segment.
+func (db *Db) compressEntries(entries []entry) {
+	dict := db.getCurrentSegment().dict
+	if !db.compressValues || dict == nil {
+		return
+	}
+	for i := range entries {
+		entries[i].compress(dict)
+	}
+}
+
+// compress replaces the value of a bytes record with its compressed form if
+// that is smaller.
+func (e *entry) compress(dict *dictionary) {
+	if e.valueType != valueTypeBytes || e.compressed {
+		return
+	}
+	if compressed, ok := dict.compress(e.value); ok {
+		e.value = compressed
+		e.compressed = true
+	}
+}
@@ -0,0 +1,153 @@
+package datastore
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func similarValue(i int) []byte {
+	return fmt.Appendf(nil, `{"user_id":%d,"status":"active","plan":"premium","region":"eu-central-1","flags":["beta","email"]}`, i)
+}
+
+func TestTrainDictionary(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		samples = append(samples, similarValue(i))
+	}
+
+	data := trainDictionary(samples, 1024)
+	if len(data) == 0 || len(data) > 1024 {
+		t.Fatalf("Expected a dictionary of up to 1024 bytes, got %d", len(data))
+	}
+
+	if data := trainDictionary([][]byte{[]byte("abcdefghij"), []byte("klmnopqrst")}, 1024); data != nil {
+		t.Errorf("Expected no dictionary for unrelated samples, got %q", data)
+	}
+}
+
+func TestDictionary_Compress(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		samples = append(samples, similarValue(i))
+	}
+	dict := newDictionary(trainDictionary(samples, maxDictionarySize))
+
+	value := similarValue(12345)
+	compressed, ok := dict.compress(value)
+	if !ok {
+		t.Fatal("Expected the value to compress")
+	}
+
+	var plain bytes.Buffer
+	writer, _ := flate.NewWriter(&plain, flate.BestCompression)
+	writer.Write(value)
+	writer.Close()
+	if len(compressed) >= plain.Len() {
+		t.Errorf("Expected the dictionary to beat plain deflate, got %d and %d bytes", len(compressed), plain.Len())
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := dict.decompress(compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, value) {
+			t.Errorf("Expected %q, got %q", value, got)
+		}
+	}
+
+	if _, ok := dict.compress([]byte("x")); ok {
+		t.Error("Expected a value that does not shrink to stay uncompressed")
+	}
+}
+
+func TestDb_DictionaryCompression(t *testing.T) {
+	dir := t.TempDir()
+	database, err := Open(dir, WithDictionaryCompression(), WithSegmentSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 40; i++ {
+		if err := database.PutBytes(fmt.Sprintf("user%d", i), similarValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	database.compactionWG.Wait()
+	database.compactOldSegments()
+
+	database.fileLock.Lock()
+	trained := database.dictionary
+	database.fileLock.Unlock()
+	if trained == nil {
+		t.Fatal("Expected compaction to train a dictionary")
+	}
+	compacted := database.segments[0]
+	if compacted.dict == nil {
+		t.Fatal("Expected the compacted segment to use the dictionary")
+	}
+	if _, err := os.Stat(dictionaryPath(compacted.path)); err != nil {
+		t.Errorf("Expected the dictionary to be stored next to the segment: %v", err)
+	}
+
+	for i := 40; i < 80; i++ {
+		if err := database.PutBytes(fmt.Sprintf("user%d", i), similarValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	current := database.getCurrentSegment()
+	if current.dict != trained {
+		t.Error("Expected segments started after compaction to use the trained dictionary")
+	}
+	location, err := database.getKeyPosition("user79")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.segment.dict == nil {
+		t.Error("Expected the newest value to be written with a dictionary")
+	}
+
+	check := func(database *Db) {
+		t.Helper()
+		for i := 0; i < 80; i++ {
+			value, err := database.GetBytes(fmt.Sprintf("user%d", i))
+			if err != nil {
+				t.Fatalf("user%d: %v", i, err)
+			}
+			if !bytes.Equal(value, similarValue(i)) {
+				t.Fatalf("user%d: expected %q, got %q", i, similarValue(i), value)
+			}
+		}
+	}
+	check(database)
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	check(reopened)
+}
+
+func TestDb_DamagedDictionary(t *testing.T) {
+	dir := t.TempDir()
+	database, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := database.getCurrentSegment().path
+	database.Close()
+
+	if err := os.WriteFile(dictionaryPath(path), []byte("not a dictionary"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); err == nil {
+		t.Error("Expected a damaged dictionary to be reported")
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !unix
+
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const lockFileName = "LOCK"
+
+// lockDirectory creates the lock file exclusively. Unlike flock, a crashed
+// process leaves the file behind and it has to be removed by hand.
+func lockDirectory(directory string, mode os.FileMode) (io.Closer, error) {
+	path := filepath.Join(directory, lockFileName)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, mode)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("%w: %s", ErrDirectoryLocked, directory)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lockFile{File: file, path: path}, nil
+}
+
+type lockFile struct {
+	*os.File
+	path string
+}
+
+func (f *lockFile) Close() error {
+	return errors.Join(f.File.Close(), os.Remove(f.path))
+}
@@ -0,0 +1,59 @@
+package datastore
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+func TestDb_DirectoryLock(t *testing.T) {
+	t.Run("second open fails while the first is open", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Open(dir); !errors.Is(err, ErrDirectoryLocked) {
+			t.Fatalf("Expected ErrDirectoryLocked, got %v", err)
+		}
+		if err := database.Put("key", "value"); err != nil {
+			t.Errorf("Expected the first database to keep working, got %v", err)
+		}
+
+		if err := database.Close(); err != nil {
+			t.Fatal(err)
+		}
+		reopened, err := Open(dir)
+		if err != nil {
+			t.Fatalf("Expected the directory to be unlocked after Close, got %v", err)
+		}
+		defer reopened.Close()
+		if value, err := reopened.Get("key"); err != nil || value != "value" {
+			t.Errorf("Expected value, got %q (%v)", value, err)
+		}
+	})
+
+	t.Run("failed open releases the lock", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.Raw(testutil.SegmentHeader(segmentFormatVersion + 1))
+
+		for i := 0; i < 2; i++ {
+			if _, err := Open(dir.Path); !errors.Is(err, ErrUnsupportedFormat) {
+				t.Fatalf("Expected ErrUnsupportedFormat, got %v", err)
+			}
+		}
+	})
+
+	t.Run("in-memory databases do not lock", func(t *testing.T) {
+		dir := t.TempDir()
+		for i := 0; i < 2; i++ {
+			database, err := Open(dir, WithInMemory())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer database.Close()
+		}
+	})
+}
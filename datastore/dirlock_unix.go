@@ -0,0 +1,32 @@
+//go:build unix
+
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const lockFileName = "LOCK"
+
+// lockDirectory takes an exclusive flock on the lock file of the directory.
+// The kernel drops the lock when the process dies, so a crash never leaves
+// the directory locked.
+func lockDirectory(directory string, mode os.FileMode) (io.Closer, error) {
+	file, err := os.OpenFile(filepath.Join(directory, lockFileName), os.O_RDWR|os.O_CREATE, mode)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrDirectoryLocked, directory)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", directory, err)
+	}
+	return file, nil
+}
@@ -0,0 +1,234 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// indexDivergenceError reports an index entry that points at the record of
+// another key, which means the index of the segment has drifted from its
+// file, for instance because an offset was miscounted.
+type indexDivergenceError struct {
+	segment  *Segment
+	position int64
+	key      string
+	found    string
+}
+
+func (e *indexDivergenceError) Error() string {
+	path := ""
+	if e.segment != nil {
+		path = e.segment.path
+	}
+	return fmt.Sprintf("index of %s places key '%s' at offset %d, which holds key '%s'", path, e.key, e.position, e.found)
+}
+
+func (e *indexDivergenceError) Unwrap() error {
+	return ErrCorruptedData
+}
+
+// readRecord reads the value of key at position in segment. Should the
+// record there belong to another key, the value is looked for in the file
+// instead, so that a drifted index never serves the value of another key.
+func (db *Db) readRecord(segment *Segment, key string, position int64, op *opTimer) ([]byte, valueType, error) {
+	value, valueType, err := segment.readTyped(key, position, op)
+	var divergence *indexDivergenceError
+	if errors.As(err, &divergence) {
+		return db.recoverDivergence(divergence)
+	}
+	return value, valueType, err
+}
+
+// readBytes is readRecord rendering typed values like Get does.
+func (db *Db) readBytes(segment *Segment, key string, position int64, op *opTimer) ([]byte, error) {
+	value, valueType, err := db.readRecord(segment, key, position, op)
+	if err != nil {
+		return nil, err
+	}
+	return formatValue(value, valueType), nil
+}
+
+// recoverDivergence logs a divergence, starts rebuilding the index of its
+// segment and scans the file for the newest record of the key. If the
+// segment holds none, the divergence is returned rather than a guess.
+func (db *Db) recoverDivergence(divergence *indexDivergenceError) ([]byte, valueType, error) {
+	fmt.Printf("Warning: %v, reading the key from the file and reindexing\n", divergence)
+	db.startReindex(divergence.segment)
+
+	segment := divergence.segment
+	record, err := db.scanFor(segment, divergence.key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if record == nil {
+		return nil, 0, divergence
+	}
+	if record.expiresAt != 0 && record.expiresAt <= db.clock.Now().UnixNano() {
+		return nil, 0, ErrKeyNotFound
+	}
+	if record.compressed {
+		if segment.dict == nil {
+			return nil, 0, fmt.Errorf("%w: value of key '%s' is compressed but its segment has no dictionary", ErrCorruptedData, record.key)
+		}
+		value, err := segment.dict.decompress(record.value)
+		return value, record.valueType, err
+	}
+	return record.value, record.valueType, nil
+}
+
+// scanFor returns the newest intact record of key in segment, or nil if it
+// has none.
+func (db *Db) scanFor(segment *Segment, key string) (*entry, error) {
+	var found *entry
+	err := db.scanSegment(segment, segment.end, func(record *entry, _ int64) {
+		if record.key == key {
+			found = record
+		}
+	})
+	return found, err
+}
+
+// scanSegment calls visit for every record of segment that passes its
+// checksum, in file order, up to end, or up to the end of the file if end is
+// 0. A record cut short at the end is taken to be still being appended.
+func (db *Db) scanSegment(segment *Segment, end int64, visit func(record *entry, position int64)) error {
+	file, err := db.fs.Open(segment.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, dataStart, err := readSegmentHeader(file)
+	if err != nil {
+		return err
+	}
+	if end <= 0 {
+		end = 1 << 62
+	}
+	reader := bufio.NewReader(io.NewSectionReader(file, dataStart, end-dataStart))
+	maxLength := db.maxRecordLength()
+
+	for position := dataStart; ; {
+		header, _ := reader.Peek(binary.MaxVarintLen64)
+		size, ok := peekRecordSize(header, segment.version)
+		if !ok {
+			return nil
+		}
+		if size <= 0 || size > maxLength {
+			return fmt.Errorf("%w: invalid record size %d at offset %d of %s", ErrCorruptedData, size, position, segment.path)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil
+		}
+
+		var record entry
+		if record.Decode(data, segment.version) == nil && record.verifyChecksum() == nil {
+			visit(&record, position)
+		}
+		position += size
+	}
+}
+
+// startReindex rebuilds the index of the segment of the database stored
+// where segment is in the background, unless that is already under way or
+// compaction has retired the segment. Close waits for it like for a
+// compaction.
+func (db *Db) startReindex(segment *Segment) {
+	if err := db.enter(); err != nil {
+		return
+	}
+	defer db.closeMutex.RUnlock()
+
+	// Snapshots read frozen copies of the active segment, so the segment
+	// is looked up by its file.
+	target := db.liveSegment(segment.path)
+	if target == nil || !target.reindexing.CompareAndSwap(false, true) {
+		return
+	}
+	db.compactionWG.Add(1)
+	go func() {
+		defer db.compactionWG.Done()
+		defer target.reindexing.Store(false)
+		if err := db.reindex(target); err != nil {
+			fmt.Printf("Warning: reindexing %s failed: %v\n", target.path, err)
+		}
+	}()
+}
+
+func (db *Db) liveSegment(path string) *Segment {
+	db.segmentLock.RLock()
+	defer db.segmentLock.RUnlock()
+
+	for _, segment := range db.segments {
+		if segment.path == path {
+			return segment
+		}
+	}
+	return nil
+}
+
+// reindex replaces the index entries of segment with those its file gives,
+// as recovery would. It holds off compaction, so that the segment is not
+// retired meanwhile, and writes, so that the file does not grow while it is
+// scanned. A sealed segment also gets a new bloom filter and hint file.
+func (db *Db) reindex(segment *Segment) error {
+	db.compactionMu.Lock()
+	defer db.compactionMu.Unlock()
+	db.fileLock.Lock()
+	defer db.fileLock.Unlock()
+
+	if segment.retired.Load() || db.isShuttingDown() {
+		return nil
+	}
+	active := segment == db.getCurrentSegment()
+	_, end, err := db.segmentSpan(segment)
+	if err != nil {
+		return err
+	}
+	if active {
+		end = db.currentOffset
+	}
+
+	positions := make(keyIndex)
+	expiries := make(map[string]int64)
+	err = db.scanSegment(segment, end, func(record *entry, position int64) {
+		positions[record.key] = position
+		expiries[record.key] = record.expiresAt
+	})
+	if err != nil {
+		return err
+	}
+
+	var stale []string
+	segment.index.forEach(func(key string, _, _ int64) bool {
+		if _, found := positions[key]; !found {
+			stale = append(stale, key)
+		}
+		return true
+	})
+	corrected := len(stale)
+	for _, key := range stale {
+		segment.index.remove(key)
+	}
+	for key, position := range positions {
+		if indexed, expiresAt, found := segment.index.get(key); !found || indexed != position || expiresAt != expiries[key] {
+			segment.setKey(key, position, expiries[key])
+			corrected++
+		}
+	}
+
+	if !active {
+		filter := newBloomFilter(len(positions))
+		for key := range positions {
+			filter.add(key)
+		}
+		segment.filter.Store(filter)
+		_ = db.writeHint(segment, end)
+	}
+	fmt.Printf("Reindexed %s: corrected %d entries of %d keys\n", segment.path, corrected, len(positions))
+	return nil
+}
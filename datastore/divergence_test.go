@@ -0,0 +1,118 @@
+package datastore
+
+import (
+	"errors"
+	"testing"
+)
+
+// drift points the index entry of key at the record of other, as a
+// miscounted offset would.
+func drift(t *testing.T, database *Db, key, other string) *Segment {
+	t.Helper()
+	location, err := database.getKeyPosition(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	location.segment.setKey(key, location.position, 0)
+	return location.segment
+}
+
+func TestDb_IndexDivergence(t *testing.T) {
+	t.Run("active segment", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		for _, key := range []string{"a", "b", "c"} {
+			if err := database.Put(key, "value-"+key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		original, err := database.getKeyPosition("b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		drift(t, database, "b", "a")
+
+		if value, err := database.Get("b"); err != nil || value != "value-b" {
+			t.Errorf("Expected 'value-b' from the file, got %q (%v)", value, err)
+		}
+		database.compactionWG.Wait()
+		if location, err := database.getKeyPosition("b"); err != nil || location.position != original.position {
+			t.Errorf("Expected the reindex to restore offset %d, got %v (%v)", original.position, location, err)
+		}
+
+		drift(t, database, "c", "a")
+		values, err := database.GetMany([]string{"a", "b", "c"})
+		if err != nil || values["c"] != "value-c" || values["a"] != "value-a" {
+			t.Errorf("Expected GetMany to read 'c' from the file, got %v (%v)", values, err)
+		}
+	})
+
+	t.Run("sealed segment", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir, WithSegmentSize(64))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+			if err := database.Put(key, "value-"+key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sealed := drift(t, database, "b", "a")
+		if sealed == database.getCurrentSegment() {
+			t.Fatal("Expected 'a' to be in a sealed segment")
+		}
+
+		it := database.Scan("b")
+		if !it.Next() {
+			t.Fatal("Expected the scan to find 'b'")
+		}
+		if value, err := it.Value(); err != nil || value != "value-b" {
+			t.Errorf("Expected 'value-b' from the file, got %q (%v)", value, err)
+		}
+		database.compactionWG.Wait()
+		if err := database.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The hint written by the reindex is what the index is loaded from.
+		database, err = Open(dir, WithSegmentSize(64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+		location, err := database.getKeyPosition("b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value, _, err := location.segment.readTypedFromSegment("b", location.position); err != nil || string(value) != "value-b" {
+			t.Errorf("Expected the index to point at 'value-b' after reopening, got %q (%v)", value, err)
+		}
+	})
+
+	t.Run("key missing from the file", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if err := database.Put("a", "value-a"); err != nil {
+			t.Fatal(err)
+		}
+		drift(t, database, "ghost", "a")
+
+		if value, err := database.Get("ghost"); !errors.Is(err, ErrCorruptedData) {
+			t.Errorf("Expected ErrCorruptedData rather than the value of 'a', got %q (%v)", value, err)
+		}
+		database.compactionWG.Wait()
+		if _, err := database.Get("ghost"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected the reindex to drop 'ghost', got %v", err)
+		}
+	})
+}
@@ -2,145 +2,285 @@ package datastore
 
 import (
 	"bufio"
-	"crypto/sha1"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
 )
 
-type entry struct {
-	key      string
-	value    string
-	checksum [20]byte
+type valueType byte
+
+const (
+	valueTypeBytes valueType = iota
+	valueTypeInt64
+)
+
+func (t valueType) String() string {
+	switch t {
+	case valueTypeBytes:
+		return "bytes"
+	case valueTypeInt64:
+		return "int64"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(t))
 }
 
 const (
-	headerSize      = 4
-	keyLengthSize   = 4
-	valueLengthSize = 4
-	checksumSize    = 20
-	totalHeaderSize = headerSize + keyLengthSize + valueLengthSize + checksumSize
+	valueTypeMask = 0x1f
+	// batchFlag marks the records of a write batch other than its last, so
+	// that recovery can tell a batch cut short from a complete one.
+	batchFlag = 0x20
+	// compressedFlag marks values compressed with the dictionary of their
+	// segment. The checksum covers the stored, compressed value.
+	compressedFlag = 0x40
+	expiryFlag     = 0x80
+	expirationSize = 8
 )
 
-func calculateEntryLength(key, value string) int64 {
-	return int64(len(key) + len(value) + totalHeaderSize)
+type entry struct {
+	key        string
+	value      []byte
+	valueType  valueType
+	compressed bool
+	// batched is set on the records of a batch that more records of the
+	// same batch follow.
+	batched   bool
+	expiresAt int64
+	checksum  uint32
 }
 
+const (
+	typeTagSize  = 1
+	checksumSize = 4
+
+	// maxRecordSize bounds the length prefix of a record, so that a damaged
+	// prefix is reported instead of allocating whatever it claims.
+	maxRecordSize = math.MaxUint32
+)
+
+// GetLength returns the size of the record in the current format: a varint
+// length of the rest of the record, the varint key length, the key, the
+// varint value length, the value, the type tag, the optional expiry and the
+// checksum.
 func (e *entry) GetLength() int64 {
-	return calculateEntryLength(e.key, e.value)
+	body := e.bodyLength()
+	return int64(uvarintSize(uint64(body))) + body
 }
 
-func (e *entry) calculateChecksum() [20]byte {
-	return sha1.Sum([]byte(e.value))
+func (e *entry) bodyLength() int64 {
+	length := int64(uvarintSize(uint64(len(e.key)))+len(e.key)) +
+		int64(uvarintSize(uint64(len(e.value)))+len(e.value)) +
+		typeTagSize + checksumSize
+	if e.expiresAt != 0 {
+		length += expirationSize
+	}
+	return length
 }
 
-func (e *entry) verifyChecksum() error {
-	expectedChecksum := sha1.Sum([]byte(e.value))
-	if expectedChecksum != e.checksum {
-		return fmt.Errorf("checksum mismatch: data corruption detected for key '%s'", e.key)
+func uvarintSize(x uint64) int {
+	size := 1
+	for x >= 0x80 {
+		x >>= 7
+		size++
 	}
-	return nil
+	return size
 }
 
-func (e *entry) Decode(data []byte) {
-	keyLength := binary.LittleEndian.Uint32(data[headerSize:])
+// crcTable is the Castagnoli polynomial, which has hardware support on most
+// platforms.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
 
-	keyStart := headerSize + keyLengthSize
-	keyEnd := keyStart + int(keyLength)
-
-	keyBytes := make([]byte, keyLength)
-	copy(keyBytes, data[keyStart:keyEnd])
-	e.key = string(keyBytes)
+// entryChecksum covers both the key and the value, so a damaged key is
+// detected as well and cannot make a record look like it belongs to another
+// key.
+func entryChecksum(key []byte, value []byte) uint32 {
+	return crc32.Update(crc32.Checksum(key, crcTable), crcTable, value)
+}
 
-	valueStart := keyEnd
-	valueLength := binary.LittleEndian.Uint32(data[valueStart:])
+func (e *entry) calculateChecksum() uint32 {
+	return entryChecksum([]byte(e.key), e.value)
+}
 
-	valueDataStart := valueStart + valueLengthSize
-	valueDataEnd := valueDataStart + int(valueLength)
+func (e *entry) verifyChecksum() error {
+	if e.calculateChecksum() != e.checksum {
+		return fmt.Errorf("%w: checksum mismatch for key '%s'", ErrCorruptedData, e.key)
+	}
+	return nil
+}
 
-	valueBytes := make([]byte, valueLength)
-	copy(valueBytes, data[valueDataStart:valueDataEnd])
-	e.value = string(valueBytes)
+// Decode parses a whole record written in the given segment format.
+// Lengths that do not fit into data are reported as ErrCorruptedData, the
+// checksum is left to verifyChecksum.
+func (e *entry) Decode(data []byte, version int) error {
+	if version == formatFixed {
+		return e.decodeFixed(data)
+	}
 
-	checksumStart := valueDataEnd
-	copy(e.checksum[:], data[checksumStart:checksumStart+checksumSize])
+	bodySize, n := binary.Uvarint(data)
+	if n <= 0 || bodySize != uint64(len(data)-n) {
+		return fmt.Errorf("%w: invalid record length", ErrCorruptedData)
+	}
+	return e.decodeBody(data[n:])
 }
 
-func readValue(reader *bufio.Reader) (string, error) {
-	headerBytes, err := reader.Peek(headerSize + keyLengthSize)
+func (e *entry) decodeBody(body []byte) error {
+	key, rest, err := readLengthPrefixed(body)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("%w: key %v", ErrCorruptedData, err)
 	}
-
-	keySize := int(binary.LittleEndian.Uint32(headerBytes[headerSize:]))
-
-	bytesToSkip := headerSize + keyLengthSize + keySize
-	_, err = reader.Discard(bytesToSkip)
+	value, rest, err := readLengthPrefixed(rest)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("%w: value %v", ErrCorruptedData, err)
+	}
+	if len(rest) < typeTagSize+checksumSize {
+		return fmt.Errorf("%w: record is too short for its trailer", ErrCorruptedData)
 	}
 
-	valueSizeBytes, err := reader.Peek(valueLengthSize)
-	if err != nil {
-		return "", err
+	e.key = string(key)
+	e.value = append([]byte(nil), value...)
+	tag := rest[0]
+	e.valueType = valueType(tag & valueTypeMask)
+	e.compressed = tag&compressedFlag != 0
+	e.batched = tag&batchFlag != 0
+	rest = rest[typeTagSize:]
+
+	e.expiresAt = 0
+	if tag&expiryFlag != 0 {
+		if len(rest) < expirationSize+checksumSize {
+			return fmt.Errorf("%w: expiration does not fit into record", ErrCorruptedData)
+		}
+		e.expiresAt = int64(binary.LittleEndian.Uint64(rest))
+		rest = rest[expirationSize:]
+	}
+	if len(rest) != checksumSize {
+		return fmt.Errorf("%w: %d unexpected bytes after the record", ErrCorruptedData, len(rest)-checksumSize)
+	}
+	e.checksum = binary.LittleEndian.Uint32(rest)
+	return nil
+}
+
+func readLengthPrefixed(data []byte) (field, rest []byte, err error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, nil, fmt.Errorf("length is invalid")
+	}
+	if length > uint64(len(data)-n) {
+		return nil, nil, fmt.Errorf("length %d exceeds record size %d", length, len(data))
 	}
+	end := n + int(length)
+	return data[n:end], data[end:], nil
+}
 
-	valueSize := int(binary.LittleEndian.Uint32(valueSizeBytes))
+// peekRecordSize returns the total size of the record starting at header, or
+// false if header is too short to tell.
+func peekRecordSize(header []byte, version int) (int64, bool) {
+	if version == formatFixed {
+		if len(header) < fixedSizeLength {
+			return 0, false
+		}
+		return int64(binary.LittleEndian.Uint32(header)), true
+	}
 
-	_, err = reader.Discard(valueLengthSize)
-	if err != nil {
-		return "", err
+	bodySize, n := binary.Uvarint(header)
+	if n == 0 {
+		return 0, false
 	}
+	if n < 0 || bodySize > maxRecordSize {
+		return -1, true
+	}
+	return int64(n) + int64(bodySize), true
+}
 
-	valueData := make([]byte, valueSize)
-	bytesRead, err := reader.Read(valueData)
+func readValue(reader *bufio.Reader, version int) (string, error) {
+	value, err := readValueBytes(reader, version)
 	if err != nil {
 		return "", err
 	}
+	return string(value), nil
+}
+
+func readValueBytes(reader *bufio.Reader, version int) ([]byte, error) {
+	value, _, err := readTypedValue(reader, version)
+	return value, err
+}
+
+func readTypedValue(reader *bufio.Reader, version int) ([]byte, valueType, error) {
+	return readTimedValue(reader, version, nil, "", nil)
+}
 
-	if bytesRead != valueSize {
-		return "", fmt.Errorf("incomplete value read: got %d bytes, expected %d", bytesRead, valueSize)
+// readTimedValue is readTypedValue decompressing values with dict and
+// recording the read of the record and its decoding as phases of op. Records
+// in the fixed format are read and decoded in one step. Unless key is empty,
+// a record of another key fails with an *indexDivergenceError.
+func readTimedValue(reader *bufio.Reader, version int, dict *dictionary, key string, op *opTimer) ([]byte, valueType, error) {
+	if version == formatFixed {
+		defer op.phase("read")
+		return readFixedValue(reader, key)
 	}
 
-	var storedChecksum [20]byte
-	checksumBytesRead, err := reader.Read(storedChecksum[:])
+	bodySize, err := binary.ReadUvarint(reader)
+	if err == io.EOF {
+		return nil, 0, err
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to read checksum: %w", err)
+		return nil, 0, fmt.Errorf("%w: invalid record length: %v", ErrCorruptedData, err)
 	}
-
-	if checksumBytesRead != checksumSize {
-		return "", fmt.Errorf("incomplete checksum read: got %d bytes, expected %d", checksumBytesRead, checksumSize)
+	if bodySize > maxRecordSize {
+		return nil, 0, fmt.Errorf("%w: invalid record length %d", ErrCorruptedData, bodySize)
 	}
 
-	expectedChecksum := sha1.Sum(valueData)
-	if expectedChecksum != storedChecksum {
-		return "", fmt.Errorf("checksum mismatch: data corruption detected")
+	body := make([]byte, bodySize)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, 0, fmt.Errorf("%w: incomplete record read: %v", ErrCorruptedData, err)
 	}
+	op.phase("read")
+	defer op.phase("decode")
 
-	return string(valueData), nil
+	var record entry
+	if err := record.decodeBody(body); err != nil {
+		return nil, 0, err
+	}
+	if err := record.verifyChecksum(); err != nil {
+		return nil, 0, err
+	}
+	if key != "" && record.key != key {
+		return nil, 0, &indexDivergenceError{key: key, found: record.key}
+	}
+	if record.compressed {
+		if dict == nil {
+			return nil, 0, fmt.Errorf("%w: value of key '%s' is compressed but its segment has no dictionary", ErrCorruptedData, record.key)
+		}
+		value, err := dict.decompress(record.value)
+		return value, record.valueType, err
+	}
+	return record.value, record.valueType, nil
 }
 
+// Encode returns the record in the current segment format.
 func (e *entry) Encode() []byte {
 	e.checksum = e.calculateChecksum()
 
-	keyLength := len(e.key)
-	valueLength := len(e.value)
-	totalSize := keyLength + valueLength + totalHeaderSize
-
-	buffer := make([]byte, totalSize)
-
-	binary.LittleEndian.PutUint32(buffer, uint32(totalSize))
-
-	binary.LittleEndian.PutUint32(buffer[headerSize:], uint32(keyLength))
+	buffer := make([]byte, 0, e.GetLength())
+	buffer = binary.AppendUvarint(buffer, uint64(e.bodyLength()))
+	buffer = binary.AppendUvarint(buffer, uint64(len(e.key)))
+	buffer = append(buffer, e.key...)
+	buffer = binary.AppendUvarint(buffer, uint64(len(e.value)))
+	buffer = append(buffer, e.value...)
 
-	copy(buffer[headerSize+keyLengthSize:], e.key)
-
-	valueStart := headerSize + keyLengthSize + keyLength
-	binary.LittleEndian.PutUint32(buffer[valueStart:], uint32(valueLength))
-
-	copy(buffer[valueStart+valueLengthSize:], e.value)
-
-	checksumStart := valueStart + valueLengthSize + valueLength
-	copy(buffer[checksumStart:], e.checksum[:])
-
-	return buffer
+	tag := byte(e.valueType)
+	if e.compressed {
+		tag |= compressedFlag
+	}
+	if e.batched {
+		tag |= batchFlag
+	}
+	if e.expiresAt != 0 {
+		tag |= expiryFlag
+	}
+	buffer = append(buffer, tag)
+	if e.expiresAt != 0 {
+		buffer = binary.LittleEndian.AppendUint64(buffer, uint64(e.expiresAt))
+	}
+	return binary.LittleEndian.AppendUint32(buffer, e.checksum)
 }
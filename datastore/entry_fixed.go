@@ -0,0 +1,92 @@
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// formatFixed is the record format of segments without a header and of
+// version 1 segments: every length is a little-endian uint32 and the record
+// size includes its own prefix.
+const (
+	formatFixed = 1
+
+	fixedSizeLength = 4
+	fixedLengthSize = 4
+	fixedHeaderSize = fixedSizeLength + 2*fixedLengthSize + typeTagSize + checksumSize
+)
+
+func (e *entry) decodeFixed(data []byte) error {
+	if len(data) < fixedHeaderSize {
+		return fmt.Errorf("%w: record of %d bytes is shorter than its header", ErrCorruptedData, len(data))
+	}
+	keyLength := binary.LittleEndian.Uint32(data[fixedSizeLength:])
+
+	keyStart := fixedSizeLength + fixedLengthSize
+	keyEnd := keyStart + int(keyLength)
+	if uint64(keyLength) > uint64(len(data)-fixedHeaderSize) {
+		return fmt.Errorf("%w: key length %d exceeds record size %d", ErrCorruptedData, keyLength, len(data))
+	}
+
+	keyBytes := make([]byte, keyLength)
+	copy(keyBytes, data[keyStart:keyEnd])
+	e.key = string(keyBytes)
+
+	valueStart := keyEnd
+	valueLength := binary.LittleEndian.Uint32(data[valueStart:])
+
+	valueDataStart := valueStart + fixedLengthSize
+	valueDataEnd := valueDataStart + int(valueLength)
+	if uint64(valueLength) > uint64(len(data)-fixedHeaderSize-int(keyLength)) {
+		return fmt.Errorf("%w: value length %d exceeds record size %d", ErrCorruptedData, valueLength, len(data))
+	}
+
+	e.value = make([]byte, valueLength)
+	copy(e.value, data[valueDataStart:valueDataEnd])
+
+	tag := data[valueDataEnd]
+	e.valueType = valueType(tag & valueTypeMask)
+
+	checksumStart := valueDataEnd + typeTagSize
+	e.expiresAt = 0
+	if tag&expiryFlag != 0 {
+		if checksumStart+expirationSize+checksumSize > len(data) {
+			return fmt.Errorf("%w: expiration does not fit into record", ErrCorruptedData)
+		}
+		e.expiresAt = int64(binary.LittleEndian.Uint64(data[checksumStart:]))
+		checksumStart += expirationSize
+	}
+	e.checksum = binary.LittleEndian.Uint32(data[checksumStart:])
+	return nil
+}
+
+func readFixedValue(reader *bufio.Reader, key string) ([]byte, valueType, error) {
+	header, err := reader.Peek(fixedSizeLength)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size := binary.LittleEndian.Uint32(header)
+	if size < fixedHeaderSize {
+		return nil, 0, fmt.Errorf("%w: invalid record size %d", ErrCorruptedData, size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, 0, fmt.Errorf("%w: incomplete record read: %v", ErrCorruptedData, err)
+	}
+
+	var record entry
+	if err := record.decodeFixed(data); err != nil {
+		return nil, 0, err
+	}
+	if err := record.verifyChecksum(); err != nil {
+		return nil, 0, err
+	}
+	if key != "" && record.key != key {
+		return nil, 0, &indexDivergenceError{key: key, found: record.key}
+	}
+	return record.value, record.valueType, nil
+}
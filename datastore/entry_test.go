@@ -3,25 +3,29 @@ package datastore
 import (
 	"bufio"
 	"bytes"
-	"crypto/sha1"
+	"errors"
+	"hash/crc32"
 	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
 )
 
 func TestEntry_EncodeWithChecksum(t *testing.T) {
-	e := entry{key: "key", value: "value"}
+	e := entry{key: "key", value: []byte("value")}
 	encoded := e.Encode()
 
 	var decoded entry
-	decoded.Decode(encoded)
+	decoded.Decode(encoded, segmentFormatVersion)
 
 	if decoded.key != "key" {
 		t.Error("incorrect key")
 	}
-	if decoded.value != "value" {
+	if string(decoded.value) != "value" {
 		t.Error("incorrect value")
 	}
 
-	expectedChecksum := sha1.Sum([]byte("value"))
+	expectedChecksum := crc32.Update(crc32.Checksum([]byte("key"), crcTable), crcTable, []byte("value"))
 	if decoded.checksum != expectedChecksum {
 		t.Error("incorrect checksum")
 	}
@@ -32,24 +36,24 @@ func TestEntry_EncodeWithChecksum(t *testing.T) {
 }
 
 func TestEntry_ChecksumVerification(t *testing.T) {
-	e := entry{key: "testkey", value: "testvalue"}
+	e := entry{key: "testkey", value: []byte("testvalue")}
 
 	e.checksum = e.calculateChecksum()
 	if err := e.verifyChecksum(); err != nil {
 		t.Errorf("Expected valid checksum to pass, got error: %v", err)
 	}
 
-	e.checksum = sha1.Sum([]byte("corrupted_value"))
-	if err := e.verifyChecksum(); err == nil {
+	e.checksum = crc32.Checksum([]byte("corrupted_value"), crcTable)
+	if err := e.verifyChecksum(); !errors.Is(err, ErrCorruptedData) {
 		t.Error("Expected invalid checksum to fail, but it passed")
 	}
 }
 
 func TestReadValueWithChecksum(t *testing.T) {
-	e := entry{key: "key", value: "value"}
+	e := entry{key: "key", value: []byte("value")}
 	data := e.Encode()
 
-	v, err := readValue(bufio.NewReader(bytes.NewReader(data)))
+	v, err := readValue(bufio.NewReader(bytes.NewReader(data)), segmentFormatVersion)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -59,13 +63,13 @@ func TestReadValueWithChecksum(t *testing.T) {
 }
 
 func TestReadValueWithCorruptedChecksum(t *testing.T) {
-	e := entry{key: "key", value: "value"}
+	e := entry{key: "key", value: []byte("value")}
 	data := e.Encode()
 
 	data[len(data)-1] = data[len(data)-1] ^ 0xFF
 
-	_, err := readValue(bufio.NewReader(bytes.NewReader(data)))
-	if err == nil {
+	_, err := readValue(bufio.NewReader(bytes.NewReader(data)), segmentFormatVersion)
+	if !errors.Is(err, ErrCorruptedData) {
 		t.Error("Expected checksum verification to fail with corrupted data, but it passed")
 	}
 
@@ -75,16 +79,14 @@ func TestReadValueWithCorruptedChecksum(t *testing.T) {
 }
 
 func TestReadValueWithCorruptedData(t *testing.T) {
-	e := entry{key: "key", value: "original_value"}
+	e := entry{key: "key", value: []byte("original_value")}
 	data := e.Encode()
 
-	valueStart := 4 + 4 + len(e.key) + 4
-	if valueStart < len(data)-20 {
-		data[valueStart] = data[valueStart] ^ 0xFF
-	}
+	valueStart := bytes.Index(data, e.value)
+	data[valueStart] = data[valueStart] ^ 0xFF
 
-	_, err := readValue(bufio.NewReader(bytes.NewReader(data)))
-	if err == nil {
+	_, err := readValue(bufio.NewReader(bytes.NewReader(data)), segmentFormatVersion)
+	if !errors.Is(err, ErrCorruptedData) {
 		t.Error("Expected checksum verification to fail with corrupted value data, but it passed")
 	}
 
@@ -108,16 +110,16 @@ func TestEntry_ChecksumConsistency(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.key+"_"+tc.value, func(t *testing.T) {
-			e := entry{key: tc.key, value: tc.value}
+			e := entry{key: tc.key, value: []byte(tc.value)}
 
 			encoded := e.Encode()
 			var decoded entry
-			decoded.Decode(encoded)
+			decoded.Decode(encoded, segmentFormatVersion)
 
 			if decoded.key != tc.key {
 				t.Errorf("Key mismatch: expected %s, got %s", tc.key, decoded.key)
 			}
-			if decoded.value != tc.value {
+			if string(decoded.value) != tc.value {
 				t.Errorf("Value mismatch: expected %s, got %s", tc.value, decoded.value)
 			}
 
@@ -125,7 +127,7 @@ func TestEntry_ChecksumConsistency(t *testing.T) {
 				t.Errorf("Checksum verification failed: %v", err)
 			}
 
-			v, err := readValue(bufio.NewReader(bytes.NewReader(encoded)))
+			v, err := readValue(bufio.NewReader(bytes.NewReader(encoded)), segmentFormatVersion)
 			if err != nil {
 				t.Fatalf("readValue failed: %v", err)
 			}
@@ -135,3 +137,105 @@ func TestEntry_ChecksumConsistency(t *testing.T) {
 		})
 	}
 }
+
+func TestReadValueWithCorruptedKey(t *testing.T) {
+	e := entry{key: "key", value: []byte("value")}
+	data := e.Encode()
+
+	data[bytes.Index(data, []byte(e.key))] ^= 0xFF
+
+	_, err := readValue(bufio.NewReader(bytes.NewReader(data)), segmentFormatVersion)
+	if !errors.Is(err, ErrCorruptedData) {
+		t.Errorf("Expected a damaged key to be detected, got %v", err)
+	}
+
+	var decoded entry
+	if err := decoded.Decode(data, segmentFormatVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.verifyChecksum(); !errors.Is(err, ErrCorruptedData) {
+		t.Errorf("Expected a damaged key to fail verification, got %v", err)
+	}
+}
+
+func TestEntry_DecodeCorruptedLengths(t *testing.T) {
+	e := entry{key: "key", value: []byte("value")}
+
+	keyLength := e.Encode()
+	keyLength[1] = 0x7f
+
+	valueLength := e.Encode()
+	valueLength[bytes.Index(valueLength, e.value)-1] = 0x7f
+
+	recordLength := e.Encode()
+	recordLength[0] = 0x7f
+
+	cases := map[string][]byte{
+		"key length":    keyLength,
+		"value length":  valueLength,
+		"record length": recordLength,
+		"short":         e.Encode()[:5],
+	}
+	for name, data := range cases {
+		var decoded entry
+		if err := decoded.Decode(data, segmentFormatVersion); !errors.Is(err, ErrCorruptedData) {
+			t.Errorf("%s: expected ErrCorruptedData, got %v", name, err)
+		}
+		if _, err := readValue(bufio.NewReader(bytes.NewReader(data)), segmentFormatVersion); !errors.Is(err, ErrCorruptedData) {
+			t.Errorf("%s: expected readValue to fail with ErrCorruptedData, got %v", name, err)
+		}
+	}
+}
+
+func TestEntry_VarintEncoding(t *testing.T) {
+	e := entry{key: "key", value: []byte("value")}
+	if length := len(e.Encode()); length != 16 || int64(length) != e.GetLength() {
+		t.Errorf("Expected a 16 byte record, got %d (GetLength %d)", length, e.GetLength())
+	}
+
+	large := entry{key: string(make([]byte, 300)), value: make([]byte, 70000), expiresAt: 42}
+	encoded := large.Encode()
+	if int64(len(encoded)) != large.GetLength() {
+		t.Errorf("Expected GetLength %d to match the encoding, got %d", large.GetLength(), len(encoded))
+	}
+	if size, ok := peekRecordSize(encoded, segmentFormatVersion); !ok || size != int64(len(encoded)) {
+		t.Errorf("Expected record size %d, got %d", len(encoded), size)
+	}
+
+	var decoded entry
+	if err := decoded.Decode(encoded, segmentFormatVersion); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.key != large.key || len(decoded.value) != len(large.value) || decoded.expiresAt != 42 {
+		t.Error("Expected the large record to round-trip")
+	}
+}
+
+func TestEntry_FixedFormat(t *testing.T) {
+	record := testutil.PutWithExpiry("key", "value", time.Unix(0, 42))
+	data := record.EncodeFixed()
+
+	var decoded entry
+	if err := decoded.Decode(data, formatFixed); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.verifyChecksum(); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.key != "key" || string(decoded.value) != "value" || decoded.expiresAt != 42 {
+		t.Errorf("Unexpected record %+v", decoded)
+	}
+	if size, ok := peekRecordSize(data, formatFixed); !ok || size != int64(len(data)) {
+		t.Errorf("Expected record size %d, got %d", len(data), size)
+	}
+
+	value, err := readValue(bufio.NewReader(bytes.NewReader(data)), formatFixed)
+	if err != nil || value != "value" {
+		t.Errorf("Expected value, got %q (%v)", value, err)
+	}
+
+	data[bytes.Index(data, []byte("value"))] ^= 0xFF
+	if _, err := readValue(bufio.NewReader(bytes.NewReader(data)), formatFixed); !errors.Is(err, ErrCorruptedData) {
+		t.Errorf("Expected ErrCorruptedData, got %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+package datastore
+
+import "errors"
+
+var (
+	ErrKeyNotFound   = errors.New("key not found in datastore")
+	ErrCorruptedData = errors.New("data corruption detected")
+	ErrDBClosed      = errors.New("database is closed")
+	ErrValueTooLarge = errors.New("value too large")
+	ErrKeyTooLarge   = errors.New("key too large")
+	ErrTypeMismatch  = errors.New("value type mismatch")
+	// ErrNotDirectory is returned by Open when the database path exists but
+	// is not a directory.
+	ErrNotDirectory = errors.New("database path is not a directory")
+	// ErrDirectoryLocked is returned by Open when another process, or another
+	// Db in this one, already uses the directory.
+	ErrDirectoryLocked = errors.New("database directory is locked")
+	// ErrUnsupportedFormat is returned by Open for segments written by a newer
+	// version of the datastore.
+	ErrUnsupportedFormat = errors.New("unsupported segment format")
+	// ErrWriteQueueFull is returned by writes that find the write queue
+	// full when WithWriteQueueShedding is set.
+	ErrWriteQueueFull = errors.New("write queue is full")
+	// ErrAccessDenied is returned by reads and writes that the Authorizer
+	// set with WithAuthorizer does not allow.
+	ErrAccessDenied = errors.New("access denied")
+)
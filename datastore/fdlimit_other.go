@@ -0,0 +1,7 @@
+//go:build !unix
+
+package datastore
+
+func checkFileLimit(maxOpenSegments int) error {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package datastore
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// fdHeadroom leaves descriptors for the active segment, compaction output
+// and whatever else the process has open besides segment reads.
+const fdHeadroom = 32
+
+func checkFileLimit(maxOpenSegments int) error {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return fmt.Errorf("failed to read the open file limit: %w", err)
+	}
+	if uint64(maxOpenSegments)+fdHeadroom > uint64(limit.Cur) {
+		return fmt.Errorf("max open segments %d does not fit into the open file limit %d", maxOpenSegments, limit.Cur)
+	}
+	return nil
+}
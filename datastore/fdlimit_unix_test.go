@@ -0,0 +1,11 @@
+//go:build unix
+
+package datastore
+
+import "testing"
+
+func TestOpen_MaxOpenSegmentsAboveFileLimit(t *testing.T) {
+	if _, err := Open(t.TempDir(), WithMaxOpenSegments(1<<40)); err == nil {
+		t.Error("Expected a budget above the open file limit to be rejected")
+	}
+}
@@ -0,0 +1,218 @@
+package datastore
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const defaultMaxOpenSegments = 128
+
+// fileHandles keeps read handles of segment files open between reads, but
+// never more than max at a time. Idle handles are closed in least recently
+// used order to make room; if every handle is busy, Open waits for one to be
+// released. Readers share a handle, so every reader gets its own offset and
+// reads through ReadAt.
+type fileHandles struct {
+	storage
+	max int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	handles map[string]*sharedHandle
+	idle    *list.List
+	open    int
+	closed  bool
+}
+
+type sharedHandle struct {
+	path    string
+	file    readFile
+	refs    int
+	removed bool
+	// deleteOnClose defers deleting the file until its last reader is done.
+	deleteOnClose bool
+	element       *list.Element
+}
+
+func newFileHandles(fs storage, max int) *fileHandles {
+	h := &fileHandles{
+		storage: fs,
+		max:     max,
+		handles: make(map[string]*sharedHandle),
+		idle:    list.New(),
+	}
+	h.cond = sync.NewCond(&h.mu)
+	return h
+}
+
+func (h *fileHandles) Open(path string) (readFile, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for {
+		if h.closed {
+			return h.storage.Open(path)
+		}
+		if handle, ok := h.handles[path]; ok {
+			h.retain(handle)
+			return &handleReader{handles: h, handle: handle}, nil
+		}
+		if h.open < h.max {
+			break
+		}
+		if !h.evictIdle() {
+			h.cond.Wait()
+		}
+	}
+
+	file, err := h.storage.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	handle := &sharedHandle{path: path, file: file, refs: 1}
+	h.handles[path] = handle
+	h.open++
+	return &handleReader{handles: h, handle: handle}, nil
+}
+
+// Remove deletes a segment file once no reader holds its handle any more,
+// so that reads in progress finish on every platform, and closes the handle
+// so that the space of the file is released. Reads that open the file
+// afterwards do not share the handle, and fail once the file is gone.
+func (h *fileHandles) Remove(path string) error {
+	h.mu.Lock()
+	if handle, ok := h.handles[path]; ok {
+		delete(h.handles, path)
+		handle.removed = true
+		h.cond.Broadcast()
+		if handle.refs > 0 {
+			handle.deleteOnClose = true
+			h.mu.Unlock()
+			return nil
+		}
+		h.idle.Remove(handle.element)
+		h.closeHandle(handle)
+	}
+	h.mu.Unlock()
+
+	return h.storage.Remove(path)
+}
+
+// closeAll closes every handle. Readers that still hold one can finish; later
+// reads open files without caching them.
+func (h *fileHandles) closeAll() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	var err error
+	for path, handle := range h.handles {
+		delete(h.handles, path)
+		handle.removed = true
+		if handle.refs == 0 {
+			h.idle.Remove(handle.element)
+			err = errors.Join(err, h.closeHandle(handle))
+		}
+	}
+	h.cond.Broadcast()
+	return err
+}
+
+func (h *fileHandles) openCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.open
+}
+
+func (h *fileHandles) retain(handle *sharedHandle) {
+	if handle.refs == 0 {
+		h.idle.Remove(handle.element)
+		handle.element = nil
+	}
+	handle.refs++
+}
+
+func (h *fileHandles) release(handle *sharedHandle) {
+	h.mu.Lock()
+	handle.refs--
+	if handle.refs > 0 {
+		h.mu.Unlock()
+		return
+	}
+	h.cond.Broadcast()
+	if !handle.removed {
+		handle.element = h.idle.PushFront(handle)
+		h.mu.Unlock()
+		return
+	}
+	h.closeHandle(handle)
+	h.mu.Unlock()
+
+	if handle.deleteOnClose {
+		if err := h.storage.Remove(handle.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Warning: failed to remove %s: %v\n", handle.path, err)
+		}
+	}
+}
+
+func (h *fileHandles) evictIdle() bool {
+	oldest := h.idle.Back()
+	if oldest == nil {
+		return false
+	}
+	handle := h.idle.Remove(oldest).(*sharedHandle)
+	delete(h.handles, handle.path)
+	h.closeHandle(handle)
+	return true
+}
+
+func (h *fileHandles) closeHandle(handle *sharedHandle) error {
+	h.open--
+	return handle.file.Close()
+}
+
+type handleReader struct {
+	handles *fileHandles
+	handle  *sharedHandle
+	offset  int64
+	once    sync.Once
+}
+
+func (r *handleReader) Read(p []byte) (int, error) {
+	n, err := r.handle.file.ReadAt(p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *handleReader) ReadAt(p []byte, offset int64) (int, error) {
+	return r.handle.file.ReadAt(p, offset)
+}
+
+func (r *handleReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	default:
+		return 0, errors.New("seek relative to the end of a shared segment handle")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative seek offset")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *handleReader) Close() error {
+	r.once.Do(func() {
+		r.handles.release(r.handle)
+	})
+	return nil
+}
@@ -0,0 +1,197 @@
+package datastore
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+type countingStorage struct {
+	storage
+	mu     sync.Mutex
+	opened int
+	closed int
+}
+
+func (s *countingStorage) Open(path string) (readFile, error) {
+	file, err := s.storage.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.opened++
+	s.mu.Unlock()
+	return &countingFile{readFile: file, storage: s}, nil
+}
+
+type countingFile struct {
+	readFile
+	storage *countingStorage
+}
+
+func (f *countingFile) Close() error {
+	f.storage.mu.Lock()
+	f.storage.closed++
+	f.storage.mu.Unlock()
+	return f.readFile.Close()
+}
+
+func (s *countingStorage) stillOpen() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.opened - s.closed
+}
+
+func TestFileHandles(t *testing.T) {
+	mem := newMemStorage()
+	for i := 0; i < 3; i++ {
+		file, _ := mem.OpenAppend(fmt.Sprintf("dir/segment%d", i), defaultFileMode)
+		file.Write([]byte(fmt.Sprintf("data%d", i)))
+	}
+	fs := &countingStorage{storage: mem}
+	handles := newFileHandles(fs, 2)
+
+	read := func(path string) string {
+		t.Helper()
+		file, err := handles.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	t.Run("reuses idle handles", func(t *testing.T) {
+		read("dir/segment0")
+		read("dir/segment0")
+		if fs.opened != 1 {
+			t.Errorf("Expected one open, got %d", fs.opened)
+		}
+	})
+
+	t.Run("evicts the least recently used handle", func(t *testing.T) {
+		read("dir/segment1")
+		read("dir/segment0")
+		if value := read("dir/segment2"); value != "data2" {
+			t.Errorf("Expected data2, got %q", value)
+		}
+		if handles.openCount() != 2 || fs.stillOpen() != 2 {
+			t.Errorf("Expected 2 open handles, got %d (%d files)", handles.openCount(), fs.stillOpen())
+		}
+		opened := fs.opened
+		read("dir/segment0")
+		if fs.opened != opened {
+			t.Error("Expected the recently used handle to stay open")
+		}
+	})
+
+	t.Run("waits for a busy handle", func(t *testing.T) {
+		first, _ := handles.Open("dir/segment0")
+		second, _ := handles.Open("dir/segment2")
+
+		opened := make(chan struct{})
+		go func() {
+			file, err := handles.Open("dir/segment1")
+			if err == nil {
+				file.Close()
+			}
+			close(opened)
+		}()
+
+		select {
+		case <-opened:
+			t.Fatal("Expected Open to wait while the budget is in use")
+		default:
+		}
+		first.Close()
+		<-opened
+		second.Close()
+
+		if fs.stillOpen() > 2 {
+			t.Errorf("Expected at most 2 open files, got %d", fs.stillOpen())
+		}
+	})
+
+	t.Run("deletes removed files after the last reader", func(t *testing.T) {
+		file, _ := handles.Open("dir/segment1")
+		before := fs.stillOpen()
+		if err := handles.Remove("dir/segment1"); err != nil {
+			t.Fatal(err)
+		}
+		if fs.stillOpen() != before {
+			t.Error("Expected the handle to stay open while in use")
+		}
+		if names, _ := mem.ListFiles("dir"); len(names) != 3 {
+			t.Errorf("Expected the file to stay while in use, got %v", names)
+		}
+		if data, err := io.ReadAll(file); err != nil || string(data) != "data1" {
+			t.Errorf("Expected the reader to finish, got %q (%v)", data, err)
+		}
+		file.Close()
+		if fs.stillOpen() != before-1 {
+			t.Error("Expected the handle to be closed once released")
+		}
+		if names, _ := mem.ListFiles("dir"); len(names) != 2 {
+			t.Errorf("Expected the file to be deleted once released, got %v", names)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		if err := handles.closeAll(); err != nil {
+			t.Fatal(err)
+		}
+		if fs.stillOpen() != 0 {
+			t.Errorf("Expected every handle to be closed, got %d", fs.stillOpen())
+		}
+		if value := read("dir/segment0"); value != "data0" {
+			t.Errorf("Expected reads after close to work, got %q", value)
+		}
+		if fs.stillOpen() != 0 {
+			t.Error("Expected reads after close not to keep handles")
+		}
+	})
+}
+
+func TestDb_MaxOpenSegments(t *testing.T) {
+	database, err := Open(t.TempDir(), WithSegmentSize(64), WithMaxOpenSegments(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	for i := 0; i < 40; i++ {
+		if err := database.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value, err := database.Get(fmt.Sprintf("key%d", i))
+			if err != nil || value != fmt.Sprintf("value%d", i) {
+				t.Errorf("Get(key%d) = %q, %v", i, value, err)
+			}
+			if open := database.handles.openCount(); open > 2 {
+				t.Errorf("Expected at most 2 open segments, got %d", open)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestOpen_MaxOpenSegmentsValidation(t *testing.T) {
+	if _, err := Open(t.TempDir(), WithMaxOpenSegments(0)); err == nil {
+		t.Error("Expected a zero budget to be rejected")
+	}
+	if _, err := Open("", WithInMemory(), WithMaxOpenSegments(1<<40)); err != nil {
+		t.Errorf("Expected in-memory storage to ignore the file limit, got %v", err)
+	}
+}
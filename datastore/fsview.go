@@ -0,0 +1,210 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// FS returns a read-only view of the keys as a file system, for tools like
+// http.FileServer and fs.WalkDir. A key is a slash-separated path: the value
+// of "templates/index.html" is the file index.html in the directory
+// templates. Directories exist as long as some key lies under them. Keys that
+// are not valid fs paths, such as ones with a leading slash or empty
+// elements, are not visible, and a key that is also the prefix of other keys
+// shows up as a file.
+func (db *Db) FS() fs.FS {
+	return dbFS{db: db}
+}
+
+type dbFS struct {
+	db *Db
+}
+
+var (
+	_ fs.ReadFileFS = dbFS{}
+	_ fs.ReadDirFS  = dbFS{}
+	_ fs.StatFS     = dbFS{}
+)
+
+func (f dbFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		value, err := f.db.GetBytes(name)
+		if err == nil {
+			return &valueFile{Reader: bytes.NewReader(value), info: fileInfo{name: path.Base(name), size: int64(len(value))}}, nil
+		}
+		if !errors.Is(err, ErrKeyNotFound) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
+	entries, err := f.readDir("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &dirFile{info: fileInfo{name: path.Base(name), dir: true}, entries: entries}, nil
+}
+
+func (f dbFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	value, err := f.db.GetBytes(name)
+	if errors.Is(err, ErrKeyNotFound) {
+		if _, dirErr := f.readDir("readfile", name); dirErr == nil {
+			return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.New("is a directory")}
+		}
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrNotExist}
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return value, nil
+}
+
+func (f dbFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		if _, err := f.db.GetBytes(name); err == nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+		}
+	}
+	return f.readDir("readdir", name)
+}
+
+func (f dbFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: errors.Unwrap(err)}
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+// readDir lists the directory name in key order. A directory without any
+// key under it does not exist, except for the root.
+func (f dbFS) readDir(op, name string) ([]fs.DirEntry, error) {
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+
+	var entries []fs.DirEntry
+	seen := make(map[string]bool)
+	it := f.db.Scan(prefix, WithOrder(KeyOrder))
+	for it.Next() {
+		key := it.Key()
+		if !fs.ValidPath(key) {
+			continue
+		}
+		child, _, isDir := strings.Cut(key[len(prefix):], "/")
+		if seen[child] {
+			continue
+		}
+		seen[child] = true
+		if isDir {
+			entries = append(entries, dirEntry{info: fileInfo{name: child, dir: true}})
+		} else {
+			entries = append(entries, dirEntry{fs: f, key: key, info: fileInfo{name: child}})
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return entries, nil
+}
+
+type fileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) ModTime() time.Time { return time.Time{} }
+func (i fileInfo) IsDir() bool        { return i.dir }
+func (i fileInfo) Sys() any           { return nil }
+
+func (i fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// dirEntry reads the value of a file only when its size is asked for.
+type dirEntry struct {
+	fs   dbFS
+	key  string
+	info fileInfo
+}
+
+func (e dirEntry) Name() string      { return e.info.name }
+func (e dirEntry) IsDir() bool       { return e.info.dir }
+func (e dirEntry) Type() fs.FileMode { return e.info.Mode().Type() }
+
+func (e dirEntry) Info() (fs.FileInfo, error) {
+	if e.info.dir {
+		return e.info, nil
+	}
+	value, err := e.fs.db.GetBytes(e.key)
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			err = fs.ErrNotExist
+		}
+		return nil, &fs.PathError{Op: "stat", Path: e.key, Err: err}
+	}
+	info := e.info
+	info.size = int64(len(value))
+	return info, nil
+}
+
+// valueFile is a value opened as a file. It can seek, which http.FileServer
+// needs to serve ranges.
+type valueFile struct {
+	*bytes.Reader
+	info fileInfo
+}
+
+func (f *valueFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *valueFile) Close() error               { return nil }
+
+type dirFile struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dirFile) Close() error               { return nil }
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(remaining))
+	d.offset += n
+	return remaining[:n], nil
+}
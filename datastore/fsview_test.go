@@ -0,0 +1,89 @@
+package datastore
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDb_FS(t *testing.T) {
+	database, err := Open("", WithInMemory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	for key, value := range map[string]string{
+		"index.html":           "<h1>home</h1>",
+		"templates/page.tmpl":  "{{.Title}}",
+		"templates/mail/reset": "reset your password",
+		"/absolute":            "hidden",
+		"double//slash":        "hidden",
+	} {
+		if err := database.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.PutInt64("counters/visits", 42); err != nil {
+		t.Fatal(err)
+	}
+	fsys := database.FS()
+
+	t.Run("passes fstest", func(t *testing.T) {
+		if err := fstest.TestFS(fsys, "index.html", "templates/page.tmpl", "templates/mail/reset", "counters/visits"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("lists directories", func(t *testing.T) {
+		entries, err := fs.ReadDir(fsys, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		want := []string{"counters", "index.html", "templates"}
+		if len(names) != len(want) {
+			t.Fatalf("Expected %v, got %v", want, names)
+		}
+		for i := range want {
+			if names[i] != want[i] {
+				t.Errorf("Expected %v, got %v", want, names)
+			}
+		}
+	})
+
+	t.Run("reads values as files", func(t *testing.T) {
+		value, err := fs.ReadFile(fsys, "counters/visits")
+		if err != nil || string(value) != "42" {
+			t.Errorf("Expected '42', got %q, %v", value, err)
+		}
+		if _, err := fs.ReadFile(fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Expected fs.ErrNotExist, got %v", err)
+		}
+		if _, err := fs.ReadFile(fsys, "templates"); err == nil {
+			t.Error("Expected reading a directory to fail")
+		}
+	})
+
+	t.Run("serves over HTTP", func(t *testing.T) {
+		server := httptest.NewServer(http.FileServerFS(fsys))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/templates/page.tmpl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "{{.Title}}" {
+			t.Errorf("Expected 200 with the template, got %d %q", resp.StatusCode, body)
+		}
+	})
+}
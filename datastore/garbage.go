@@ -0,0 +1,159 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// garbageMinimumShare sets the least garbage that triggers a compaction as a
+// share of the segment size, so that a handful of overwrites in a nearly
+// empty database does not rotate and compact after every write.
+const garbageMinimumShare = 4
+
+// Garbage describes how much of the data in the segments is held by records
+// that a newer record of the same key has replaced.
+type Garbage struct {
+	DataBytes   int64
+	DeadBytes   int64
+	Ratio       float64
+	Compactions int64
+}
+
+// Garbage returns the dead bytes tracked with WithGarbageCompaction and how
+// many compactions they triggered since the database was opened.
+func (db *Db) Garbage() Garbage {
+	db.segmentLock.RLock()
+	defer db.segmentLock.RUnlock()
+
+	stats := Garbage{Compactions: db.garbageCompactions.Load()}
+	for _, segment := range db.segments {
+		stats.DataBytes += segment.dataBytes.Load()
+		stats.DeadBytes += segment.deadBytes.Load()
+	}
+	if stats.DataBytes > 0 {
+		stats.Ratio = float64(stats.DeadBytes) / float64(stats.DataBytes)
+	}
+	return stats
+}
+
+// recordSize reads the size of the record at position from its header.
+func (segment *Segment) recordSize(position int64) (int64, error) {
+	reader, release, err := segment.recordReader(position)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	header, err := reader.Peek(binary.MaxVarintLen64)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	size, ok := peekRecordSize(header, segment.version)
+	if !ok || size <= 0 {
+		return 0, fmt.Errorf("%w: no record at %d in %s", ErrCorruptedData, position, segment.path)
+	}
+	return size, nil
+}
+
+// markReplaced counts the record key currently points to as dead, before
+// the writer indexes the record that replaces it.
+func (db *Db) markReplaced(key string) {
+	segment, position, err := db.findKeyLocation(key)
+	if err != nil {
+		return
+	}
+	if size, err := segment.recordSize(position); err == nil {
+		segment.deadBytes.Add(size)
+	}
+}
+
+// measureGarbage sets the data and dead bytes of every segment after
+// recovery: whatever is not the newest record of a key is dead.
+func (db *Db) measureGarbage() error {
+	for i, segment := range db.segments {
+		dataStart, end, err := db.segmentSpan(segment)
+		if err != nil {
+			return err
+		}
+		if i == len(db.segments)-1 {
+			end = db.currentOffset
+		}
+
+		live, err := db.liveBytes(segment, db.segments[i+1:])
+		if err != nil {
+			return err
+		}
+		segment.dataBytes.Store(end - dataStart)
+		segment.deadBytes.Store(end - dataStart - live)
+	}
+	return nil
+}
+
+// segmentSpan returns the offsets of the first record of segment and of the
+// end of its file.
+func (db *Db) segmentSpan(segment *Segment) (dataStart, end int64, err error) {
+	file, err := db.fs.Open(segment.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, dataStart, err = readSegmentHeader(file)
+	file.Close()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	appended, err := db.fs.OpenAppend(segment.path, db.fileMode)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer appended.Close()
+	end, err = appended.Size()
+	return dataStart, end, err
+}
+
+// liveBytes adds up the records of segment whose keys no segment of newer
+// has replaced.
+func (db *Db) liveBytes(segment *Segment, newer []*Segment) (int64, error) {
+	var live int64
+	var err error
+	segment.index.forEach(func(key string, position, _ int64) bool {
+		for _, other := range newer {
+			if _, _, found := other.index.get(key); found {
+				return true
+			}
+		}
+		var size int64
+		size, err = segment.recordSize(position)
+		live += size
+		return err == nil
+	})
+	return live, err
+}
+
+// compactGarbage runs on the writer goroutine after every batch of writes.
+// Once the dead bytes exceed the ratio set with WithGarbageCompaction, it
+// seals the active segment, so that the garbage in it can be reclaimed too,
+// and compacts every sealed segment regardless of their number.
+func (db *Db) compactGarbage() {
+	if db.garbageRatio <= 0 || db.pendingCompactions.Load() > 0 || db.isShuttingDown() {
+		return
+	}
+	stats := db.Garbage()
+	if stats.Ratio <= db.garbageRatio || stats.DeadBytes < db.maxSegmentSize/garbageMinimumShare {
+		return
+	}
+
+	fmt.Printf("Garbage ratio %.2f exceeds %.2f, compacting %d dead bytes\n", stats.Ratio, db.garbageRatio, stats.DeadBytes)
+	if db.getCurrentSegment().dataBytes.Load() > 0 {
+		if err := db.initializeNewSegment(); err != nil {
+			return
+		}
+	}
+	if db.pendingCompactions.Load() == 0 {
+		db.startCompaction(1)
+	}
+	// Counted once the compaction is pending, so that whoever sees the count
+	// can wait for it.
+	db.garbageCompactions.Add(1)
+}
@@ -0,0 +1,78 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDb_GarbageTracking(t *testing.T) {
+	dir := t.TempDir()
+	database, err := Open(dir, WithGarbageCompaction(0.9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"1", "2", "3"} {
+		if err := database.Put("a", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.Put("b", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	record := entry{key: "a", value: []byte("1")}
+	expected := Garbage{DataBytes: 4 * record.GetLength(), DeadBytes: 2 * record.GetLength(), Ratio: 0.5}
+	if stats := database.Garbage(); stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	database, err = Open(dir, WithGarbageCompaction(0.9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	if stats := database.Garbage(); stats != expected {
+		t.Errorf("Expected %+v after reopening, got %+v", expected, stats)
+	}
+}
+
+func TestDb_GarbageCompaction(t *testing.T) {
+	database, err := Open(t.TempDir(), WithSegmentSize(1024), WithGarbageCompaction(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := database.Put(fmt.Sprintf("cold%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Garbage written while the compaction runs is only checked on the next
+	// write, so the writes stop once it is triggered.
+	last := ""
+	for i := 0; i < 100 && database.Garbage().Compactions == 0; i++ {
+		last = fmt.Sprintf("value%d", i)
+		if err := database.Put("hot", last); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for database.Garbage().Compactions == 0 || database.pendingCompactions.Load() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected garbage to trigger a compaction, got %+v", database.Garbage())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if stats := database.Garbage(); stats.Ratio > 0.5 {
+		t.Errorf("Expected compaction to reclaim the garbage, got %+v", stats)
+	}
+	assertValue(t, database, "hot", last)
+	assertValue(t, database, "cold9", "value")
+}
@@ -0,0 +1,139 @@
+package datastore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// GetMany returns the values of all keys that are present in the datastore.
+// Missing keys are omitted from the result.
+func (db *Db) GetMany(keys []string) (map[string]string, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.closeMutex.RUnlock()
+
+	locations, err := db.getKeyPositions(keys)
+	if err != nil {
+		return nil, err
+	}
+	values, err := db.readLocations(locations)
+	if err != nil && anyRetired(locations) {
+		// Compaction swapped a segment out after the lookup, so the keys
+		// are looked up again in the segments that replaced it.
+		if locations, err = db.getKeyPositions(keys); err != nil {
+			return nil, err
+		}
+		values, err = db.readLocations(locations)
+	}
+	return values, err
+}
+
+func anyRetired(locations map[string]*KeyLocation) bool {
+	for _, location := range locations {
+		if location.segment.retired.Load() {
+			return true
+		}
+	}
+	return false
+}
+
+// readLocations reads the values at locations, those in different segments
+// in parallel.
+func (db *Db) readLocations(locations map[string]*KeyLocation) (map[string]string, error) {
+	bySegment := make(map[*Segment]map[string]int64)
+	for key, location := range locations {
+		positions, ok := bySegment[location.segment]
+		if !ok {
+			positions = make(map[string]int64)
+			bySegment[location.segment] = positions
+		}
+		positions[key] = location.position
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	results := make(map[string]string, len(locations))
+
+	for segment, positions := range bySegment {
+		wg.Add(1)
+		go func(segment *Segment, positions map[string]int64) {
+			defer wg.Done()
+			values, err := db.readMany(segment, positions)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for key, value := range values {
+				results[key] = value
+			}
+		}(segment, positions)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+func (db *Db) getKeyPositions(keys []string) (map[string]*KeyLocation, error) {
+	if err := db.authorize(context.Background(), AccessRead, keys...); err != nil {
+		return nil, err
+	}
+
+	db.segmentLock.RLock()
+	defer db.segmentLock.RUnlock()
+
+	return locateAll(db.segments, keys, db.clock.Now().UnixNano()), nil
+}
+
+// locateAll looks keys up in segments as of now, and leaves out those not
+// found.
+func locateAll(segments []*Segment, keys []string, now int64) map[string]*KeyLocation {
+	locations := make(map[string]*KeyLocation, len(keys))
+	for _, key := range keys {
+		if segment, position, err := findIn(segments, key, now); err == nil {
+			locations[key] = &KeyLocation{segment, position}
+		}
+	}
+	return locations
+}
+
+func (db *Db) readMany(segment *Segment, positions map[string]int64) (map[string]string, error) {
+	file, err := segment.fs.Open(segment.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string, len(positions))
+	for key, position := range positions {
+		reader := bufio.NewReader(io.NewSectionReader(file, position, 1<<62))
+		value, valueType, err := readTimedValue(reader, segment.version, segment.dict, key, nil)
+		var divergence *indexDivergenceError
+		if errors.As(err, &divergence) {
+			divergence.segment, divergence.position = segment, position
+			value, valueType, err = db.recoverDivergence(divergence)
+			if err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			return nil, fmt.Errorf("checksum verification failed: %w", err)
+		}
+		values[key] = string(formatValue(value, valueType))
+	}
+	return values, nil
+}
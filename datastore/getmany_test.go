@@ -0,0 +1,47 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDb_GetMany(t *testing.T) {
+	database, err := Open(t.TempDir(), WithSegmentSize(300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	keys := make([]string, 0, 10)
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		keys = append(keys, key)
+		if err := database.Put(key, fmt.Sprintf("value_%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.Put("key_3", "updated"); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := database.GetMany(append(keys, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(values) != len(keys) {
+		t.Errorf("Expected %d values, got %d", len(keys), len(values))
+	}
+	if _, ok := values["missing"]; ok {
+		t.Error("Missing key should not be present in result")
+	}
+	for i, key := range keys {
+		expected := fmt.Sprintf("value_%d", i)
+		if key == "key_3" {
+			expected = "updated"
+		}
+		if values[key] != expected {
+			t.Errorf("Value mismatch for %s: expected %s, got %s", key, expected, values[key])
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// A hint file sits next to a sealed segment and lists where each key of the
+// segment is stored, so that recovery can load the index without reading
+// every record. It starts with a header like a segment, followed by the size
+// of the segment it describes, one entry per key, the bloom filter of the
+// segment and a CRC32-C of everything before it. Version 1 hints have no
+// filter; it is rebuilt from the keys. A hint that is damaged or describes
+// a segment of another size is ignored and the segment is replayed instead.
+const (
+	hintSuffix  = ".hint"
+	hintMagic   = "\x89KVH"
+	hintVersion = 2
+)
+
+func hintPath(segmentPath string) string {
+	return segmentPath + hintSuffix
+}
+
+func encodeHint(segment *Segment, size int64) []byte {
+	buffer := make([]byte, segmentHeaderSize, segmentHeaderSize+binary.MaxVarintLen64)
+	copy(buffer, hintMagic)
+	binary.LittleEndian.PutUint16(buffer[len(hintMagic):], hintVersion)
+	buffer = binary.AppendUvarint(buffer, uint64(size))
+
+	buffer = appendIndex(buffer, segment)
+	if filter := segment.filter.Load(); filter != nil {
+		buffer = filter.appendTo(buffer)
+	}
+
+	return binary.LittleEndian.AppendUint32(buffer, crc32.Checksum(buffer, crcTable))
+}
+
+// appendIndex encodes the number of keys of segment followed by the key,
+// position and expiry of each. The caller makes sure no keys are added
+// meanwhile.
+func appendIndex(buffer []byte, segment *Segment) []byte {
+	buffer = binary.AppendUvarint(buffer, uint64(segment.index.len()))
+	segment.index.forEach(func(key string, position, expiresAt int64) bool {
+		buffer = binary.AppendUvarint(buffer, uint64(len(key)))
+		buffer = append(buffer, key...)
+		buffer = binary.AppendUvarint(buffer, uint64(position))
+		buffer = binary.AppendVarint(buffer, expiresAt)
+		return true
+	})
+	return buffer
+}
+
+// decodeIndex reads an index written by appendIndex and returns the data
+// that follows it. Positions must lie before size.
+func decodeIndex(data []byte, size int64) (index keyIndex, expiries map[string]int64, rest []byte, ok bool) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data)) {
+		return nil, nil, nil, false
+	}
+	data = data[n:]
+
+	index = make(keyIndex, count)
+	expiries = make(map[string]int64)
+	for ; count > 0; count-- {
+		keyLength, n := binary.Uvarint(data)
+		if n <= 0 || keyLength > uint64(len(data)-n) {
+			return nil, nil, nil, false
+		}
+		key := string(data[n : n+int(keyLength)])
+		data = data[n+int(keyLength):]
+
+		position, n := binary.Uvarint(data)
+		if n <= 0 || position >= uint64(size) {
+			return nil, nil, nil, false
+		}
+		data = data[n:]
+
+		expiresAt, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, nil, nil, false
+		}
+		data = data[n:]
+
+		index[key] = int64(position)
+		if expiresAt != 0 {
+			expiries[key] = expiresAt
+		}
+	}
+	return index, expiries, data, true
+}
+
+// restoreIndex adds a decoded index to segment.
+func (segment *Segment) restoreIndex(index keyIndex, expiries map[string]int64) {
+	for key, position := range index {
+		segment.setKey(key, position, expiries[key])
+	}
+}
+
+// writeHint records the index of a sealed segment whose data ends at size.
+// Hints only speed up recovery, so callers may ignore the error.
+func (db *Db) writeHint(segment *Segment, size int64) error {
+	path := hintPath(segment.path)
+	_ = db.fs.Remove(path)
+
+	file, err := db.fs.OpenAppend(path, db.fileMode)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(encodeHint(segment, size)); err != nil {
+		file.Close()
+		_ = db.fs.Remove(path)
+		return err
+	}
+	if db.syncPolicy != SyncNever {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			_ = db.fs.Remove(path)
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// loadHint fills the index of segment from its hint file. It reports false,
+// leaving the index untouched, if there is no usable hint for segmentFile.
+func (db *Db) loadHint(segment *Segment, segmentFile io.ReaderAt) bool {
+	file, err := db.fs.Open(hintPath(segment.path))
+	if err != nil {
+		return false
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil || len(data) < segmentHeaderSize+crc32.Size {
+		return false
+	}
+
+	body, checksum := data[:len(data)-crc32.Size], data[len(data)-crc32.Size:]
+	if crc32.Checksum(body, crcTable) != binary.LittleEndian.Uint32(checksum) ||
+		!bytes.HasPrefix(body, []byte(hintMagic)) {
+		return false
+	}
+	version := binary.LittleEndian.Uint16(body[len(hintMagic):])
+	if version == 0 || version > hintVersion {
+		return false
+	}
+	body = body[segmentHeaderSize:]
+
+	size, n := binary.Uvarint(body)
+	if n <= 0 || !hasSize(segmentFile, int64(size)) {
+		return false
+	}
+	index, expiries, rest, ok := decodeIndex(body[n:], int64(size))
+	if !ok {
+		return false
+	}
+	var filter *bloomFilter
+	if version > 1 && len(rest) > 0 {
+		if filter, rest, ok = decodeBloomFilter(rest); !ok {
+			return false
+		}
+	}
+	if len(rest) > 0 {
+		return false
+	}
+	segment.restoreIndex(index, expiries)
+	if filter != nil {
+		segment.filter.Store(filter)
+	}
+
+	if segment == db.getCurrentSegment() {
+		db.currentOffset = int64(size)
+	}
+	return true
+}
+
+// hasSize reports whether file ends exactly at size.
+func hasSize(file io.ReaderAt, size int64) bool {
+	probe := make([]byte, 1)
+	if size > 0 {
+		if _, err := file.ReadAt(probe, size-1); err != nil {
+			return false
+		}
+	}
+	_, err := file.ReadAt(probe, size)
+	return err == io.EOF
+}
@@ -0,0 +1,138 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+func TestDb_HintFiles(t *testing.T) {
+	// sealed fills the first segment with a and b and starts a second one
+	// with c, leaving a hint for the first.
+	sealed := func(t *testing.T) string {
+		t.Helper()
+		dir := t.TempDir()
+		database, err := Open(dir, WithSegmentSize(40))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"a", "b", "c"} {
+			if err := database.Put(key, key+"-value"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		database.Close()
+
+		if _, err := os.Stat(filepath.Join(dir, dataFileName+"0"+hintSuffix)); err != nil {
+			t.Fatalf("Expected a hint for the sealed segment: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, dataFileName+"1"+hintSuffix)); !os.IsNotExist(err) {
+			t.Fatalf("Expected no hint for the active segment, got %v", err)
+		}
+		return dir
+	}
+
+	damageValue := func(t *testing.T, path, value string) {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[bytes.Index(data, []byte(value))] ^= 0xff
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("recovery loads the index from the hint", func(t *testing.T) {
+		dir := sealed(t)
+		// Replaying the segment would drop the damaged record; only the
+		// hint still points at it.
+		damageValue(t, filepath.Join(dir, dataFileName+"0"), "a-value")
+
+		database, err := Open(dir, WithSegmentSize(40))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if _, err := database.Get("a"); !errors.Is(err, ErrCorruptedData) {
+			t.Errorf("Expected the hinted record to be read, got %v", err)
+		}
+		assertValue(t, database, "b", "b-value")
+		assertValue(t, database, "c", "c-value")
+	})
+
+	t.Run("damaged hint is ignored", func(t *testing.T) {
+		dir := sealed(t)
+		damageValue(t, filepath.Join(dir, dataFileName+"0"+hintSuffix), "a")
+
+		database, err := Open(dir, WithSegmentSize(40))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		assertValue(t, database, "a", "a-value")
+		assertValue(t, database, "b", "b-value")
+	})
+
+	t.Run("hint of a segment that grew is ignored", func(t *testing.T) {
+		dir := sealed(t)
+		segment := filepath.Join(dir, dataFileName+"0")
+		file, err := os.OpenFile(segment, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(testutil.Put("d", "d-value").Encode())
+		file.Close()
+
+		database, err := Open(dir, WithSegmentSize(40))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		assertValue(t, database, "a", "a-value")
+		assertValue(t, database, "d", "d-value")
+	})
+
+	t.Run("compaction replaces the hints of merged segments", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir, WithSegmentSize(40))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			if err := database.Put(key, key+"-value"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		database.compactOldSegments()
+		database.Close()
+
+		hints, err := filepath.Glob(filepath.Join(dir, "*"+hintSuffix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		database.segmentLock.RLock()
+		compacted := database.segments[0].path
+		database.segmentLock.RUnlock()
+		if len(hints) != 1 || hints[0] != hintPath(compacted) {
+			t.Errorf("Expected only the hint of %s, got %v", compacted, hints)
+		}
+
+		reopened, err := Open(dir, WithSegmentSize(40))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			assertValue(t, reopened, key, key+"-value")
+		}
+	})
+}
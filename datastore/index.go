@@ -0,0 +1,102 @@
+package datastore
+
+import "sync"
+
+// indexShards is the number of independently locked parts of a segment
+// index. Readers only contend with the writer when their keys hash to the
+// same shard.
+const indexShards = 32
+
+type keyIndex map[string]int64
+
+// shardedIndex maps the keys of a segment to the positions of their newest
+// records and their expiry times, spread over shards by key hash.
+type shardedIndex struct {
+	shards [indexShards]indexShard
+}
+
+type indexShard struct {
+	mu       sync.RWMutex
+	keys     keyIndex
+	expiries map[string]int64
+}
+
+func newShardedIndex() *shardedIndex {
+	index := &shardedIndex{}
+	for i := range index.shards {
+		index.shards[i].keys = make(keyIndex)
+		index.shards[i].expiries = make(map[string]int64)
+	}
+	return index
+}
+
+// shard picks the shard of key by its FNV-1a hash.
+func (index *shardedIndex) shard(key string) *indexShard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return &index.shards[hash%indexShards]
+}
+
+func (index *shardedIndex) set(key string, position, expiresAt int64) {
+	shard := index.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.keys[key] = position
+	if expiresAt != 0 {
+		shard.expiries[key] = expiresAt
+	} else {
+		delete(shard.expiries, key)
+	}
+}
+
+func (index *shardedIndex) remove(key string) {
+	shard := index.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	delete(shard.keys, key)
+	delete(shard.expiries, key)
+}
+
+// get returns the position and expiry time of key; expiresAt is 0 for keys
+// that do not expire.
+func (index *shardedIndex) get(key string) (position, expiresAt int64, found bool) {
+	shard := index.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	position, found = shard.keys[key]
+	return position, shard.expiries[key], found
+}
+
+func (index *shardedIndex) len() int {
+	total := 0
+	for i := range index.shards {
+		shard := &index.shards[i]
+		shard.mu.RLock()
+		total += len(shard.keys)
+		shard.mu.RUnlock()
+	}
+	return total
+}
+
+// forEach calls visit for every key until it returns false. Each shard is
+// read locked while its keys are visited, so keys set concurrently may or
+// may not be seen.
+func (index *shardedIndex) forEach(visit func(key string, position, expiresAt int64) bool) {
+	for i := range index.shards {
+		shard := &index.shards[i]
+		shard.mu.RLock()
+		for key, position := range shard.keys {
+			if !visit(key, position, shard.expiries[key]) {
+				shard.mu.RUnlock()
+				return
+			}
+		}
+		shard.mu.RUnlock()
+	}
+}
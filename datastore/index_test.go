@@ -0,0 +1,68 @@
+package datastore
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestShardedIndex(t *testing.T) {
+	index := newShardedIndex()
+	index.set("a", 10, 0)
+	index.set("b", 20, 500)
+	index.set("a", 30, 0)
+
+	if position, expiresAt, found := index.get("a"); !found || position != 30 || expiresAt != 0 {
+		t.Errorf("Expected a at 30 without expiry, got %d, %d, %v", position, expiresAt, found)
+	}
+	if position, expiresAt, found := index.get("b"); !found || position != 20 || expiresAt != 500 {
+		t.Errorf("Expected b at 20 expiring at 500, got %d, %d, %v", position, expiresAt, found)
+	}
+	index.set("b", 40, 0)
+	if _, expiresAt, _ := index.get("b"); expiresAt != 0 {
+		t.Errorf("Expected b to lose its expiry, got %d", expiresAt)
+	}
+	if _, _, found := index.get("missing"); found {
+		t.Error("Expected missing key not to be found")
+	}
+	if index.len() != 2 {
+		t.Errorf("Expected 2 keys, got %d", index.len())
+	}
+
+	visited := 0
+	index.forEach(func(string, int64, int64) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Errorf("Expected forEach to stop after the first key, visited %d", visited)
+	}
+}
+
+func TestShardedIndex_Concurrent(t *testing.T) {
+	index := newShardedIndex()
+	var wg sync.WaitGroup
+	for writer := 0; writer < 4; writer++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				index.set(fmt.Sprintf("w%d-key%d", writer, i), int64(i), 0)
+			}
+		}(writer)
+	}
+	for reader := 0; reader < 4; reader++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				index.get(fmt.Sprintf("w0-key%d", i))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if index.len() != 4000 {
+		t.Errorf("Expected 4000 keys, got %d", index.len())
+	}
+}
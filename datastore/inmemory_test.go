@@ -0,0 +1,73 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDb_InMemory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "never-created")
+	database, err := Open(dir, WithInMemory(), WithSegmentSize(200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	for i := 0; i < 30; i++ {
+		if err := database.Put(fmt.Sprintf("key%d", i%10), fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 20; i < 30; i++ {
+		assertValue(t, database, fmt.Sprintf("key%d", i%10), fmt.Sprintf("value%d", i))
+	}
+
+	if n, err := database.Increment("counter", 3); err != nil || n != 3 {
+		t.Errorf("Expected 3, got %d (%v)", n, err)
+	}
+	values, err := database.GetMany([]string{"key1", "key2"})
+	if err != nil || values["key1"] != "value21" || values["key2"] != "value22" {
+		t.Errorf("Unexpected GetMany result %v (%v)", values, err)
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected no directory on disk, got %v", err)
+	}
+}
+
+func TestMemStorage(t *testing.T) {
+	fs := newMemStorage()
+	file, err := fs.OpenAppend("dir/current-data0", 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("hello"))
+
+	reader, err := fs.Open("dir/current-data0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Truncate(2)
+	file.Write([]byte("XYZ"))
+
+	buffer := make([]byte, 5)
+	if _, err := reader.ReadAt(buffer, 0); err != nil || string(buffer) != "hello" {
+		t.Errorf("Expected reader opened earlier to keep its contents, got %q (%v)", buffer, err)
+	}
+	if size, _ := file.Size(); size != 5 {
+		t.Errorf("Expected size 5, got %d", size)
+	}
+
+	names, _ := fs.ListFiles("dir")
+	if len(names) != 1 || names[0] != "current-data0" {
+		t.Errorf("Unexpected listing %v", names)
+	}
+	if err := fs.Remove("dir/current-data0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Open("dir/current-data0"); !os.IsNotExist(err) {
+		t.Errorf("Expected removed file to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package datastore
+
+import (
+	"sort"
+	"strings"
+)
+
+type iteratorEntry struct {
+	key       string
+	location  KeyLocation
+	expiresAt int64
+}
+
+// Iterator walks a point-in-time list of keys. Values are read lazily from
+// the segment files when requested.
+type Iterator struct {
+	db      *Db
+	entries []iteratorEntry
+	index   int
+	err     error
+}
+
+func (it *Iterator) Next() bool {
+	if it.err != nil || it.index >= len(it.entries) {
+		return false
+	}
+	it.index++
+	return true
+}
+
+func (it *Iterator) Key() string {
+	return it.entries[it.index-1].key
+}
+
+func (it *Iterator) Value() (string, error) {
+	location := it.entries[it.index-1].location
+	value, err := it.db.readBytes(location.segment, it.Key(), location.position, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// Event returns the current key as the EventPut that Apply writes back as it
+// is, with its value type and expiry.
+func (it *Iterator) Event() (Event, error) {
+	current := it.entries[it.index-1]
+	value, valueType, err := it.db.readRecord(current.location.segment, current.key, current.location.position, nil)
+	if err != nil {
+		return Event{}, err
+	}
+	return newPutEvent(entry{key: current.key, value: value, valueType: valueType, expiresAt: current.expiresAt}), nil
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Order selects the order in which Keys and Scan return keys.
+type Order int
+
+const (
+	// WriteOrder returns keys in the order their live records were written.
+	// Compaction rewrites records, so the order is only stable between
+	// compactions.
+	WriteOrder Order = iota
+	// KeyOrder returns keys in lexicographic order, which does not depend on
+	// how the records are laid out on disk.
+	KeyOrder
+)
+
+type iteratorOptions struct {
+	order Order
+}
+
+type IteratorOption func(*iteratorOptions)
+
+// WithOrder selects the order of the keys of one iterator. Keys default to
+// WriteOrder.
+func WithOrder(order Order) IteratorOption {
+	return func(o *iteratorOptions) {
+		o.order = order
+	}
+}
+
+func (db *Db) Keys(opts ...IteratorOption) *Iterator {
+	return db.Scan("", opts...)
+}
+
+func (db *Db) Scan(prefix string, opts ...IteratorOption) *Iterator {
+	return scan(db, db.collectKeys, prefix, opts)
+}
+
+// Range iterates keys in lexicographic order within [start, end). An empty
+// end leaves the range unbounded.
+func (db *Db) Range(start, end string) *Iterator {
+	return keyRange(db, db.collectKeys, start, end)
+}
+
+// collector returns the live keys that match in write order.
+type collector func(match func(key string) bool) ([]iteratorEntry, error)
+
+func scan(db *Db, collect collector, prefix string, opts []IteratorOption) *Iterator {
+	var config iteratorOptions
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	entries, err := collect(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+	if config.order == KeyOrder {
+		sortByKey(entries)
+	}
+	return &Iterator{db: db, entries: entries, err: err}
+}
+
+func keyRange(db *Db, collect collector, start, end string) *Iterator {
+	entries, err := collect(func(key string) bool {
+		return key >= start && (end == "" || key < end)
+	})
+	sortByKey(entries)
+	return &Iterator{db: db, entries: entries, err: err}
+}
+
+func sortByKey(entries []iteratorEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+}
+
+// collectKeys returns the newest location of every matching key ordered by
+// segment and offset, i.e. in the order the live records were written.
+func (db *Db) collectKeys(match func(key string) bool) ([]iteratorEntry, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.closeMutex.RUnlock()
+
+	db.segmentLock.RLock()
+	defer db.segmentLock.RUnlock()
+	return db.collectFrom(db.segments, db.clock.Now().UnixNano(), match), nil
+}
+
+// collectFrom returns the newest location in segments of every matching key
+// that is live at now, in write order.
+func (db *Db) collectFrom(segments []*Segment, now int64, match func(key string) bool) []iteratorEntry {
+	segmentOrder := make(map[*Segment]int, len(segments))
+	seen := make(map[string]bool)
+	var entries []iteratorEntry
+	for i := len(segments) - 1; i >= 0; i-- {
+		segment := segments[i]
+		segmentOrder[segment] = i
+
+		segment.index.forEach(func(key string, position, expiresAt int64) bool {
+			if seen[key] || !match(key) || !db.readable(key) {
+				return true
+			}
+			seen[key] = true
+			if expiresAt != 0 && expiresAt <= now {
+				return true
+			}
+			entries = append(entries, iteratorEntry{key: key, location: KeyLocation{segment, position}, expiresAt: expiresAt})
+			return true
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		left, right := entries[i].location, entries[j].location
+		if left.segment != right.segment {
+			return segmentOrder[left.segment] < segmentOrder[right.segment]
+		}
+		return left.position < right.position
+	})
+	return entries
+}
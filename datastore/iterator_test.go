@@ -0,0 +1,175 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDb_KeysAndScan(t *testing.T) {
+	database, err := Open(t.TempDir(), WithSegmentSize(300))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := database.Put(fmt.Sprintf("user:%d", i), fmt.Sprintf("u%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Put(fmt.Sprintf("order:%d", i), fmt.Sprintf("o%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.Put("user:1", "updated"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("keys are deduplicated across segments", func(t *testing.T) {
+		it := database.Keys()
+		count := 0
+		seen := make(map[string]bool)
+		for it.Next() {
+			if seen[it.Key()] {
+				t.Errorf("Key %s returned twice", it.Key())
+			}
+			seen[it.Key()] = true
+			count++
+		}
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		if count != 8 {
+			t.Errorf("Expected 8 keys, got %d", count)
+		}
+	})
+
+	t.Run("scan by prefix returns newest values", func(t *testing.T) {
+		it := database.Scan("user:")
+		values := make(map[string]string)
+		for it.Next() {
+			value, err := it.Value()
+			if err != nil {
+				t.Fatal(err)
+			}
+			values[it.Key()] = value
+		}
+
+		if len(values) != 4 {
+			t.Errorf("Expected 4 user keys, got %d", len(values))
+		}
+		if values["user:1"] != "updated" {
+			t.Errorf("Expected newest value for user:1, got %s", values["user:1"])
+		}
+	})
+
+	t.Run("keys follow write order", func(t *testing.T) {
+		it := database.Scan("user:")
+		var keys []string
+		for it.Next() {
+			keys = append(keys, it.Key())
+		}
+		expected := []string{"user:0", "user:2", "user:3", "user:1"}
+		for i := range expected {
+			if i >= len(keys) || keys[i] != expected[i] {
+				t.Fatalf("Expected order %v, got %v", expected, keys)
+			}
+		}
+	})
+
+	t.Run("keys in key order", func(t *testing.T) {
+		it := database.Keys(WithOrder(KeyOrder))
+		var keys []string
+		for it.Next() {
+			keys = append(keys, it.Key())
+		}
+		expected := []string{"order:0", "order:1", "order:2", "order:3", "user:0", "user:1", "user:2", "user:3"}
+		if fmt.Sprint(keys) != fmt.Sprint(expected) {
+			t.Errorf("Expected %v, got %v", expected, keys)
+		}
+
+		it = database.Scan("user:", WithOrder(KeyOrder))
+		keys = nil
+		for it.Next() {
+			keys = append(keys, it.Key())
+		}
+		if fmt.Sprint(keys) != fmt.Sprint(expected[4:]) {
+			t.Errorf("Expected %v, got %v", expected[4:], keys)
+		}
+	})
+
+	t.Run("closed database", func(t *testing.T) {
+		database.Close()
+		it := database.Keys()
+		if it.Next() {
+			t.Error("Expected no keys from closed database")
+		}
+		if it.Err() == nil {
+			t.Error("Expected error from closed database")
+		}
+	})
+}
+
+func TestDb_Range(t *testing.T) {
+	database, err := Open(t.TempDir(), WithSegmentSize(200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	for _, key := range []string{"e", "a", "d", "b", "c", "f"} {
+		if err := database.Put(key, "value_"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collect := func(it *Iterator) []string {
+		var keys []string
+		for it.Next() {
+			keys = append(keys, it.Key())
+		}
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		return keys
+	}
+
+	testCases := []struct {
+		start, end string
+		expected   string
+	}{
+		{"", "", "abcdef"},
+		{"b", "e", "bcd"},
+		{"c", "", "cdef"},
+		{"x", "", ""},
+	}
+	for _, tc := range testCases {
+		keys := collect(database.Range(tc.start, tc.end))
+		got := ""
+		for _, key := range keys {
+			got += key
+		}
+		if got != tc.expected {
+			t.Errorf("Range(%q, %q): expected %s, got %s", tc.start, tc.end, tc.expected, got)
+		}
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		var pages []string
+		start := ""
+		for {
+			it := database.Range(start, "")
+			page := ""
+			for len(page) < 2 && it.Next() {
+				page += it.Key()
+			}
+			if page == "" {
+				break
+			}
+			pages = append(pages, page)
+			start = page[len(page)-1:] + "\x00"
+		}
+		if len(pages) != 3 || pages[0] != "ab" || pages[2] != "ef" {
+			t.Errorf("Unexpected pages %v", pages)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package datastore
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDb_SizeLimits(t *testing.T) {
+	database, err := Open(t.TempDir(), WithMaxKeySize(8), WithMaxValueSize(16), WithSegmentSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	if err := database.Put("too-long-key", "v"); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("Expected ErrKeyTooLarge, got %v", err)
+	}
+	if err := database.Put("key", strings.Repeat("v", 17)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Expected ErrValueTooLarge, got %v", err)
+	}
+	if err := database.Put("key", strings.Repeat("v", 16)); err != nil {
+		t.Errorf("Expected a value at the limit to be accepted, got %v", err)
+	}
+	if err := database.Append("key", "v"); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Expected Append past the limit to fail, got %v", err)
+	}
+
+	batch := NewWriteBatch()
+	batch.Put("a", "1")
+	batch.Put("b", strings.Repeat("v", 17))
+	if err := database.Write(batch); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Expected the batch to be rejected, got %v", err)
+	}
+	if _, err := database.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Error("Expected no record of a rejected batch to be written")
+	}
+}
+
+func TestDb_RecordLargerThanSegment(t *testing.T) {
+	database, err := Open(t.TempDir(), WithSegmentSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	if err := database.Put("key", strings.Repeat("v", 64)); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Expected ErrValueTooLarge, got %v", err)
+	}
+}
+
+func TestDb_RecoverLargeValue(t *testing.T) {
+	dir := t.TempDir()
+	database, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("x", 200*1024)
+	if err := database.Put("large", large); err != nil {
+		t.Fatal(err)
+	}
+	database.Put("small", "v")
+	database.Close()
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if value, err := reopened.Get("large"); err != nil || value != large {
+		t.Errorf("Expected the large value after reopen, got %d bytes (%v)", len(value), err)
+	}
+	assertValue(t, reopened, "small", "v")
+}
+
+func TestOpen_InvalidSizeLimits(t *testing.T) {
+	for _, opt := range []Option{WithMaxKeySize(0), WithMaxValueSize(-1), WithMaxValueSize(1 << 40)} {
+		if _, err := Open(t.TempDir(), opt); err == nil {
+			t.Error("Expected invalid size limits to be rejected")
+		}
+	}
+}
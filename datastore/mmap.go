@@ -0,0 +1,112 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var errMmapUnsupported = errors.New("memory-mapped segments are not supported on this platform")
+
+// segmentMapping is a sealed segment mapped into memory. Reads copy records
+// out of the mapping, and the mapping is only unmapped once the segment is
+// gone and no read still uses it.
+type segmentMapping struct {
+	mu     sync.Mutex
+	data   []byte
+	refs   int
+	closed bool
+}
+
+func (m *segmentMapping) acquire() ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, false
+	}
+	m.refs++
+	return m.data, true
+}
+
+func (m *segmentMapping) release() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.refs--
+	if m.closed && m.refs == 0 {
+		m.unmap()
+	}
+}
+
+func (m *segmentMapping) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return
+	}
+	m.closed = true
+	if m.refs == 0 {
+		m.unmap()
+	}
+}
+
+func (m *segmentMapping) unmap() {
+	if m.data == nil {
+		return
+	}
+	if err := munmapFile(m.data); err != nil {
+		fmt.Printf("Warning: failed to unmap segment: %v\n", err)
+	}
+	m.data = nil
+}
+
+// mapSegment maps a sealed segment if memory-mapped reads are enabled.
+// Where mapping fails or is not supported, the segment keeps being read
+// through its file.
+func (db *Db) mapSegment(segment *Segment) {
+	if !db.mmapSegments || segment.mapping.Load() != nil {
+		return
+	}
+	data, err := mmapFile(segment.path)
+	if err != nil {
+		if err != errMmapUnsupported {
+			fmt.Printf("Warning: failed to map segment %s: %v\n", segment.path, err)
+		}
+		return
+	}
+	segment.mapping.Store(&segmentMapping{data: data})
+}
+
+func (segment *Segment) unmap() {
+	if mapping := segment.mapping.Load(); mapping != nil {
+		mapping.close()
+	}
+}
+
+// recordReader returns a reader positioned at the record at position and a
+// function that releases it.
+func (segment *Segment) recordReader(position int64) (*bufio.Reader, func(), error) {
+	if mapping := segment.mapping.Load(); mapping != nil {
+		if data, ok := mapping.acquire(); ok {
+			if position < 0 || position >= int64(len(data)) {
+				mapping.release()
+				return nil, nil, fmt.Errorf("%w: position %d is outside segment %s", ErrCorruptedData, position, segment.path)
+			}
+			return bufio.NewReader(bytes.NewReader(data[position:])), mapping.release, nil
+		}
+	}
+
+	file, err := segment.fs.Open(segment.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := file.Seek(position, 0); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return bufio.NewReader(file), func() { file.Close() }, nil
+}
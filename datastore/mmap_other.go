@@ -0,0 +1,11 @@
+//go:build !unix
+
+package datastore
+
+func mmapFile(path string) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
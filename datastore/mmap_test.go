@@ -0,0 +1,112 @@
+package datastore
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestDb_MmapReads(t *testing.T) {
+	dir := t.TempDir()
+	database, err := Open(dir, WithMmapReads(), WithSegmentSize(256))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 30; i++ {
+		if err := database.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	database.compactionWG.Wait()
+
+	database.segmentLock.RLock()
+	sealed := database.segments[0]
+	database.segmentLock.RUnlock()
+	if _, err := mmapFile(sealed.path); err == errMmapUnsupported {
+		t.Skip(err)
+	}
+	if sealed.mapping.Load() == nil {
+		t.Fatal("Expected sealed segments to be mapped")
+	}
+	if database.getCurrentSegment().mapping.Load() != nil {
+		t.Error("Expected the active segment to be read through its file")
+	}
+
+	check := func(database *Db) {
+		t.Helper()
+		for i := 0; i < 30; i++ {
+			value, err := database.Get(fmt.Sprintf("key%d", i))
+			if err != nil || value != fmt.Sprintf("value%d", i) {
+				t.Fatalf("key%d: expected value%d, got %q, %v", i, i, value, err)
+			}
+		}
+	}
+	check(database)
+
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for i := 0; i < 30; i++ {
+				database.Get(fmt.Sprintf("key%d", i))
+			}
+		}()
+	}
+	for i := 0; i < 30; i++ {
+		if err := database.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readers.Wait()
+	database.compactionWG.Wait()
+	database.compactOldSegments()
+
+	database.segmentLock.RLock()
+	compacted := database.segments[0]
+	database.segmentLock.RUnlock()
+	if compacted == sealed {
+		t.Fatal("Expected compaction to replace the oldest segment")
+	}
+	mapping := sealed.mapping.Load()
+	mapping.mu.Lock()
+	unmapped := mapping.closed && mapping.data == nil
+	mapping.mu.Unlock()
+	if !unmapped {
+		t.Error("Expected compaction to unmap the segments it removed")
+	}
+	if compacted.mapping.Load() == nil {
+		t.Error("Expected the compacted segment to be mapped")
+	}
+	check(database)
+
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(dir, WithMmapReads())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	check(reopened)
+}
+
+func TestSegmentMapping(t *testing.T) {
+	mapping := &segmentMapping{}
+
+	if _, ok := mapping.acquire(); !ok {
+		t.Fatal("Expected an open mapping to be acquired")
+	}
+	mapping.close()
+	if _, ok := mapping.acquire(); ok {
+		t.Error("Expected a closed mapping not to be acquired")
+	}
+	if mapping.refs != 1 {
+		t.Errorf("Expected the reader to keep its reference, got %d", mapping.refs)
+	}
+	mapping.release()
+	if mapping.refs != 0 {
+		t.Errorf("Expected no references left, got %d", mapping.refs)
+	}
+}
@@ -0,0 +1,343 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	defaultSegmentSize   = 10 * 1024 * 1024
+	defaultBufferSize    = 8192
+	defaultFileMode      = 0644
+	defaultDirMode       = 0755
+	defaultSweepInterval = time.Minute
+	defaultSyncInterval  = time.Second
+	defaultMaxKeySize    = 4 * 1024
+	defaultMaxValueSize  = 1024 * 1024
+	defaultWriteQueue    = 100
+	// defaultCompactionSegments is the segment count that triggers a
+	// compaction unless WithCompactionSegments sets another.
+	defaultCompactionSegments = 3
+)
+
+type SyncPolicy int
+
+const (
+	SyncNever SyncPolicy = iota
+	SyncAlways
+	// SyncInterval flushes written data to disk periodically, bounding the
+	// window of acknowledged writes a crash can lose.
+	SyncInterval
+)
+
+type options struct {
+	maxSegmentSize        int64
+	bufferSize            int
+	fileMode              os.FileMode
+	dirMode               os.FileMode
+	syncPolicy            SyncPolicy
+	syncInterval          time.Duration
+	sweepInterval         time.Duration
+	checkpointInterval    time.Duration
+	inMemory              bool
+	lowPriorityCompaction bool
+	compactionRate        int64
+	verifyCompaction      bool
+	slowOpThreshold       time.Duration
+	valueCacheSize        int64
+	dictionaryCompression bool
+	mmapReads             bool
+	maxOpenSegments       int
+	maxKeySize            int
+	maxValueSize          int
+	writeQueueDepth       int
+	shedWrites            bool
+	garbageRatio          float64
+	compactionSegments    int
+	compactionInterval    time.Duration
+	authorizer            Authorizer
+	clock                 Clock
+}
+
+type Option func(*options)
+
+func defaultOptions() options {
+	return options{
+		maxSegmentSize:  defaultSegmentSize,
+		bufferSize:      defaultBufferSize,
+		fileMode:        defaultFileMode,
+		dirMode:         defaultDirMode,
+		syncPolicy:      SyncNever,
+		syncInterval:    defaultSyncInterval,
+		sweepInterval:   defaultSweepInterval,
+		maxOpenSegments: defaultMaxOpenSegments,
+		maxKeySize:      defaultMaxKeySize,
+		maxValueSize:    defaultMaxValueSize,
+		writeQueueDepth: defaultWriteQueue,
+
+		compactionSegments: defaultCompactionSegments,
+		clock:              systemClock{},
+	}
+}
+
+func WithSegmentSize(size int64) Option {
+	return func(o *options) {
+		o.maxSegmentSize = size
+	}
+}
+
+func WithBufferSize(size int) Option {
+	return func(o *options) {
+		o.bufferSize = size
+	}
+}
+
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.fileMode = mode
+	}
+}
+
+// WithDirMode sets the permissions of the database directory when Open has
+// to create it. An existing directory keeps its permissions.
+func WithDirMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.dirMode = mode
+	}
+}
+
+func WithSyncPolicy(policy SyncPolicy) Option {
+	return func(o *options) {
+		o.syncPolicy = policy
+	}
+}
+
+// WithSyncInterval selects the SyncInterval policy with the given period.
+func WithSyncInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.syncPolicy = SyncInterval
+		o.syncInterval = interval
+	}
+}
+
+// WithInMemory keeps segments in memory instead of files in the directory.
+// Nothing is persisted: the data is gone once the database is closed.
+func WithInMemory() Option {
+	return func(o *options) {
+		o.inMemory = true
+	}
+}
+
+// WithLowPriorityCompaction runs compaction at idle I/O priority and a
+// higher nice value where the platform supports it, and paces it so that
+// foreground reads and writes are not starved.
+func WithLowPriorityCompaction() Option {
+	return func(o *options) {
+		o.lowPriorityCompaction = true
+	}
+}
+
+// WithCompactionRateLimit holds compaction to writing about bytesPerSecond
+// of compacted records, so that it leaves disk bandwidth to foreground reads
+// and writes. A non-positive rate leaves compaction unlimited.
+func WithCompactionRateLimit(bytesPerSecond int64) Option {
+	return func(o *options) {
+		o.compactionRate = bytesPerSecond
+	}
+}
+
+// WithCompactionVerification re-reads every compacted segment before it
+// replaces the segments it was merged from. The swap is abandoned if any
+// record fails its checksum or a key is missing, duplicated or holds
+// anything but its newest live version.
+func WithCompactionVerification() Option {
+	return func(o *options) {
+		o.verifyCompaction = true
+	}
+}
+
+// WithSlowOpThreshold logs every get, put and compaction that takes at
+// least threshold, with the time spent in each of its phases, and counts
+// them in SlowOps. A non-positive threshold disables the log.
+func WithSlowOpThreshold(threshold time.Duration) Option {
+	return func(o *options) {
+		o.slowOpThreshold = threshold
+	}
+}
+
+// WithValueCache keeps recently read values in memory, up to size bytes of
+// keys and values, so that reads of hot keys skip the segment files.
+func WithValueCache(size int64) Option {
+	return func(o *options) {
+		o.valueCacheSize = size
+	}
+}
+
+// WithDictionaryCompression compresses values with a dictionary that
+// compaction trains on the values it copies. It pays off for many small
+// values that look alike. Segments written without it stay readable, and so
+// do compressed segments once it is turned off.
+func WithDictionaryCompression() Option {
+	return func(o *options) {
+		o.dictionaryCompression = true
+	}
+}
+
+// WithMmapReads maps sealed segments into memory and reads records from
+// the mapping instead of the file. Where mapping is not supported, segments
+// are read through their files as usual. It has no effect with WithInMemory.
+func WithMmapReads() Option {
+	return func(o *options) {
+		o.mmapReads = true
+	}
+}
+
+// WithExpirySweepInterval sets how often sealed segments are checked for
+// expired keys. A non-positive interval disables the sweeper.
+func WithExpirySweepInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.sweepInterval = interval
+	}
+}
+
+// WithCheckpointInterval writes an index checkpoint with the given period
+// and when the database is closed, so that Open only replays records written
+// after the last checkpoint. A non-positive interval disables checkpoints.
+func WithCheckpointInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.checkpointInterval = interval
+	}
+}
+
+// WithMaxOpenSegments limits how many segment files are held open for
+// reading at once. Handles stay open between reads and the least recently
+// used idle ones are closed when the limit is reached.
+func WithMaxOpenSegments(n int) Option {
+	return func(o *options) {
+		o.maxOpenSegments = n
+	}
+}
+
+// WithMaxKeySize limits the length of keys in bytes. Writes of longer keys
+// fail with ErrKeyTooLarge.
+func WithMaxKeySize(size int) Option {
+	return func(o *options) {
+		o.maxKeySize = size
+	}
+}
+
+// WithMaxValueSize limits the length of values in bytes. Writes of longer
+// values fail with ErrValueTooLarge.
+func WithMaxValueSize(size int) Option {
+	return func(o *options) {
+		o.maxValueSize = size
+	}
+}
+
+// WithWriteQueueDepth sets how many writes may wait for the writer before
+// further writes block, or fail with WithWriteQueueShedding.
+func WithWriteQueueDepth(depth int) Option {
+	return func(o *options) {
+		o.writeQueueDepth = depth
+	}
+}
+
+// WithWriteQueueShedding makes writes fail with ErrWriteQueueFull instead of
+// blocking while the write queue is full, so that callers can shed load.
+func WithWriteQueueShedding() Option {
+	return func(o *options) {
+		o.shedWrites = true
+	}
+}
+
+// WithGarbageCompaction tracks how many bytes of the segments are held by
+// replaced records and compacts as soon as they exceed ratio of all record
+// bytes, once they would fill at least a quarter of a segment, instead of
+// waiting for enough segments to pile up. The active segment is sealed
+// first so that its garbage is reclaimed as well. Open reads the size of
+// every live record to take the initial measure.
+func WithGarbageCompaction(ratio float64) Option {
+	return func(o *options) {
+		o.garbageRatio = ratio
+	}
+}
+
+// WithCompactionSegments compacts every sealed segment as soon as there are
+// n segments including the active one. Fewer segments mean less disk used by
+// replaced records at the cost of rewriting live ones more often. An n of 0
+// leaves compaction to the other triggers: WithGarbageCompaction,
+// WithCompactionInterval, the expiry sweeper and Compact.
+func WithCompactionSegments(n int) Option {
+	return func(o *options) {
+		o.compactionSegments = n
+	}
+}
+
+// WithCompactionInterval compacts the sealed segments with the given period,
+// whatever their number, if there is anything to merge: two or more of them,
+// or a single one that was written rather than compacted. A non-positive
+// interval disables scheduled compaction.
+func WithCompactionInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.compactionInterval = interval
+	}
+}
+
+// WithAuthorizer checks every read and write of a key with authorize, which
+// sees the principal attached to the context of the call. Denied calls fail
+// with ErrAccessDenied, and iterators skip keys that may not be read.
+func WithAuthorizer(authorize Authorizer) Option {
+	return func(o *options) {
+		o.authorizer = authorize
+	}
+}
+
+// WithClock replaces the system clock the database tells time by, which
+// lets tests expire keys and run periodic work by moving a fake clock
+// forward.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+func (o options) validate() error {
+	if o.maxSegmentSize <= 0 {
+		return fmt.Errorf("invalid segment size: %d", o.maxSegmentSize)
+	}
+	if o.bufferSize < fixedHeaderSize {
+		return fmt.Errorf("invalid buffer size: %d", o.bufferSize)
+	}
+	if o.maxKeySize <= 0 || o.maxValueSize <= 0 || int64(o.maxKeySize)+int64(o.maxValueSize) > maxRecordSize/2 {
+		return fmt.Errorf("invalid key and value size limits: %d and %d", o.maxKeySize, o.maxValueSize)
+	}
+	if o.valueCacheSize < 0 {
+		return fmt.Errorf("invalid value cache size: %d", o.valueCacheSize)
+	}
+	if o.writeQueueDepth < 1 {
+		return fmt.Errorf("invalid write queue depth: %d", o.writeQueueDepth)
+	}
+	if o.garbageRatio < 0 || o.garbageRatio >= 1 {
+		return fmt.Errorf("invalid garbage ratio: %g", o.garbageRatio)
+	}
+	if o.compactionSegments != 0 && o.compactionSegments < 2 {
+		return fmt.Errorf("invalid compaction segment count: %d", o.compactionSegments)
+	}
+	if o.clock == nil {
+		return fmt.Errorf("missing clock")
+	}
+	if o.maxOpenSegments < 1 {
+		return fmt.Errorf("invalid max open segments: %d", o.maxOpenSegments)
+	}
+	switch o.syncPolicy {
+	case SyncNever, SyncAlways:
+	case SyncInterval:
+		if o.syncInterval <= 0 {
+			return fmt.Errorf("invalid sync interval: %s", o.syncInterval)
+		}
+	default:
+		return fmt.Errorf("unknown sync policy: %d", o.syncPolicy)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package datastore
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+func TestDb_RecoverFromFixtures(t *testing.T) {
+	t.Run("multiple segments", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.Segment(testutil.Put("a", "1"), testutil.Put("b", "1"))
+		dir.Segment(testutil.Put("a", "2"), testutil.PutInt64("n", 7))
+
+		database, err := Open(dir.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		assertValue(t, database, "a", "2")
+		assertValue(t, database, "b", "1")
+		if n, err := database.GetInt64("n"); err != nil || n != 7 {
+			t.Errorf("Expected 7, got %d (%v)", n, err)
+		}
+	})
+
+	t.Run("expired record", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.Segment(
+			testutil.PutWithExpiry("gone", "v", time.Now().Add(-time.Minute)),
+			testutil.PutWithExpiry("kept", "v", time.Now().Add(time.Hour)),
+		)
+
+		database, err := Open(dir.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if _, err := database.Get("gone"); err == nil {
+			t.Error("Expected expired key to be missing")
+		}
+		assertValue(t, database, "kept", "v")
+	})
+
+	t.Run("corrupted record is skipped", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		first := testutil.Put("a", "value")
+		path := dir.Segment(first, testutil.Put("b", "value"))
+		dir.FlipByte(path, testutil.HeaderSize+int64(len(first.Encode())-1))
+
+		database, err := Open(dir.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if _, err := database.Get("a"); err == nil {
+			t.Error("Expected corrupted key to be missing")
+		}
+		assertValue(t, database, "b", "value")
+	})
+
+	t.Run("damaged key is skipped", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		first := testutil.Put("a", "1")
+		path := dir.Segment(first, testutil.Put("b", "1"), testutil.Put("a", "2"))
+		dir.FlipByte(path, testutil.HeaderSize+int64(len(first.Encode())+2))
+
+		database, err := Open(dir.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if _, err := database.Get("c"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected the damaged record not to show up under another key, got %v", err)
+		}
+		assertValue(t, database, "a", "2")
+	})
+
+	t.Run("batch with a damaged record is dropped", func(t *testing.T) {
+		batched := func(r testutil.Record) testutil.Record {
+			r.Batched = true
+			return r
+		}
+		dir := testutil.NewDir(t)
+		before := []testutil.Record{testutil.Put("a", "1"), batched(testutil.Put("a", "2"))}
+		damaged := batched(testutil.Put("b", "2"))
+		path := dir.Segment(append(before, damaged, testutil.Put("c", "2"), testutil.Put("d", "4"))...)
+		offset := int64(testutil.HeaderSize)
+		for _, r := range before {
+			offset += int64(len(r.Encode()))
+		}
+		// Damage to the value leaves the batch flag of the record readable.
+		dir.FlipByte(path, offset+int64(len(damaged.Encode())-6))
+
+		database, err := Open(dir.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		assertValue(t, database, "a", "1")
+		for _, key := range []string{"b", "c"} {
+			if _, err := database.Get(key); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected %s of the damaged batch to be dropped, got %v", key, err)
+			}
+		}
+		assertValue(t, database, "d", "4")
+	})
+
+	t.Run("damage after recovery is reported on read", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		path := dir.Segment(testutil.Put("a", "value"))
+
+		database, err := Open(dir.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		dir.FlipByte(path, testutil.HeaderSize+int64(len(testutil.Put("a", "value").Encode())-6))
+		if _, err := database.Get("a"); !errors.Is(err, ErrCorruptedData) {
+			t.Errorf("Expected ErrCorruptedData, got %v", err)
+		}
+	})
+
+	for name, cut := range map[string]int64{"torn record": 3, "torn header": 1} {
+		t.Run(name+" is truncated", func(t *testing.T) {
+			dir := testutil.NewDir(t)
+			first := testutil.Put("a", "1")
+			valid := testutil.HeaderSize + int64(len(first.Encode()))
+			path := dir.Segment(first, testutil.Put("b", "value"))
+			if err := os.Truncate(path, valid+cut); err != nil {
+				t.Fatal(err)
+			}
+
+			database, err := Open(dir.Path)
+			if err != nil {
+				t.Fatalf("Expected a torn tail not to prevent opening, got %v", err)
+			}
+			assertValue(t, database, "a", "1")
+			if _, err := database.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected the torn record to be dropped, got %v", err)
+			}
+			if err := database.Put("c", "3"); err != nil {
+				t.Fatal(err)
+			}
+			if err := database.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			database, err = Open(dir.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer database.Close()
+			assertValue(t, database, "a", "1")
+			assertValue(t, database, "c", "3")
+		})
+	}
+
+	t.Run("pending compaction", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.PendingCompaction(
+			[]testutil.Record{testutil.Put("a", "1"), testutil.Put("b", "1")},
+			[]testutil.Record{testutil.Put("a", "2")},
+		)
+
+		database, err := Open(dir.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		assertValue(t, database, "a", "2")
+		assertValue(t, database, "b", "1")
+	})
+}
+
+func assertValue(t *testing.T, database *Db, key, expected string) {
+	t.Helper()
+	value, err := database.Get(key)
+	if err != nil {
+		t.Errorf("Get(%s): %v", key, err)
+		return
+	}
+	if value != expected {
+		t.Errorf("Get(%s) = %q, expected %q", key, value, expected)
+	}
+}
@@ -0,0 +1,187 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if _, ok := parseSegmentName(entry.Name()); ok {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestDb_Reopen(t *testing.T) {
+	t.Run("continues the last segment", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		database.Put("a", "1")
+		database.Close()
+
+		reopened, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reopened.Put("b", "2")
+		reopened.Close()
+
+		if files := segmentFiles(t, dir); len(files) != 1 || files[0] != "current-data0" {
+			t.Errorf("Expected a single segment, got %v", files)
+		}
+
+		again, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer again.Close()
+		assertValue(t, again, "a", "1")
+		assertValue(t, again, "b", "2")
+	})
+
+	t.Run("starts a new segment when the last one is full", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.Segment(testutil.Put("a", "1"), testutil.Put("b", "1"))
+
+		database, err := Open(dir.Path, WithSegmentSize(30))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+		database.Put("a", "2")
+
+		if files := segmentFiles(t, dir.Path); len(files) != 2 || files[1] != "current-data1" {
+			t.Errorf("Expected the counter to resume at 1, got %v", files)
+		}
+		assertValue(t, database, "a", "2")
+	})
+
+	t.Run("orders segments numerically", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		for i := 0; i < 12; i++ {
+			dir.Segment(testutil.Put("key", fmt.Sprintf("v%d", i)))
+		}
+
+		database, err := Open(dir.Path, WithSegmentSize(1<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+		assertValue(t, database, "key", "v11")
+	})
+
+	t.Run("compaction output survives reopen", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir, WithSegmentSize(100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 12; i++ {
+			if err := database.Put(fmt.Sprintf("k%d", i%3), fmt.Sprintf("v%d", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		database.compactOldSegments()
+		database.Close()
+
+		reopened, err := Open(dir, WithSegmentSize(100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		for i := 9; i < 12; i++ {
+			assertValue(t, reopened, fmt.Sprintf("k%d", i%3), fmt.Sprintf("v%d", i))
+		}
+	})
+
+	t.Run("replay restores every position of every segment", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir, WithSegmentSize(200), WithExpirySweepInterval(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 40; i++ {
+			value := strings.Repeat("v", i%7+1) + fmt.Sprint(i)
+			if err := database.Put(fmt.Sprintf("k%d", i%9), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		database.Close()
+		written := indexPositions(database)
+		if len(written) < 2 {
+			t.Fatalf("Expected several segments, got %d", len(written))
+		}
+
+		hints, _ := filepath.Glob(filepath.Join(dir, "*"+hintSuffix))
+		for _, hint := range hints {
+			os.Remove(hint)
+		}
+		reopened, err := Open(dir, WithSegmentSize(200), WithExpirySweepInterval(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+
+		if recovered := indexPositions(reopened); !reflect.DeepEqual(recovered, written) {
+			t.Errorf("Expected replay to restore\n%v\ngot\n%v", written, recovered)
+		}
+		for i := 31; i < 40; i++ {
+			assertValue(t, reopened, fmt.Sprintf("k%d", i%9), strings.Repeat("v", i%7+1)+fmt.Sprint(i))
+		}
+	})
+}
+
+// indexPositions maps the name of every segment to the positions its index
+// holds.
+func indexPositions(database *Db) map[string]map[string]int64 {
+	positions := make(map[string]map[string]int64)
+	for _, segment := range database.segments {
+		index := make(map[string]int64)
+		segment.index.forEach(func(key string, position, _ int64) bool {
+			index[key] = position
+			return true
+		})
+		positions[filepath.Base(segment.path)] = index
+	}
+	return positions
+}
+
+func TestParseSegmentName(t *testing.T) {
+	cases := map[string]segmentID{
+		"current-data0":    {0, 0},
+		"current-data12":   {12, 0},
+		"current-data12.3": {12, 3},
+	}
+	for name, expected := range cases {
+		if id, ok := parseSegmentName(name); !ok || id != expected {
+			t.Errorf("parseSegmentName(%q) = %v, %v", name, id, ok)
+		}
+		if id, _ := parseSegmentName(name); id.fileName() != name {
+			t.Errorf("Expected %q to round-trip, got %q", name, id.fileName())
+		}
+	}
+	for _, name := range []string{"current-data", "current-data-1", "current-data01", "current-data1.0", "current-data1.x", "other"} {
+		if _, ok := parseSegmentName(name); ok {
+			t.Errorf("Expected %q to be ignored", name)
+		}
+	}
+}
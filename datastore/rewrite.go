@@ -0,0 +1,186 @@
+package datastore
+
+import (
+	"sync"
+	"time"
+)
+
+// rewriteBatchKeys is how many keys one write operation of a rewrite
+// upgrades, so that foreground writes queue behind a few of them at most.
+const rewriteBatchKeys = 64
+
+// RewriteStatus reports the progress of the latest RewriteAll.
+type RewriteStatus struct {
+	Running   bool
+	Rewritten int64
+	// Skipped counts records left as they are because they exceed the
+	// current size limits.
+	Skipped       int64
+	LastCompleted time.Time
+	Error         string
+}
+
+type rewriteState struct {
+	mu     sync.Mutex
+	status RewriteStatus
+}
+
+// RewriteStatus reports whether a rewrite is running and how many records
+// it has upgraded so far.
+func (db *Db) RewriteStatus() RewriteStatus {
+	db.rewrite.mu.Lock()
+	defer db.rewrite.mu.Unlock()
+	return db.rewrite.status
+}
+
+// RewriteAll starts upgrading, in the background, every live record stored
+// in an outdated format: segments in an older record format, and segments
+// without a dictionary since dictionary compression was turned on, or with
+// one since it was turned off. Reads keep decoding every format meanwhile,
+// so a database can switch formats without downtime.
+//
+// Records are rewritten a few keys at a time through the writer, at the
+// pace set with WithCompactionRateLimit and PauseCompaction, and a key
+// written meanwhile is left to its new value. Compaction reclaims the
+// space of the old records. RewriteAll does nothing while a rewrite is
+// running already.
+func (db *Db) RewriteAll() error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.closeMutex.RUnlock()
+
+	db.rewrite.mu.Lock()
+	defer db.rewrite.mu.Unlock()
+	if db.rewrite.status.Running {
+		return nil
+	}
+	db.rewrite.status = RewriteStatus{Running: true, LastCompleted: db.rewrite.status.LastCompleted}
+	db.compactionWG.Add(1)
+	go db.runRewrite()
+	return nil
+}
+
+func (db *Db) runRewrite() {
+	defer db.compactionWG.Done()
+	err := db.rewriteOutdated()
+
+	db.rewrite.mu.Lock()
+	defer db.rewrite.mu.Unlock()
+	db.rewrite.status.Running = false
+	if err != nil {
+		db.rewrite.status.Error = err.Error()
+		return
+	}
+	db.rewrite.status.LastCompleted = db.clock.Now()
+}
+
+// rewriteOutdated rewrites the live keys of every outdated segment. The
+// database may be closed while it runs, which it notices on its next write
+// and Close waits for.
+func (db *Db) rewriteOutdated() error {
+	pacer := db.newCompactionPacer()
+
+	db.segmentLock.RLock()
+	segments := append([]*Segment(nil), db.segments...)
+	db.segmentLock.RUnlock()
+
+	for _, segment := range segments {
+		if !db.outdated(segment) {
+			continue
+		}
+		var keys []string
+		segment.index.forEach(func(key string, position, _ int64) bool {
+			if db.locatedAt(key, segment, position) {
+				keys = append(keys, key)
+			}
+			return true
+		})
+
+		for start := 0; start < len(keys); start += rewriteBatchKeys {
+			batch := keys[start:min(start+rewriteBatchKeys, len(keys))]
+			var entries []entry
+			var written, skipped int64
+			err := db.submitRewrite(func() ([]entry, error) {
+				entries, written, skipped = db.prepareRewrite(batch)
+				return entries, nil
+			})
+			if err != nil {
+				return err
+			}
+			db.countRewrite(int64(len(entries)), skipped)
+			for range batch {
+				pacer.step()
+			}
+			pacer.copied(int(written))
+		}
+	}
+	return nil
+}
+
+// outdated reports whether new writes would store the records of segment
+// differently. With dictionary compression, that is only once compaction
+// has trained the dictionary new writes use, which the active segment has.
+func (db *Db) outdated(segment *Segment) bool {
+	current := db.getCurrentSegment()
+	if segment == current {
+		return false
+	}
+	if segment.version != segmentFormatVersion {
+		return true
+	}
+	if db.compressValues {
+		return segment.dict == nil && current.dict != nil
+	}
+	return segment.dict != nil
+}
+
+// locatedAt reports whether the live record of key is the one at position
+// of segment.
+func (db *Db) locatedAt(key string, segment *Segment, position int64) bool {
+	current, currentPosition, err := db.findKeyLocation(key)
+	return err == nil && current == segment && currentPosition == position
+}
+
+// prepareRewrite runs on the writer goroutine and returns the records that
+// upgrade those of keys still stored in an outdated segment, with their
+// total size and the number of records too large to be written again.
+func (db *Db) prepareRewrite(keys []string) (entries []entry, size, skipped int64) {
+	for _, key := range keys {
+		segment, _, err := db.findKeyLocation(key)
+		if err != nil || !db.outdated(segment) {
+			continue
+		}
+		value, valueType, expiresAt, found, err := db.readCurrent(key)
+		if err != nil || !found {
+			continue
+		}
+
+		record := entry{key: key, value: value, valueType: valueType, expiresAt: expiresAt}
+		if db.checkEntrySize(record) != nil {
+			skipped++
+			continue
+		}
+		entries = append(entries, record)
+		size += record.GetLength()
+	}
+	return entries, size, skipped
+}
+
+func (db *Db) countRewrite(rewritten, skipped int64) {
+	db.rewrite.mu.Lock()
+	defer db.rewrite.mu.Unlock()
+	db.rewrite.status.Rewritten += rewritten
+	db.rewrite.status.Skipped += skipped
+}
+
+// submitRewrite queues a write operation of a rewrite. Unlike
+// readModifyWrite it bypasses the authorizer and watchers, since it does
+// not change any value.
+func (db *Db) submitRewrite(prepare func() ([]entry, error)) error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.closeMutex.RUnlock()
+	return db.submitOperation(WriteOperation{prepare: prepare, rewrite: true})
+}
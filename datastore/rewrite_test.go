@@ -0,0 +1,145 @@
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+// waitForRewrite waits until the rewrite started by RewriteAll is done.
+func waitForRewrite(t *testing.T, database *Db) RewriteStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := database.RewriteStatus()
+		if !status.Running {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the rewrite to finish, got %+v", status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDb_RewriteAll(t *testing.T) {
+	t.Run("older record format", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.UnversionedSegment(testutil.Put("a", "1"), testutil.PutInt64("n", 7))
+		dir.FixedSegment(testutil.Put("a", "2"), testutil.PutWithExpiry("b", "1", time.Now().Add(time.Hour)))
+
+		// Compaction would migrate the records on its own.
+		database, err := Open(dir.Path, WithCompactionSegments(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if err := database.RewriteAll(); err != nil {
+			t.Fatal(err)
+		}
+		status := waitForRewrite(t, database)
+		if status.Rewritten != 3 || status.Error != "" || status.LastCompleted.IsZero() {
+			t.Errorf("Expected 3 records to be rewritten, got %+v", status)
+		}
+		for _, key := range []string{"a", "b", "n"} {
+			segment, _, err := database.findKeyLocation(key)
+			if err != nil || segment.version != segmentFormatVersion {
+				t.Errorf("Expected key '%s' in the current format, got %v", key, err)
+			}
+		}
+		assertValue(t, database, "a", "2")
+		assertValue(t, database, "b", "1")
+		if n, err := database.GetInt64("n"); err != nil || n != 7 {
+			t.Errorf("Expected 7, got %d (%v)", n, err)
+		}
+		if _, expiresAt, _ := database.getCurrentSegment().index.get("b"); expiresAt == 0 {
+			t.Error("Expected the expiration of 'b' to be kept")
+		}
+
+		if err := database.RewriteAll(); err != nil {
+			t.Fatal(err)
+		}
+		if status := waitForRewrite(t, database); status.Rewritten != 0 {
+			t.Errorf("Expected nothing left to rewrite, got %+v", status)
+		}
+	})
+
+	t.Run("dictionary compression turned off", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir, WithDictionaryCompression(), WithSegmentSize(1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 40; i++ {
+			if err := database.PutBytes(fmt.Sprintf("user%d", i), similarValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := database.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		database.Close()
+
+		database, err = Open(dir, WithSegmentSize(1024), WithCompactionSegments(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+		if err := database.RewriteAll(); err != nil {
+			t.Fatal(err)
+		}
+		if status := waitForRewrite(t, database); status.Rewritten != 40 {
+			t.Errorf("Expected every key to be rewritten, got %+v", status)
+		}
+		for i := 0; i < 40; i++ {
+			key := fmt.Sprintf("user%d", i)
+			if segment, _, _ := database.findKeyLocation(key); segment == nil || segment.dict != nil {
+				t.Errorf("Expected key '%s' in a segment without a dictionary", key)
+			}
+			value, err := database.GetBytes(key)
+			if err != nil || !bytes.Equal(value, similarValue(i)) {
+				t.Errorf("Expected %q for key '%s', got %q (%v)", similarValue(i), key, value, err)
+			}
+		}
+	})
+
+	t.Run("keys written meanwhile keep their value", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.FixedSegment(testutil.Put("a", "1"), testutil.Put("b", "1"))
+
+		database, err := Open(dir.Path, WithCompactionSegments(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if err := database.Put("a", "2"); err != nil {
+			t.Fatal(err)
+		}
+		database.PauseCompaction()
+		if err := database.RewriteAll(); err != nil {
+			t.Fatal(err)
+		}
+		database.ResumeCompaction()
+		if status := waitForRewrite(t, database); status.Rewritten != 1 {
+			t.Errorf("Expected only 'b' to be rewritten, got %+v", status)
+		}
+		assertValue(t, database, "a", "2")
+		assertValue(t, database, "b", "1")
+	})
+
+	t.Run("closed database", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		database.Close()
+		if err := database.RewriteAll(); err != ErrDBClosed {
+			t.Errorf("Expected ErrDBClosed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Every segment starts with a header of magic bytes and the format version
+// of the records that follow. Read as a record size, the magic is far larger
+// than any record, so segments written before the header existed are told
+// apart reliably and read as version 1.
+const (
+	segmentMagic      = "\x89KVS"
+	segmentHeaderSize = 8
+
+	// formatVarint encodes record, key and value lengths as varints.
+	formatVarint = 2
+	// formatBatches adds batchFlag to the type tag of records, which older
+	// versions would read as an unknown value type.
+	formatBatches        = 3
+	segmentFormatVersion = formatBatches
+)
+
+func encodeSegmentHeader() []byte {
+	header := make([]byte, segmentHeaderSize)
+	copy(header, segmentMagic)
+	binary.LittleEndian.PutUint16(header[len(segmentMagic):], segmentFormatVersion)
+	return header
+}
+
+// readSegmentHeader returns the format version of a segment and the offset
+// its first record starts at.
+func readSegmentHeader(file io.ReaderAt) (version int, dataStart int64, err error) {
+	header := make([]byte, segmentHeaderSize)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return 0, 0, err
+	}
+	if n < len(segmentMagic) || string(header[:len(segmentMagic)]) != segmentMagic {
+		return formatFixed, 0, nil
+	}
+	if n < segmentHeaderSize {
+		return 0, 0, fmt.Errorf("%w: truncated segment header", ErrCorruptedData)
+	}
+
+	version = int(binary.LittleEndian.Uint16(header[len(segmentMagic):]))
+	if version == 0 || version > segmentFormatVersion {
+		return 0, 0, fmt.Errorf("%w: segment format version %d", ErrUnsupportedFormat, version)
+	}
+	return version, segmentHeaderSize, nil
+}
+
+// writeSegmentHeader starts a new segment file and returns the offset of its
+// first record.
+func writeSegmentHeader(file io.Writer) (int64, error) {
+	n, err := file.Write(encodeSegmentHeader())
+	return int64(n), err
+}
@@ -0,0 +1,128 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+func TestSegmentHeader(t *testing.T) {
+	t.Run("new segments start with the header", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		database.Put("key", "value")
+		database.Close()
+
+		data, err := os.ReadFile(filepath.Join(dir, "current-data0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, encodeSegmentHeader()) {
+			t.Errorf("Expected the segment to start with the header, got %x", data[:segmentHeaderSize])
+		}
+		if !bytes.Equal(encodeSegmentHeader(), testutil.SegmentHeader(segmentFormatVersion)) {
+			t.Error("Expected testutil to write the same header")
+		}
+	})
+
+	t.Run("unversioned segments stay readable", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.UnversionedSegment(testutil.Put("a", "1"))
+		dir.UnversionedSegment(testutil.Put("b", "1"))
+
+		database, err := Open(dir.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		database.Put("c", "1")
+		database.Close()
+
+		reopened, err := Open(dir.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		assertValue(t, reopened, "a", "1")
+		assertValue(t, reopened, "b", "1")
+		assertValue(t, reopened, "c", "1")
+	})
+
+	t.Run("empty segment gets a header", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		path := dir.Raw(nil)
+
+		database, err := Open(dir.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		database.Put("key", "value")
+		database.Close()
+
+		data, _ := os.ReadFile(path)
+		if !bytes.HasPrefix(data, encodeSegmentHeader()) {
+			t.Error("Expected a header in the previously empty segment")
+		}
+	})
+
+	t.Run("fixed-size records are migrated", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.UnversionedSegment(testutil.Put("a", "1"), testutil.PutInt64("n", 7))
+		last := dir.FixedSegment(testutil.Put("a", "2"), testutil.PutWithExpiry("b", "1", time.Now().Add(time.Hour)))
+
+		database, err := Open(dir.Path, WithSegmentSize(1<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		assertValue(t, database, "a", "2")
+		assertValue(t, database, "b", "1")
+		if n, err := database.GetInt64("n"); err != nil || n != 7 {
+			t.Errorf("Expected 7, got %d (%v)", n, err)
+		}
+
+		if err := database.Put("c", "1"); err != nil {
+			t.Fatal(err)
+		}
+		if database.activeFilePath == last {
+			t.Error("Expected the version 1 segment not to be appended to")
+		}
+		assertValue(t, database, "c", "1")
+
+		database.compactOldSegments()
+		database.segmentLock.RLock()
+		compacted := database.segments[0]
+		database.segmentLock.RUnlock()
+		if compacted.version != segmentFormatVersion {
+			t.Errorf("Expected compaction to write version %d, got %d", segmentFormatVersion, compacted.version)
+		}
+		assertValue(t, database, "a", "2")
+		assertValue(t, database, "b", "1")
+	})
+
+	t.Run("newer format is rejected", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.Raw(append(testutil.SegmentHeader(segmentFormatVersion+1), testutil.Put("a", "1").Encode()...))
+
+		if _, err := Open(dir.Path); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+		}
+	})
+
+	t.Run("truncated header", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.Raw(testutil.SegmentHeader(segmentFormatVersion)[:5])
+
+		if _, err := Open(dir.Path); !errors.Is(err, ErrCorruptedData) {
+			t.Errorf("Expected ErrCorruptedData, got %v", err)
+		}
+	})
+}
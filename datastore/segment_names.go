@@ -0,0 +1,75 @@
+package datastore
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Segment files are named current-data<number>[.<generation>]. New active
+// segments get the next number. Compaction output takes the number of the
+// newest segment it merged with the next generation, so that it sorts after
+// every segment it replaces and before every segment written later, even if
+// the process dies before the merged files are removed.
+type segmentID struct {
+	number     int
+	generation int
+}
+
+func (id segmentID) less(other segmentID) bool {
+	if id.number != other.number {
+		return id.number < other.number
+	}
+	return id.generation < other.generation
+}
+
+func (id segmentID) fileName() string {
+	if id.generation == 0 {
+		return fmt.Sprintf("%s%d", dataFileName, id.number)
+	}
+	return fmt.Sprintf("%s%d.%d", dataFileName, id.number, id.generation)
+}
+
+func parseSegmentName(name string) (segmentID, bool) {
+	suffix, ok := strings.CutPrefix(name, dataFileName)
+	if !ok {
+		return segmentID{}, false
+	}
+	numberPart, generationPart, hasGeneration := strings.Cut(suffix, ".")
+
+	number, err := strconv.Atoi(numberPart)
+	if err != nil || number < 0 || strconv.Itoa(number) != numberPart {
+		return segmentID{}, false
+	}
+	id := segmentID{number: number}
+	if hasGeneration {
+		id.generation, err = strconv.Atoi(generationPart)
+		if err != nil || id.generation <= 0 || strconv.Itoa(id.generation) != generationPart {
+			return segmentID{}, false
+		}
+	}
+	return id, true
+}
+
+// listSegments returns the segment files of directory from oldest to newest.
+func listSegments(fs storage, directory string) ([]segmentID, error) {
+	names, err := fs.ListFiles(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []segmentID
+	for _, name := range names {
+		if id, ok := parseSegmentName(name); ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+	return ids, nil
+}
+
+func (db *Db) segmentPath(id segmentID) string {
+	return filepath.Join(db.directory, id.fileName())
+}
@@ -0,0 +1,106 @@
+package datastore
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Operations that take longer than the slow operation threshold are logged
+// with the time spent in each of their phases and counted by kind.
+const (
+	opGet     = "get"
+	opPut     = "put"
+	opCompact = "compact"
+)
+
+// SlowOps counts the operations that exceeded the slow operation threshold.
+type SlowOps struct {
+	Get     int64
+	Put     int64
+	Compact int64
+}
+
+type slowOpCounters struct {
+	get, put, compact atomic.Int64
+}
+
+func (c *slowOpCounters) counter(op string) *atomic.Int64 {
+	switch op {
+	case opGet:
+		return &c.get
+	case opPut:
+		return &c.put
+	default:
+		return &c.compact
+	}
+}
+
+// SlowOps returns how many operations exceeded the threshold set with
+// WithSlowOpThreshold since the database was opened.
+func (db *Db) SlowOps() SlowOps {
+	return SlowOps{
+		Get:     db.slowOps.get.Load(),
+		Put:     db.slowOps.put.Load(),
+		Compact: db.slowOps.compact.Load(),
+	}
+}
+
+type opPhase struct {
+	name string
+	took time.Duration
+}
+
+// opTimer records the phases of one operation. A nil timer, returned while
+// the threshold is disabled, ignores all calls, so the read and write paths
+// can use it unconditionally. The phases of a write are recorded by the
+// writer goroutine, which hands the timer back through the response channel.
+type opTimer struct {
+	db     *Db
+	op     string
+	key    string
+	start  time.Time
+	last   time.Time
+	phases []opPhase
+}
+
+func (db *Db) startOp(op, key string) *opTimer {
+	if db.slowOpThreshold <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return &opTimer{db: db, op: op, key: key, start: now, last: now}
+}
+
+// phase ends the phase that started with the previous call or startOp.
+func (t *opTimer) phase(name string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.phases = append(t.phases, opPhase{name: name, took: now.Sub(t.last)})
+	t.last = now
+}
+
+// finish logs and counts the operation if it was slow.
+func (t *opTimer) finish() {
+	if t == nil {
+		return
+	}
+	total := time.Since(t.start)
+	if total < t.db.slowOpThreshold {
+		return
+	}
+	t.db.slowOps.counter(t.op).Add(1)
+
+	breakdown := make([]string, 0, len(t.phases))
+	for _, phase := range t.phases {
+		breakdown = append(breakdown, fmt.Sprintf("%s %s", phase.name, phase.took))
+	}
+	subject := t.op
+	if t.key != "" {
+		subject = fmt.Sprintf("%s of key '%s'", t.op, t.key)
+	}
+	fmt.Printf("Warning: slow %s took %s (%s)\n", subject, total, strings.Join(breakdown, ", "))
+}
@@ -0,0 +1,105 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+func TestDb_SlowOps(t *testing.T) {
+	t.Run("disabled by default", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if err := database.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.Get("key"); err != nil {
+			t.Fatal(err)
+		}
+		if got := database.SlowOps(); got != (SlowOps{}) {
+			t.Errorf("Expected no slow operations, got %+v", got)
+		}
+	})
+
+	t.Run("counts operations over the threshold", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.Segment(testutil.Put("a", "1"))
+		dir.Segment(testutil.Put("a", "2"))
+		dir.Segment(testutil.Put("b", "1"))
+
+		database, err := Open(dir.Path, WithSlowOpThreshold(time.Nanosecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if err := database.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.Get("key"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.GetContext(context.Background(), "a"); err != nil {
+			t.Fatal(err)
+		}
+		database.compactOldSegments()
+
+		got := database.SlowOps()
+		if got.Put != 1 || got.Get != 2 || got.Compact != 1 {
+			t.Errorf("Expected 1 put, 2 gets and 1 compaction, got %+v", got)
+		}
+	})
+
+	t.Run("fast operations are not counted", func(t *testing.T) {
+		database, err := Open(t.TempDir(), WithSlowOpThreshold(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if err := database.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.Get("key"); err != nil {
+			t.Fatal(err)
+		}
+		if got := database.SlowOps(); got != (SlowOps{}) {
+			t.Errorf("Expected no slow operations, got %+v", got)
+		}
+	})
+
+	t.Run("records the phases of a read", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+		database.slowOpThreshold = time.Hour
+
+		if err := database.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+		location, err := database.getKeyPosition("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		op := database.startOp(opGet, "key")
+		if _, err := database.readBytes(location.segment, "key", location.position, op); err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, phase := range op.phases {
+			names = append(names, phase.name)
+		}
+		if len(names) != 2 || names[0] != "read" || names[1] != "decode" {
+			t.Errorf("Expected read and decode phases, got %v", names)
+		}
+	})
+}
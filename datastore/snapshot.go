@@ -0,0 +1,270 @@
+package datastore
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+)
+
+// Snapshot is a read-only view of the database as it was when it was taken.
+// Writes, expirations and compactions that come later do not change what it
+// reads, so several reads and iterations through it agree with each other.
+//
+// A snapshot keeps the segment files it reads on disk, even once compaction
+// has replaced them, until it is released, so it should not be held longer
+// than needed. Iterators of a snapshot read values until it is released.
+// Closing the database releases every snapshot.
+type Snapshot struct {
+	db *Db
+	// segments are those of the database, newest last, except that the
+	// active one is a frozen copy.
+	segments []*Segment
+	pinned   []*Segment
+	now      int64
+
+	mu       sync.RWMutex
+	released bool
+}
+
+// Snapshot pins the current segments and copies the index of the active
+// segment, which holds off writes while it is copied.
+func (db *Db) Snapshot() (*Snapshot, error) {
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.closeMutex.RUnlock()
+
+	db.fileLock.Lock()
+	db.segmentLock.RLock()
+	pinned := append([]*Segment(nil), db.segments...)
+	for _, segment := range pinned {
+		segment.pin()
+	}
+	segments := append([]*Segment(nil), pinned...)
+	segments[len(segments)-1] = pinned[len(pinned)-1].frozen(db.currentOffset)
+	now := db.clock.Now().UnixNano()
+	db.segmentLock.RUnlock()
+	db.fileLock.Unlock()
+
+	snapshot := &Snapshot{db: db, segments: segments, pinned: pinned, now: now}
+	db.snapshots.add(snapshot)
+	return snapshot, nil
+}
+
+// Release lets compaction remove the files only the snapshot still read.
+// Reads through a released snapshot fail with ErrDBClosed. Releasing it
+// again does nothing.
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	s.db.snapshots.remove(s)
+	for _, segment := range s.pinned {
+		segment.unpin()
+	}
+}
+
+// enter holds off Release while a read runs.
+func (s *Snapshot) enter() error {
+	s.mu.RLock()
+	if s.released {
+		s.mu.RUnlock()
+		return ErrDBClosed
+	}
+	return nil
+}
+
+func (s *Snapshot) Get(key string) (string, error) {
+	value, err := s.GetBytes(key)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func (s *Snapshot) GetBytes(key string) ([]byte, error) {
+	if err := s.db.authorize(context.Background(), AccessRead, key); err != nil {
+		return nil, err
+	}
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	segment, position, err := findIn(s.segments, key, s.now)
+	if err != nil {
+		return nil, err
+	}
+	return s.db.readBytes(segment, key, position, nil)
+}
+
+func (s *Snapshot) GetInt64(key string) (int64, error) {
+	if err := s.db.authorize(context.Background(), AccessRead, key); err != nil {
+		return 0, err
+	}
+	if err := s.enter(); err != nil {
+		return 0, err
+	}
+	defer s.mu.RUnlock()
+
+	segment, position, err := findIn(s.segments, key, s.now)
+	if err != nil {
+		return 0, err
+	}
+	value, valueType, err := s.db.readRecord(segment, key, position, nil)
+	if err != nil {
+		return 0, err
+	}
+	return decodeInt64(key, value, valueType)
+}
+
+func (s *Snapshot) Has(key string) (bool, error) {
+	if err := s.db.authorize(context.Background(), AccessRead, key); err != nil {
+		return false, err
+	}
+	if err := s.enter(); err != nil {
+		return false, err
+	}
+	defer s.mu.RUnlock()
+
+	_, _, err := findIn(s.segments, key, s.now)
+	return err == nil, nil
+}
+
+// GetMany returns the values of all keys present in the snapshot. Missing
+// keys are omitted from the result.
+func (s *Snapshot) GetMany(keys []string) (map[string]string, error) {
+	if err := s.db.authorize(context.Background(), AccessRead, keys...); err != nil {
+		return nil, err
+	}
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	return s.db.readLocations(locateAll(s.segments, keys, s.now))
+}
+
+func (s *Snapshot) Keys(opts ...IteratorOption) *Iterator {
+	return s.Scan("", opts...)
+}
+
+func (s *Snapshot) Scan(prefix string, opts ...IteratorOption) *Iterator {
+	return scan(s.db, s.collectKeys, prefix, opts)
+}
+
+// Range iterates the keys of the snapshot in lexicographic order within
+// [start, end). An empty end leaves the range unbounded.
+func (s *Snapshot) Range(start, end string) *Iterator {
+	return keyRange(s.db, s.collectKeys, start, end)
+}
+
+func (s *Snapshot) collectKeys(match func(key string) bool) ([]iteratorEntry, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+	return s.db.collectFrom(s.segments, s.now, match), nil
+}
+
+// frozen returns a copy of the segment that reads the same file up to end
+// but has its own copy of the index, which later writes leave as it is.
+func (segment *Segment) frozen(end int64) *Segment {
+	frozen := newSegment(segment.fs, segment.id, segment.path)
+	frozen.end = end
+	frozen.version = segment.version
+	frozen.dict = segment.dict
+	frozen.created = segment.created
+	segment.index.forEach(func(key string, position, expiresAt int64) bool {
+		frozen.index.set(key, position, expiresAt)
+		return true
+	})
+	return frozen
+}
+
+func (segment *Segment) pin() {
+	segment.pinMu.Lock()
+	defer segment.pinMu.Unlock()
+	segment.pins++
+}
+
+// unpin runs the cleanup of a retired segment once no snapshot reads it.
+func (segment *Segment) unpin() {
+	segment.pinMu.Lock()
+	segment.pins--
+	var cleanup func()
+	if segment.pins == 0 {
+		cleanup, segment.cleanup = segment.cleanup, nil
+	}
+	segment.pinMu.Unlock()
+
+	if cleanup != nil {
+		cleanup()
+	}
+}
+
+// retire marks a segment compaction has swapped out, and runs cleanup right
+// away, or once the last snapshot reading the segment is released.
+func (segment *Segment) retire(cleanup func()) {
+	segment.retired.Store(true)
+
+	segment.pinMu.Lock()
+	if segment.pins > 0 {
+		segment.cleanup = cleanup
+		segment.pinMu.Unlock()
+		return
+	}
+	segment.pinMu.Unlock()
+	cleanup()
+}
+
+// snapshotSet tracks the snapshots that have not been released.
+type snapshotSet struct {
+	mu   sync.Mutex
+	open map[*Snapshot]struct{}
+}
+
+func (set *snapshotSet) add(s *Snapshot) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if set.open == nil {
+		set.open = make(map[*Snapshot]struct{})
+	}
+	set.open[s] = struct{}{}
+}
+
+func (set *snapshotSet) remove(s *Snapshot) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	delete(set.open, s)
+}
+
+// segmentNames returns the file names of the segments snapshots still read.
+func (set *snapshotSet) segmentNames() []string {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	var names []string
+	for s := range set.open {
+		for _, segment := range s.pinned {
+			names = append(names, filepath.Base(segment.path))
+		}
+	}
+	return names
+}
+
+// releaseAll releases every snapshot once the database is closed.
+func (set *snapshotSet) releaseAll() {
+	set.mu.Lock()
+	snapshots := make([]*Snapshot, 0, len(set.open))
+	for s := range set.open {
+		snapshots = append(snapshots, s)
+	}
+	set.mu.Unlock()
+
+	for _, s := range snapshots {
+		s.Release()
+	}
+}
@@ -0,0 +1,174 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+func TestDb_Snapshot(t *testing.T) {
+	t.Run("later writes", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if err := database.Put("a", "1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.PutInt64("n", 7); err != nil {
+			t.Fatal(err)
+		}
+		snapshot, err := database.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snapshot.Release()
+
+		if err := database.Put("a", "2"); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Put("b", "1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.Increment("n", 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if value, err := snapshot.Get("a"); err != nil || value != "1" {
+			t.Errorf("Expected '1' in the snapshot, got %q (%v)", value, err)
+		}
+		if n, err := snapshot.GetInt64("n"); err != nil || n != 7 {
+			t.Errorf("Expected 7 in the snapshot, got %d (%v)", n, err)
+		}
+		if found, _ := snapshot.Has("b"); found {
+			t.Error("Expected 'b' to be missing from the snapshot")
+		}
+		if _, err := snapshot.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected ErrKeyNotFound, got %v", err)
+		}
+		values, err := snapshot.GetMany([]string{"a", "b", "n"})
+		if err != nil || len(values) != 2 || values["a"] != "1" || values["n"] != "7" {
+			t.Errorf("Expected a=1 and n=7, got %v (%v)", values, err)
+		}
+
+		var keys []string
+		it := snapshot.Keys(WithOrder(KeyOrder))
+		for it.Next() {
+			keys = append(keys, it.Key())
+		}
+		if fmt.Sprint(keys) != "[a n]" || it.Err() != nil {
+			t.Errorf("Expected keys [a n], got %v (%v)", keys, it.Err())
+		}
+
+		assertValue(t, database, "a", "2")
+	})
+
+	t.Run("compaction", func(t *testing.T) {
+		dir := t.TempDir()
+		database, err := Open(dir, WithSegmentSize(256), WithCompactionSegments(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		for i := 0; i < 20; i++ {
+			if err := database.Put(fmt.Sprintf("key%d", i), "old"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		snapshot, err := database.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 20; i++ {
+			if err := database.Put(fmt.Sprintf("key%d", i), "new"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := database.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		if removed, err := database.Vacuum(); err != nil || removed != 0 {
+			t.Errorf("Expected Vacuum to keep the files of the snapshot, removed %d (%v)", removed, err)
+		}
+
+		it := snapshot.Range("key1", "key2")
+		count := 0
+		for it.Next() {
+			count++
+			if value, err := it.Value(); err != nil || value != "old" {
+				t.Errorf("Expected 'old' for key '%s' in the snapshot, got %q (%v)", it.Key(), value, err)
+			}
+		}
+		if count != 11 {
+			t.Errorf("Expected 11 keys in the range, got %d", count)
+		}
+		assertValue(t, database, "key5", "new")
+
+		snapshot.Release()
+		snapshot.Release()
+		for _, segment := range snapshot.pinned {
+			if _, err := os.Stat(segment.path); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Expected the replaced segment %s to be removed once released, got %v", segment.path, err)
+			}
+		}
+		if _, err := snapshot.Get("key1"); !errors.Is(err, ErrDBClosed) {
+			t.Errorf("Expected ErrDBClosed from a released snapshot, got %v", err)
+		}
+	})
+
+	t.Run("expirations", func(t *testing.T) {
+		clock := testutil.NewClock(time.Now())
+		database, err := Open(t.TempDir(), WithClock(clock))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if err := database.PutWithTTL("a", "1", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		snapshot, err := database.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snapshot.Release()
+
+		clock.Advance(time.Hour)
+		if _, err := database.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected 'a' to have expired, got %v", err)
+		}
+		if value, err := snapshot.Get("a"); err != nil || value != "1" {
+			t.Errorf("Expected 'a' to be live in the snapshot, got %q (%v)", value, err)
+		}
+	})
+
+	t.Run("closed database", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Put("a", "1"); err != nil {
+			t.Fatal(err)
+		}
+		snapshot, err := database.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := snapshot.Get("a"); !errors.Is(err, ErrDBClosed) {
+			t.Errorf("Expected Close to release the snapshot, got %v", err)
+		}
+		if _, err := database.Snapshot(); !errors.Is(err, ErrDBClosed) {
+			t.Errorf("Expected ErrDBClosed, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package datastore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Stats summarizes what a database holds and how much of it compaction
+// could reclaim.
+type Stats struct {
+	// Keys counts the keys that can be read, leaving expired ones out.
+	Keys     int
+	Segments int
+	// DiskBytes adds up the segments, hints, dictionaries and the index
+	// checkpoint of the database.
+	DiskBytes int64
+	// DataBytes adds up the records in the segments, and DeadBytes those of
+	// them that were replaced or expired. Unless WithGarbageCompaction
+	// tracks replaced records as they are written, DeadBytes is estimated
+	// from the share of the keys of each segment that a newer segment holds
+	// or that expired, and misses records replaced within a segment.
+	DataBytes       int64
+	DeadBytes       int64
+	WriteQueueDepth int
+	Compaction      CompactionStatus
+}
+
+// Stats goes through the index and lists the database directory to report
+// the current Stats. It takes time in the order of the number of keys, so
+// it suits monitoring rather than every request.
+func (db *Db) Stats() (Stats, error) {
+	if err := db.enter(); err != nil {
+		return Stats{}, err
+	}
+	defer db.closeMutex.RUnlock()
+
+	stats := Stats{
+		WriteQueueDepth: db.WriteQueueDepth(),
+		Compaction:      db.CompactionStatus(),
+	}
+	db.countKeys(&stats)
+
+	names, err := db.fs.ListFiles(db.directory)
+	if err != nil {
+		return Stats{}, err
+	}
+	for _, name := range names {
+		if _, ok := segmentFileOf(name); !ok && name != checkpointFileName {
+			continue
+		}
+		size, _, err := db.fs.Stat(filepath.Join(db.directory, name))
+		if errors.Is(err, os.ErrNotExist) {
+			// Removed by a compaction since it was listed.
+			continue
+		}
+		if err != nil {
+			return Stats{}, err
+		}
+		stats.DiskBytes += size
+	}
+	return stats, nil
+}
+
+// countKeys fills in the key, segment and byte counts of stats.
+func (db *Db) countKeys(stats *Stats) {
+	db.segmentLock.RLock()
+	defer db.segmentLock.RUnlock()
+
+	now := db.clock.Now().UnixNano()
+	seen := make(map[string]bool)
+	stats.Segments = len(db.segments)
+	for i := len(db.segments) - 1; i >= 0; i-- {
+		segment := db.segments[i]
+		entries, live := 0, 0
+		segment.index.forEach(func(key string, _, expiresAt int64) bool {
+			entries++
+			if seen[key] {
+				return true
+			}
+			seen[key] = true
+			if expiresAt == 0 || expiresAt > now {
+				live++
+			}
+			return true
+		})
+		stats.Keys += live
+
+		data := segment.dataBytes.Load()
+		stats.DataBytes += data
+		switch {
+		case db.garbageRatio > 0:
+			stats.DeadBytes += segment.deadBytes.Load()
+		case entries > 0:
+			stats.DeadBytes += data * int64(entries-live) / int64(entries)
+		}
+	}
+}
+
+// SegmentInfo describes one segment file of a database.
+type SegmentInfo struct {
+	Path string
+	Size int64
+	// Keys counts the keys the segment holds a record of, including ones
+	// that newer segments replaced or that expired.
+	Keys int
+	// Created is when the database started the segment. For segments that
+	// were on disk when the database was opened, it is when their file was
+	// last written.
+	Created time.Time
+	// Active is set for the segment new writes go to, and Compressed for
+	// segments whose values are compressed with a dictionary.
+	Active     bool
+	Compressed bool
+}
+
+// Segments lists the segments of the database from oldest to newest. It
+// returns nil once the database is closed.
+func (db *Db) Segments() []SegmentInfo {
+	if db.enter() != nil {
+		return nil
+	}
+	defer db.closeMutex.RUnlock()
+
+	db.segmentLock.RLock()
+	defer db.segmentLock.RUnlock()
+
+	infos := make([]SegmentInfo, 0, len(db.segments))
+	for i, segment := range db.segments {
+		infos = append(infos, SegmentInfo{
+			Path:       segment.path,
+			Size:       segment.dataStart() + segment.dataBytes.Load(),
+			Keys:       segment.index.len(),
+			Created:    segment.created,
+			Active:     i == len(db.segments)-1,
+			Compressed: segment.dict != nil,
+		})
+	}
+	return infos
+}
@@ -0,0 +1,148 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+func TestDb_Stats(t *testing.T) {
+	dir := t.TempDir()
+	clock := testutil.NewClock(time.Now())
+	database, err := Open(dir, WithSegmentSize(100), WithCompactionSegments(0), WithExpirySweepInterval(0), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := database.Put(fmt.Sprintf("key%d", i), "first"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if err := database.Put(fmt.Sprintf("key%d", i), "second"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.PutWithTTL("session", "value", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second)
+
+	stats, err := database.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != 10 {
+		t.Errorf("Expected 10 live keys, got %d", stats.Keys)
+	}
+	database.segmentLock.RLock()
+	segments := len(database.segments)
+	database.segmentLock.RUnlock()
+	if stats.Segments != segments || segments < 2 {
+		t.Errorf("Expected the %d segments, got %d", segments, stats.Segments)
+	}
+	var onDisk int64
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if info, _ := entry.Info(); entry.Name() != lockFileName {
+			onDisk += info.Size()
+		}
+	}
+	if stats.DiskBytes != onDisk {
+		t.Errorf("Expected %d bytes on disk, got %d", onDisk, stats.DiskBytes)
+	}
+	if stats.DeadBytes <= 0 || stats.DeadBytes >= stats.DataBytes || stats.DataBytes > stats.DiskBytes {
+		t.Errorf("Expected some of the data to be dead, got %d of %d", stats.DeadBytes, stats.DataBytes)
+	}
+
+	if err := database.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	compacted, err := database.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted.Keys != 10 || compacted.DeadBytes != 0 || compacted.Compaction.Compactions != 1 {
+		t.Errorf("Expected compaction to reclaim every dead byte, got %+v", compacted)
+	}
+	if compacted.DiskBytes >= stats.DiskBytes {
+		t.Errorf("Expected compaction to shrink the files from %d bytes, got %d", stats.DiskBytes, compacted.DiskBytes)
+	}
+
+	// Sealed segments are measured when the database is opened again.
+	database.Close()
+	database, err = Open(dir, WithSegmentSize(100), WithCompactionSegments(0), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	reopened, err := database.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.DataBytes != compacted.DataBytes || reopened.Keys != 10 {
+		t.Errorf("Expected the same data after reopening, got %+v and %+v", reopened, compacted)
+	}
+
+	database.Close()
+	if _, err := database.Stats(); err != ErrDBClosed {
+		t.Errorf("Expected ErrDBClosed, got %v", err)
+	}
+}
+
+func TestDb_Segments(t *testing.T) {
+	dir := t.TempDir()
+	clock := testutil.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	database, err := Open(dir, WithSegmentSize(100), WithCompactionSegments(0), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	for i := 0; i < 8; i++ {
+		clock.Advance(time.Minute)
+		if err := database.Put(fmt.Sprintf("key%d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	segments := database.Segments()
+	if len(segments) < 2 {
+		t.Fatalf("Expected several segments, got %+v", segments)
+	}
+	keys := 0
+	for i, segment := range segments {
+		info, err := os.Stat(segment.Path)
+		if err != nil || info.Size() != segment.Size {
+			t.Errorf("Expected segment %s to be %d bytes, got %d (%v)", segment.Path, info.Size(), segment.Size, err)
+		}
+		if segment.Active != (i == len(segments)-1) {
+			t.Errorf("Expected only the last segment to be active, got %+v", segment)
+		}
+		if i > 0 && !segment.Created.After(segments[i-1].Created) {
+			t.Errorf("Expected segments to be created one after another, got %s and %s", segments[i-1].Created, segment.Created)
+		}
+		keys += segment.Keys
+	}
+	if keys != 8 {
+		t.Errorf("Expected 8 keys over the segments, got %d", keys)
+	}
+
+	clock.Advance(time.Hour)
+	if err := database.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	compacted := database.Segments()[0]
+	if compacted.Keys != 8 || !compacted.Created.Equal(clock.Now()) || compacted.Active {
+		t.Errorf("Expected one compacted segment with every key, got %+v", compacted)
+	}
+
+	database.Close()
+	if segments := database.Segments(); segments != nil {
+		t.Errorf("Expected no segments once closed, got %+v", segments)
+	}
+}
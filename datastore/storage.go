@@ -0,0 +1,221 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// storage is the file system segments live in. It is the real disk unless
+// the database was opened WithInMemory.
+type storage interface {
+	MkdirAll(dir string, perm os.FileMode) error
+	ListFiles(dir string) ([]string, error)
+	OpenAppend(path string, perm os.FileMode) (appendFile, error)
+	Open(path string) (readFile, error)
+	Remove(path string) error
+	// Stat returns the size of a file and when it was last written.
+	Stat(path string) (size int64, modTime time.Time, err error)
+}
+
+type readFile interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+type appendFile interface {
+	io.WriteCloser
+	Size() (int64, error)
+	Truncate(size int64) error
+	Sync() error
+}
+
+type diskStorage struct{}
+
+func (diskStorage) MkdirAll(dir string, perm os.FileMode) error {
+	info, err := os.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%w: %s", ErrNotDirectory, dir)
+		}
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to access database directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return fmt.Errorf("failed to create database directory: %w", err)
+	}
+	return nil
+}
+
+func (diskStorage) ListFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (diskStorage) OpenAppend(path string, perm os.FileMode) (appendFile, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_RDWR|os.O_CREATE, perm)
+	if err != nil {
+		return nil, err
+	}
+	return diskFile{file}, nil
+}
+
+func (diskStorage) Open(path string) (readFile, error) {
+	return os.Open(path)
+}
+
+func (diskStorage) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (diskStorage) Stat(path string) (int64, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return info.Size(), info.ModTime(), nil
+}
+
+type diskFile struct {
+	*os.File
+}
+
+func (f diskFile) Size() (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+type memStorage struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{files: make(map[string]*memFile)}
+}
+
+func (s *memStorage) MkdirAll(dir string, perm os.FileMode) error {
+	return nil
+}
+
+func (s *memStorage) ListFiles(dir string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for path := range s.files {
+		if filepath.Dir(path) == filepath.Clean(dir) {
+			names = append(names, filepath.Base(path))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *memStorage) OpenAppend(path string, perm os.FileMode) (appendFile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, ok := s.files[path]
+	if !ok {
+		file = &memFile{}
+		s.files[path] = file
+	}
+	return file, nil
+}
+
+func (s *memStorage) Open(path string) (readFile, error) {
+	s.mu.Lock()
+	file, ok := s.files[path]
+	s.mu.Unlock()
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return memReader{bytes.NewReader(file.snapshot())}, nil
+}
+
+func (s *memStorage) Remove(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[path]; !ok {
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrNotExist}
+	}
+	delete(s.files, path)
+	return nil
+}
+
+// Stat leaves out the modification time, which nothing in memory needs.
+func (s *memStorage) Stat(path string) (int64, time.Time, error) {
+	s.mu.Lock()
+	file, ok := s.files[path]
+	s.mu.Unlock()
+	if !ok {
+		return 0, time.Time{}, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	return int64(len(file.snapshot())), time.Time{}, nil
+}
+
+type memFile struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data = append(f.data, p...)
+	return len(p), nil
+}
+
+// snapshot returns the current contents. Records are only ever appended
+// after the returned length, so the slice stays valid for readers.
+func (f *memFile) snapshot() []byte {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.data[:len(f.data):len(f.data)]
+}
+
+func (f *memFile) Size() (int64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return int64(len(f.data)), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if size < int64(len(f.data)) {
+		f.data = f.data[:size:size]
+	}
+	return nil
+}
+
+func (f *memFile) Sync() error  { return nil }
+func (f *memFile) Close() error { return nil }
+
+type memReader struct {
+	*bytes.Reader
+}
+
+func (memReader) Close() error { return nil }
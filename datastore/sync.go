@@ -0,0 +1,57 @@
+package datastore
+
+import (
+	"fmt"
+)
+
+// Sync flushes all acknowledged writes to stable storage regardless of the
+// sync policy.
+func (db *Db) Sync() error {
+	if err := db.enter(); err != nil {
+		return err
+	}
+	defer db.closeMutex.RUnlock()
+
+	db.fileLock.Lock()
+	defer db.fileLock.Unlock()
+	return db.syncActiveFile()
+}
+
+// syncActiveFile must be called with fileLock held.
+func (db *Db) syncActiveFile() error {
+	if !db.unsynced {
+		return nil
+	}
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	db.unsynced = false
+	return nil
+}
+
+func (db *Db) startSyncer() {
+	if db.syncPolicy != SyncInterval {
+		return
+	}
+
+	db.syncWG.Add(1)
+	go func() {
+		defer db.syncWG.Done()
+		ticks, stop := db.clock.NewTicker(db.syncInterval)
+		defer stop()
+
+		for {
+			select {
+			case <-db.done:
+				return
+			case <-ticks:
+				db.fileLock.Lock()
+				err := db.syncActiveFile()
+				db.fileLock.Unlock()
+				if err != nil {
+					fmt.Printf("Warning: periodic sync of %s failed: %v\n", db.activeFilePath, err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+package datastore
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDb_Sync(t *testing.T) {
+	t.Run("explicit sync", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+		if !database.unsynced {
+			t.Error("Expected write to leave unsynced data with SyncNever")
+		}
+		if err := database.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		if database.unsynced {
+			t.Error("Expected Sync to flush pending data")
+		}
+		database.Close()
+		if err := database.Sync(); !errors.Is(err, ErrDBClosed) {
+			t.Errorf("Expected ErrDBClosed, got %v", err)
+		}
+	})
+
+	t.Run("sync always", func(t *testing.T) {
+		database, err := Open(t.TempDir(), WithSyncPolicy(SyncAlways))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if err := database.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+		if database.unsynced {
+			t.Error("Expected every write to be synced")
+		}
+	})
+
+	t.Run("sync on interval", func(t *testing.T) {
+		database, err := Open(t.TempDir(), WithSyncInterval(10*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		if err := database.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for {
+			database.fileLock.Lock()
+			unsynced := database.unsynced
+			database.fileLock.Unlock()
+			if !unsynced {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Expected background sync to flush the write")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+
+	t.Run("invalid interval", func(t *testing.T) {
+		if _, err := Open(t.TempDir(), WithSyncInterval(0)); err == nil {
+			t.Error("Expected non-positive sync interval to be rejected")
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	ticks, stop := clock.NewTicker(time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case <-ticks:
+		t.Fatal("Expected no tick before the period is up")
+	default:
+	}
+
+	clock.Advance(5 * time.Second)
+	if tick := <-ticks; !tick.Equal(start.Add(time.Second)) {
+		t.Errorf("Expected the first tick at %s, got %s", start.Add(time.Second), tick)
+	}
+	select {
+	case <-ticks:
+		t.Error("Expected missed ticks to be dropped")
+	default:
+	}
+	if now := clock.Now(); !now.Equal(start.Add(5999 * time.Millisecond)) {
+		t.Errorf("Unexpected time %s", now)
+	}
+
+	stop()
+	clock.Advance(time.Hour)
+	select {
+	case <-ticks:
+		t.Error("Expected no ticks after stop")
+	default:
+	}
+}
@@ -0,0 +1,280 @@
+// Package testutil builds datastore directories in specific on-disk states,
+// such as several segments, an interrupted compaction or a corrupted tail,
+// so that recovery code can be exercised without handcrafted binary files.
+// Its Clock lets tests move the time of a database forward.
+package testutil
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	segmentPrefix = "current-data"
+
+	// HeaderSize is the size of the header that starts every segment; the
+	// first record follows it.
+	HeaderSize    = 8
+	segmentMagic  = "\x89KVS"
+	formatVersion = 3
+
+	typeBytes  = 0
+	typeInt64  = 1
+	batchFlag  = 0x20
+	expiryFlag = 0x80
+)
+
+type Record struct {
+	Key       string
+	Value     []byte
+	Int64     bool
+	ExpiresAt time.Time
+	// Batched marks a record of a write batch that more records of the
+	// batch follow.
+	Batched bool
+}
+
+func Put(key, value string) Record {
+	return Record{Key: key, Value: []byte(value)}
+}
+
+func PutInt64(key string, value int64) Record {
+	encoded := make([]byte, 8)
+	binary.LittleEndian.PutUint64(encoded, uint64(value))
+	return Record{Key: key, Value: encoded, Int64: true}
+}
+
+func PutWithExpiry(key, value string, expiresAt time.Time) Record {
+	return Record{Key: key, Value: []byte(value), ExpiresAt: expiresAt}
+}
+
+// Encode returns the record in the current segment format: varint length
+// of the rest of the record, varint key length, key, varint value length,
+// value, type tag, optional expiry and the CRC32-C of the key and value.
+func (r Record) Encode() []byte {
+	var body []byte
+	body = binary.AppendUvarint(body, uint64(len(r.Key)))
+	body = append(body, r.Key...)
+	body = binary.AppendUvarint(body, uint64(len(r.Value)))
+	body = append(body, r.Value...)
+	body = r.appendTrailer(body)
+
+	buffer := binary.AppendUvarint(nil, uint64(len(body)))
+	return append(buffer, body...)
+}
+
+// EncodeFixed returns the record in the format of unversioned and version 1
+// segments, where every length is a fixed 32-bit integer.
+func (r Record) EncodeFixed() []byte {
+	size := 4 + 4 + len(r.Key) + 4 + len(r.Value) + 1 + crc32.Size
+	if !r.ExpiresAt.IsZero() {
+		size += 8
+	}
+
+	buffer := make([]byte, 0, size)
+	buffer = binary.LittleEndian.AppendUint32(buffer, uint32(size))
+	buffer = binary.LittleEndian.AppendUint32(buffer, uint32(len(r.Key)))
+	buffer = append(buffer, r.Key...)
+	buffer = binary.LittleEndian.AppendUint32(buffer, uint32(len(r.Value)))
+	buffer = append(buffer, r.Value...)
+	return r.appendTrailer(buffer)
+}
+
+func (r Record) appendTrailer(buffer []byte) []byte {
+	tag := byte(typeBytes)
+	if r.Int64 {
+		tag = typeInt64
+	}
+	if r.Batched {
+		tag |= batchFlag
+	}
+	if r.ExpiresAt.IsZero() {
+		buffer = append(buffer, tag)
+	} else {
+		buffer = append(buffer, tag|expiryFlag)
+		buffer = binary.LittleEndian.AppendUint64(buffer, uint64(r.ExpiresAt.UnixNano()))
+	}
+
+	table := crc32.MakeTable(crc32.Castagnoli)
+	checksum := crc32.Update(crc32.Checksum([]byte(r.Key), table), table, r.Value)
+	return binary.LittleEndian.AppendUint32(buffer, checksum)
+}
+
+// EncodeLegacy returns the record in the original segment format, which had
+// no type tag, no expiration and a SHA-1 of the value only.
+func (r Record) EncodeLegacy() []byte {
+	size := 4 + 4 + len(r.Key) + 4 + len(r.Value) + sha1.Size
+
+	buffer := make([]byte, 0, size)
+	buffer = binary.LittleEndian.AppendUint32(buffer, uint32(size))
+	buffer = binary.LittleEndian.AppendUint32(buffer, uint32(len(r.Key)))
+	buffer = append(buffer, r.Key...)
+	buffer = binary.LittleEndian.AppendUint32(buffer, uint32(len(r.Value)))
+	buffer = append(buffer, r.Value...)
+
+	checksum := sha1.Sum(r.Value)
+	return append(buffer, checksum[:]...)
+}
+
+// Dir is a data directory under construction. Segments are numbered in the
+// order they are added.
+type Dir struct {
+	t    testing.TB
+	Path string
+	next int
+}
+
+func NewDir(t testing.TB) *Dir {
+	t.Helper()
+	return &Dir{t: t, Path: t.TempDir()}
+}
+
+// SegmentHeader returns the segment header for the given format version.
+func SegmentHeader(version uint16) []byte {
+	header := make([]byte, HeaderSize)
+	copy(header, segmentMagic)
+	binary.LittleEndian.PutUint16(header[len(segmentMagic):], version)
+	return header
+}
+
+// Segment writes a segment file with the given records and returns its path.
+func (d *Dir) Segment(records ...Record) string {
+	d.t.Helper()
+	data := SegmentHeader(formatVersion)
+	for _, r := range records {
+		data = append(data, r.Encode()...)
+	}
+	return d.Raw(data)
+}
+
+// UnversionedSegment writes a segment without a header, the way segments
+// were written before the format was versioned.
+func (d *Dir) UnversionedSegment(records ...Record) string {
+	d.t.Helper()
+	var data []byte
+	for _, r := range records {
+		data = append(data, r.EncodeFixed()...)
+	}
+	return d.Raw(data)
+}
+
+// FixedSegment writes a version 1 segment, with a header and records with
+// fixed-size lengths.
+func (d *Dir) FixedSegment(records ...Record) string {
+	d.t.Helper()
+	data := SegmentHeader(1)
+	for _, r := range records {
+		data = append(data, r.EncodeFixed()...)
+	}
+	return d.Raw(data)
+}
+
+// LegacySegment writes a segment file in the original format.
+func (d *Dir) LegacySegment(records ...Record) string {
+	d.t.Helper()
+	var data []byte
+	for _, r := range records {
+		data = append(data, r.EncodeLegacy()...)
+	}
+	return d.Raw(data)
+}
+
+// Raw writes a segment file with arbitrary contents.
+func (d *Dir) Raw(data []byte) string {
+	d.t.Helper()
+	path := d.write(fmt.Sprintf("%s%d", segmentPrefix, d.next), data)
+	d.next++
+	return path
+}
+
+func (d *Dir) write(name string, data []byte) string {
+	d.t.Helper()
+	path := filepath.Join(d.Path, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		d.t.Fatal(err)
+	}
+	return path
+}
+
+// PendingCompaction leaves the directory as if the process died after a
+// compaction wrote its output but before the merged segments were removed:
+// every segment is on disk next to the compaction output, which holds the
+// newest value of each key and carries the number of the newest merged
+// segment with the next generation.
+func (d *Dir) PendingCompaction(segments ...[]Record) string {
+	d.t.Helper()
+	latest := make(map[string]Record)
+	var order []string
+	for _, records := range segments {
+		d.Segment(records...)
+		for _, r := range records {
+			if _, seen := latest[r.Key]; !seen {
+				order = append(order, r.Key)
+			}
+			latest[r.Key] = r
+		}
+	}
+
+	data := SegmentHeader(formatVersion)
+	for _, key := range order {
+		data = append(data, latest[key].Encode()...)
+	}
+	return d.write(fmt.Sprintf("%s%d.1", segmentPrefix, d.next-1), data)
+}
+
+// AppendGarbage appends bytes to the end of a segment, e.g. a partially
+// written record.
+func (d *Dir) AppendGarbage(path string, garbage []byte) {
+	d.t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(garbage); err != nil {
+		d.t.Fatal(err)
+	}
+}
+
+// CorruptTail appends the first half of an encoded record, simulating a
+// crash in the middle of a write.
+func (d *Dir) CorruptTail(path string, r Record) {
+	d.t.Helper()
+	encoded := r.Encode()
+	d.AppendGarbage(path, encoded[:len(encoded)/2])
+}
+
+// TruncateTail cuts n bytes off the end of a segment.
+func (d *Dir) TruncateTail(path string, n int64) {
+	d.t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	if err := os.Truncate(path, max(info.Size()-n, 0)); err != nil {
+		d.t.Fatal(err)
+	}
+}
+
+// FlipByte inverts the byte at offset, breaking the checksum of whichever
+// record it belongs to.
+func (d *Dir) FlipByte(path string, offset int64) {
+	d.t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	if offset < 0 || offset >= int64(len(data)) {
+		d.t.Fatalf("offset %d outside of %s (%d bytes)", offset, path, len(data))
+	}
+	data[offset] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		d.t.Fatal(err)
+	}
+}
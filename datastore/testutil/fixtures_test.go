@@ -0,0 +1,39 @@
+package testutil
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestDirFixtures(t *testing.T) {
+	dir := NewDir(t)
+	record := Put("key", "value")
+
+	path := dir.Segment(record)
+	dir.CorruptTail(path, Put("partial", "write"))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	complete := append(SegmentHeader(formatVersion), record.Encode()...)
+	if !bytes.HasPrefix(data, complete) {
+		t.Error("Expected segment to start with the header and the encoded record")
+	}
+	if len(data) <= len(complete) {
+		t.Error("Expected a partial record after the first one")
+	}
+
+	dir.TruncateTail(path, int64(len(data)-len(complete)))
+	if info, _ := os.Stat(path); info.Size() != int64(len(complete)) {
+		t.Errorf("Expected truncated size %d, got %d", len(complete), info.Size())
+	}
+
+	if legacy := record.EncodeLegacy(); len(legacy) != len(record.EncodeFixed())-1-4+20 {
+		t.Errorf("Expected legacy record to lack the type tag and carry a SHA-1, got %d bytes", len(legacy))
+	}
+	if len(record.Encode()) >= len(record.EncodeFixed()) {
+		t.Error("Expected varint lengths to shrink the record")
+	}
+}
@@ -0,0 +1,63 @@
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+func (db *Db) PutWithTTL(key, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid ttl: %s", ttl)
+	}
+
+	return db.write([]entry{{
+		key:       key,
+		value:     bytes.Clone([]byte(value)),
+		expiresAt: db.clock.Now().Add(ttl).UnixNano(),
+	}})
+}
+
+func (db *Db) startExpirySweeper() {
+	if db.sweepInterval <= 0 {
+		return
+	}
+
+	db.sweepWG.Add(1)
+	go func() {
+		defer db.sweepWG.Done()
+		ticks, stop := db.clock.NewTicker(db.sweepInterval)
+		defer stop()
+
+		for {
+			select {
+			case <-db.done:
+				return
+			case <-ticks:
+				if db.hasExpiredSealedKeys() {
+					db.compactOldSegments()
+				}
+			}
+		}
+	}()
+}
+
+// hasExpiredSealedKeys reports whether compaction would reclaim expired
+// records. The active segment is skipped since compaction never touches it.
+func (db *Db) hasExpiredSealedKeys() bool {
+	db.segmentLock.RLock()
+	defer db.segmentLock.RUnlock()
+
+	now := db.clock.Now().UnixNano()
+	for i := 0; i < len(db.segments)-1; i++ {
+		expired := false
+		db.segments[i].index.forEach(func(_ string, _, expiresAt int64) bool {
+			expired = expiresAt != 0 && expiresAt <= now
+			return !expired
+		})
+		if expired {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package datastore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+func TestDb_PutWithTTL(t *testing.T) {
+	tempDir := t.TempDir()
+	clock := testutil.NewClock(time.Now())
+
+	database, err := Open(tempDir, WithSegmentSize(100), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.Put("session", "old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.PutWithTTL("session", "fresh", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.PutWithTTL("long", "lived", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := database.Get("session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "fresh" {
+		t.Errorf("Expected fresh value before expiry, got %s", value)
+	}
+
+	clock.Advance(50*time.Millisecond - time.Nanosecond)
+	if value, err := database.Get("session"); err != nil || value != "fresh" {
+		t.Errorf("Expected fresh value until the ttl is up, got %q (%v)", value, err)
+	}
+	clock.Advance(time.Nanosecond)
+
+	if _, err := database.Get("session"); !errors.Is(err, ErrKeyNotFound) {
+		t.Error("Expired key should not be readable")
+	}
+	if found, _ := database.Has("session"); found {
+		t.Error("Expired key should not be reported by Has")
+	}
+	it := database.Keys()
+	for it.Next() {
+		if it.Key() == "session" {
+			t.Error("Expired key should not be listed")
+		}
+	}
+
+	if err := database.PutWithTTL("bad", "ttl", 0); err == nil {
+		t.Error("Expected non-positive ttl to be rejected")
+	}
+
+	database.Close()
+
+	reopened, err := Open(tempDir, WithSegmentSize(100), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	if _, err := reopened.Get("session"); err == nil {
+		t.Error("Expired key should stay expired after reopen")
+	}
+	value, err = reopened.Get("long")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "lived" {
+		t.Errorf("Expected long-lived key to survive reopen, got %s", value)
+	}
+}
+
+func TestDb_CompactionDropsExpiredKeys(t *testing.T) {
+	clock := testutil.NewClock(time.Now())
+	database, err := Open(t.TempDir(), WithSegmentSize(40), WithExpirySweepInterval(0), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	if err := database.PutWithTTL("short", "value", 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.PutWithTTL("long", "value", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if database.hasExpiredSealedKeys() {
+		t.Fatal("Expected no expired key yet")
+	}
+	clock.Advance(20 * time.Millisecond)
+
+	if !database.hasExpiredSealedKeys() {
+		t.Fatal("Expected sealed segment with expired key")
+	}
+	if err := database.Put("filler", "value"); err != nil {
+		t.Fatal(err)
+	}
+	database.compactOldSegments()
+
+	database.segmentLock.RLock()
+	compacted := database.segments[0]
+	database.segmentLock.RUnlock()
+
+	if _, found, _ := compacted.lookup("short", clock.Now().UnixNano()); found {
+		t.Error("Compaction should drop expired keys")
+	}
+	if _, expiresAt, _ := compacted.index.get("long"); expiresAt == 0 {
+		t.Error("Compaction should keep expiration of live keys")
+	}
+
+	value, err := database.Get("long")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "value" {
+		t.Errorf("Unexpected value for long-lived key: %s", value)
+	}
+}
+
+func TestDb_CompactionKeepsExpiredKeysDeleted(t *testing.T) {
+	clock := testutil.NewClock(time.Now())
+	database, err := Open(t.TempDir(), WithSegmentSize(40), WithExpirySweepInterval(0), WithCompactionSegments(0), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	// The expired record is newer than a live one of the same key, which
+	// compaction must not bring back.
+	if err := database.Put("key", "old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.PutWithTTL("key", "new", 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Put("filler", "value"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(20 * time.Millisecond)
+	if _, err := database.Get("key"); err != ErrKeyNotFound {
+		t.Fatalf("Expected the key to have expired, got %v", err)
+	}
+
+	if err := database.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Get("key"); err != ErrKeyNotFound {
+		t.Errorf("Expected the key to stay expired after compaction, got %v", err)
+	}
+	database.segmentLock.RLock()
+	compacted := database.segments[0]
+	database.segmentLock.RUnlock()
+	if _, _, found := compacted.index.get("key"); found {
+		t.Error("Expected compaction to drop every record of the expired key")
+	}
+}
+
+func TestDb_ExpirySweeperFollowsClock(t *testing.T) {
+	clock := testutil.NewClock(time.Now())
+	database, err := Open(t.TempDir(), WithSegmentSize(40), WithExpirySweepInterval(time.Minute), WithCompactionSegments(0), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	if err := database.PutWithTTL("short", "value", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Put("filler", "value"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second)
+	if database.CompactionStatus().Compactions != 0 {
+		t.Fatal("Expected no sweep before the sweep interval")
+	}
+
+	clock.Advance(time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for database.CompactionStatus().Compactions == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the sweeper to compact the expired key away")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if status := database.CompactionStatus(); !status.LastCompleted.Equal(clock.Now()) {
+		t.Errorf("Expected the compaction to be timed by the clock, got %s", status.LastCompleted)
+	}
+}
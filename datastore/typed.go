@@ -0,0 +1,56 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+const int64ValueSize = 8
+
+func (db *Db) PutInt64(key string, value int64) error {
+	return db.write([]entry{newInt64Entry(key, value)})
+}
+
+func (db *Db) GetInt64(key string) (int64, error) {
+	if err := db.enter(); err != nil {
+		return 0, err
+	}
+	defer db.closeMutex.RUnlock()
+
+	location, err := db.getKeyPosition(key)
+	if err != nil {
+		return 0, err
+	}
+
+	value, valueType, err := db.readRecord(location.segment, key, location.position, nil)
+	if err != nil {
+		return 0, err
+	}
+	return decodeInt64(key, value, valueType)
+}
+
+func newInt64Entry(key string, value int64) entry {
+	encoded := make([]byte, int64ValueSize)
+	binary.LittleEndian.PutUint64(encoded, uint64(value))
+	return entry{key: key, value: encoded, valueType: valueTypeInt64}
+}
+
+func decodeInt64(key string, value []byte, valueType valueType) (int64, error) {
+	if valueType != valueTypeInt64 {
+		return 0, fmt.Errorf("%w: key '%s' holds %s", ErrTypeMismatch, key, valueType)
+	}
+	if len(value) != int64ValueSize {
+		return 0, fmt.Errorf("invalid int64 value size for key '%s': %d", key, len(value))
+	}
+	return int64(binary.LittleEndian.Uint64(value)), nil
+}
+
+// formatValue renders typed values for the byte and string APIs, so numeric
+// records stay readable through Get.
+func formatValue(value []byte, valueType valueType) []byte {
+	if valueType == valueTypeInt64 && len(value) == int64ValueSize {
+		return strconv.AppendInt(nil, int64(binary.LittleEndian.Uint64(value)), 10)
+	}
+	return value
+}
@@ -0,0 +1,77 @@
+package datastore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDb_Int64Values(t *testing.T) {
+	tempDir := t.TempDir()
+
+	database, err := Open(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.PutInt64("counter", -42); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Put("name", "osb"); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := database.GetInt64("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != -42 {
+		t.Errorf("Expected -42, got %d", value)
+	}
+
+	text, err := database.Get("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "-42" {
+		t.Errorf("Expected numeric value to read as string -42, got %q", text)
+	}
+
+	if _, err := database.GetInt64("name"); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected ErrTypeMismatch for string value, got %v", err)
+	}
+
+	database.Close()
+
+	reopened, err := Open(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	value, err = reopened.GetInt64("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != -42 {
+		t.Errorf("Expected -42 after reopen, got %d", value)
+	}
+}
+
+func TestEntry_TypeTagRoundTrip(t *testing.T) {
+	e := newInt64Entry("key", 7)
+	encoded := e.Encode()
+
+	var decoded entry
+	decoded.Decode(encoded, segmentFormatVersion)
+	if decoded.valueType != valueTypeInt64 {
+		t.Errorf("Expected int64 type tag, got %s", decoded.valueType)
+	}
+
+	number, err := decodeInt64(decoded.key, decoded.value, decoded.valueType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number != 7 {
+		t.Errorf("Expected 7, got %d", number)
+	}
+}
@@ -0,0 +1,89 @@
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Vacuum removes the files in the database directory that belong to no
+// segment of the database: segment files compaction failed to remove after
+// replacing them, and the hints and dictionaries of segments that are gone.
+// It returns how many files it removed, and why the others could not be.
+// A segment file still being read is only deleted once its last reader is
+// done, and those of segments a snapshot still reads are kept. The lock
+// file, the index checkpoint and files Vacuum does not know are left alone.
+//
+// Segments a compaction replaced but that were still on disk when the
+// database was opened again are loaded like any other, and left to the
+// next compaction.
+func (db *Db) Vacuum() (int, error) {
+	if err := db.enter(); err != nil {
+		return 0, err
+	}
+	// Like Compact, Close waits for Vacuum rather than for the read lock, so
+	// that it gets to end a pause a compaction holds the lock below in.
+	db.compactionWG.Add(1)
+	db.closeMutex.RUnlock()
+	defer db.compactionWG.Done()
+
+	// No compaction output or new active segment may appear while the
+	// directory is compared with the segment list.
+	db.compactionMu.Lock()
+	defer db.compactionMu.Unlock()
+	db.fileLock.Lock()
+	defer db.fileLock.Unlock()
+
+	names, err := db.fs.ListFiles(db.directory)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list database files: %w", err)
+	}
+
+	db.segmentLock.RLock()
+	live := make(map[string]bool, len(db.segments))
+	for _, segment := range db.segments {
+		live[filepath.Base(segment.path)] = true
+	}
+	db.segmentLock.RUnlock()
+	for _, name := range db.snapshots.segmentNames() {
+		live[name] = true
+	}
+
+	removed := 0
+	var errs error
+	for _, name := range names {
+		segmentName, ok := segmentFileOf(name)
+		if !ok || live[segmentName] {
+			continue
+		}
+		if err := db.fs.Remove(filepath.Join(db.directory, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = errors.Join(errs, fmt.Errorf("failed to remove %s: %w", name, err))
+			continue
+		}
+		removed++
+	}
+	return removed, errs
+}
+
+// segmentFileOf returns the name of the segment a segment, hint or
+// dictionary file belongs to.
+func segmentFileOf(name string) (string, bool) {
+	segmentName, ok := strings.CutSuffix(name, hintSuffix)
+	if !ok {
+		segmentName, _ = strings.CutSuffix(name, dictionarySuffix)
+	}
+	_, ok = parseSegmentName(segmentName)
+	return segmentName, ok
+}
+
+// removeSegmentFiles deletes the files of a segment compaction replaced.
+// Files it fails to delete are left to Vacuum.
+func (db *Db) removeSegmentFiles(path string) {
+	for _, file := range []string{hintPath(path), dictionaryPath(path), path} {
+		if err := db.fs.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Warning: failed to remove %s, Vacuum retries: %v\n", file, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package datastore
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDb_Vacuum(t *testing.T) {
+	dir := t.TempDir()
+	database, err := Open(dir, WithSegmentSize(64), WithCompactionSegments(0), WithCheckpointInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := database.Put(key, "value-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// What a compaction leaves behind when it fails to remove the files
+	// of the segments it replaced.
+	orphans := []string{"current-data90", "current-data90.hint", "current-data91.2.dict"}
+	kept := []string{"notes.txt", "current-data.hint"}
+	for _, name := range append(orphans, kept...) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("stale"), defaultFileMode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, _ := os.ReadDir(dir)
+
+	removed, err := database.Vacuum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != len(orphans) {
+		t.Errorf("Expected %d files to be removed, got %d", len(orphans), removed)
+	}
+	after, _ := os.ReadDir(dir)
+	if len(after) != len(before)-len(orphans) {
+		t.Errorf("Expected only the orphans to be removed, %d files left of %d", len(after), len(before))
+	}
+	for _, entry := range after {
+		if slices.Contains(orphans, entry.Name()) {
+			t.Errorf("Expected %s to be removed", entry.Name())
+		}
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		assertValue(t, database, key, "value-"+key)
+	}
+
+	if removed, err := database.Vacuum(); err != nil || removed != 0 {
+		t.Errorf("Expected nothing left to remove, got %d (%v)", removed, err)
+	}
+
+	database.Close()
+	if _, err := database.Vacuum(); err != ErrDBClosed {
+		t.Errorf("Expected ErrDBClosed, got %v", err)
+	}
+}
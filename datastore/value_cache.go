@@ -0,0 +1,106 @@
+package datastore
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+)
+
+// valueCache keeps recently read values in memory, up to a budget of bytes
+// counted over keys and values. Each value remembers the record it was read
+// from, and a lookup only hits while the index still points at that record,
+// so a value overwritten or moved by compaction is never served. Writes
+// also drop the values of their keys to free the memory early. A nil cache
+// is disabled.
+type valueCache struct {
+	budget int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type cachedValue struct {
+	key      string
+	segment  segmentID
+	position int64
+	value    []byte
+}
+
+func (v *cachedValue) size() int64 {
+	return int64(len(v.key) + len(v.value))
+}
+
+func newValueCache(budget int64) *valueCache {
+	if budget <= 0 {
+		return nil
+	}
+	return &valueCache{
+		budget:  budget,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns a copy of the cached value of key if it was read from
+// location.
+func (c *valueCache) get(key string, location *KeyLocation) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	cached := element.Value.(*cachedValue)
+	if cached.segment != location.segment.id || cached.position != location.position {
+		c.removeElement(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return bytes.Clone(cached.value), true
+}
+
+func (c *valueCache) add(key string, location *KeyLocation, value []byte) {
+	if c == nil {
+		return
+	}
+	cached := &cachedValue{key: key, segment: location.segment.id, position: location.position, value: bytes.Clone(value)}
+	if cached.size() > c.budget {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+	c.entries[key] = c.order.PushFront(cached)
+	c.size += cached.size()
+	for c.size > c.budget {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *valueCache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+func (c *valueCache) removeElement(element *list.Element) {
+	cached := c.order.Remove(element).(*cachedValue)
+	delete(c.entries, cached.key)
+	c.size -= cached.size()
+}
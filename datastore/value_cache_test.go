@@ -0,0 +1,116 @@
+package datastore
+
+import (
+	"testing"
+)
+
+func TestValueCache(t *testing.T) {
+	segment := newSegment(nil, segmentID{number: 1}, "segment-1")
+	at := func(position int64) *KeyLocation {
+		return &KeyLocation{segment: segment, position: position}
+	}
+
+	t.Run("disabled without a budget", func(t *testing.T) {
+		cache := newValueCache(0)
+		cache.add("key", at(0), []byte("value"))
+		if _, ok := cache.get("key", at(0)); ok {
+			t.Error("Expected a disabled cache to miss")
+		}
+	})
+
+	t.Run("hits only the cached record", func(t *testing.T) {
+		cache := newValueCache(1024)
+		cache.add("key", at(10), []byte("value"))
+
+		if value, ok := cache.get("key", at(10)); !ok || string(value) != "value" {
+			t.Errorf("Expected a hit with 'value', got %q, %v", value, ok)
+		}
+		if _, ok := cache.get("key", at(20)); ok {
+			t.Error("Expected a miss for another position")
+		}
+		if _, ok := cache.get("key", at(10)); ok {
+			t.Error("Expected the stale value to be dropped")
+		}
+	})
+
+	t.Run("evicts least recently used values", func(t *testing.T) {
+		cache := newValueCache(12)
+		cache.add("a", at(1), []byte("11111"))
+		cache.add("b", at(2), []byte("22222"))
+		cache.get("a", at(1))
+		cache.add("c", at(3), []byte("33333"))
+
+		if _, ok := cache.get("b", at(2)); ok {
+			t.Error("Expected 'b' to be evicted")
+		}
+		if _, ok := cache.get("a", at(1)); !ok {
+			t.Error("Expected 'a' to stay cached")
+		}
+		if cache.size > cache.budget {
+			t.Errorf("Expected at most %d bytes cached, got %d", cache.budget, cache.size)
+		}
+	})
+
+	t.Run("skips values over the budget", func(t *testing.T) {
+		cache := newValueCache(4)
+		cache.add("key", at(0), []byte("value"))
+		if len(cache.entries) != 0 {
+			t.Error("Expected an oversized value not to be cached")
+		}
+	})
+
+	t.Run("returns copies", func(t *testing.T) {
+		cache := newValueCache(1024)
+		value := []byte("value")
+		cache.add("key", at(0), value)
+		value[0] = 'X'
+
+		got, _ := cache.get("key", at(0))
+		got[1] = 'Y'
+		if again, _ := cache.get("key", at(0)); string(again) != "value" {
+			t.Errorf("Expected the cached value to stay 'value', got %q", again)
+		}
+	})
+}
+
+func TestDb_ValueCache(t *testing.T) {
+	database, err := Open(t.TempDir(), WithValueCache(1024), WithSegmentSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	if err := database.Put("key", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := database.Get("key"); err != nil || value != "first" {
+		t.Fatalf("Expected 'first', got %q, %v", value, err)
+	}
+	if _, ok := database.cache.entries["key"]; !ok {
+		t.Fatal("Expected the value to be cached after Get")
+	}
+
+	if err := database.Put("key", "second"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := database.cache.entries["key"]; ok {
+		t.Error("Expected Put to drop the cached value")
+	}
+	if value, err := database.Get("key"); err != nil || value != "second" {
+		t.Errorf("Expected 'second', got %q, %v", value, err)
+	}
+
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if err := database.Put(key, "filler value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	database.compactOldSegments()
+	if value, err := database.Get("key"); err != nil || value != "second" {
+		t.Errorf("Expected 'second' after compaction, got %q, %v", value, err)
+	}
+
+	if _, err := Open(t.TempDir(), WithValueCache(-1)); err == nil {
+		t.Error("Expected error for a negative cache size")
+	}
+}
@@ -0,0 +1,130 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// ValueReader reads a value straight from its segment file, so that large
+// values can be served without holding them in memory. It keeps the file
+// open, and a retired segment on disk, until it is closed.
+type ValueReader struct {
+	*io.SectionReader
+	close func() error
+}
+
+// Close releases the segment file of the value.
+func (r *ValueReader) Close() error {
+	return r.close()
+}
+
+// OpenValue opens the value of key for reading. The record is checked
+// against its checksum before OpenValue returns, so a damaged value fails
+// here as it would with GetContext. Compressed and int64 values, and values
+// of segments in the fixed format, are read into memory instead.
+func (db *Db) OpenValue(ctx context.Context, key string) (*ValueReader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	op := db.startOp(opGet, key)
+	defer op.finish()
+
+	if err := db.enter(); err != nil {
+		return nil, err
+	}
+	defer db.closeMutex.RUnlock()
+
+	location, err := db.getKeyPositionContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	op.phase("index")
+
+	if reader, ok := location.segment.openValue(key, location.position); ok {
+		op.phase("open")
+		return reader, nil
+	}
+	value, err := db.readLocation(key, location, op)
+	if err != nil {
+		return nil, err
+	}
+	reader := bytes.NewReader(value)
+	return &ValueReader{io.NewSectionReader(reader, 0, reader.Size()), func() error { return nil }}, nil
+}
+
+// openValue opens the plain value of the record of key at position. It
+// reports false for anything but an intact plain value of key, which the
+// caller then reads the usual way to get the usual errors and recovery.
+func (segment *Segment) openValue(key string, position int64) (*ValueReader, bool) {
+	if segment.version == formatFixed {
+		return nil, false
+	}
+	file, err := segment.fs.Open(segment.path)
+	if err != nil {
+		return nil, false
+	}
+	reader, err := openRecordValue(file, key, position)
+	if err != nil {
+		file.Close()
+		return nil, false
+	}
+	return &ValueReader{reader, file.Close}, true
+}
+
+// openRecordValue returns a reader over the value of the record at position
+// in file after verifying the checksum of the record.
+func openRecordValue(file io.ReaderAt, key string, position int64) (*io.SectionReader, error) {
+	header := bufio.NewReader(io.NewSectionReader(file, position, maxRecordSize))
+	bodySize, err := binary.ReadUvarint(header)
+	if err != nil || bodySize > maxRecordSize {
+		return nil, fmt.Errorf("%w: invalid record length", ErrCorruptedData)
+	}
+	keySize, err := binary.ReadUvarint(header)
+	if err != nil || keySize != uint64(len(key)) {
+		return nil, fmt.Errorf("%w: record does not belong to key '%s'", ErrCorruptedData, key)
+	}
+	recordKey := make([]byte, keySize)
+	if _, err := io.ReadFull(header, recordKey); err != nil || string(recordKey) != key {
+		return nil, fmt.Errorf("%w: record does not belong to key '%s'", ErrCorruptedData, key)
+	}
+	valueSize, err := binary.ReadUvarint(header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid value length", ErrCorruptedData)
+	}
+	valueOffset := position + int64(uvarintSize(bodySize)+uvarintSize(keySize)+len(key)+uvarintSize(valueSize))
+	trailerOffset := valueOffset + int64(valueSize)
+	if trailerOffset+typeTagSize+checksumSize > position+int64(uvarintSize(bodySize))+int64(bodySize) {
+		return nil, fmt.Errorf("%w: value length exceeds record size", ErrCorruptedData)
+	}
+
+	var tag [typeTagSize]byte
+	if _, err := file.ReadAt(tag[:], trailerOffset); err != nil {
+		return nil, fmt.Errorf("%w: incomplete record read: %v", ErrCorruptedData, err)
+	}
+	if tag[0]&compressedFlag != 0 || valueType(tag[0]&valueTypeMask) != valueTypeBytes {
+		return nil, fmt.Errorf("value of key '%s' is not stored as it is", key)
+	}
+	checksumOffset := trailerOffset + typeTagSize
+	if tag[0]&expiryFlag != 0 {
+		checksumOffset += expirationSize
+	}
+	var stored [checksumSize]byte
+	if _, err := file.ReadAt(stored[:], checksumOffset); err != nil {
+		return nil, fmt.Errorf("%w: incomplete record read: %v", ErrCorruptedData, err)
+	}
+
+	checksum := crc32.New(crcTable)
+	checksum.Write(recordKey)
+	if _, err := io.Copy(checksum, io.NewSectionReader(file, valueOffset, int64(valueSize))); err != nil {
+		return nil, fmt.Errorf("%w: incomplete record read: %v", ErrCorruptedData, err)
+	}
+	if checksum.Sum32() != binary.LittleEndian.Uint32(stored[:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch for key '%s'", ErrCorruptedData, key)
+	}
+	return io.NewSectionReader(file, valueOffset, int64(valueSize)), nil
+}
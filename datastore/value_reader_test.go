@@ -0,0 +1,105 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func readOpenedValue(t *testing.T, database *Db, key string) string {
+	t.Helper()
+	reader, err := database.OpenValue(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	value, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(value)) != reader.Size() {
+		t.Errorf("Expected a size of %d, got %d", len(value), reader.Size())
+	}
+	return string(value)
+}
+
+func TestDb_OpenValue(t *testing.T) {
+	database, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	if err := database.Put("plain", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.PutWithTTL("expiring", "soon", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.PutInt64("counter", 42); err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]string{"plain": "value", "expiring": "soon", "counter": "42"} {
+		if value := readOpenedValue(t, database, key); value != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, key, value)
+		}
+	}
+	if _, err := database.OpenValue(context.Background(), "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	t.Run("reader outlives compaction", func(t *testing.T) {
+		reader, err := database.OpenValue(context.Background(), "plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		if err := database.Put("plain", "newer"); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		value, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(value) != "value" {
+			t.Errorf("Expected the value at the time of opening, got %s", value)
+		}
+	})
+}
+
+func TestDb_OpenValue_Damaged(t *testing.T) {
+	database, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	if err := database.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	segment := database.getCurrentSegment()
+	location, err := database.getKeyPosition("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(segment.path, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The value follows the record length, the key and the value length, one
+	// byte each for a record this small.
+	if _, err := file.WriteAt([]byte("V"), location.position+3+int64(len("key"))); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if _, err := database.OpenValue(context.Background(), "key"); !errors.Is(err, ErrCorruptedData) {
+		t.Errorf("Expected ErrCorruptedData, got %v", err)
+	}
+}
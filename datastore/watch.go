@@ -0,0 +1,190 @@
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchBufferSize is how many events a watcher may fall behind by before it
+// is dropped.
+const watchBufferSize = 256
+
+// EventType tells what a write did to a key.
+type EventType int
+
+const (
+	// EventPut reports a key set to a new value. The database has no
+	// deletions yet; keys that expire produce no event.
+	EventPut EventType = iota
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "put"
+	}
+	return "unknown"
+}
+
+// Event reports a write to a watched key. Value is the value as GetBytes
+// would return it, shared by every watcher, so it must not be modified.
+type Event struct {
+	Type  EventType
+	Key   string
+	Value []byte
+	// Int64 is set for values written with PutInt64, Increment or
+	// Decrement; Value holds them in decimal.
+	Int64     bool
+	ExpiresAt time.Time
+}
+
+func newPutEvent(e entry) Event {
+	event := Event{Type: EventPut, Key: e.key, Value: formatValue(e.value, e.valueType), Int64: e.valueType == valueTypeInt64}
+	if e.expiresAt != 0 {
+		event.ExpiresAt = time.Unix(0, e.expiresAt)
+	}
+	return event
+}
+
+// Apply writes the record an event reports, with its value type and expiry,
+// so that a replica can repeat the writes Watch reports on another
+// database. An event whose expiry has passed still hides the older values of
+// its key.
+func (db *Db) Apply(event Event) error {
+	if event.Type != EventPut {
+		return fmt.Errorf("cannot apply %s event of key '%s'", event.Type, event.Key)
+	}
+	record := entry{key: event.Key, value: bytes.Clone(event.Value)}
+	if event.Int64 {
+		value, err := strconv.ParseInt(string(event.Value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: key '%s' holds %q, not an int64", ErrTypeMismatch, event.Key, event.Value)
+		}
+		record = newInt64Entry(event.Key, value)
+	}
+	if !event.ExpiresAt.IsZero() {
+		record.expiresAt = event.ExpiresAt.UnixNano()
+	}
+	return db.write([]entry{record})
+}
+
+// watchers fans the writes out to the channels returned by Watch. It is
+// fed by the writer goroutine, so every watcher sees the writes in the
+// order they were applied.
+type watchers struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]*watcher
+}
+
+type watcher struct {
+	prefix string
+	events chan Event
+}
+
+// Watch returns a channel that receives an event for every write to a key
+// with prefix once it is written, before the write returns, and a function
+// that stops the watch and closes the channel. Writes that only rewrite
+// stored records in another format produce no event.
+//
+// Events are sent without blocking the writer. A watcher that falls more
+// than a few hundred events behind has its channel closed and has to watch
+// again and reread the keys it cares about, since it may have missed
+// changes. The channel is also closed when the database is, and is closed
+// right away if it is closed already.
+func (db *Db) Watch(prefix string) (<-chan Event, func()) {
+	events := make(chan Event, watchBufferSize)
+	if err := db.enter(); err != nil {
+		close(events)
+		return events, func() {}
+	}
+	defer db.closeMutex.RUnlock()
+
+	db.watchers.mu.Lock()
+	defer db.watchers.mu.Unlock()
+	if db.watchers.subs == nil {
+		db.watchers.subs = make(map[int]*watcher)
+	}
+	id := db.watchers.next
+	db.watchers.next++
+	db.watchers.subs[id] = &watcher{prefix: prefix, events: events}
+	return events, func() { db.watchers.remove(id) }
+}
+
+// remove stops a watcher. It does nothing if the watcher is gone already.
+func (w *watchers) remove(id int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if sub, ok := w.subs[id]; ok {
+		delete(w.subs, id)
+		close(sub.events)
+	}
+}
+
+// match returns the events of the records a watcher is interested in, or
+// nil if none is. It has to be called before the records are compressed.
+func (w *watchers) match(entries []entry) []Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.subs) == 0 {
+		return nil
+	}
+
+	var events []Event
+	for _, e := range entries {
+		if !w.watched(e.key) {
+			continue
+		}
+		events = append(events, newPutEvent(e))
+	}
+	return events
+}
+
+func (w *watchers) watched(key string) bool {
+	for _, sub := range w.subs {
+		if strings.HasPrefix(key, sub.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish sends events to the watchers of their keys, and drops the
+// watchers that cannot take them.
+func (w *watchers) publish(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for id, sub := range w.subs {
+		for _, event := range events {
+			if !strings.HasPrefix(event.Key, sub.prefix) {
+				continue
+			}
+			select {
+			case sub.events <- event:
+				continue
+			default:
+			}
+			delete(w.subs, id)
+			close(sub.events)
+			break
+		}
+	}
+}
+
+// closeAll ends every watch once the database is closed.
+func (w *watchers) closeAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, sub := range w.subs {
+		delete(w.subs, id)
+		close(sub.events)
+	}
+}
@@ -0,0 +1,260 @@
+package datastore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/LeVasTiaN/KPI_Lab5/datastore/testutil"
+)
+
+// receive takes the events the channel holds without waiting, and reports
+// whether it was closed.
+func receive(events <-chan Event) ([]Event, bool) {
+	var received []Event
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return received, true
+			}
+			received = append(received, event)
+		default:
+			return received, false
+		}
+	}
+}
+
+func TestDb_Watch(t *testing.T) {
+	t.Run("writes to watched keys", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		events, stop := database.Watch("user:")
+		defer stop()
+
+		if err := database.Put("user:1", "alice"); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.Put("session:1", "ignored"); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.PutInt64("user:visits", 7); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := database.Increment("user:visits", 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.PutWithTTL("user:2", "bob", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		batch := NewWriteBatch()
+		batch.Put("user:1", "carol")
+		batch.Put("session:2", "ignored")
+		if err := database.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+
+		received, closed := receive(events)
+		if closed {
+			t.Fatal("Expected the watch to stay open")
+		}
+		expected := []struct{ key, value string }{
+			{"user:1", "alice"},
+			{"user:visits", "7"},
+			{"user:visits", "9"},
+			{"user:2", "bob"},
+			{"user:1", "carol"},
+		}
+		if len(received) != len(expected) {
+			t.Fatalf("Expected %d events, got %+v", len(expected), received)
+		}
+		for i, event := range received {
+			if event.Type != EventPut || event.Key != expected[i].key || string(event.Value) != expected[i].value {
+				t.Errorf("Expected a put of %q to '%s', got %s of %q to '%s'",
+					expected[i].value, expected[i].key, event.Type, event.Value, event.Key)
+			}
+		}
+		if received[3].ExpiresAt.IsZero() || !received[0].ExpiresAt.IsZero() {
+			t.Errorf("Expected only the put with a TTL to expire, got %v and %v", received[3].ExpiresAt, received[0].ExpiresAt)
+		}
+	})
+
+	t.Run("compressed values", func(t *testing.T) {
+		database, err := Open(t.TempDir(), WithDictionaryCompression(), WithSegmentSize(1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+		for i := 0; i < 40; i++ {
+			if err := database.PutBytes(fmt.Sprintf("user%d", i), similarValue(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := database.Compact(); err != nil {
+			t.Fatal(err)
+		}
+
+		events, stop := database.Watch("")
+		defer stop()
+		if err := database.PutBytes("user100", similarValue(100)); err != nil {
+			t.Fatal(err)
+		}
+		received, _ := receive(events)
+		if len(received) != 1 || !bytes.Equal(received[0].Value, similarValue(100)) {
+			t.Errorf("Expected the value as written, got %+v", received)
+		}
+	})
+
+	t.Run("rewrites are not changes", func(t *testing.T) {
+		dir := testutil.NewDir(t)
+		dir.FixedSegment(testutil.Put("a", "1"), testutil.Put("b", "1"))
+		database, err := Open(dir.Path, WithCompactionSegments(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		events, stop := database.Watch("")
+		defer stop()
+		if err := database.RewriteAll(); err != nil {
+			t.Fatal(err)
+		}
+		if status := waitForRewrite(t, database); status.Rewritten != 2 {
+			t.Fatalf("Expected both keys to be rewritten, got %+v", status)
+		}
+		if received, _ := receive(events); len(received) != 0 {
+			t.Errorf("Expected no events, got %+v", received)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		events, stop := database.Watch("")
+		stop()
+		stop()
+		if err := database.Put("a", "1"); err != nil {
+			t.Fatal(err)
+		}
+		if received, closed := receive(events); !closed || len(received) != 0 {
+			t.Errorf("Expected a closed watch without events, got %+v", received)
+		}
+	})
+
+	t.Run("watcher falling behind", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer database.Close()
+
+		slow, stopSlow := database.Watch("")
+		defer stopSlow()
+		other, stopOther := database.Watch("other")
+		defer stopOther()
+
+		for i := 0; i <= watchBufferSize; i++ {
+			if err := database.Put(fmt.Sprintf("key%d", i), "v"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if received, closed := receive(slow); !closed || len(received) != watchBufferSize {
+			t.Errorf("Expected the watch closed after %d events, got %d (closed %v)", watchBufferSize, len(received), closed)
+		}
+
+		if err := database.Put("other", "v"); err != nil {
+			t.Fatal(err)
+		}
+		if received, closed := receive(other); closed || len(received) != 1 {
+			t.Errorf("Expected the other watch to keep going, got %+v (closed %v)", received, closed)
+		}
+	})
+
+	t.Run("closed database", func(t *testing.T) {
+		database, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		events, _ := database.Watch("")
+		if err := database.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, closed := receive(events); !closed {
+			t.Error("Expected Close to end the watch")
+		}
+
+		events, stop := database.Watch("")
+		defer stop()
+		if _, closed := receive(events); !closed {
+			t.Error("Expected a watch of a closed database to be closed")
+		}
+	})
+}
+
+func TestDb_Apply(t *testing.T) {
+	clock := testutil.NewClock(time.Now())
+	source, err := Open(t.TempDir(), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	replica, err := Open(t.TempDir(), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+
+	if err := replica.Put("gone", "old"); err != nil {
+		t.Fatal(err)
+	}
+	events, stop := source.Watch("")
+	defer stop()
+	if err := source.PutInt64("visits", 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.PutWithTTL("session", "s1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.PutWithTTL("gone", "new", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	received, _ := receive(events)
+	clock.Advance(2 * time.Second)
+	for _, event := range received {
+		if err := replica.Apply(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := replica.GetInt64("visits"); err != nil || n != 7 {
+		t.Errorf("Expected the int64 7 on the replica, got %d (%v)", n, err)
+	}
+	if _, err := replica.Get("gone"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected an expired event to hide the older value, got %v", err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := replica.Get("session"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the replica to expire 'session' with the source, got %v", err)
+	}
+
+	it := source.Keys(WithOrder(KeyOrder))
+	if !it.Next() {
+		t.Fatal("Expected a key")
+	}
+	if event, err := it.Event(); err != nil || event.Key != "visits" || !event.Int64 || string(event.Value) != "7" {
+		t.Errorf("Expected the iterator to report the int64 'visits', got %+v (%v)", event, err)
+	}
+	if err := replica.Apply(Event{Type: EventPut, Key: "bad", Value: []byte("x"), Int64: true}); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("Expected ErrTypeMismatch for a malformed int64, got %v", err)
+	}
+}
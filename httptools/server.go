@@ -1,6 +1,8 @@
 package httptools
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,6 +11,9 @@ import (
 
 type Server interface {
 	Start()
+	// Shutdown stops accepting connections and waits for active requests
+	// to finish until ctx is done.
+	Shutdown(ctx context.Context) error
 }
 
 type server struct {
@@ -18,19 +23,44 @@ type server struct {
 func (s server) Start() {
 	go func() {
 		log.Println("Staring the HTTP server...")
-		err := s.httpServer.ListenAndServe()
+		var err error
+		if s.httpServer.TLSConfig != nil {
+			err = s.httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
+		if errors.Is(err, http.ErrServerClosed) {
+			log.Println("HTTP server stopped")
+			return
+		}
 		log.Fatalf("HTTP server finished: %s. Finishing the process.", err)
 	}()
 }
 
+func (s server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
 func CreateServer(port int, handler http.Handler) Server {
 	return server{
-		httpServer: &http.Server{
-			Addr:           fmt.Sprintf(":%d", port),
-			Handler:        handler,
-			ReadTimeout:    10 * time.Second,
-			WriteTimeout:   10 * time.Second,
-			MaxHeaderBytes: 1 << 20,
-		},
+		httpServer: newHTTPServer(port, handler),
+	}
+}
+
+func CreateTLSServer(port int, handler http.Handler, certificates *CertificateReloader) Server {
+	httpServer := newHTTPServer(port, handler)
+	httpServer.TLSConfig = certificates.TLSConfig()
+	return server{
+		httpServer: httpServer,
+	}
+}
+
+func newHTTPServer(port int, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:           fmt.Sprintf(":%d", port),
+		Handler:        handler,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
 	}
 }
@@ -3,8 +3,10 @@ package integration
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -16,12 +18,23 @@ const (
 	requestTimeout  = 3 * time.Second
 	testTimeout     = 30 * time.Second
 	retryDelay      = 500 * time.Millisecond
+	// latencyBudget is the p95 latency requests through the balancer are
+	// expected to stay under.
+	latencyBudget = time.Second
 )
 
+// testCoordinator sends requests through the balancer and collects their
+// outcomes and timings for the assertion helpers, which turn latency,
+// error and balance budgets into test failures.
 type testCoordinator struct {
 	client     *http.Client
 	ctx        context.Context
 	serverHits map[string]int
+
+	mu        sync.Mutex
+	requests  int
+	errors    int
+	latencies []time.Duration
 }
 
 func newTestCoordinator(ctx context.Context) *testCoordinator {
@@ -33,6 +46,23 @@ func newTestCoordinator(ctx context.Context) *testCoordinator {
 }
 
 func (tc *testCoordinator) executeRequest() (string, error) {
+	started := time.Now()
+	server, err := tc.send()
+	elapsed := time.Since(started)
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.requests++
+	if err != nil {
+		tc.errors++
+		return "", err
+	}
+	tc.serverHits[server]++
+	tc.latencies = append(tc.latencies, elapsed)
+	return server, nil
+}
+
+func (tc *testCoordinator) send() (string, error) {
 	req, err := http.NewRequestWithContext(tc.ctx, http.MethodGet, balancerURL, nil)
 	if err != nil {
 		return "", err
@@ -48,11 +78,60 @@ func (tc *testCoordinator) executeRequest() (string, error) {
 	if server == "" {
 		return "", fmt.Errorf("missing '%s' header", serverHeader)
 	}
-
-	tc.serverHits[server]++
 	return server, nil
 }
 
+// AssertP95Below fails t if the 95th percentile latency of the successful
+// requests exceeds budget.
+func (tc *testCoordinator) AssertP95Below(t testing.TB, budget time.Duration) {
+	t.Helper()
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if len(tc.latencies) == 0 {
+		t.Errorf("No successful requests to check the p95 latency budget of %s", budget)
+		return
+	}
+	if p95 := percentile(tc.latencies, 0.95); p95 > budget {
+		t.Errorf("p95 latency %s exceeds the budget of %s", p95, budget)
+	}
+}
+
+// AssertErrorRateBelow fails t if more than max of the requests failed.
+func (tc *testCoordinator) AssertErrorRateBelow(t testing.TB, max float64) {
+	t.Helper()
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.requests == 0 {
+		t.Errorf("No requests sent")
+		return
+	}
+	if rate := float64(tc.errors) / float64(tc.requests); rate > max {
+		t.Errorf("Error rate %.0f%% (%d/%d) exceeds %.0f%%", rate*100, tc.errors, tc.requests, max*100)
+	}
+}
+
+// AssertDistributionBalanced fails t unless every one of servers received
+// an even share of the successful requests, give or take tolerance of that
+// share.
+func (tc *testCoordinator) AssertDistributionBalanced(t testing.TB, servers int, tolerance float64) {
+	t.Helper()
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if len(tc.serverHits) != servers {
+		t.Errorf("Expected requests on %d servers, got %v", servers, tc.serverHits)
+		return
+	}
+	even := float64(len(tc.latencies)) / float64(servers)
+	for server, hits := range tc.serverHits {
+		if deviation := math.Abs(float64(hits)-even) / even; deviation > tolerance {
+			t.Errorf("Server %s received %d requests, %.0f%% off an even share of %.1f", server, hits, deviation*100, even)
+		}
+	}
+}
+
 func TestLoadBalancerDistribution(t *testing.T) {
 	if os.Getenv("INTEGRATION_TEST") == "" {
 		t.Skip("Skip integration test (set INTEGRATION_TEST to enable)")
@@ -88,12 +167,11 @@ func TestLoadBalancerDistribution(t *testing.T) {
 		}
 	}
 
-	if len(errors) > 0 {
-		t.Errorf("Errors during requests (%d/%d):", len(errors), totalRequests)
-		for _, err := range errors {
-			t.Logf("- %v", err)
-		}
+	for _, err := range errors {
+		t.Logf("- %v", err)
 	}
+	tc.AssertErrorRateBelow(t, 0)
+	tc.AssertP95Below(t, latencyBudget)
 
 	if len(tc.serverHits) < 1 {
 		t.Errorf("Expected at least %d servers, got %d. Hits: %v",
@@ -102,3 +180,54 @@ func TestLoadBalancerDistribution(t *testing.T) {
 		t.Logf("Load balanced across servers: %v", tc.serverHits)
 	}
 }
+
+// recordingTB collects the failures of the assertion helpers instead of
+// failing the test that checks them.
+type recordingTB struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestCoordinatorAssertions(t *testing.T) {
+	tc := &testCoordinator{serverHits: map[string]int{"server1:8080": 6, "server2:8080": 4}, requests: 11, errors: 1}
+	for i := 1; i <= 10; i++ {
+		tc.latencies = append(tc.latencies, time.Duration(i)*10*time.Millisecond)
+	}
+
+	cases := []struct {
+		name   string
+		assert func(testing.TB)
+		fails  bool
+	}{
+		{"p95 within budget", func(tb testing.TB) { tc.AssertP95Below(tb, 100*time.Millisecond) }, false},
+		{"p95 over budget", func(tb testing.TB) { tc.AssertP95Below(tb, 90*time.Millisecond) }, true},
+		{"error rate within limit", func(tb testing.TB) { tc.AssertErrorRateBelow(tb, 0.1) }, false},
+		{"error rate over limit", func(tb testing.TB) { tc.AssertErrorRateBelow(tb, 0.05) }, true},
+		{"balanced within tolerance", func(tb testing.TB) { tc.AssertDistributionBalanced(tb, 2, 0.2) }, false},
+		{"unbalanced", func(tb testing.TB) { tc.AssertDistributionBalanced(tb, 2, 0.1) }, true},
+		{"server without requests", func(tb testing.TB) { tc.AssertDistributionBalanced(tb, 3, 1) }, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tb := &recordingTB{TB: t}
+			c.assert(tb)
+			if failed := len(tb.failures) > 0; failed != c.fails {
+				t.Errorf("Expected failure %v, got %v", c.fails, tb.failures)
+			}
+		})
+	}
+
+	empty := &testCoordinator{serverHits: make(map[string]int)}
+	tb := &recordingTB{TB: t}
+	empty.AssertP95Below(tb, time.Second)
+	empty.AssertErrorRateBelow(tb, 1)
+	if len(tb.failures) != 2 {
+		t.Errorf("Expected assertions without requests to fail, got %v", tb.failures)
+	}
+}
@@ -0,0 +1,347 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Controller changes the environment under test, e.g. by stopping a
+// container or adding network delay to it.
+type Controller interface {
+	Stop(ctx context.Context, server string) error
+	Start(ctx context.Context, server string) error
+	SetLatency(ctx context.Context, server string, delay time.Duration) error
+}
+
+// Scenario is a reproducible sequence of actions and assertions.
+type Scenario struct {
+	Name  string
+	Steps []Step
+}
+
+type Step interface {
+	Describe() string
+	Run(ctx context.Context, r *Runner) error
+}
+
+// Load sends Requests requests with at most Concurrency in flight. Each Load
+// starts a new measurement window used by the following assertions.
+type Load struct {
+	Requests    int
+	Concurrency int
+	Interval    time.Duration
+}
+
+type Stop struct{ Server string }
+
+type Start struct{ Server string }
+
+type InjectLatency struct {
+	Server string
+	Delay  time.Duration
+}
+
+type Wait struct{ Duration time.Duration }
+
+// AssertDistribution checks the servers that answered in the last window.
+type AssertDistribution struct {
+	MinServers int
+	Excluded   []string
+	MaxShare   float64
+}
+
+type AssertErrorRate struct{ Max float64 }
+
+type AssertLatency struct {
+	Percentile float64
+	Max        time.Duration
+}
+
+type window struct {
+	requests  int
+	errors    int
+	hits      map[string]int
+	latencies []time.Duration
+}
+
+type Runner struct {
+	Client     *http.Client
+	URL        string
+	Header     string
+	Controller Controller
+
+	mu     sync.Mutex
+	window window
+}
+
+func NewRunner(url string, controller Controller) *Runner {
+	return &Runner{
+		Client:     &http.Client{Timeout: 3 * time.Second},
+		URL:        url,
+		Header:     "lb-from",
+		Controller: controller,
+	}
+}
+
+type StepResult struct {
+	Description string
+	Duration    time.Duration
+	Err         error
+}
+
+type Report struct {
+	Scenario string
+	Steps    []StepResult
+}
+
+func (r *Report) Failed() bool {
+	for _, step := range r.Steps {
+		if step.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "scenario %s\n", r.Scenario)
+	for i, step := range r.Steps {
+		status := "ok"
+		if step.Err != nil {
+			status = "FAIL: " + step.Err.Error()
+		}
+		fmt.Fprintf(&b, "%2d. %-50s %10s  %s\n", i+1, step.Description, step.Duration.Round(time.Millisecond), status)
+	}
+	return b.String()
+}
+
+// Run executes the steps in order and stops at the first failing one.
+func (r *Runner) Run(ctx context.Context, scenario Scenario) *Report {
+	report := &Report{Scenario: scenario.Name}
+	for _, step := range scenario.Steps {
+		started := time.Now()
+		err := step.Run(ctx, r)
+		report.Steps = append(report.Steps, StepResult{
+			Description: step.Describe(),
+			Duration:    time.Since(started),
+			Err:         err,
+		})
+		if err != nil {
+			break
+		}
+	}
+	return report
+}
+
+func (r *Runner) request(ctx context.Context) {
+	started := time.Now()
+	server, err := r.send(ctx)
+	elapsed := time.Since(started)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.window.requests++
+	if err != nil {
+		r.window.errors++
+		return
+	}
+	r.window.hits[server]++
+	r.window.latencies = append(r.window.latencies, elapsed)
+}
+
+func (r *Runner) send(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	server := resp.Header.Get(r.Header)
+	if server == "" {
+		return "", fmt.Errorf("missing '%s' header", r.Header)
+	}
+	return server, nil
+}
+
+func (r *Runner) snapshot() window {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.window
+}
+
+func (l Load) Describe() string {
+	return fmt.Sprintf("load %d requests x%d every %s", l.Requests, l.Concurrency, l.Interval)
+}
+
+func (l Load) Run(ctx context.Context, r *Runner) error {
+	r.mu.Lock()
+	r.window = window{hits: make(map[string]int)}
+	r.mu.Unlock()
+
+	concurrency := l.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < l.Requests; i++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			r.request(ctx)
+		}()
+		if l.Interval > 0 {
+			time.Sleep(l.Interval)
+		}
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s Stop) Describe() string { return "stop " + s.Server }
+
+func (s Stop) Run(ctx context.Context, r *Runner) error {
+	return r.Controller.Stop(ctx, s.Server)
+}
+
+func (s Start) Describe() string { return "start " + s.Server }
+
+func (s Start) Run(ctx context.Context, r *Runner) error {
+	return r.Controller.Start(ctx, s.Server)
+}
+
+func (s InjectLatency) Describe() string {
+	return fmt.Sprintf("inject %s latency into %s", s.Delay, s.Server)
+}
+
+func (s InjectLatency) Run(ctx context.Context, r *Runner) error {
+	return r.Controller.SetLatency(ctx, s.Server, s.Delay)
+}
+
+func (w Wait) Describe() string { return "wait " + w.Duration.String() }
+
+func (w Wait) Run(ctx context.Context, r *Runner) error {
+	select {
+	case <-time.After(w.Duration):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a AssertDistribution) Describe() string {
+	return fmt.Sprintf("assert distribution over >=%d servers", a.MinServers)
+}
+
+func (a AssertDistribution) Run(ctx context.Context, r *Runner) error {
+	w := r.snapshot()
+	if len(w.hits) < a.MinServers {
+		return fmt.Errorf("expected at least %d servers, got %v", a.MinServers, w.hits)
+	}
+	for _, excluded := range a.Excluded {
+		for server, hits := range w.hits {
+			if strings.HasPrefix(server, excluded) {
+				return fmt.Errorf("excluded server %s received %d requests", server, hits)
+			}
+		}
+	}
+	if a.MaxShare > 0 {
+		total := w.requests - w.errors
+		for server, hits := range w.hits {
+			if share := float64(hits) / float64(total); share > a.MaxShare {
+				return fmt.Errorf("server %s received %.0f%% of requests, max %.0f%%", server, share*100, a.MaxShare*100)
+			}
+		}
+	}
+	return nil
+}
+
+func (a AssertErrorRate) Describe() string {
+	return fmt.Sprintf("assert error rate <= %.0f%%", a.Max*100)
+}
+
+func (a AssertErrorRate) Run(ctx context.Context, r *Runner) error {
+	w := r.snapshot()
+	if w.requests == 0 {
+		return fmt.Errorf("no requests sent")
+	}
+	if rate := float64(w.errors) / float64(w.requests); rate > a.Max {
+		return fmt.Errorf("error rate %.0f%% (%d/%d)", rate*100, w.errors, w.requests)
+	}
+	return nil
+}
+
+func (a AssertLatency) Describe() string {
+	return fmt.Sprintf("assert p%.0f latency <= %s", a.Percentile*100, a.Max)
+}
+
+func (a AssertLatency) Run(ctx context.Context, r *Runner) error {
+	w := r.snapshot()
+	if len(w.latencies) == 0 {
+		return fmt.Errorf("no successful requests")
+	}
+	if latency := percentile(w.latencies, a.Percentile); latency > a.Max {
+		return fmt.Errorf("p%.0f latency %s exceeds %s", a.Percentile*100, latency, a.Max)
+	}
+	return nil
+}
+
+// percentile returns the latency below which the share p of latencies
+// falls, by the nearest rank. latencies must not be empty.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(index, 0), len(sorted)-1)]
+}
+
+// CommandController runs an external command for every action, passing the
+// action, the server and (for latency) the delay in milliseconds as
+// arguments. It lets the same scenarios drive docker, tc or a custom script.
+type CommandController struct {
+	Command []string
+}
+
+func (c CommandController) Stop(ctx context.Context, server string) error {
+	return c.run(ctx, "stop", server)
+}
+
+func (c CommandController) Start(ctx context.Context, server string) error {
+	return c.run(ctx, "start", server)
+}
+
+func (c CommandController) SetLatency(ctx context.Context, server string, delay time.Duration) error {
+	return c.run(ctx, "latency", server, fmt.Sprint(delay.Milliseconds()))
+}
+
+func (c CommandController) run(ctx context.Context, args ...string) error {
+	if len(c.Command) == 0 {
+		return fmt.Errorf("no controller command configured")
+	}
+	cmd := exec.CommandContext(ctx, c.Command[0], append(c.Command[1:], args...)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %w: %s", strings.Join(c.Command, " "), strings.Join(args, " "), err, output)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeCluster struct {
+	mu      sync.Mutex
+	servers []string
+	down    map[string]bool
+	latency map[string]time.Duration
+	next    int
+}
+
+func newFakeCluster(servers ...string) *fakeCluster {
+	return &fakeCluster{servers: servers, down: map[string]bool{}, latency: map[string]time.Duration{}}
+}
+
+func (c *fakeCluster) Stop(ctx context.Context, server string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down[server] = true
+	return nil
+}
+
+func (c *fakeCluster) Start(ctx context.Context, server string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.down, server)
+	return nil
+}
+
+func (c *fakeCluster) SetLatency(ctx context.Context, server string, delay time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latency[server] = delay
+	return nil
+}
+
+func (c *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	var server string
+	for i := 0; i < len(c.servers) && server == ""; i++ {
+		candidate := c.servers[c.next%len(c.servers)]
+		c.next++
+		if !c.down[candidate] {
+			server = candidate
+		}
+	}
+	delay := c.latency[server]
+	c.mu.Unlock()
+
+	if server == "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	time.Sleep(delay)
+	w.Header().Set(serverHeader, server)
+}
+
+func TestScenarioRunner(t *testing.T) {
+	cluster := newFakeCluster("server1:8080", "server2:8080", "server3:8080")
+	balancer := httptest.NewServer(cluster)
+	defer balancer.Close()
+
+	runner := NewRunner(balancer.URL, cluster)
+	report := runner.Run(context.Background(), Scenario{
+		Name: "server2 outage",
+		Steps: []Step{
+			Load{Requests: 30, Concurrency: 3},
+			AssertDistribution{MinServers: 3, MaxShare: 0.5},
+			Stop{Server: "server2:8080"},
+			Load{Requests: 30, Concurrency: 3},
+			AssertDistribution{MinServers: 2, Excluded: []string{"server2"}},
+			AssertErrorRate{Max: 0},
+			Start{Server: "server2:8080"},
+			InjectLatency{Server: "server1:8080", Delay: 20 * time.Millisecond},
+			Load{Requests: 30, Concurrency: 3},
+			AssertLatency{Percentile: 0.5, Max: time.Second},
+		},
+	})
+	if report.Failed() {
+		t.Fatalf("Scenario failed:\n%s", report)
+	}
+	if len(report.Steps) != 10 {
+		t.Errorf("Expected 10 step results, got %d", len(report.Steps))
+	}
+
+	t.Run("failing assertion stops the scenario", func(t *testing.T) {
+		report := runner.Run(context.Background(), Scenario{
+			Name: "slow server",
+			Steps: []Step{
+				InjectLatency{Server: "server3:8080", Delay: 30 * time.Millisecond},
+				Load{Requests: 9, Concurrency: 1},
+				AssertLatency{Percentile: 0.99, Max: 10 * time.Millisecond},
+				Wait{Duration: time.Hour},
+			},
+		})
+		if !report.Failed() {
+			t.Fatal("Expected latency assertion to fail")
+		}
+		if len(report.Steps) != 3 {
+			t.Errorf("Expected the runner to stop after the failing step, got %d steps", len(report.Steps))
+		}
+		if !strings.Contains(report.String(), "FAIL") {
+			t.Errorf("Expected report to mention the failure:\n%s", report)
+		}
+	})
+}
+
+func TestServerOutageScenario(t *testing.T) {
+	if os.Getenv("INTEGRATION_TEST") == "" {
+		t.Skip("Skip integration test (set INTEGRATION_TEST to enable)")
+	}
+	command := os.Getenv("SCENARIO_CONTROLLER")
+	if command == "" {
+		t.Skip("Skip scenario test (set SCENARIO_CONTROLLER to a command handling stop/start/latency)")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	runner := NewRunner(balancerURL, CommandController{Command: strings.Fields(command)})
+	report := runner.Run(ctx, Scenario{
+		Name: "server2 outage",
+		Steps: []Step{
+			Load{Requests: totalRequests, Concurrency: 2, Interval: 100 * time.Millisecond},
+			AssertErrorRate{Max: 0},
+			Stop{Server: "server2"},
+			Wait{Duration: 15 * time.Second},
+			Load{Requests: totalRequests, Concurrency: 2, Interval: 100 * time.Millisecond},
+			AssertDistribution{MinServers: 1, Excluded: []string{"server2"}},
+			AssertErrorRate{Max: 0},
+			Start{Server: "server2"},
+		},
+	})
+	t.Log("\n" + report.String())
+	if report.Failed() {
+		t.Fail()
+	}
+}
+
+// TestRollingDeployScenario relies on the servers draining before they stop:
+// requests sent right after a server is stopped must not reach it.
+func TestRollingDeployScenario(t *testing.T) {
+	if os.Getenv("INTEGRATION_TEST") == "" {
+		t.Skip("Skip integration test (set INTEGRATION_TEST to enable)")
+	}
+	command := os.Getenv("SCENARIO_CONTROLLER")
+	if command == "" {
+		t.Skip("Skip scenario test (set SCENARIO_CONTROLLER to a command handling stop/start/latency)")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	runner := NewRunner(balancerURL, CommandController{Command: strings.Fields(command)})
+	var steps []Step
+	for _, server := range []string{"server1", "server2", "server3"} {
+		steps = append(steps,
+			Stop{Server: server},
+			Load{Requests: totalRequests, Concurrency: 2, Interval: 50 * time.Millisecond},
+			AssertErrorRate{Max: 0},
+			Start{Server: server},
+			Wait{Duration: 5 * time.Second},
+		)
+	}
+	report := runner.Run(ctx, Scenario{Name: "rolling deploy", Steps: steps})
+	t.Log("\n" + report.String())
+	if report.Failed() {
+		t.Fail()
+	}
+}
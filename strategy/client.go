@@ -0,0 +1,65 @@
+package strategy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type ClientInfo struct {
+	Key    string `json:"key"`
+	Zone   string `json:"zone,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	Tier   string `json:"tier,omitempty"`
+}
+
+type ClientInfoFunc func(r *http.Request) ClientInfo
+
+func HeaderClientInfo(zoneHeader, tenantHeader, tierHeader string) ClientInfoFunc {
+	return func(r *http.Request) ClientInfo {
+		return ClientInfo{
+			Key:    r.RemoteAddr,
+			Zone:   r.Header.Get(zoneHeader),
+			Tenant: r.Header.Get(tenantHeader),
+			Tier:   r.Header.Get(tierHeader),
+		}
+	}
+}
+
+// ZoneAffinity prefers backends located in the client's zone and falls back
+// to the whole pool when the zone is unknown or has no healthy backends.
+type ZoneAffinity struct {
+	Next  Strategy
+	Zones map[string]string
+}
+
+func (s ZoneAffinity) Select(client ClientInfo, backends []string) (string, error) {
+	if client.Zone != "" {
+		local := make([]string, 0, len(backends))
+		for _, backend := range backends {
+			if s.Zones[backend] == client.Zone {
+				local = append(local, backend)
+			}
+		}
+		if len(local) > 0 {
+			return s.Next.Select(client, local)
+		}
+	}
+	return s.Next.Select(client, backends)
+}
+
+// ParseZones reads a comma separated list of backend=zone pairs.
+func ParseZones(spec string) (map[string]string, error) {
+	zones := make(map[string]string)
+	if strings.TrimSpace(spec) == "" {
+		return zones, nil
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		backend, zone, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || backend == "" || zone == "" {
+			return nil, fmt.Errorf("invalid zone mapping: %q", pair)
+		}
+		zones[backend] = zone
+	}
+	return zones, nil
+}
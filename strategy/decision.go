@@ -0,0 +1,62 @@
+package strategy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Decision records one routing choice of the balancer: the backends the
+// strategy was offered for a client and the one it picked. Health is a hash
+// of the healthy backends and their weights at the time, so decisions made
+// under the same conditions can be grouped.
+type Decision struct {
+	Time     time.Time  `json:"time"`
+	Client   ClientInfo `json:"client"`
+	Strategy string     `json:"strategy"`
+	Backends []string   `json:"backends"`
+	Backend  string     `json:"backend"`
+	Health   string     `json:"health"`
+}
+
+// ReplayResult compares the recorded decisions with the choices another
+// strategy makes for the same clients and backends.
+type ReplayResult struct {
+	Total   int
+	Changed int
+	// Before and After count the decisions routed to each backend.
+	Before map[string]int
+	After  map[string]int
+}
+
+// Replay reads decisions written one JSON object per line and lets s choose
+// among the recorded backends again.
+func Replay(r io.Reader, s Strategy) (ReplayResult, error) {
+	result := ReplayResult{Before: make(map[string]int), After: make(map[string]int)}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var decision Decision
+		if err := json.Unmarshal(scanner.Bytes(), &decision); err != nil {
+			return result, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		selected, err := s.Select(decision.Client, decision.Backends)
+		if err != nil {
+			return result, fmt.Errorf("line %d: %w", line, err)
+		}
+		result.Total++
+		result.Before[decision.Backend]++
+		result.After[selected]++
+		if selected != decision.Backend {
+			result.Changed++
+		}
+	}
+	return result, scanner.Err()
+}
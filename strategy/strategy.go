@@ -0,0 +1,60 @@
+package strategy
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var ErrNoBackends = errors.New("no healthy servers available")
+
+type Strategy interface {
+	Select(client ClientInfo, backends []string) (string, error)
+}
+
+type Hash struct{}
+
+func (Hash) Select(client ClientInfo, backends []string) (string, error) {
+	if len(backends) == 0 {
+		return "", ErrNoBackends
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(client.Key))
+	return backends[int(hash.Sum32())%len(backends)], nil
+}
+
+type Random struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func NewRandom(source rand.Source) *Random {
+	if source == nil {
+		source = rand.NewSource(time.Now().UnixNano())
+	}
+	return &Random{rnd: rand.New(source)}
+}
+
+func (s *Random) Select(_ ClientInfo, backends []string) (string, error) {
+	if len(backends) == 0 {
+		return "", ErrNoBackends
+	}
+
+	s.mu.Lock()
+	index := s.rnd.Intn(len(backends))
+	s.mu.Unlock()
+	return backends[index], nil
+}
+
+func New(name string, source rand.Source) (Strategy, error) {
+	switch name {
+	case "hash":
+		return Hash{}, nil
+	case "random":
+		return NewRandom(source), nil
+	}
+	return nil, errors.New("unknown strategy: " + name)
+}
@@ -0,0 +1,131 @@
+package strategy_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LeVasTiaN/KPI_Lab5/strategy"
+	"github.com/LeVasTiaN/KPI_Lab5/strategy/strategytest"
+)
+
+func TestHash(t *testing.T) {
+	strategytest.Run(t, func(rand.Source) strategy.Strategy {
+		return strategy.Hash{}
+	})
+
+	backends := strategytest.Backends(3)
+	first, _ := strategy.Hash{}.Select(strategytest.Client("192.168.1.1:1234"), backends)
+	for i := 0; i < 10; i++ {
+		selected, _ := strategy.Hash{}.Select(strategytest.Client("192.168.1.1:1234"), backends)
+		if selected != first {
+			t.Errorf("Hash strategy is not sticky: %s != %s", selected, first)
+		}
+	}
+}
+
+func TestRandom(t *testing.T) {
+	strategytest.Run(t, func(source rand.Source) strategy.Strategy {
+		return strategy.NewRandom(source)
+	})
+
+	backends := strategytest.Backends(3)
+	hits := strategytest.Distribution(strategy.NewRandom(rand.NewSource(7)), backends, 300)
+	for _, backend := range backends {
+		if hits[backend] == 0 {
+			t.Errorf("Backend %s never selected: %v", backend, hits)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := strategy.New("hash", nil); err != nil {
+		t.Error(err)
+	}
+	if _, err := strategy.New("random", rand.NewSource(1)); err != nil {
+		t.Error(err)
+	}
+	if _, err := strategy.New("bogus", nil); err == nil {
+		t.Error("Expected error for unknown strategy")
+	}
+}
+
+func TestZoneAffinity(t *testing.T) {
+	zones, err := strategy.ParseZones("server1:8080=a, server2:8080=b,server3:8080=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := strategy.ZoneAffinity{Next: strategy.Hash{}, Zones: zones}
+	backends := strategytest.Backends(3)
+
+	strategytest.Run(t, func(rand.Source) strategy.Strategy { return s })
+
+	for i := 0; i < 20; i++ {
+		client := strategy.ClientInfo{Key: fmt.Sprintf("client-%d", i), Zone: "b"}
+		selected, err := s.Select(client, backends)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if zones[selected] != "b" {
+			t.Errorf("Expected same-zone backend, got %s", selected)
+		}
+	}
+
+	selected, err := s.Select(strategy.ClientInfo{Key: "c", Zone: "a"}, backends[1:])
+	if err != nil || selected == "" {
+		t.Errorf("Expected fallback to other zones, got %q, %v", selected, err)
+	}
+
+	if _, err := strategy.ParseZones("server1:8080"); err == nil {
+		t.Error("Expected malformed zone mapping to be rejected")
+	}
+}
+
+func TestHeaderClientInfo(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Zone", "a")
+	req.Header.Set("X-Tenant", "osb")
+	req.Header.Set("X-Tier", "premium")
+
+	info := strategy.HeaderClientInfo("X-Zone", "X-Tenant", "X-Tier")(req)
+	expected := strategy.ClientInfo{Key: "10.0.0.1:1234", Zone: "a", Tenant: "osb", Tier: "premium"}
+	if info != expected {
+		t.Errorf("Expected %+v, got %+v", expected, info)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	backends := strategytest.Backends(3)
+	var log strings.Builder
+	encoder := json.NewEncoder(&log)
+	for i := 0; i < 50; i++ {
+		client := strategytest.Client(fmt.Sprintf("10.0.0.%d:1234", i))
+		selected, _ := strategy.Hash{}.Select(client, backends)
+		encoder.Encode(strategy.Decision{Client: client, Strategy: "hash", Backends: backends, Backend: selected})
+	}
+
+	same, err := strategy.Replay(strings.NewReader(log.String()), strategy.Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same.Total != 50 || same.Changed != 0 {
+		t.Errorf("Expected the recorded strategy to make the same 50 choices, got %+v", same)
+	}
+
+	other, err := strategy.Replay(strings.NewReader(log.String()), strategy.NewRandom(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Changed == 0 || other.After[backends[0]]+other.After[backends[1]]+other.After[backends[2]] != 50 {
+		t.Errorf("Expected another strategy to route differently, got %+v", other)
+	}
+
+	if _, err := strategy.Replay(strings.NewReader("{\n"), strategy.Hash{}); err == nil {
+		t.Error("Expected an error for a malformed log")
+	}
+}
@@ -0,0 +1,95 @@
+// Package strategytest contains checks every strategy.Strategy implementation
+// is expected to pass. Factories receive a seeded source so runs are
+// reproducible.
+package strategytest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/LeVasTiaN/KPI_Lab5/strategy"
+)
+
+type Factory func(source rand.Source) strategy.Strategy
+
+const selectionsPerCheck = 200
+
+func Backends(n int) []string {
+	backends := make([]string, n)
+	for i := range backends {
+		backends[i] = fmt.Sprintf("server%d:8080", i+1)
+	}
+	return backends
+}
+
+func Run(t *testing.T, factory Factory) {
+	t.Run("no backends", func(t *testing.T) {
+		_, err := factory(rand.NewSource(1)).Select(Client("client"), nil)
+		if !errors.Is(err, strategy.ErrNoBackends) {
+			t.Errorf("Expected ErrNoBackends, got %v", err)
+		}
+	})
+
+	t.Run("selects known backend", func(t *testing.T) {
+		backends := Backends(3)
+		known := make(map[string]bool)
+		for _, backend := range backends {
+			known[backend] = true
+		}
+
+		s := factory(rand.NewSource(1))
+		for i := 0; i < selectionsPerCheck; i++ {
+			selected, err := s.Select(Client(fmt.Sprintf("10.0.0.%d:1234", i)), backends)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !known[selected] {
+				t.Fatalf("Selected unknown backend %s", selected)
+			}
+		}
+	})
+
+	t.Run("single backend", func(t *testing.T) {
+		backends := Backends(1)
+		selected, err := factory(rand.NewSource(1)).Select(Client("client"), backends)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if selected != backends[0] {
+			t.Errorf("Expected %s, got %s", backends[0], selected)
+		}
+	})
+
+	t.Run("deterministic with same seed", func(t *testing.T) {
+		backends := Backends(5)
+		first := Sequence(factory(rand.NewSource(42)), backends, selectionsPerCheck)
+		second := Sequence(factory(rand.NewSource(42)), backends, selectionsPerCheck)
+		for i := range first {
+			if first[i] != second[i] {
+				t.Fatalf("Selections diverged at %d: %s != %s", i, first[i], second[i])
+			}
+		}
+	})
+}
+
+func Client(key string) strategy.ClientInfo {
+	return strategy.ClientInfo{Key: key}
+}
+
+func Sequence(s strategy.Strategy, backends []string, n int) []string {
+	selections := make([]string, n)
+	for i := range selections {
+		selections[i], _ = s.Select(Client(fmt.Sprintf("10.0.0.%d:1234", i)), backends)
+	}
+	return selections
+}
+
+func Distribution(s strategy.Strategy, backends []string, n int) map[string]int {
+	hits := make(map[string]int)
+	for _, selected := range Sequence(s, backends, n) {
+		hits[selected]++
+	}
+	return hits
+}